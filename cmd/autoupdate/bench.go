@@ -0,0 +1,237 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/openslides/openslides-autoupdate-service/internal/autoupdate"
+	"github.com/openslides/openslides-autoupdate-service/internal/keysbuilder"
+	"github.com/openslides/openslides-autoupdate-service/internal/test"
+	"github.com/openslides/openslides-autoupdate-service/pkg/autoupdatetest"
+)
+
+// benchResult is the machine readable result of one benchmark.
+type benchResult struct {
+	Name        string `json:"name"`
+	Keys        int    `json:"keys"`
+	Connections int    `json:"connections"`
+	N           int    `json:"n"`
+	NsPerOp     int64  `json:"ns_per_op"`
+	AllocsPerOp int64  `json:"allocs_per_op"`
+	BytesPerOp  int64  `json:"bytes_per_op"`
+}
+
+// cmdBench runs benchmarks of the autoupdate service and prints the results as
+// json.
+func cmdBench(args []string, w io.Writer) error {
+	fs := flag.NewFlagSet("bench", flag.ExitOnError)
+	keyCount := fs.Int("keys", 100, "Number of keys per connection.")
+	connections := fs.Int("connections", 100, "Number of connections for the end-to-end benchmark.")
+	run := fs.String("run", "", "Only run benchmarks that contain this string.")
+	fs.Parse(args)
+
+	benchmarks := []struct {
+		name string
+		f    func(b *testing.B, keyCount, connections int)
+	}{
+		{"filter-changing", benchFilterChanging},
+		{"filter-not-changing", benchFilterNotChanging},
+		{"keysbuilder", benchKeysbuilder},
+		{"end-to-end", benchEndToEnd},
+	}
+
+	results := make([]benchResult, 0, len(benchmarks))
+	for _, bm := range benchmarks {
+		if !strings.Contains(bm.name, *run) {
+			continue
+		}
+
+		var benchErr error
+		r := testing.Benchmark(func(b *testing.B) {
+			defer func() {
+				if r := recover(); r != nil {
+					benchErr = fmt.Errorf("%v", r)
+				}
+			}()
+			b.ReportAllocs()
+			bm.f(b, *keyCount, *connections)
+		})
+		if benchErr != nil {
+			return fmt.Errorf("benchmark %s: %w", bm.name, benchErr)
+		}
+
+		results = append(results, benchResult{
+			Name:        bm.name,
+			Keys:        *keyCount,
+			Connections: *connections,
+			N:           r.N,
+			NsPerOp:     r.NsPerOp(),
+			AllocsPerOp: r.AllocsPerOp(),
+			BytesPerOp:  r.AllocedBytesPerOp(),
+		})
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(results); err != nil {
+		return fmt.Errorf("encoding results: %w", err)
+	}
+	return nil
+}
+
+func userKeys(count int) []string {
+	keys := make([]string, count)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("user/%d/name", i+1)
+	}
+	return keys
+}
+
+// benchFilterChanging measures a connection where all keys change.
+func benchFilterChanging(b *testing.B, keyCount, _ int) {
+	closed := make(chan struct{})
+	defer close(closed)
+	datastore := test.NewMockDatastore()
+	s := autoupdate.New(datastore, new(test.MockRestricter), closed)
+	keys := userKeys(keyCount)
+	c := s.Connect(1, &keysbuilder.Simple{K: keys}, 0)
+	defer c.Close()
+	ctx := context.Background()
+
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		if _, err := c.Next(ctx); err != nil {
+			panic(err)
+		}
+
+		b.StopTimer()
+		data := make(map[string]json.RawMessage, keyCount)
+		for _, key := range keys {
+			data[key] = []byte(fmt.Sprintf(`"value %d"`, n))
+		}
+		datastore.Update(data)
+		b.StartTimer()
+
+		datastore.Send(keys)
+	}
+}
+
+// benchFilterNotChanging measures a connection where keys are updated but the
+// values stay the same.
+func benchFilterNotChanging(b *testing.B, keyCount, _ int) {
+	closed := make(chan struct{})
+	defer close(closed)
+	datastore := test.NewMockDatastore()
+	s := autoupdate.New(datastore, new(test.MockRestricter), closed)
+	keys := userKeys(keyCount)
+	c := s.Connect(1, &keysbuilder.Simple{K: keys}, 0)
+	defer c.Close()
+	ctx := context.Background()
+
+	// Read the first data.
+	if _, err := c.Next(ctx); err != nil {
+		panic(err)
+	}
+
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		// The first key changes, so that Next returns.
+		datastore.Update(map[string]json.RawMessage{keys[0]: []byte(fmt.Sprintf(`"value %d"`, n))})
+		datastore.Send(keys)
+		if _, err := c.Next(ctx); err != nil {
+			panic(err)
+		}
+	}
+}
+
+// benchKeysbuilder measures the building of keys with relation lists.
+func benchKeysbuilder(b *testing.B, keyCount, _ int) {
+	datastore := test.NewMockDatastore()
+	closed := make(chan struct{})
+	defer close(closed)
+	s := autoupdate.New(datastore, new(test.MockRestricter), closed)
+
+	ids := make([]string, keyCount)
+	for i := range ids {
+		ids[i] = fmt.Sprint(i + 1)
+	}
+	request := fmt.Sprintf(`[{
+		"ids": [%s],
+		"collection": "user",
+		"fields": {
+			"name": null,
+			"group_ids": {
+				"type": "relation-list",
+				"collection": "group",
+				"fields": {"name": null}
+			}
+		}
+	}]`, strings.Join(ids, ","))
+	ctx := context.Background()
+
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		if _, err := keysbuilder.ManyFromJSON(ctx, strings.NewReader(request), s, 1); err != nil {
+			panic(err)
+		}
+	}
+}
+
+// benchEndToEnd measures the time from an update until all http connections
+// received it.
+func benchEndToEnd(b *testing.B, keyCount, connections int) {
+	keys := userKeys(keyCount)
+	data := make(map[string]json.RawMessage, keyCount)
+	for _, key := range keys {
+		data[key] = []byte(`"value"`)
+	}
+
+	srv := autoupdatetest.NewServer(autoupdatetest.WithData(data))
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ids := make([]string, keyCount)
+	for i := range ids {
+		ids[i] = fmt.Sprint(i + 1)
+	}
+	request := fmt.Sprintf(`[{"ids":[%s],"collection":"user","fields":{"name":null}}]`, strings.Join(ids, ","))
+
+	clients := make([]*autoupdatetest.Client, connections)
+	for i := range clients {
+		c, err := srv.Connect(ctx, 1, request)
+		if err != nil {
+			panic(err)
+		}
+		defer c.Close()
+
+		if _, err := c.Next(); err != nil {
+			panic(err)
+		}
+		clients[i] = c
+	}
+
+	received := make(chan error, connections)
+
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		srv.Update(map[string]json.RawMessage{keys[0]: []byte(fmt.Sprintf(`"value %d"`, n))})
+		for _, c := range clients {
+			go func(c *autoupdatetest.Client) {
+				_, err := c.Next()
+				received <- err
+			}(c)
+		}
+		for range clients {
+			if err := <-received; err != nil {
+				panic(err)
+			}
+		}
+	}
+}
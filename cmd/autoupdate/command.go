@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/openslides/openslides-autoupdate-service/internal/autoupdate"
+	"github.com/openslides/openslides-autoupdate-service/internal/keysbuilder"
+)
+
+// runCommand runs a subcommand of the autoupdate binary and exits the process
+// if the command fails.
+func runCommand(name string, args []string) {
+	var err error
+	switch name {
+	case "request":
+		err = cmdRequest(args, os.Stdout)
+	case "loadtest":
+		err = cmdLoadtest(args, os.Stdout)
+	case "bench":
+		err = cmdBench(args, os.Stdout)
+	default:
+		err = fmt.Errorf("unknown command %s", name)
+	}
+
+	if err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+}
+
+// cmdRequest reads a keysrequest from a file, builds the keys for a user and
+// prints the restricted data.
+//
+// It uses the same environment variables as the service to connect to the
+// datastore.
+func cmdRequest(args []string, w io.Writer) error {
+	fs := flag.NewFlagSet("request", flag.ExitOnError)
+	file := fs.String("file", "", "Path to a file with the keysrequest. Use - for stdin.")
+	uid := fs.Int("uid", 0, "User id that is used to build the keys and restrict the data.")
+	fs.Parse(args)
+
+	if *file == "" {
+		return fmt.Errorf("flag -file is required")
+	}
+
+	var r io.Reader = os.Stdin
+	if *file != "-" {
+		f, err := os.Open(*file)
+		if err != nil {
+			return fmt.Errorf("open request file: %w", err)
+		}
+		defer f.Close()
+		r = f
+	}
+
+	closed := make(chan struct{})
+	defer close(closed)
+
+	errHandler := func(err error) {
+		log.Printf("Error: %v", err)
+	}
+
+	// The command does not wait for changes. So stdin is not read by the
+	// fake datastore and can be used for the request.
+	datastoreService, err := buildDatastore(closed, errHandler, strings.NewReader(""))
+	if err != nil {
+		return fmt.Errorf("create datastore service: %w", err)
+	}
+
+	service := autoupdate.New(datastoreService, buildRestricter(datastoreService), closed)
+
+	ctx := context.Background()
+	kb, err := keysbuilder.ManyFromJSON(ctx, r, service, *uid)
+	if err != nil {
+		return fmt.Errorf("build keys: %w", err)
+	}
+
+	data, err := service.RestrictedData(ctx, *uid, kb.Keys()...)
+	if err != nil {
+		return fmt.Errorf("get restricted data: %w", err)
+	}
+
+	// Show keys that are missing or restricted as null.
+	for k, v := range data {
+		if v == nil {
+			data[k] = []byte("null")
+		}
+	}
+
+	encoded, err := json.MarshalIndent(data, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encoding data: %w", err)
+	}
+	fmt.Fprintf(w, "%s\n", encoded)
+	return nil
+}
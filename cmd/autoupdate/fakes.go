@@ -2,11 +2,11 @@ package main
 
 import (
 	"bufio"
-	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
@@ -16,11 +16,17 @@ import (
 // faker implements the Datastore interface. It reads form a Reader, for example
 // stdin and takes each word on each line as changed key.
 //
+// If the first word of a line is not a key, the line is interpreted as a
+// command. See faker.command() for the supported commands.
+//
 // If it is created with newFaker(), it starts an fake datastore server. The nil
 // value can also be used but does nothing.
 type faker struct {
 	ts  *test.DatastoreServer
 	buf *bufio.Reader
+
+	// queue holds updates that were created by one line but not returned yet.
+	queue []map[string]json.RawMessage
 }
 
 func newFaker(r io.Reader) *faker {
@@ -33,6 +39,33 @@ func newFaker(r io.Reader) *faker {
 	return f
 }
 
+// loadExampleData reads a file in the format of the OpenSlides example-data.json
+// and uses its content as the only data of the fake datastore.
+func (f *faker) loadExampleData(r io.Reader) error {
+	var collections map[string][]map[string]json.RawMessage
+	if err := json.NewDecoder(r).Decode(&collections); err != nil {
+		return fmt.Errorf("decoding example data: %w", err)
+	}
+
+	data := make(map[string]json.RawMessage)
+	for collection, elements := range collections {
+		for i, element := range elements {
+			var id int
+			if err := json.Unmarshal(element["id"], &id); err != nil {
+				return fmt.Errorf("decoding id of %dth element in collection %s: %w", i, collection, err)
+			}
+
+			for field, value := range element {
+				data[fmt.Sprintf("%s/%d/%s", collection, id, field)] = value
+			}
+		}
+	}
+
+	f.ts.Update(data)
+	f.ts.OnlyData = true
+	return nil
+}
+
 // Update blocks, until there in new data. The nil value blocks forever. If
 // the faker was initialized with a reader, it reads each line form it and
 // interpretes each word (separated by space) and a key that should be updated.
@@ -42,16 +75,109 @@ func (f *faker) Update() (map[string]json.RawMessage, error) {
 		select {}
 	}
 
-	msg, err := f.buf.ReadString('\n')
-	if err != nil {
-		if err == io.EOF {
-			// Don't return anything (block forever) if the reader is empty.
-			select {}
+	for len(f.queue) == 0 {
+		msg, err := f.buf.ReadString('\n')
+		if err != nil {
+			if err == io.EOF {
+				// Don't return anything (block forever) if the reader is empty.
+				select {}
+			}
+			return nil, fmt.Errorf("read from buffer: %w", err)
+		}
+
+		input := strings.Fields(msg)
+		if len(input) == 0 {
+			continue
+		}
+
+		if strings.Contains(input[0], "/") {
+			f.queue = append(f.queue, parseKeyValues(input))
+			continue
+		}
+
+		if err := f.command(input[0], input[1:]); err != nil {
+			fmt.Printf("Invalid command: %v\n", err)
+		}
+	}
+
+	data := f.queue[0]
+	f.queue = f.queue[1:]
+
+	// Also change the values in the fake datastore so new requests get the
+	// new values.
+	f.ts.Update(data)
+	return data, nil
+}
+
+// command runs one of the commands of the faker:
+//
+// burst N key[=value]... sends the same update N times.
+//
+// delete key... sends an update that removes the keys.
+//
+// error [message] lets each request to the datastore fail. Without a message,
+// the requests succeed again.
+//
+// latency duration delays each request to the datastore, for example
+// `latency 500ms`.
+//
+// flush sends an update with all known keys of the datastore.
+func (f *faker) command(name string, args []string) error {
+	switch name {
+	case "burst":
+		if len(args) < 2 {
+			return fmt.Errorf("usage: burst N key[=value]...")
+		}
+		n, err := strconv.Atoi(args[0])
+		if err != nil {
+			return fmt.Errorf("invalid number %s: %w", args[0], err)
+		}
+		for i := 0; i < n; i++ {
+			f.queue = append(f.queue, parseKeyValues(args[1:]))
+		}
+
+	case "delete":
+		if len(args) == 0 {
+			return fmt.Errorf("usage: delete key...")
+		}
+		data := make(map[string]json.RawMessage, len(args))
+		for _, key := range args {
+			data[key] = nil
 		}
-		return nil, fmt.Errorf("read from buffer: %w", err)
+		f.queue = append(f.queue, data)
+
+	case "error":
+		if len(args) == 0 {
+			f.ts.SetError(nil)
+			fmt.Println("Datastore errors disabled")
+			return nil
+		}
+		f.ts.SetError(errors.New(strings.Join(args, " ")))
+		fmt.Println("Datastore errors enabled")
+
+	case "latency":
+		if len(args) != 1 {
+			return fmt.Errorf("usage: latency duration")
+		}
+		d, err := time.ParseDuration(args[0])
+		if err != nil {
+			return fmt.Errorf("invalid duration %s: %w", args[0], err)
+		}
+		f.ts.SetLatency(d)
+		fmt.Printf("Datastore latency set to %s\n", d)
+
+	case "flush":
+		f.queue = append(f.queue, f.ts.All())
+
+	default:
+		return fmt.Errorf("unknown command %s", name)
 	}
+	return nil
+}
 
-	input := strings.Split(strings.TrimSpace(msg), " ")
+// parseKeyValues parses words in the form key or key=value. If the value is
+// skipped, the current time is used.
+func parseKeyValues(input []string) map[string]json.RawMessage {
 	data := make(map[string]json.RawMessage)
 	for _, d := range input {
 		keyValue := strings.SplitN(d, "=", 2)
@@ -60,12 +186,5 @@ func (f *faker) Update() (map[string]json.RawMessage, error) {
 		}
 		data[keyValue[0]] = []byte(keyValue[1])
 	}
-	return data, nil
-}
-
-// fake Auth implements the Authenticater interface. It always returns the given number.
-type fakeAuth int
-
-func (a fakeAuth) Authenticate(context.Context, *http.Request) (int, error) {
-	return int(a), nil
+	return data
 }
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+
+	"github.com/quic-go/quic-go/http3"
+)
+
+// newHTTP3 creates an http3 server on the udp port of addr. It uses the same
+// certificate as the tls server.
+func newHTTP3(addr string, cert tls.Certificate, h http.Handler) *http3.Server {
+	return &http3.Server{
+		Addr:      addr,
+		Handler:   h,
+		TLSConfig: http3.ConfigureTLSConfig(&tls.Config{Certificates: []tls.Certificate{cert}}),
+	}
+}
+
+// serveHTTP3 serves the http3 server until closed is closed. Open streams are
+// closed with the server, like the connections of the http2 server.
+func serveHTTP3(closed <-chan struct{}, srv *http3.Server) {
+	conn, err := net.ListenPacket("udp", srv.Addr)
+	if err != nil {
+		log.Printf("Can not listen for http3: %v", err)
+		return
+	}
+
+	go func() {
+		<-closed
+		if err := srv.Close(); err != nil {
+			log.Printf("Error on http3 server shutdown: %v", err)
+		}
+	}()
+
+	fmt.Printf("Listen for http3 on udp %s\n", conn.LocalAddr())
+	if err := srv.Serve(conn); err != nil && err != http.ErrServerClosed {
+		log.Printf("Error on http3 server: %v", err)
+	}
+}
+
+// altSvc announces the http3 server on the udp port to the clients of the
+// http2 server with the header Alt-Svc. Browsers use http3 for the next
+// requests.
+//
+// The header is not taken from the http3 server, since it is only known after
+// the server listens.
+func altSvc(port string, next http.Handler) http.Handler {
+	header := fmt.Sprintf(`h3=":%s"; ma=2592000`, port)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Alt-Svc", header)
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// loadtestTopic is the redis stream, that is read by the autoupdate service.
+const loadtestTopic = "ModifiedFields"
+
+// cmdLoadtest opens many connections to an autoupdate service and prints how
+// long it took until the first data was received. If updates is greater then
+// zero, it changes the key in redis that many times, after all clients have
+// received the data before. It prints, how long it took from the change until
+// each client received the update.
+func cmdLoadtest(args []string, w io.Writer) error {
+	fs := flag.NewFlagSet("loadtest", flag.ExitOnError)
+	clients := fs.Int("clients", 100, "Number of concurrent connections.")
+	requestFile := fs.String("request", "", "Path to a file with the keysrequest that is sent by each client.")
+	url := fs.String("url", "https://localhost:9012/system/autoupdate", "URL of the autoupdate service.")
+	updates := fs.Int("updates", 0, "Number of updates each client waits for after the first data.")
+	key := fs.String("key", "", "Key, that is changed for each update. It has to be in the keysrequest.")
+	redisAddr := fs.String("redis", "localhost:6379", "Address of the redis server, that is used by the autoupdate service.")
+	timeout := fs.Duration("timeout", time.Minute, "Maximum time of the test.")
+	fs.Parse(args)
+
+	if *requestFile == "" {
+		return fmt.Errorf("flag -request is required")
+	}
+
+	if *updates > 0 && *key == "" {
+		return fmt.Errorf("flag -key is required for updates")
+	}
+
+	body, err := ioutil.ReadFile(*requestFile)
+	if err != nil {
+		return fmt.Errorf("reading request file: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	httpClient := &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig:   &tls.Config{InsecureSkipVerify: true},
+			ForceAttemptHTTP2: true,
+		},
+	}
+
+	var (
+		mu        sync.Mutex
+		ttfb      []time.Duration
+		latencies = make([][]time.Duration, *updates)
+		published = make([]time.Time, *updates)
+		failed    int
+		wg        sync.WaitGroup
+	)
+
+	// stages[0] is done, when each client has received the first data or
+	// failed. stages[u+1] is done, when each client has received the update u
+	// or failed.
+	stages := make([]sync.WaitGroup, *updates+1)
+	for i := range stages {
+		stages[i].Add(*clients)
+	}
+
+	fail := func(err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		fmt.Fprintf(w, "Client error: %v\n", err)
+		failed++
+	}
+
+	for i := 0; i < *clients; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			// stage is the next stage of the client. On return, the
+			// remaining stages are marked as done, so the updates are not
+			// blocked by a failed client.
+			stage := 0
+			defer func() {
+				for ; stage < len(stages); stage++ {
+					stages[stage].Done()
+				}
+			}()
+
+			req, err := http.NewRequestWithContext(ctx, "POST", *url, bytes.NewReader(body))
+			if err != nil {
+				fail(fmt.Errorf("create request: %w", err))
+				return
+			}
+
+			start := time.Now()
+			resp, err := httpClient.Do(req)
+			if err != nil {
+				fail(fmt.Errorf("send request: %w", err))
+				return
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != http.StatusOK {
+				fail(fmt.Errorf("server returned %s", resp.Status))
+				return
+			}
+
+			r := bufio.NewReader(resp.Body)
+			if _, err := r.ReadBytes('\n'); err != nil {
+				fail(fmt.Errorf("reading first data: %w", err))
+				return
+			}
+			d := time.Since(start)
+			mu.Lock()
+			ttfb = append(ttfb, d)
+			mu.Unlock()
+			stages[stage].Done()
+			stage++
+
+			for u := 0; u < *updates; u++ {
+				if _, err := r.ReadBytes('\n'); err != nil {
+					fail(fmt.Errorf("reading update: %w", err))
+					return
+				}
+				now := time.Now()
+				mu.Lock()
+				latencies[u] = append(latencies[u], now.Sub(published[u]))
+				mu.Unlock()
+				stages[stage].Done()
+				stage++
+			}
+		}()
+	}
+
+	if *updates > 0 {
+		if err := triggerUpdates(ctx, *redisAddr, *key, stages, func(u int) {
+			mu.Lock()
+			published[u] = time.Now()
+			mu.Unlock()
+		}); err != nil {
+			fail(fmt.Errorf("trigger updates: %w", err))
+			cancel()
+		}
+	}
+	wg.Wait()
+
+	fmt.Fprintf(w, "Clients: %d, failed: %d\n", *clients, failed)
+	fmt.Fprintf(w, "Time to first data: %s\n", formatPercentiles(ttfb))
+
+	for u, latency := range latencies {
+		if len(latency) == 0 {
+			continue
+		}
+		fmt.Fprintf(w, "Update %d latency after the change: %s\n", u+1, formatPercentiles(latency))
+	}
+	return nil
+}
+
+// triggerUpdates changes the key in redis for each update. Each change waits,
+// until the clients have received the data before. stamp is called with the
+// number of the update directly before the change is published.
+func triggerUpdates(ctx context.Context, addr, key string, stages []sync.WaitGroup, stamp func(u int)) error {
+	conn, err := redis.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return fmt.Errorf("connect to redis: %w", err)
+	}
+	defer conn.Close()
+
+	for u := 0; u < len(stages)-1; u++ {
+		stages[u].Wait()
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		value := fmt.Sprintf(`"loadtest %d"`, time.Now().UnixNano())
+		stamp(u)
+		if _, err := conn.Do("XADD", loadtestTopic, "*", key, value); err != nil {
+			return fmt.Errorf("publish update %d: %w", u+1, err)
+		}
+	}
+	return nil
+}
+
+// formatPercentiles returns the 50th, 90th and 99th percentile and the maximum
+// of the given durations.
+func formatPercentiles(d []time.Duration) string {
+	if len(d) == 0 {
+		return "no data"
+	}
+
+	sort.Slice(d, func(i, j int) bool { return d[i] < d[j] })
+	p := func(percent int) time.Duration {
+		return d[(len(d)-1)*percent/100]
+	}
+	return fmt.Sprintf("p50=%s p90=%s p99=%s max=%s", p(50), p(90), p(99), d[len(d)-1])
+}
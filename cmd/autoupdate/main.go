@@ -3,21 +3,30 @@ package main
 import (
 	"context"
 	"crypto/tls"
+	"encoding/json"
 	"fmt"
+	"io"
 	"log"
 	"net"
 	"net/http"
 	"os"
 	"os/signal"
 	"path"
+	"strconv"
+	"strings"
 	"syscall"
+	"time"
 
 	"github.com/openslides/openslides-autoupdate-service/internal/autoupdate"
 	"github.com/openslides/openslides-autoupdate-service/internal/datastore"
+	autoupdateGrpc "github.com/openslides/openslides-autoupdate-service/internal/grpc"
 	autoupdateHttp "github.com/openslides/openslides-autoupdate-service/internal/http"
+	"github.com/openslides/openslides-autoupdate-service/internal/keysbuilder"
 	"github.com/openslides/openslides-autoupdate-service/internal/redis"
 	"github.com/openslides/openslides-autoupdate-service/internal/restrict"
 	"github.com/openslides/openslides-autoupdate-service/internal/test"
+	"github.com/openslides/openslides-autoupdate-service/internal/vote"
+	"github.com/openslides/openslides-autoupdate-service/internal/webhook"
 )
 
 const (
@@ -28,6 +37,11 @@ const (
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		runCommand(os.Args[1], os.Args[2:])
+		return
+	}
+
 	closed := make(chan struct{})
 
 	errHandler := func(err error) {
@@ -35,46 +49,323 @@ func main() {
 	}
 
 	// Datastore Service.
-	datastoreService, err := buildDatastore(closed, errHandler)
+	datastoreService, err := buildDatastore(closed, errHandler, os.Stdin)
 	if err != nil {
 		log.Fatalf("Can not create datastore service: %v", err)
 	}
 
-	// Perm Service.
-	perms := &test.MockPermission{}
-	perms.Default = true
+	// Autoupdate Service.
+	autoupdateOptions := []autoupdate.Option{
+		autoupdate.WithAggregates(autoupdate.DefaultAggregates()...),
+	}
+	if v := getEnv("AUTOUPDATE_CONCURRENCY", ""); v != "" {
+		concurrency, err := strconv.Atoi(v)
+		if err != nil || concurrency < 1 {
+			log.Fatalf("Invalid value for AUTOUPDATE_CONCURRENCY: %s", v)
+		}
+		autoupdateOptions = append(autoupdateOptions, autoupdate.WithConcurrency(concurrency))
+	}
+	// Live results of the vote service.
+	var ds autoupdate.Datastore = datastoreService
+	if voteURL := getEnv("VOTE_URL", ""); voteURL != "" {
+		fmt.Println("Live poll results from:", voteURL)
+		ds = vote.New(voteURL, datastoreService, closed, errHandler)
+	}
 
-	// Restricter Service.
-	restricter := restrict.New(perms, restrict.OpenSlidesChecker(perms))
+	// A higher priority is only allowed for admins, projector managers and
+	// chairpersons.
+	autoupdateOptions = append(autoupdateOptions, autoupdate.WithPriorityPermitter(restrict.NewGroupPriority(ds)))
+	restricter := buildRestricter(ds)
+	service := autoupdate.New(ds, restricter, closed, autoupdateOptions...)
 
-	// Autoupdate Service.
-	service := autoupdate.New(datastoreService, restricter, closed)
+	// Webhooks.
+	if hookFile := getEnv("WEBHOOK_CONFIG", ""); hookFile != "" {
+		hooks, err := loadHooks(hookFile)
+		if err != nil {
+			log.Fatalf("Can not load webhooks: %v", err)
+		}
+		fmt.Printf("Use %d webhooks from: %s\n", len(hooks), hookFile)
+		datastoreService.RegisterChangeListener(webhook.New(hooks, service, closed, errHandler).OnChange)
+	}
 
 	// Auth Service.
 	authService := buildAuth()
 
+	// Restriction definition and model metadata.
+	definition := restrict.DefaultDefinition()
+	definitionFile := getEnv("RESTRICTION_DEFINITION", "")
+	if definitionFile != "" {
+		definition, err = loadDefinition(definitionFile)
+		if err != nil {
+			log.Fatalf("Can not load restriction definition: %v", err)
+		}
+		restricter.SetDefinition(definition)
+		fmt.Printf("Use %d relation fields from: %s\n", len(definition), definitionFile)
+	}
+
+	models, err := restrict.DefinitionInfo(definition)
+	if err != nil {
+		log.Fatalf("Can not create model metadata: %v", err)
+	}
+	encodedModels, err := json.Marshal(models)
+	if err != nil {
+		log.Fatalf("Can not encode model metadata: %v", err)
+	}
+
+	// Meeting quotas.
+	maxMeetingConnections, err := strconv.Atoi(getEnv("MEETING_MAX_CONNECTIONS", "0"))
+	if err != nil {
+		log.Fatalf("Invalid value for MEETING_MAX_CONNECTIONS: %v", err)
+	}
+	maxMeetingBytes, err := strconv.Atoi(getEnv("MEETING_MAX_BYTES_PER_SECOND", "0"))
+	if err != nil {
+		log.Fatalf("Invalid value for MEETING_MAX_BYTES_PER_SECOND: %v", err)
+	}
+
+	userBandwidthLimit, err := strconv.Atoi(getEnv("USER_MAX_BYTES_PER_SECOND", "0"))
+	if err != nil {
+		log.Fatalf("Invalid value for USER_MAX_BYTES_PER_SECOND: %v", err)
+	}
+
+	// IP filter.
+	ipAllow, err := parseNetworks(getEnv("IP_ALLOW", ""))
+	if err != nil {
+		log.Fatalf("Invalid value for IP_ALLOW: %v", err)
+	}
+	ipDeny, err := parseNetworks(getEnv("IP_DENY", ""))
+	if err != nil {
+		log.Fatalf("Invalid value for IP_DENY: %v", err)
+	}
+	maxConnections, err := strconv.Atoi(getEnv("MAX_CONNECTIONS", "0"))
+	if err != nil {
+		log.Fatalf("Invalid value for MAX_CONNECTIONS: %v", err)
+	}
+	maxIPConnections, err := strconv.Atoi(getEnv("IP_MAX_CONNECTIONS", "0"))
+	if err != nil {
+		log.Fatalf("Invalid value for IP_MAX_CONNECTIONS: %v", err)
+	}
+	trustedProxies, err := parseNetworks(getEnv("TRUSTED_PROXIES", ""))
+	if err != nil {
+		log.Fatalf("Invalid value for TRUSTED_PROXIES: %v", err)
+	}
+	ipRate, err := strconv.ParseFloat(getEnv("IP_RATE_LIMIT", "0"), 64)
+	if err != nil {
+		log.Fatalf("Invalid value for IP_RATE_LIMIT: %v", err)
+	}
+	ipRateBurst, err := strconv.Atoi(getEnv("IP_RATE_BURST", "10"))
+	if err != nil {
+		log.Fatalf("Invalid value for IP_RATE_BURST: %v", err)
+	}
+	maxBodySize, err := strconv.ParseInt(getEnv("MAX_BODY_SIZE", "1048576"), 10, 64)
+	if err != nil {
+		log.Fatalf("Invalid value for MAX_BODY_SIZE: %v", err)
+	}
+
+	kbMaxDepth, err := strconv.Atoi(getEnv("KEYSBUILDER_MAX_DEPTH", "0"))
+	if err != nil {
+		log.Fatalf("Invalid value for KEYSBUILDER_MAX_DEPTH: %v", err)
+	}
+
+	kbMaxKeys, err := strconv.Atoi(getEnv("KEYSBUILDER_MAX_KEYS", "0"))
+	if err != nil {
+		log.Fatalf("Invalid value for KEYSBUILDER_MAX_KEYS: %v", err)
+	}
+
+	// Keysbuilder presets.
+	var presets *keysbuilder.Presets
+	if presetFile := getEnv("KEYSBUILDER_PRESETS", ""); presetFile != "" {
+		presets, err = loadPresets(presetFile)
+		if err != nil {
+			log.Fatalf("Can not load keysbuilder presets: %v", err)
+		}
+		fmt.Printf("Use %d keysbuilder presets from: %s\n", len(presets.Names()), presetFile)
+	}
+
+	// Model schema.
+	var schema *keysbuilder.Schema
+	if schemaFile := getEnv("MODELS_SCHEMA", ""); schemaFile != "" {
+		schema, err = loadSchema(schemaFile)
+		if err != nil {
+			log.Fatalf("Can not load model schema: %v", err)
+		}
+		fmt.Printf("Use model schema from: %s\n", schemaFile)
+	}
+
 	// HTTP Hanlder.
-	handler := autoupdateHttp.New(service, authService)
+	reloader := &restrictionReloader{restricter: restricter}
+	handlerOptions := []autoupdateHttp.Option{
+		autoupdateHttp.WithModels(encodedModels, models.Version),
+		autoupdateHttp.WithReadiness(datastoreService.Ready),
+		autoupdateHttp.WithHealth(datastoreService.Health),
+		autoupdateHttp.WithMeetingQuota(maxMeetingConnections, maxMeetingBytes),
+		autoupdateHttp.WithUserBandwidthLimit(userBandwidthLimit),
+		autoupdateHttp.WithTrustedProxies(trustedProxies),
+		autoupdateHttp.WithIPFilter(ipAllow, ipDeny),
+		autoupdateHttp.WithConnectionLimit(maxConnections),
+		autoupdateHttp.WithIPConnectionLimit(maxIPConnections),
+		autoupdateHttp.WithIPRateLimit(ipRate, ipRateBurst),
+		autoupdateHttp.WithMaxBodySize(maxBodySize),
+		autoupdateHttp.WithCORS(
+			parseList(getEnv("CORS_ALLOWED_ORIGINS", "")),
+			parseList(getEnv("CORS_ALLOWED_HEADERS", "")),
+			parseList(getEnv("CORS_ALLOWED_METHODS", "")),
+		),
+		autoupdateHttp.WithPresets(presets),
+		autoupdateHttp.WithSchema(schema),
+		autoupdateHttp.WithKeysbuilderMaxDepth(kbMaxDepth),
+		autoupdateHttp.WithKeysbuilderMaxKeys(kbMaxKeys),
+		autoupdateHttp.WithRestrictionReload(reloader.reload),
+	}
+	if getEnv("GZIP_ACCEPT_ENCODING", "true") == "true" {
+		handlerOptions = append(
+			handlerOptions,
+			autoupdateHttp.WithAcceptEncoding(),
+			autoupdateHttp.WithEncoding("br", autoupdateHttp.NewBrotliEncoder),
+		)
+	}
+	keepaliveInterval, err := time.ParseDuration(getEnv("KEEPALIVE_INTERVAL", "0s"))
+	if err != nil {
+		log.Fatalf("Invalid value for KEEPALIVE_INTERVAL: %v", err)
+	}
+	if keepaliveInterval > 0 {
+		fmt.Printf("Send keepalive messages after %s without data\n", keepaliveInterval)
+		handlerOptions = append(handlerOptions, autoupdateHttp.WithKeepalive(keepaliveInterval))
+	}
+	firstTimeout, err := time.ParseDuration(getEnv("FIRST_RESPONSE_TIMEOUT", "0s"))
+	if err != nil {
+		log.Fatalf("Invalid value for FIRST_RESPONSE_TIMEOUT: %v", err)
+	}
+	if firstTimeout > 0 {
+		handlerOptions = append(handlerOptions, autoupdateHttp.WithFirstResponseTimeout(firstTimeout))
+	}
+	if getEnv("STATS_ADDR", "") != "" {
+		handlerOptions = append(handlerOptions, autoupdateHttp.WithMetrics())
+	}
+	if getEnv("ACCESS_LOG", "false") == "true" {
+		handlerOptions = append(handlerOptions, autoupdateHttp.WithAccessLog(os.Stdout))
+	}
+	useTLS := getEnv("AUTOUPDATE_TLS", "on") != "off"
+	if !useTLS {
+		// Without TLS, only clients with prior knowledge use http2. Allow
+		// http 1.1 for the other clients.
+		handlerOptions = append(handlerOptions, autoupdateHttp.WithHTTP1())
+	}
 
-	// Create tls http2 server.
-	cert, err := getCert()
+	handler := autoupdateHttp.New(service, authService, handlerOptions...)
+	reloader.handler = handler
+
+	// Reload the restriction definition on SIGHUP.
+	if definitionFile != "" {
+		go reloader.reloadOnSignal(closed, definitionFile)
+	}
+
+	// Stats logging.
+	statsInterval, err := strconv.Atoi(getEnv("STATS_INTERVAL", "0"))
 	if err != nil {
-		log.Fatalf("Can not get certificate: %v", err)
+		log.Fatalf("Invalid value for STATS_INTERVAL: %v", err)
+	}
+	if statsInterval > 0 {
+		go logStats(closed, time.Duration(statsInterval)*time.Second, handler, datastoreService)
+	}
+
+	// Internal stats endpoint.
+	if statsAddr := getEnv("STATS_ADDR", ""); statsAddr != "" {
+		fmt.Println("Stats on:", statsAddr)
+		go serveStats(closed, statsAddr, getEnv("ADMIN_TOKEN", ""), getEnv("PPROF", "false") == "true", handler, datastoreService)
 	}
 
-	listenAddr := getEnv("AUTOUPDATE_HOST", "") + ":" + getEnv("AUTOUPDATE_PORT", "9012")
+	// Internal endpoints for other services and the operators.
+	if internalAddr := getEnv("INTERNAL_ADDR", ""); internalAddr != "" {
+		fmt.Println("Internal endpoints on:", internalAddr)
+		mux := statsMux(getEnv("ADMIN_TOKEN", ""), getEnv("PPROF", "false") == "true", handler, datastoreService)
+		mux.Handle("/healthz", handler)
+		mux.Handle("/readyz", handler)
+		mux.Handle("/system/autoupdate/health", handler)
+		mux.Handle("/internal/", http.StripPrefix("/internal", handler.InternalHandler()))
+		go serveInternal(closed, "internal", internalAddr, mux)
+	}
+
+	// Internal grpc api for other services.
+	if grpcAddr := getEnv("GRPC_ADDR", ""); grpcAddr != "" {
+		fmt.Println("Grpc api on:", grpcAddr)
+		grpcServer := autoupdateGrpc.New(
+			service,
+			keysbuilder.WithSchema(schema),
+			keysbuilder.WithMaxDepth(kbMaxDepth),
+			keysbuilder.WithMaxKeys(kbMaxKeys),
+		)
+		go func() {
+			if err := autoupdateGrpc.Serve(closed, grpcAddr, grpcServer); err != nil {
+				log.Printf("Error on grpc server: %v", err)
+			}
+		}()
+	}
+
+	// StatsD exporter.
+	if statsdAddr := getEnv("STATSD_ADDR", ""); statsdAddr != "" {
+		statsdInterval, err := time.ParseDuration(getEnv("STATSD_INTERVAL", "10s"))
+		if err != nil {
+			log.Fatalf("Invalid value for STATSD_INTERVAL: %v", err)
+		}
+		fmt.Println("Send stats to statsd:", statsdAddr)
+		go pushStatsd(closed, statsdAddr, getEnv("STATSD_PREFIX", "autoupdate."), statsdInterval, handler, datastoreService)
+	}
+
+	// Profiling on SIGUSR1.
+	go profileOnSignal(closed, getEnv("PROFILE_DIR", "/tmp/autoupdate-profiles"))
+
+	drainTime, err := time.ParseDuration(getEnv("DRAIN_TIME", "0s"))
+	if err != nil {
+		log.Fatalf("Invalid value for DRAIN_TIME: %v", err)
+	}
+
+	gracePeriod, err := time.ParseDuration(getEnv("SHUTDOWN_GRACE_PERIOD", "0s"))
+	if err != nil {
+		log.Fatalf("Invalid value for SHUTDOWN_GRACE_PERIOD: %v", err)
+	}
+
+	useHTTP3 := getEnv("HTTP3", "false") == "true"
+	port := getEnv("AUTOUPDATE_PORT", "9012")
+	listenAddr := getEnv("AUTOUPDATE_HOST", "") + ":" + port
 	srv := &http.Server{Addr: listenAddr, Handler: handler}
-	tlsConf := new(tls.Config)
-	tlsConf.NextProtos = []string{"h2"}
-	tlsConf.Certificates = []tls.Certificate{cert}
 
-	ln, err := net.Listen("tcp", listenAddr)
+	ln, err := listen(listenAddr, getEnv("AUTOUPDATE_SOCKET", ""))
 	if err != nil {
-		log.Fatalf("Can not listen on %s: %v", listenAddr, err)
+		log.Fatalf("Can not listen: %v", err)
 	}
 	defer ln.Close()
 
-	tlsListener := tls.NewListener(ln, tlsConf)
+	if useTLS {
+		// Create tls http2 server.
+		cert, err := getCert()
+		if err != nil {
+			log.Fatalf("Can not get certificate: %v", err)
+		}
+
+		tlsConf := new(tls.Config)
+		// http/1.1 is only used for websockets. All other urls need http2.
+		tlsConf.NextProtos = []string{"h2", "http/1.1"}
+		tlsConf.Certificates = []tls.Certificate{cert}
+		ln = tls.NewListener(ln, tlsConf)
+
+		if useHTTP3 {
+			h3 := newHTTP3(listenAddr, cert, handler)
+			srv.Handler = altSvc(port, handler)
+			go serveHTTP3(closed, h3)
+		}
+	} else {
+		fmt.Println("Serve plain http without TLS")
+
+		// Serve unencrypted http2 (h2c) and http 1.1.
+		var protocols http.Protocols
+		protocols.SetHTTP1(true)
+		protocols.SetUnencryptedHTTP2(true)
+		srv.Protocols = &protocols
+
+		if useHTTP3 {
+			fmt.Println("HTTP3 is disabled, since it needs TLS")
+		}
+	}
 
 	// Shutdown logig in separate goroutine.
 	shutdownDone := make(chan struct{})
@@ -82,19 +373,56 @@ func main() {
 		defer close(shutdownDone)
 		waitForShutdown()
 
+		// Flip the readiness first, so no new connections are sent to this
+		// instance, before the existing connections are closed.
+		handler.Drain()
+		time.Sleep(drainTime)
+
+		// Ask the clients to reconnect to another instance and give them
+		// some time to do so.
+		ctx, cancel := context.WithTimeout(context.Background(), gracePeriod)
+		if err := handler.Shutdown(ctx); err != nil {
+			log.Printf("Closing connections after shutdown grace period: %v", err)
+		}
+		cancel()
+
 		close(closed)
 		if err := srv.Shutdown(context.Background()); err != nil {
 			log.Printf("Error on HTTP server shutdown: %v", err)
 		}
 	}()
 
-	fmt.Printf("Listen on %s\n", listenAddr)
-	if err := srv.Serve(tlsListener); err != http.ErrServerClosed {
+	fmt.Printf("Listen on %s\n", ln.Addr())
+	if err := srv.Serve(ln); err != http.ErrServerClosed {
 		log.Fatalf("HTTP Server Error: %v", err)
 	}
 	<-shutdownDone
 }
 
+// listen opens a tcp listener on addr. If socket is not empty, it opens a unix
+// socket with this path instead.
+//
+// An old socket file, for example from a crashed process, is removed before.
+func listen(addr, socket string) (net.Listener, error) {
+	if socket == "" {
+		ln, err := net.Listen("tcp", addr)
+		if err != nil {
+			return nil, fmt.Errorf("listen on %s: %w", addr, err)
+		}
+		return ln, nil
+	}
+
+	if err := os.Remove(socket); err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("remove old socket %s: %w", socket, err)
+	}
+
+	ln, err := net.Listen("unix", socket)
+	if err != nil {
+		return nil, fmt.Errorf("listen on unix socket %s: %w", socket, err)
+	}
+	return ln, nil
+}
+
 func getCert() (tls.Certificate, error) {
 	certDir := getEnv("CERT_DIR", "")
 	if certDir == "" {
@@ -151,33 +479,153 @@ func waitForShutdown() {
 
 // buildDatastore builds the datastore implementation needed by the autoupdate
 // service. It uses environment variables to make the decission. Per default, a
-// fake server is started and its url is used.
-func buildDatastore(closed <-chan struct{}, errHandler func(error)) (autoupdate.Datastore, error) {
+// fake server is started and its url is used. The fake datastore reads the
+// changed keys from updates.
+func buildDatastore(closed <-chan struct{}, errHandler func(error), updates io.Reader) (*datastore.Datastore, error) {
 	var f *faker
 	var url string
+	var receiver datastore.Updater
 	dsService := getEnv("DATASTORE", "fake")
 	switch dsService {
 	case "fake":
 		fmt.Println("Fake Datastore")
-		f = newFaker(os.Stdin)
+		f = newFaker(updates)
 		url = f.ts.TS.URL
 
+		if dataFile := getEnv("DATASTORE_EXAMPLE_DATA", ""); dataFile != "" {
+			if err := loadExampleData(f, dataFile); err != nil {
+				return nil, fmt.Errorf("load example data: %w", err)
+			}
+			fmt.Println("Use example data from:", dataFile)
+		}
+
 	case "service":
 		host := getEnv("DATASTORE_READER_HOST", "localhost")
 		port := getEnv("DATASTORE_READER_PORT", "9010")
 		protocol := getEnv("DATASTORE_READER_PROTOCOL", "http")
 		url = protocol + "://" + host + ":" + port
 
+	case "replay":
+		replayFile := getEnv("REPLAY_FILE", "autoupdate-record.jsonl")
+		fmt.Println("Replay Datastore and Messaging from:", replayFile)
+		file, err := os.Open(replayFile)
+		if err != nil {
+			return nil, fmt.Errorf("open replay file: %w", err)
+		}
+		defer file.Close()
+
+		rp, err := newReplayer(file)
+		if err != nil {
+			return nil, fmt.Errorf("load replay file: %w", err)
+		}
+		url = rp.ts.TS.URL
+		receiver = rp
+
 	default:
 		return nil, fmt.Errorf("unknown datastore %s", dsService)
 	}
 
+	if receiver == nil {
+		var err error
+		receiver, err = buildReceiver(f)
+		if err != nil {
+			return nil, fmt.Errorf("build receiver: %w", err)
+		}
+	}
+
+	recordFile, err := openRecordFile()
+	if err != nil {
+		return nil, fmt.Errorf("open record file: %w", err)
+	}
+	if recordFile != nil {
+		rec := newRecorder(recordFile)
+		url = rec.proxy(url, errHandler)
+		receiver = rec.updater(receiver)
+	}
+
+	var options []datastore.Option
+	if getEnv("STATS_ADDR", "") != "" {
+		options = append(options, datastore.WithHotKeys())
+	}
+
+	compressThreshold, err := strconv.Atoi(getEnv("CACHE_COMPRESS_THRESHOLD", "0"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid value for CACHE_COMPRESS_THRESHOLD: %w", err)
+	}
+	if compressThreshold > 0 {
+		fmt.Printf("Compress cache values bigger then %d bytes\n", compressThreshold)
+		options = append(options, datastore.WithCompression(compressThreshold))
+	}
+
+	cacheMaxKeys, err := strconv.Atoi(getEnv("CACHE_MAX_KEYS", "0"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid value for CACHE_MAX_KEYS: %w", err)
+	}
+	if cacheMaxKeys > 0 {
+		fmt.Printf("Keep at most %d keys in the cache\n", cacheMaxKeys)
+		options = append(options, datastore.WithCacheLimit(cacheMaxKeys))
+	}
+
+	if getEnv("CACHE_ARENA", "false") == "true" {
+		fmt.Println("Save small cache values in an arena")
+		options = append(options, datastore.WithArena())
+	}
+
+	requestTimeout, err := time.ParseDuration(getEnv("DATASTORE_READER_TIMEOUT", "0s"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid value for DATASTORE_READER_TIMEOUT: %w", err)
+	}
+	if requestTimeout > 0 {
+		fmt.Printf("Deliver keys, that are not read in %s, later\n", requestTimeout)
+		options = append(options, datastore.WithRequestTimeout(requestTimeout))
+	}
+
 	fmt.Println("Datastore URL:", url)
-	receiver, err := buildReceiver(f)
+	return datastore.New(url, closed, errHandler, receiver, options...), nil
+}
+
+// loadExampleData loads the data of the fake datastore from a file.
+func loadExampleData(f *faker, fileName string) error {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return fmt.Errorf("open file: %w", err)
+	}
+	defer file.Close()
+
+	return f.loadExampleData(file)
+}
+
+// loadHooks loads the webhook configuration from a file.
+func loadHooks(fileName string) ([]webhook.Hook, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return nil, fmt.Errorf("open file: %w", err)
+	}
+	defer file.Close()
+
+	return webhook.LoadHooks(file)
+}
+
+// loadPresets loads the keysbuilder presets from a file.
+func loadPresets(fileName string) (*keysbuilder.Presets, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return nil, fmt.Errorf("open file: %w", err)
+	}
+	defer file.Close()
+
+	return keysbuilder.ParsePresets(file)
+}
+
+// loadSchema loads the fields of the models from a file.
+func loadSchema(fileName string) (*keysbuilder.Schema, error) {
+	file, err := os.Open(fileName)
 	if err != nil {
-		return nil, fmt.Errorf("build receiver: %w", err)
+		return nil, fmt.Errorf("open file: %w", err)
 	}
-	return datastore.New(url, closed, errHandler, receiver), nil
+	defer file.Close()
+
+	return keysbuilder.ParseSchema(file)
 }
 
 // buildReceiver builds the receiver needed by the datastore service. It uses
@@ -210,11 +658,67 @@ func buildReceiver(f *faker) (datastore.Updater, error) {
 	return receiver, nil
 }
 
+// buildRestricter returns the restricter needed by the autoupdate service.
+//
+// Currently, the permission service is a mock that allows everything. Only
+// the mediafiles are restricted by their access groups.
+func buildRestricter(ds restrict.Datastore) *restrict.Restricter {
+	mockPerms := &test.MockPermission{}
+	mockPerms.Default = true
+	perms := restrict.NewMediafilePermission(mockPerms, ds)
+	return restrict.New(perms, restrict.OpenSlidesChecker(perms))
+}
+
 // buildAuth returns the auth service needed by the http server.
 //
-// Currently, there is only the fakeAuth service.
+// Currently, there is only the mock auth service. Requests without a token
+// are authenticated as user 1.
 func buildAuth() autoupdateHttp.Authenticator {
-	return fakeAuth(1)
+	return &test.MockAuth{Default: 1}
+}
+
+// parseNetworks parses a comma separated list of networks in CIDR notation.
+// A single ip address is handled as a network with only this address.
+func parseNetworks(value string) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		if !strings.Contains(part, "/") {
+			ip := net.ParseIP(part)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid ip address %s", part)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = 8 * net.IPv4len
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(part)
+		if err != nil {
+			return nil, fmt.Errorf("parsing network %s: %w", part, err)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// parseList parses a comma separated list. Empty entries are ignored.
+func parseList(value string) []string {
+	var list []string
+	for _, part := range strings.Split(value, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			list = append(list, part)
+		}
+	}
+	return list
 }
 
 // getEnv returns the value of the environment variable env. If it is empty, the
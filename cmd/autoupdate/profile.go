@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	httpPprof "net/http/pprof"
+	"os"
+	"os/signal"
+	"path"
+	"runtime/pprof"
+	"syscall"
+	"time"
+)
+
+// profileOnSignal writes a heap and a goroutine profile into dir each time the
+// process receives SIGUSR1. Blocks until the service is closed.
+func profileOnSignal(closed <-chan struct{}, dir string) {
+	sigusr1 := make(chan os.Signal, 1)
+	signal.Notify(sigusr1, syscall.SIGUSR1)
+	defer signal.Stop(sigusr1)
+
+	for {
+		select {
+		case <-closed:
+			return
+		case <-sigusr1:
+			if err := writeProfiles(dir, time.Now()); err != nil {
+				log.Printf("Error: writing profiles: %v", err)
+			}
+		}
+	}
+}
+
+// writeProfiles writes the heap and goroutine profiles into dir. The file
+// names contain the given time.
+func writeProfiles(dir string, t time.Time) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("creating profile dir: %w", err)
+	}
+
+	timestamp := t.Format("20060102-150405")
+	for _, name := range []string{"heap", "goroutine"} {
+		fileName := path.Join(dir, fmt.Sprintf("%s-%s.pprof", name, timestamp))
+		if err := writeProfile(name, fileName); err != nil {
+			return fmt.Errorf("writing %s profile: %w", name, err)
+		}
+		log.Printf("Wrote %s profile to %s", name, fileName)
+	}
+	return nil
+}
+
+func writeProfile(name, fileName string) (err error) {
+	f, err := os.Create(fileName)
+	if err != nil {
+		return fmt.Errorf("creating file: %w", err)
+	}
+	defer func() {
+		if cerr := f.Close(); err == nil && cerr != nil {
+			err = fmt.Errorf("closing file: %w", cerr)
+		}
+	}()
+
+	if err := pprof.Lookup(name).WriteTo(f, 0); err != nil {
+		return fmt.Errorf("writing profile: %w", err)
+	}
+	return nil
+}
+
+// registerPprof registers the handlers of net/http/pprof on the mux under
+// /debug/pprof/. They can be used with `go tool pprof` to profile a running
+// instance.
+func registerPprof(mux *http.ServeMux) {
+	mux.HandleFunc("/debug/pprof/", httpPprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", httpPprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", httpPprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", httpPprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", httpPprof.Trace)
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/openslides/openslides-autoupdate-service/internal/datastore"
+	"github.com/openslides/openslides-autoupdate-service/internal/test"
+)
+
+const (
+	eventGet    = "get"
+	eventUpdate = "update"
+)
+
+// recordEvent is one line in a record file.
+type recordEvent struct {
+	// Time since the start of the recording in milliseconds.
+	Time int64                      `json:"time"`
+	Type string                     `json:"type"`
+	Data map[string]json.RawMessage `json:"data"`
+}
+
+// recorder writes all responses from the datastore and all messages from the
+// message bus into a file.
+//
+// Has to be created with newRecorder().
+type recorder struct {
+	mu    sync.Mutex
+	enc   *json.Encoder
+	start time.Time
+}
+
+func newRecorder(w io.Writer) *recorder {
+	return &recorder{
+		enc:   json.NewEncoder(w),
+		start: time.Now(),
+	}
+}
+
+func (r *recorder) record(eventType string, data map[string]json.RawMessage) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	event := recordEvent{
+		Time: int64(time.Since(r.start) / time.Millisecond),
+		Type: eventType,
+		Data: data,
+	}
+	if err := r.enc.Encode(event); err != nil {
+		return fmt.Errorf("writing %s event: %w", eventType, err)
+	}
+	return nil
+}
+
+// proxy starts a http server that forwards all requests to the datastore
+// reader at url and records the responses. It returns the url of the proxy.
+func (r *recorder) proxy(url string, errHandler func(error)) string {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		resp, err := http.Post(url+req.URL.Path, "application/json", req.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		defer resp.Body.Close()
+
+		body, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+
+		if resp.StatusCode == http.StatusOK {
+			var data map[string]map[string]map[string]json.RawMessage
+			if err := json.Unmarshal(body, &data); err != nil {
+				errHandler(fmt.Errorf("decoding datastore response for recording: %w", err))
+			} else if err := r.record(eventGet, flattenDatastoreResponse(data)); err != nil {
+				errHandler(err)
+			}
+		}
+
+		w.WriteHeader(resp.StatusCode)
+		w.Write(body)
+	}))
+	return ts.URL
+}
+
+// updater wraps a datastore.Updater and records all its messages.
+func (r *recorder) updater(u datastore.Updater) datastore.Updater {
+	return recordingUpdater{recorder: r, updater: u}
+}
+
+type recordingUpdater struct {
+	recorder *recorder
+	updater  datastore.Updater
+}
+
+func (u recordingUpdater) Update() (map[string]json.RawMessage, error) {
+	data, err := u.updater.Update()
+	if err != nil || len(data) == 0 {
+		return data, err
+	}
+
+	if err := u.recorder.record(eventUpdate, data); err != nil {
+		return nil, fmt.Errorf("recording update: %w", err)
+	}
+	return data, nil
+}
+
+// replayer implements the datastore.Updater interface and serves a fake
+// datastore from a recorded file.
+//
+// The events are replayed in the recorded order. The datastore starts with the
+// values of the gets before the first update. The updates are returned at the
+// same time relative to the start of the replayer as they were recorded. Each
+// update also changes the data of the datastore. The values of the gets after
+// an update are added to the datastore after the update before them, so keys,
+// that were first requested later, have the value of their time.
+//
+// Has to be created with newReplayer().
+type replayer struct {
+	ts     *test.DatastoreServer
+	events []recordEvent
+	start  time.Time
+}
+
+func newReplayer(r io.Reader) (*replayer, error) {
+	rp := &replayer{
+		ts: test.NewDatastoreServer(),
+	}
+	rp.ts.OnlyData = true
+
+	initial := make(map[string]json.RawMessage)
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 64*1024*1024)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		var event recordEvent
+		if err := json.Unmarshal(line, &event); err != nil {
+			return nil, fmt.Errorf("decoding event: %w", err)
+		}
+
+		switch event.Type {
+		case eventGet:
+			if len(rp.events) > 0 {
+				rp.events = append(rp.events, event)
+				continue
+			}
+
+			for k, v := range event.Data {
+				if _, ok := initial[k]; !ok {
+					initial[k] = v
+				}
+			}
+		case eventUpdate:
+			rp.events = append(rp.events, event)
+		default:
+			return nil, fmt.Errorf("unknown event type %s", event.Type)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading record: %w", err)
+	}
+
+	rp.ts.Update(initial)
+	rp.start = time.Now()
+	return rp, nil
+}
+
+// Update returns the next recorded update at its recorded time. The gets
+// before it are added to the datastore without waiting. Blocks forever when
+// all updates are returned.
+func (rp *replayer) Update() (map[string]json.RawMessage, error) {
+	for len(rp.events) > 0 {
+		event := rp.events[0]
+		rp.events = rp.events[1:]
+
+		if event.Type == eventGet {
+			rp.ts.Update(event.Data)
+			continue
+		}
+
+		wait := time.Duration(event.Time)*time.Millisecond - time.Since(rp.start)
+		time.Sleep(wait)
+
+		rp.ts.Update(event.Data)
+		return event.Data, nil
+	}
+
+	select {}
+}
+
+// openRecordFile opens the file for recording. Returns nil, if no file is
+// configured.
+func openRecordFile() (*os.File, error) {
+	fileName := getEnv("RECORD_FILE", "")
+	if fileName == "" {
+		return nil, nil
+	}
+
+	f, err := os.Create(fileName)
+	if err != nil {
+		return nil, fmt.Errorf("creating record file: %w", err)
+	}
+	fmt.Println("Record traffic to:", fileName)
+	return f, nil
+}
+
+// flattenDatastoreResponse converts the nested datastore response to
+// key-values.
+func flattenDatastoreResponse(data map[string]map[string]map[string]json.RawMessage) map[string]json.RawMessage {
+	keyValue := make(map[string]json.RawMessage)
+	for collection, idField := range data {
+		for id, fieldValue := range idField {
+			for field, value := range fieldValue {
+				keyValue[strings.Join([]string{collection, id, field}, "/")] = value
+			}
+		}
+	}
+	return keyValue
+}
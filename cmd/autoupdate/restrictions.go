@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	autoupdateHttp "github.com/openslides/openslides-autoupdate-service/internal/http"
+	"github.com/openslides/openslides-autoupdate-service/internal/restrict"
+)
+
+// restrictionReloader replaces the restriction definition of the restricter
+// and the model metadata of the handler at runtime.
+type restrictionReloader struct {
+	restricter *restrict.Restricter
+
+	// handler has to be set, before reload is called.
+	handler *autoupdateHttp.Handler
+}
+
+// reload reads a definition from r. If it is valid, the restricter and the
+// model metadata are replaced. Otherwise, the old definition is kept.
+func (rr *restrictionReloader) reload(r io.Reader) error {
+	def, err := restrict.ParseDefinition(r)
+	if err != nil {
+		return fmt.Errorf("parsing definition: %w", err)
+	}
+
+	models, err := restrict.DefinitionInfo(def)
+	if err != nil {
+		return fmt.Errorf("creating model metadata: %w", err)
+	}
+	encodedModels, err := json.Marshal(models)
+	if err != nil {
+		return fmt.Errorf("encoding model metadata: %w", err)
+	}
+
+	rr.restricter.SetDefinition(def)
+	rr.handler.SetModels(encodedModels, models.Version)
+	log.Printf("Reloaded restriction definition with %d relation fields, models version %s", len(def), models.Version)
+	return nil
+}
+
+// reloadFile calls reload with the content of a file.
+func (rr *restrictionReloader) reloadFile(fileName string) error {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return fmt.Errorf("open file: %w", err)
+	}
+	defer file.Close()
+
+	return rr.reload(file)
+}
+
+// reloadOnSignal reloads the definition from the file each time the process
+// receives SIGHUP. Blocks until the service is closed.
+func (rr *restrictionReloader) reloadOnSignal(closed <-chan struct{}, fileName string) {
+	sighup := make(chan os.Signal, 1)
+	signal.Notify(sighup, syscall.SIGHUP)
+	defer signal.Stop(sighup)
+
+	for {
+		select {
+		case <-closed:
+			return
+		case <-sighup:
+			if err := rr.reloadFile(fileName); err != nil {
+				log.Printf("Error: reloading restriction definition: %v", err)
+			}
+		}
+	}
+}
+
+// loadDefinition loads the restriction definition from a file.
+func loadDefinition(fileName string) (restrict.Definition, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return nil, fmt.Errorf("open file: %w", err)
+	}
+	defer file.Close()
+
+	return restrict.ParseDefinition(file)
+}
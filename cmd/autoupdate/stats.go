@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"runtime"
+	"time"
+
+	"github.com/openslides/openslides-autoupdate-service/internal/datastore"
+	autoupdateHttp "github.com/openslides/openslides-autoupdate-service/internal/http"
+)
+
+// logStats prints a line with some statistics of the service every interval.
+// Blocks until the service is closed.
+func logStats(closed <-chan struct{}, interval time.Duration, handler *autoupdateHttp.Handler, ds *datastore.Datastore) {
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+
+	lastMessages := handler.MessageCount()
+	lastTime := time.Now()
+	var mem runtime.MemStats
+
+	for {
+		select {
+		case <-closed:
+			return
+		case now := <-tick.C:
+			messages := handler.MessageCount()
+			msgPerSec := float64(messages-lastMessages) / now.Sub(lastTime).Seconds()
+			lastMessages = messages
+			lastTime = now
+
+			runtime.ReadMemStats(&mem)
+			log.Printf(
+				"Stats: connections=%d goroutines=%d heap=%dKiB cache=%d messages/sec=%.1f",
+				handler.ConnectionCount(),
+				runtime.NumGoroutine(),
+				mem.HeapAlloc/1024,
+				ds.CacheSize(),
+				msgPerSec,
+			)
+		}
+	}
+}
+
+// hotKeyCount is the number of hot keys that are shown on the stats endpoint.
+const hotKeyCount = 20
+
+// serveStats starts a http server on addr that returns the statistics of the
+// service as json. Blocks until the service is closed.
+//
+// If adminToken is not empty, the admin handler is served under /admin/. If
+// withPprof is true, the handlers of net/http/pprof are served under
+// /debug/pprof/.
+func serveStats(closed <-chan struct{}, addr, adminToken string, withPprof bool, handler *autoupdateHttp.Handler, ds *datastore.Datastore) {
+	serveInternal(closed, "stats", addr, statsMux(adminToken, withPprof, handler, ds))
+}
+
+// statsMux returns the urls of the stats server.
+func statsMux(adminToken string, withPprof bool, handler *autoupdateHttp.Handler, ds *datastore.Datastore) *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.Handle("/", statsHandler(handler, ds))
+	mux.Handle("/metrics", metricsHandler(handler, ds))
+	if adminToken != "" {
+		mux.Handle("/admin/", http.StripPrefix("/admin", handler.AdminHandler(adminToken)))
+	}
+	if withPprof {
+		registerPprof(mux)
+	}
+	return mux
+}
+
+// serveInternal starts a plain http server on addr, that is not exposed to
+// the public. Blocks until the service is closed. name is used in the logs.
+func serveInternal(closed <-chan struct{}, name, addr string, h http.Handler) {
+	srv := &http.Server{Addr: addr, Handler: h}
+	go func() {
+		<-closed
+		if err := srv.Shutdown(context.Background()); err != nil {
+			log.Printf("Error on %s server shutdown: %v", name, err)
+		}
+	}()
+
+	if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+		log.Printf("Error on %s server: %v", name, err)
+	}
+}
+
+// stats are the metrics of the service. They are used by the stats endpoint
+// and the statsd exporter.
+type stats struct {
+	Connections int               `json:"connections"`
+	Goroutines  int               `json:"goroutines"`
+	HeapKiB     uint64            `json:"heap_kib"`
+	Cache       int               `json:"cache"`
+	Messages    uint64            `json:"messages"`
+	BytesSent   uint64            `json:"bytes_sent"`
+	HotKeys     datastore.HotKeys `json:"hot_keys,omitempty"`
+}
+
+// readStats returns the current stats of the service. The hot keys are only
+// read, if withHotKeys is true.
+func readStats(handler *autoupdateHttp.Handler, ds *datastore.Datastore, withHotKeys bool) stats {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+
+	s := stats{
+		Connections: handler.ConnectionCount(),
+		Goroutines:  runtime.NumGoroutine(),
+		HeapKiB:     mem.HeapAlloc / 1024,
+		Cache:       ds.CacheSize(),
+		Messages:    handler.MessageCount(),
+		BytesSent:   handler.Bandwidth().Total,
+	}
+	if withHotKeys {
+		s.HotKeys = ds.HotKeys(hotKeyCount)
+	}
+	return s
+}
+
+// statsHandler returns the statistics of the service including the hot keys of
+// the datastore.
+func statsHandler(handler *autoupdateHttp.Handler, ds *datastore.Datastore) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(readStats(handler, ds, true)); err != nil {
+			log.Printf("Error writing stats: %v", err)
+		}
+	})
+}
+
+// metricsHandler returns the metrics of the service in the prometheus text
+// format.
+func metricsHandler(handler *autoupdateHttp.Handler, ds *datastore.Datastore) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s := readStats(handler, ds, false)
+
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		handler.WriteMetrics(w)
+
+		fmt.Fprintln(w, "# HELP autoupdate_goroutines Number of goroutines.")
+		fmt.Fprintln(w, "# TYPE autoupdate_goroutines gauge")
+		fmt.Fprintf(w, "autoupdate_goroutines %d\n", s.Goroutines)
+
+		fmt.Fprintln(w, "# HELP autoupdate_heap_bytes Allocated bytes on the heap.")
+		fmt.Fprintln(w, "# TYPE autoupdate_heap_bytes gauge")
+		fmt.Fprintf(w, "autoupdate_heap_bytes %d\n", s.HeapKiB*1024)
+
+		fmt.Fprintln(w, "# HELP autoupdate_cache_keys Number of keys in the cache.")
+		fmt.Fprintln(w, "# TYPE autoupdate_cache_keys gauge")
+		fmt.Fprintf(w, "autoupdate_cache_keys %d\n", s.Cache)
+	})
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net"
+	"time"
+
+	"github.com/openslides/openslides-autoupdate-service/internal/datastore"
+	autoupdateHttp "github.com/openslides/openslides-autoupdate-service/internal/http"
+)
+
+// pushStatsd sends the stats of the service every interval to a statsd server
+// at addr. Each metric name starts with prefix. Blocks until the service is
+// closed.
+func pushStatsd(closed <-chan struct{}, addr, prefix string, interval time.Duration, handler *autoupdateHttp.Handler, ds *datastore.Datastore) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		log.Printf("Can not connect to statsd: %v", err)
+		return
+	}
+	defer conn.Close()
+
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+
+	lastMessages := handler.MessageCount()
+	lastBytes := handler.Bandwidth().Total
+	var buf bytes.Buffer
+	for {
+		select {
+		case <-closed:
+			return
+		case <-tick.C:
+			s := readStats(handler, ds, false)
+
+			buf.Reset()
+			writeStatsd(&buf, prefix, s, s.Messages-lastMessages, s.BytesSent-lastBytes)
+			lastMessages = s.Messages
+			lastBytes = s.BytesSent
+
+			if _, err := conn.Write(buf.Bytes()); err != nil {
+				log.Printf("Error sending stats to statsd: %v", err)
+			}
+		}
+	}
+}
+
+// writeStatsd writes the stats in the statsd line protocol. The messages and
+// the sent bytes are sent as counter, all other values as gauges.
+func writeStatsd(buf *bytes.Buffer, prefix string, s stats, newMessages, newBytes uint64) {
+	fmt.Fprintf(buf, "%sconnections:%d|g\n", prefix, s.Connections)
+	fmt.Fprintf(buf, "%sgoroutines:%d|g\n", prefix, s.Goroutines)
+	fmt.Fprintf(buf, "%sheap_kib:%d|g\n", prefix, s.HeapKiB)
+	fmt.Fprintf(buf, "%scache:%d|g\n", prefix, s.Cache)
+	fmt.Fprintf(buf, "%smessages:%d|c\n", prefix, newMessages)
+	fmt.Fprintf(buf, "%sbytes_sent:%d|c\n", prefix, newBytes)
+}
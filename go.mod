@@ -1,8 +1,22 @@
 module github.com/openslides/openslides-autoupdate-service
 
-go 1.15
+go 1.25.0
 
 require (
+	github.com/andybalholm/brotli v1.2.0
 	github.com/gomodule/redigo v1.8.2
-	github.com/ostcar/topic v0.3.4-0.20200613094955-61bb28837a98
+	github.com/quic-go/quic-go v0.61.0
+	google.golang.org/grpc v1.84.0
+	google.golang.org/protobuf v1.36.11
+)
+
+require (
+	github.com/davecgh/go-spew v1.1.2-0.20180830191138-d8f796af33cc // indirect
+	github.com/pmezard/go-difflib v1.0.1-0.20181226105442-5d4384ee4fb2 // indirect
+	github.com/quic-go/qpack v0.6.0 // indirect
+	golang.org/x/crypto v0.54.0 // indirect
+	golang.org/x/net v0.57.0 // indirect
+	golang.org/x/sys v0.47.0 // indirect
+	golang.org/x/text v0.40.0 // indirect
+	google.golang.org/genproto/googleapis/rpc v0.0.0-20260706201446-f0a921348800 // indirect
 )
@@ -0,0 +1,410 @@
+package autoupdate
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Aggregate is a synthetic field, that is computed from relation lists. Its
+// value is the number of objects in the relation lists of the sources. If
+// Where is set, only the objects are counted, where the given fields have the
+// given values.
+//
+// The field is visible for a user, if the user can see at least one of the
+// source fields. The number is counted from the unrestricted data.
+type Aggregate struct {
+	Collection string
+	Field      string
+	Sources    []AggregateSource
+	Where      map[string]json.RawMessage
+}
+
+// AggregateSource is a relation list field of an Aggregate. Relation is the
+// name of the field and To the collection it points to.
+type AggregateSource struct {
+	Relation string
+	To       string
+}
+
+// DefaultAggregates returns the aggregate fields for the OpenSlides models.
+func DefaultAggregates() []Aggregate {
+	started := map[string]json.RawMessage{"state": []byte(`"started"`)}
+	return []Aggregate{
+		{
+			Collection: "list_of_speakers",
+			Field:      "speaker_count",
+			Sources:    []AggregateSource{{Relation: "speaker_ids", To: "speaker"}},
+		},
+		{
+			Collection: "list_of_speakers",
+			Field:      "waiting_speaker_count",
+			Sources:    []AggregateSource{{Relation: "speaker_ids", To: "speaker"}},
+			Where:      map[string]json.RawMessage{"begin_time": []byte(`null`)},
+		},
+		{
+			Collection: "meeting",
+			Field:      "active_poll_count",
+			Sources: []AggregateSource{
+				{Relation: "motion_poll_ids", To: "motion_poll"},
+				{Relation: "assignment_poll_ids", To: "assignment_poll"},
+			},
+			Where: started,
+		},
+	}
+}
+
+// aggregates holds the aggregate fields of the service.
+type aggregates struct {
+	// fields maps collection/field to the aggregate.
+	fields map[string]*Aggregate
+
+	// byField maps the name of an aggregate field to the aggregates with this
+	// name. It is used to find the aggregate of a key without allocations.
+	byField map[string][]*Aggregate
+
+	// mu protects requested, related and relatedOf.
+	mu sync.Mutex
+
+	// requested counts the connections, that subscribed an aggregate key.
+	requested map[string]int
+
+	// related maps the fqid of a related object, for example motion_poll/1,
+	// to the requested aggregate keys, that count this object. If a where
+	// field of the object changes, only these keys are handled as changed.
+	related map[string]map[string]bool
+
+	// relatedOf is the reverse of related. It holds the fqids of the related
+	// objects of each requested aggregate key.
+	relatedOf map[string][]string
+}
+
+func newAggregates(defs []Aggregate) *aggregates {
+	a := &aggregates{
+		fields:    make(map[string]*Aggregate, len(defs)),
+		byField:   make(map[string][]*Aggregate, len(defs)),
+		requested: make(map[string]int),
+		related:   make(map[string]map[string]bool),
+		relatedOf: make(map[string][]string),
+	}
+	for i := range defs {
+		def := &defs[i]
+		a.fields[def.Collection+"/"+def.Field] = def
+		a.byField[def.Field] = append(a.byField[def.Field], def)
+	}
+	return a
+}
+
+// lookup returns the aggregate for a key and the fqid of the key. Returns nil
+// if the key is not an aggregate field.
+func (a *aggregates) lookup(key string) (*Aggregate, string) {
+	if a == nil || len(a.fields) == 0 {
+		return nil, ""
+	}
+
+	collection, fqid, field, ok := splitKey(key)
+	if !ok {
+		return nil, ""
+	}
+
+	for _, def := range a.byField[field] {
+		if def.Collection == collection {
+			return def, fqid
+		}
+	}
+	return nil, ""
+}
+
+// splitKey returns the collection, the fqid and the field of a key.
+func splitKey(key string) (collection, fqid, field string, ok bool) {
+	i := strings.IndexByte(key, '/')
+	j := strings.LastIndexByte(key, '/')
+	if i == -1 || i == j {
+		return "", "", "", false
+	}
+	return key[:i], key[:j], key[j+1:], true
+}
+
+// acquire registers the aggregate keys of a connection. Other keys are
+// ignored. Returns the registered keys. They have to be released with release.
+func (a *aggregates) acquire(keys map[string]bool) []string {
+	if a == nil || len(a.fields) == 0 {
+		return nil
+	}
+
+	var acquired []string
+	for key := range keys {
+		if def, _ := a.lookup(key); def != nil {
+			acquired = append(acquired, key)
+		}
+	}
+
+	if len(acquired) == 0 {
+		return nil
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for _, key := range acquired {
+		a.requested[key]++
+	}
+	return acquired
+}
+
+// release unregisters aggregate keys, that were returned by acquire. A key,
+// that is not requested by any connection, is forgotten.
+func (a *aggregates) release(keys []string) {
+	if len(keys) == 0 {
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for _, key := range keys {
+		a.requested[key]--
+		if a.requested[key] > 0 {
+			continue
+		}
+
+		delete(a.requested, key)
+		a.setRelatedLocked(key, nil)
+	}
+}
+
+// setRelated saves the fqids of the related objects of an aggregate key. It is
+// only saved, if the key is requested by a connection.
+func (a *aggregates) setRelated(key string, fqids []string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.requested[key] == 0 {
+		return
+	}
+	a.setRelatedLocked(key, fqids)
+}
+
+func (a *aggregates) setRelatedLocked(key string, fqids []string) {
+	for _, fqid := range a.relatedOf[key] {
+		delete(a.related[fqid], key)
+		if len(a.related[fqid]) == 0 {
+			delete(a.related, fqid)
+		}
+	}
+
+	if len(fqids) == 0 {
+		delete(a.relatedOf, key)
+		return
+	}
+
+	a.relatedOf[key] = fqids
+	for _, fqid := range fqids {
+		if a.related[fqid] == nil {
+			a.related[fqid] = make(map[string]bool)
+		}
+		a.related[fqid][key] = true
+	}
+}
+
+// changed returns the aggregate keys, that change because of the changed keys.
+//
+// A changed relation list changes the aggregate of the same object. A changed
+// where field changes the requested aggregate keys, that count the object of
+// the field.
+func (a *aggregates) changed(keys []string) []string {
+	if a == nil || len(a.fields) == 0 {
+		return nil
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	var out []string
+	seen := make(map[string]bool)
+	add := func(key string) {
+		if !seen[key] {
+			out = append(out, key)
+			seen[key] = true
+		}
+	}
+
+	for _, key := range keys {
+		collection, fqid, field, ok := splitKey(key)
+		if !ok {
+			continue
+		}
+
+		for _, def := range a.fields {
+			if def.Collection != collection {
+				continue
+			}
+			for _, source := range def.Sources {
+				if source.Relation == field {
+					add(fqid + "/" + def.Field)
+				}
+			}
+		}
+
+		for requested := range a.related[fqid] {
+			if def, _ := a.lookup(requested); def != nil {
+				if _, ok := def.Where[field]; ok {
+					add(requested)
+				}
+			}
+		}
+	}
+	return out
+}
+
+// aggregateValue is the computed value of an aggregate key. sources are the
+// values of the source fields, that decide, if the value is visible.
+type aggregateValue struct {
+	value   json.RawMessage
+	sources map[string]json.RawMessage
+}
+
+// aggregateData computes the values of all aggregate keys in keys.
+func (a *Autoupdate) aggregateData(ctx context.Context, keys []string) (map[string]aggregateValue, error) {
+	type request struct {
+		def  *Aggregate
+		fqid string
+	}
+
+	var requests map[string]request
+	for _, key := range keys {
+		def, fqid := a.aggregates.lookup(key)
+		if def == nil {
+			continue
+		}
+		if requests == nil {
+			requests = make(map[string]request)
+		}
+		requests[key] = request{def: def, fqid: fqid}
+	}
+
+	if len(requests) == 0 {
+		return nil, nil
+	}
+
+	// Read the relation lists.
+	sourceSet := make(map[string]bool)
+	var sourceKeys []string
+	for _, r := range requests {
+		for _, source := range r.def.Sources {
+			key := r.fqid + "/" + source.Relation
+			if !sourceSet[key] {
+				sourceKeys = append(sourceKeys, key)
+				sourceSet[key] = true
+			}
+		}
+	}
+	values, err := a.get(ctx, sourceKeys...)
+	if err != nil {
+		return nil, fmt.Errorf("get relation lists: %w", err)
+	}
+
+	rawSources := make(map[string]json.RawMessage, len(sourceKeys))
+	sources := make(map[string][]int, len(sourceKeys))
+	for i, key := range sourceKeys {
+		rawSources[key] = values[i]
+		if values[i] == nil {
+			continue
+		}
+
+		var ids []int
+		if err := json.Unmarshal(values[i], &ids); err != nil {
+			return nil, fmt.Errorf("decoding %s: %w", key, err)
+		}
+		sources[key] = ids
+	}
+
+	// Read the where fields of the related objects.
+	var whereKeys []string
+	for _, r := range requests {
+		for _, source := range r.def.Sources {
+			for _, id := range sources[r.fqid+"/"+source.Relation] {
+				for field := range r.def.Where {
+					whereKeys = append(whereKeys, source.To+"/"+strconv.Itoa(id)+"/"+field)
+				}
+			}
+		}
+	}
+
+	whereValues := make(map[string]interface{}, len(whereKeys))
+	if len(whereKeys) > 0 {
+		values, err := a.get(ctx, whereKeys...)
+		if err != nil {
+			return nil, fmt.Errorf("get where fields: %w", err)
+		}
+
+		for i, key := range whereKeys {
+			var v interface{}
+			if values[i] != nil {
+				if err := json.Unmarshal(values[i], &v); err != nil {
+					return nil, fmt.Errorf("decoding %s: %w", key, err)
+				}
+			}
+			whereValues[key] = v
+		}
+	}
+
+	out := make(map[string]aggregateValue, len(requests))
+	for key, r := range requests {
+		count, err := r.def.count(r.fqid, sources, whereValues)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(r.def.Where) > 0 {
+			var related []string
+			for _, source := range r.def.Sources {
+				for _, id := range sources[r.fqid+"/"+source.Relation] {
+					related = append(related, source.To+"/"+strconv.Itoa(id))
+				}
+			}
+			a.aggregates.setRelated(key, related)
+		}
+
+		v := aggregateValue{
+			value:   []byte(strconv.Itoa(count)),
+			sources: make(map[string]json.RawMessage, len(r.def.Sources)),
+		}
+		for _, source := range r.def.Sources {
+			sourceKey := r.fqid + "/" + source.Relation
+			v.sources[sourceKey] = rawSources[sourceKey]
+		}
+		out[key] = v
+	}
+	return out, nil
+}
+
+// count returns the number of related objects of the object fqid.
+func (def *Aggregate) count(fqid string, sources map[string][]int, whereValues map[string]interface{}) (int, error) {
+	var count int
+	for _, source := range def.Sources {
+		for _, id := range sources[fqid+"/"+source.Relation] {
+			match := true
+			for field, raw := range def.Where {
+				var expected interface{}
+				if err := json.Unmarshal(raw, &expected); err != nil {
+					return 0, fmt.Errorf("decoding where value of %s/%s: %w", def.Collection, def.Field, err)
+				}
+
+				if !reflect.DeepEqual(whereValues[source.To+"/"+strconv.Itoa(id)+"/"+field], expected) {
+					match = false
+					break
+				}
+			}
+
+			if match {
+				count++
+			}
+		}
+	}
+	return count, nil
+}
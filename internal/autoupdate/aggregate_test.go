@@ -0,0 +1,116 @@
+package autoupdate_test
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/openslides/openslides-autoupdate-service/internal/autoupdate"
+	"github.com/openslides/openslides-autoupdate-service/internal/restrict"
+	"github.com/openslides/openslides-autoupdate-service/internal/test"
+)
+
+func pollData() map[string]json.RawMessage {
+	return map[string]json.RawMessage{
+		"meeting/1/motion_poll_ids":     []byte(`[1,2]`),
+		"meeting/1/assignment_poll_ids": []byte(`[3]`),
+		"motion_poll/1/state":           []byte(`"started"`),
+		"motion_poll/2/state":           []byte(`"finished"`),
+		"assignment_poll/3/state":       []byte(`"started"`),
+	}
+}
+
+func TestAggregate(t *testing.T) {
+	datastore := test.NewMockDatastore(test.WithData(pollData()), test.WithOnlyData())
+	closed := make(chan struct{})
+	defer close(closed)
+	s := autoupdate.New(datastore, new(test.MockRestricter), closed, autoupdate.WithAggregates(autoupdate.DefaultAggregates()...))
+
+	kb := mockKeysBuilder{keys: test.Str("meeting/1/active_poll_count")}
+	c := s.Connect(1, kb, 0)
+
+	data, err := c.Next(context.Background())
+	if err != nil {
+		t.Fatalf("c.Next() returned an error: %v", err)
+	}
+	cmpMap(t, data, map[string]json.RawMessage{"meeting/1/active_poll_count": []byte(`2`)})
+
+	// A changed where field of a related object changes the aggregate.
+	datastore.Update(map[string]json.RawMessage{"motion_poll/2/state": []byte(`"started"`)})
+	datastore.Send(test.Str("motion_poll/2/state"))
+
+	data, err = c.Next(context.Background())
+	if err != nil {
+		t.Fatalf("c.Next() returned an error: %v", err)
+	}
+	cmpMap(t, data, map[string]json.RawMessage{"meeting/1/active_poll_count": []byte(`3`)})
+
+	// A changed relation list changes the aggregate.
+	datastore.Update(map[string]json.RawMessage{"meeting/1/assignment_poll_ids": []byte(`[]`)})
+	datastore.Send(test.Str("meeting/1/assignment_poll_ids"))
+
+	data, err = c.Next(context.Background())
+	if err != nil {
+		t.Fatalf("c.Next() returned an error: %v", err)
+	}
+	cmpMap(t, data, map[string]json.RawMessage{"meeting/1/active_poll_count": []byte(`2`)})
+}
+
+func TestAggregateRestricted(t *testing.T) {
+	datastore := test.NewMockDatastore(test.WithData(pollData()), test.WithOnlyData())
+	closed := make(chan struct{})
+	defer close(closed)
+
+	perm := &test.MockPermission{Default: false}
+	s := autoupdate.New(datastore, restrict.New(perm, nil), closed, autoupdate.WithAggregates(autoupdate.DefaultAggregates()...))
+
+	data, err := s.RestrictedData(context.Background(), 1, "meeting/1/active_poll_count")
+	if err != nil {
+		t.Fatalf("RestrictedData returned an error: %v", err)
+	}
+
+	if len(data) != 1 || data["meeting/1/active_poll_count"] != nil {
+		t.Errorf("Got %v, expected only an invisible aggregate", data)
+	}
+}
+
+func TestAggregateOnlyRelatedChanges(t *testing.T) {
+	datastore := test.NewMockDatastore(test.WithOnlyData(), test.WithData(map[string]json.RawMessage{
+		"list_of_speakers/1/speaker_ids": []byte(`[1]`),
+		"list_of_speakers/2/speaker_ids": []byte(`[2]`),
+		"speaker/1/begin_time":           []byte(`null`),
+		"speaker/2/begin_time":           []byte(`null`),
+	}))
+	closed := make(chan struct{})
+	defer close(closed)
+	s := autoupdate.New(datastore, new(test.MockRestricter), closed, autoupdate.WithAggregates(autoupdate.DefaultAggregates()...))
+
+	c := s.Connect(1, mockKeysBuilder{keys: test.Str("list_of_speakers/2/waiting_speaker_count")}, 0)
+	defer c.Close()
+
+	data, err := c.Next(context.Background())
+	if err != nil {
+		t.Fatalf("c.Next() returned an error: %v", err)
+	}
+	cmpMap(t, data, map[string]json.RawMessage{"list_of_speakers/2/waiting_speaker_count": []byte(`1`)})
+
+	// The speaker of the other list does not change the aggregate.
+	datastore.Update(map[string]json.RawMessage{"speaker/1/begin_time": []byte(`100`)})
+	datastore.Send(test.Str("speaker/1/begin_time"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if data, err := c.Next(ctx); err == nil {
+		t.Errorf("Got message %v for a speaker of another list", data)
+	}
+
+	datastore.Update(map[string]json.RawMessage{"speaker/2/begin_time": []byte(`100`)})
+	datastore.Send(test.Str("speaker/2/begin_time"))
+
+	data, err = c.Next(context.Background())
+	if err != nil {
+		t.Fatalf("c.Next() returned an error: %v", err)
+	}
+	cmpMap(t, data, map[string]json.RawMessage{"list_of_speakers/2/waiting_speaker_count": []byte(`0`)})
+}
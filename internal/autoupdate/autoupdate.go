@@ -10,9 +10,10 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"runtime"
 	"time"
 
-	"github.com/ostcar/topic"
+	"github.com/openslides/openslides-autoupdate-service/internal/clock"
 )
 
 // pruneTime defines how long a topic id will be valid. If a client needs more
@@ -25,24 +26,48 @@ const pruneTime = 10 * time.Minute
 type Autoupdate struct {
 	datastore  Datastore
 	restricter Restricter
-	topic      *topic.Topic
+	topic      *ring
+	ringSize   int
+	clock      clock.Clock
+	batch      restrictBatch
+
+	concurrency       int
+	scheduler         *scheduler
+	priorityPermitter PriorityPermitter
+	aggregates        *aggregates
+
+	// instance identifies this instance of the service for resume tokens.
+	instance []byte
 }
 
 // New creates a new autoupdate service.
-func New(datastore Datastore, restricter Restricter, closed <-chan struct{}) *Autoupdate {
+func New(datastore Datastore, restricter Restricter, closed <-chan struct{}, options ...Option) *Autoupdate {
 	a := &Autoupdate{
 		datastore:  datastore,
 		restricter: restricter,
-		topic:      topic.New(topic.WithClosed(closed)),
+		ringSize:   defaultRingSize,
+		clock:      clock.Real{},
+
+		concurrency: 2 * runtime.NumCPU(),
+		instance:    newInstanceID(),
+	}
+
+	for _, o := range options {
+		o(a)
 	}
 
+	a.topic = newRing(a.ringSize, closed)
+	a.scheduler = newScheduler(a.concurrency)
+
 	// Update the topic when an data update is received.
 	a.datastore.RegisterChangeListener(func(data map[string]json.RawMessage) error {
 		keys := make([]string, 0, len(data))
 		for k := range data {
 			keys = append(keys, k)
 		}
-		a.topic.Publish(keys...)
+		synthetic := append(a.aggregates.changed(keys), countdownChanged(keys)...)
+		keys = append(keys, synthetic...)
+		a.topic.publish(a.clock.Now(), keys...)
 		return nil
 	})
 
@@ -54,7 +79,7 @@ func New(datastore Datastore, restricter Restricter, closed <-chan struct{}) *Au
 // Connect has to be called by a client to register to the service. The method
 // returns a Connection object, that can be used to receive the data.
 //
-// There is no need to "close" the Connection object.
+// The Connection has to be closed with Close, when it is not used anymore.
 func (a *Autoupdate) Connect(userID int, kb KeysBuilder, tid uint64) *Connection {
 	return &Connection{
 		autoupdate: a,
@@ -66,31 +91,52 @@ func (a *Autoupdate) Connect(userID int, kb KeysBuilder, tid uint64) *Connection
 
 // LastID returns the last id of the last data update.
 func (a *Autoupdate) LastID() uint64 {
-	return a.topic.LastID()
+	return a.topic.lastID()
 }
 
 // pruneTopic removes old data from the topic. Blocks until the service is
 // closed.
 func (a *Autoupdate) pruneTopic(closed <-chan struct{}) {
-	tick := time.NewTicker(time.Minute)
+	tick := a.clock.NewTicker(time.Minute)
 	defer tick.Stop()
 
 	for {
 		select {
 		case <-closed:
 			return
-		case <-tick.C:
-			a.topic.Prune(time.Now().Add(-pruneTime))
+		case <-tick.C():
+			a.topic.prune(a.clock.Now().Add(-pruneTime))
 		}
 	}
 }
 
+// get reads the keys from the datastore. While the connection waits for the
+// datastore, other connections can use its slot of the scheduler.
+func (a *Autoupdate) get(ctx context.Context, keys ...string) ([]json.RawMessage, error) {
+	var values []json.RawMessage
+	err := waitIO(ctx, func() error {
+		var err error
+		values, err = a.datastore.Get(ctx, keys...)
+		return err
+	})
+	return values, err
+}
+
+// updating tells, if the datastore has changed values, that are not in the
+// topic yet. It has to be called before changedSince, so each change, that was
+// written before, is either in the topic or still updating.
+func (a *Autoupdate) updating() bool {
+	indicator, ok := a.datastore.(UpdateIndicator)
+	return ok && indicator.Updating()
+}
+
 // RestrictedData returns a map containing the restricted values for the given
 // keys. If a key does not exist or the user has not the permission to see it,
 // the value in the returned map is nil.
 func (a *Autoupdate) RestrictedData(ctx context.Context, uid int, keys ...string) (map[string]json.RawMessage, error) {
-	values, err := a.datastore.Get(ctx, keys...)
-	if err != nil {
+	values, err := a.get(ctx, keys...)
+	pending, partial := pendingKeys(err)
+	if err != nil && !partial {
 		return nil, fmt.Errorf("get values for keys `%v` from datastore: %w", keys, err)
 	}
 
@@ -99,8 +145,59 @@ func (a *Autoupdate) RestrictedData(ctx context.Context, uid int, keys ...string
 		data[key] = values[i]
 	}
 
-	if err := a.restricter.Restrict(uid, data); err != nil {
+	aggregated, err := a.aggregateData(ctx, keys)
+	if err != nil {
+		return nil, fmt.Errorf("compute aggregate fields: %w", err)
+	}
+
+	countdowns, err := a.countdownData(ctx, keys)
+	if err != nil {
+		return nil, fmt.Errorf("compute countdown fields: %w", err)
+	}
+	for key, v := range countdowns {
+		if aggregated == nil {
+			aggregated = make(map[string]aggregateValue, len(countdowns))
+		}
+		aggregated[key] = v
+	}
+
+	// The aggregate and countdown fields are not known by the restricter.
+	// Their source fields are restricted instead.
+	var added []string
+	for key, agg := range aggregated {
+		delete(data, key)
+		for sourceKey, value := range agg.sources {
+			if _, ok := data[sourceKey]; !ok {
+				data[sourceKey] = value
+				added = append(added, sourceKey)
+			}
+		}
+	}
+
+	if err := a.restricter.Restrict(ctx, uid, data); err != nil {
 		return nil, fmt.Errorf("restrict data: %w", err)
 	}
+
+	for key, agg := range aggregated {
+		var value json.RawMessage
+		for sourceKey := range agg.sources {
+			if data[sourceKey] != nil {
+				value = agg.value
+				break
+			}
+		}
+		data[key] = value
+	}
+
+	for _, key := range added {
+		delete(data, key)
+	}
+
+	if len(pending) > 0 {
+		for _, key := range pending {
+			delete(data, key)
+		}
+		return data, PendingError{keys: pending}
+	}
 	return data, nil
 }
@@ -0,0 +1,288 @@
+package autoupdate
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// restrictBatch shares restricted values between the connections in one update
+// cycle.
+//
+// An update cycle is identified by the topic id. All connections, that
+// process the same topic id and have the same permission class, get the same
+// restricted values. So each key has to be restricted only once per class.
+//
+// Only the latest update cycle is saved. Connections that are behind restrict
+// their data on their own.
+type restrictBatch struct {
+	mu      sync.Mutex
+	tid     uint64
+	classes map[string]*classBatch
+}
+
+// classBatch holds the restricted values of one permission class.
+//
+// pending holds the keys, that are restricted at the moment. The channel is
+// closed, when the value is in data or the restriction failed.
+type classBatch struct {
+	mu      sync.Mutex
+	data    map[string]json.RawMessage
+	pending map[string]chan struct{}
+}
+
+// class returns the batch for the permission class in the update cycle tid.
+// Returns nil, if tid is older then the current update cycle.
+func (b *restrictBatch) class(tid uint64, class string) *classBatch {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if tid < b.tid {
+		return nil
+	}
+
+	if tid > b.tid || b.classes == nil {
+		b.tid = tid
+		b.classes = make(map[string]*classBatch)
+	}
+
+	cb, ok := b.classes[class]
+	if !ok {
+		cb = &classBatch{
+			data:    make(map[string]json.RawMessage),
+			pending: make(map[string]chan struct{}),
+		}
+		b.classes[class] = cb
+	}
+	return cb
+}
+
+// claim returns the keys, that are neither restricted nor pending. They are
+// marked as pending and have to be released by the caller. The returned
+// channels are closed, when the other pending keys are done.
+func (cb *classBatch) claim(keys []string) ([]string, []chan struct{}) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	var missing []string
+	var wait []chan struct{}
+	seen := make(map[chan struct{}]bool)
+	for _, key := range keys {
+		if _, ok := cb.data[key]; ok {
+			continue
+		}
+
+		if done, ok := cb.pending[key]; ok {
+			if !seen[done] {
+				seen[done] = true
+				wait = append(wait, done)
+			}
+			continue
+		}
+
+		missing = append(missing, key)
+	}
+
+	if len(missing) > 0 {
+		done := make(chan struct{})
+		for _, key := range missing {
+			cb.pending[key] = done
+		}
+	}
+	return missing, wait
+}
+
+// release saves the restricted values of the claimed keys and wakes up the
+// connections, that wait for them. Claimed keys without a value are not saved,
+// so the next connection restricts them again.
+func (cb *classBatch) release(claimed []string, restricted map[string]json.RawMessage) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	var done chan struct{}
+	for _, key := range claimed {
+		if v, ok := restricted[key]; ok {
+			cb.data[key] = v
+		}
+		done = cb.pending[key]
+		delete(cb.pending, key)
+	}
+
+	if done != nil {
+		close(done)
+	}
+}
+
+// permissionClass returns the permission class of a user. If the restricter
+// does not implement the PermissionClasser interface, each user is its own
+// class.
+func (a *Autoupdate) permissionClass(ctx context.Context, uid int) (string, error) {
+	if pc, ok := a.restricter.(PermissionClasser); ok {
+		return pc.PermissionClass(ctx, uid)
+	}
+	return strconv.Itoa(uid), nil
+}
+
+// userScoped splits the keys in the keys, that can be shared with the users of
+// the same permission class, and the keys, that depend on the user itself.
+func (a *Autoupdate) userScoped(keys []string) (shared, scoped []string) {
+	pc, ok := a.restricter.(PermissionClasser)
+	if !ok {
+		// Each user is its own class.
+		return keys, nil
+	}
+
+	for _, key := range keys {
+		if pc.UserScoped(key) {
+			scoped = append(scoped, key)
+			continue
+		}
+		shared = append(shared, key)
+	}
+	return shared, scoped
+}
+
+// restrictedDataInCycle is like RestrictedData but shares the restricted values
+// with other connections in the same update cycle.
+//
+// A key, that is restricted by another connection of the same class, is not
+// restricted again. The connection waits for the other connection instead.
+// No lock is held while the data is restricted.
+//
+// Keys, that depend on the user itself, are only shared with the other
+// connections of the same user.
+func (a *Autoupdate) restrictedDataInCycle(ctx context.Context, tid uint64, uid int, keys ...string) (map[string]json.RawMessage, error) {
+	class, err := a.permissionClass(ctx, uid)
+	if err != nil {
+		return nil, fmt.Errorf("get permission class: %w", err)
+	}
+
+	shared, scoped := a.userScoped(keys)
+	groups := []struct {
+		class string
+		keys  []string
+	}{
+		{class, shared},
+		{class + "/user:" + strconv.Itoa(uid), scoped},
+	}
+
+	data := make(map[string]json.RawMessage, len(keys))
+	var pendingList []string
+	for _, g := range groups {
+		if len(g.keys) == 0 {
+			continue
+		}
+
+		restricted, err := a.restrictInClass(ctx, tid, uid, g.class, g.keys)
+		if err != nil {
+			keys, ok := pendingKeys(err)
+			if !ok {
+				return nil, err
+			}
+			pendingList = append(pendingList, keys...)
+		}
+		for k, v := range restricted {
+			data[k] = v
+		}
+	}
+
+	if len(pendingList) > 0 {
+		sort.Strings(pendingList)
+		return data, PendingError{keys: pendingList}
+	}
+	return data, nil
+}
+
+// restrictInClass restricts the keys with the restricted values of the
+// permission class in the update cycle tid.
+func (a *Autoupdate) restrictInClass(ctx context.Context, tid uint64, uid int, class string, keys []string) (map[string]json.RawMessage, error) {
+	cb := a.batch.class(tid, class)
+	if cb == nil {
+		return a.RestrictedData(ctx, uid, keys...)
+	}
+
+	missing, wait := cb.claim(keys)
+
+	// pendingList are the pending keys of both calls to RestrictedData.
+	var pendingList []string
+	var pending map[string]bool
+	if len(missing) > 0 {
+		restricted, err := a.RestrictedData(ctx, uid, missing...)
+		if err != nil {
+			keys, ok := pendingKeys(err)
+			if !ok {
+				cb.release(missing, nil)
+				return nil, err
+			}
+			pendingList = append(pendingList, keys...)
+			pending = make(map[string]bool, len(keys))
+			for _, key := range keys {
+				pending[key] = true
+				// Pending keys are not saved, so the next connection
+				// reads them again.
+				delete(restricted, key)
+			}
+		}
+		cb.release(missing, restricted)
+	}
+
+	// The other connections need a slot of the scheduler to finish, so the
+	// slot is given back while waiting.
+	err := waitIO(ctx, func() error {
+		for _, done := range wait {
+			select {
+			case <-done:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	data := make(map[string]json.RawMessage, len(keys))
+	var again []string
+	cb.mu.Lock()
+	for _, key := range keys {
+		if pending[key] {
+			continue
+		}
+
+		v, ok := cb.data[key]
+		if !ok {
+			// The other connection failed to restrict the key.
+			again = append(again, key)
+			continue
+		}
+		data[key] = v
+	}
+	cb.mu.Unlock()
+
+	if len(again) > 0 {
+		restricted, err := a.RestrictedData(ctx, uid, again...)
+		if err != nil {
+			keys, ok := pendingKeys(err)
+			if !ok {
+				return nil, err
+			}
+			pendingList = append(pendingList, keys...)
+			for _, key := range keys {
+				delete(restricted, key)
+			}
+		}
+		for k, v := range restricted {
+			data[k] = v
+		}
+	}
+
+	if len(pendingList) > 0 {
+		sort.Strings(pendingList)
+		return data, PendingError{keys: pendingList}
+	}
+	return data, nil
+}
@@ -0,0 +1,128 @@
+package autoupdate
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// batchDatastore returns a pending error for the keys in pending. onGet is
+// called at the start of each call to Get.
+type batchDatastore struct {
+	pending map[string]bool
+	onGet   func(keys []string)
+}
+
+func (d *batchDatastore) Get(ctx context.Context, keys ...string) ([]json.RawMessage, error) {
+	if d.onGet != nil {
+		d.onGet(keys)
+	}
+
+	values := make([]json.RawMessage, len(keys))
+	var pending []string
+	for i, key := range keys {
+		if d.pending[key] {
+			pending = append(pending, key)
+			continue
+		}
+		values[i] = json.RawMessage(`"value"`)
+	}
+
+	if len(pending) > 0 {
+		return values, PendingError{keys: pending}
+	}
+	return values, nil
+}
+
+func (d *batchDatastore) RegisterChangeListener(f func(map[string]json.RawMessage) error) {}
+
+type allowRestricter struct{}
+
+func (allowRestricter) Restrict(ctx context.Context, uid int, data map[string]json.RawMessage) error {
+	return nil
+}
+
+func TestRestrictedDataInCyclePendingKeys(t *testing.T) {
+	closed := make(chan struct{})
+	defer close(closed)
+
+	ds := &batchDatastore{pending: map[string]bool{"user/1/name": true, "user/2/name": true}}
+	a := New(ds, allowRestricter{}, closed)
+
+	// Another connection of the same class claims user/1/name and fails to
+	// restrict it, while the connection reads its own keys. So user/1/name is
+	// read in a second pass.
+	cb := a.batch.class(1, "1")
+	claimed, _ := cb.claim([]string{"user/1/name"})
+	ds.onGet = func(keys []string) {
+		if len(claimed) > 0 {
+			cb.release(claimed, nil)
+			claimed = nil
+		}
+	}
+
+	data, err := a.restrictedDataInCycle(context.Background(), 1, 1, "user/1/name", "user/2/name", "user/3/name")
+
+	var pendingErr PendingError
+	if !errors.As(err, &pendingErr) {
+		t.Fatalf("Got error %v, expected a PendingError", err)
+	}
+
+	if got, expect := pendingErr.PendingKeys(), []string{"user/1/name", "user/2/name"}; !reflect.DeepEqual(got, expect) {
+		t.Errorf("Got pending keys %v, expected %v", got, expect)
+	}
+
+	if _, ok := data["user/3/name"]; !ok || len(data) != 1 {
+		t.Errorf("Got data %v, expected only user/3/name", data)
+	}
+}
+
+// ownerRestricter puts all users in one class. But only user 1 can see the
+// personal notes.
+type ownerRestricter struct{}
+
+func (ownerRestricter) Restrict(ctx context.Context, uid int, data map[string]json.RawMessage) error {
+	for key := range data {
+		if strings.HasPrefix(key, "personal_note/") && uid != 1 {
+			data[key] = nil
+		}
+	}
+	return nil
+}
+
+func (ownerRestricter) PermissionClass(ctx context.Context, uid int) (string, error) {
+	return "all", nil
+}
+
+func (ownerRestricter) UserScoped(key string) bool {
+	return strings.HasPrefix(key, "personal_note/")
+}
+
+func TestRestrictedDataInCycleUserScoped(t *testing.T) {
+	closed := make(chan struct{})
+	defer close(closed)
+
+	a := New(&batchDatastore{}, ownerRestricter{}, closed)
+
+	owner, err := a.restrictedDataInCycle(context.Background(), 1, 1, "personal_note/1/note", "motion/1/title")
+	if err != nil {
+		t.Fatalf("restrictedDataInCycle returned an error: %v", err)
+	}
+	if owner["personal_note/1/note"] == nil {
+		t.Errorf("The owner can not see the personal note")
+	}
+
+	other, err := a.restrictedDataInCycle(context.Background(), 1, 2, "personal_note/1/note", "motion/1/title")
+	if err != nil {
+		t.Fatalf("restrictedDataInCycle returned an error: %v", err)
+	}
+	if v := other["personal_note/1/note"]; v != nil {
+		t.Errorf("Another user of the same class got the personal note %s, expected nil", v)
+	}
+	if other["motion/1/title"] == nil {
+		t.Errorf("Got no value for the shared key")
+	}
+}
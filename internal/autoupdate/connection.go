@@ -3,7 +3,10 @@ package autoupdate
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"sort"
+	"time"
 )
 
 // Connection holds the state of a client. It has to be created by colling
@@ -14,23 +17,101 @@ type Connection struct {
 	kb         KeysBuilder
 	tid        uint64
 	filter     *filter
+	priority   Priority
+
+	// subscribed is the set of the keys of the keysbuilder. It is used to
+	// ignore updates, that do not change any key of the connection.
+	subscribed map[string]bool
+
+	// next, changed and keys are reused on each call of Next to reduce
+	// allocations. They keep the capacity of the last update.
+	next    map[string]bool
+	changed map[string]bool
+	keys    []string
+
+	// knownHashes are the collection hashes of the data, that the client
+	// already has.
+	knownHashes map[string]string
+
+	// failed are the keys, that are read again on the next call to Next
+	// without waiting for changes. These are the changed keys of an update,
+	// that returned an UpdateError, or the pending keys of the last message.
+	failed []string
+
+	// pending are the keys, that could not be read in time for the last
+	// message.
+	pending []string
+
+	// aggregateKeys are the subscribed aggregate keys, that are registered in
+	// the aggregates of the service. They are released by Close.
+	aggregateKeys []string
+
+	// resumePosition and resumeToken are set, if the client has the data of a
+	// snapshot.
+	resumePosition uint64
+	resumeToken    string
+
+	// class is the permission class of the user at the position of the last
+	// message. It is part of the resume token.
+	class string
+}
+
+// SetKnownHashes sets the collection hashes of the data, that the client
+// already has. See CollectionHashes. The collections with the same hash are
+// not sent in the first response. If the hash of a collection differs, all
+// keys of the collection are sent.
+//
+// Has to be called before the first call to Next.
+func (c *Connection) SetKnownHashes(hashes map[string]string) {
+	c.knownHashes = hashes
 }
 
 // Next returns the next data for the user.
 //
 // Next blocks until there are new data or the context or the server closes. In
 // this case, nil is returned.
+//
+// If some keys could not be read in time, the other keys are returned and the
+// missing keys can be read with Pending. The next call to Next reads them
+// again without waiting for changes.
 func (c *Connection) Next(ctx context.Context) (map[string]json.RawMessage, error) {
+	c.pending = nil
+
 	if c.filter == nil {
 		// First time called
 		c.filter = new(filter)
 		if c.tid == 0 {
-			c.tid = c.autoupdate.topic.LastID()
+			c.tid = c.autoupdate.topic.lastID()
 		}
 
-		data, err := c.autoupdate.RestrictedData(ctx, c.uid, c.kb.Keys()...)
+		c.subscribed = resetSet(c.subscribed)
+		forEachKey(c.kb, func(key string) bool {
+			c.subscribed[key] = true
+			return true
+		})
+		c.subscribeAggregates(c.subscribed)
+
+		if err := c.updateClass(ctx); err != nil {
+			c.filter = nil
+			return nil, err
+		}
+
+		slotCtx, slot, err := c.autoupdate.scheduler.hold(ctx, c.priority)
 		if err != nil {
-			return nil, fmt.Errorf("get first time restricted data: %w", err)
+			return nil, fmt.Errorf("wait for first time data: %w", err)
+		}
+		data, err := c.firstData(slotCtx)
+		slot.free()
+		if err != nil {
+			var cErr consistencyError
+			if errors.As(err, &cErr) {
+				// Start again with the first data on the next call.
+				c.filter = nil
+				keys := c.kb.Keys()
+				sort.Strings(keys)
+				return nil, UpdateError{keys: keys, err: err}
+			}
+			return nil, err
 		}
 
 		// Delete empty values in first responce.
@@ -44,50 +125,260 @@ func (c *Connection) Next(ctx context.Context) (map[string]json.RawMessage, erro
 			return nil, fmt.Errorf("filter data for the first time: %w", err)
 		}
 
+		// The filter has to know all values, so this has to be done after
+		// filtering.
+		removeKnownCollections(data, c.knownHashes)
+		if err := c.removeUnchangedSince(data); err != nil {
+			return nil, fmt.Errorf("remove unchanged data: %w", err)
+		}
+
+		c.failed = c.pending
 		return data, nil
 	}
 
-	var err error
-	var changedKeys []string
+	for {
+		var err error
+		var changedKeys []string
+		var resync bool
 
-	// Blocks until the topic is closed (on server exit) or the context is done.
-	c.tid, changedKeys, err = c.autoupdate.topic.Receive(ctx, c.tid)
-	if err != nil {
-		return nil, fmt.Errorf("get updated keys: %w", err)
-	}
+		if c.failed != nil {
+			// The last update failed. Read its keys again without waiting for
+			// new changes.
+			changedKeys, c.failed = c.failed, nil
+		} else {
+			// Blocks until the topic is closed (on server exit) or the context is done.
+			c.tid, changedKeys, err = c.autoupdate.topic.receive(ctx, c.tid)
+			var tooOld tooOldError
+			resync = errors.As(err, &tooOld)
+			if err != nil && !resync {
+				return nil, fmt.Errorf("get updated keys: %w", err)
+			}
+		}
 
-	oldKeys := c.kb.Keys()
+		// Only the changed keys, that the connection has subscribed, are
+		// relevant. The keysbuilder only depends on the values of its own
+		// keys, so if no subscribed key changed, there is nothing to do.
+		//
+		// If the connection missed some updates, all keys are handled as
+		// changed. The filter makes sure, that only the changed values are
+		// sent to the client.
+		c.changed = resetSet(c.changed)
+		for _, key := range changedKeys {
+			if c.subscribed[key] {
+				c.changed[key] = true
+			}
+		}
+		if resync {
+			for key := range c.subscribed {
+				c.changed[key] = true
+			}
+		}
 
-	// Update keysbuilder get new list of keys
-	if err := c.kb.Update(ctx); err != nil {
-		return nil, fmt.Errorf("update keysbuilder: %w", err)
-	}
+		if len(c.changed) == 0 {
+			continue
+		}
 
-	// Start with keys hat are new for the user
-	keys := keysDiff(oldKeys, c.kb.Keys())
+		// The class is read before the data, so the data is not lost, if it
+		// fails.
+		if err := c.updateClass(ctx); err != nil {
+			return nil, c.updateFailed(err)
+		}
 
-	changedSlice := make(map[string]bool, len(changedKeys))
-	for _, key := range changedKeys {
-		changedSlice[key] = true
-	}
+		slotCtx, slot, err := c.autoupdate.scheduler.hold(ctx, c.priority)
+		if err != nil {
+			return nil, fmt.Errorf("wait for updated data: %w", err)
+		}
+		data, err := c.updatedData(slotCtx)
+		slot.free()
+		if err != nil {
+			return nil, c.updateFailed(err)
+		}
 
-	// Append keys that are old but have been changed.
-	for _, key := range oldKeys {
-		if !changedSlice[key] {
+		c.failed = c.pending
+		if len(data) == 0 && len(c.pending) > 0 {
+			// Nothing could be read in time. Try the pending keys again.
 			continue
 		}
-		keys = append(keys, key)
+		return data, nil
 	}
+}
 
-	if len(keys) == 0 {
-		// No data. Try again.
-		return c.Next(ctx)
+// updateFailed remembers the changed keys, so they are read again on the next
+// call to Next, and returns an UpdateError.
+func (c *Connection) updateFailed(err error) error {
+	c.failed = make([]string, 0, len(c.changed))
+	for key := range c.changed {
+		c.failed = append(c.failed, key)
 	}
+	sort.Strings(c.failed)
+	return UpdateError{keys: c.failed, err: err}
+}
 
-	data, err := c.autoupdate.RestrictedData(ctx, c.uid, keys...)
-	if err != nil {
-		return nil, fmt.Errorf("restrict data: %w", err)
+// UpdateError is returned by Connection.Next, when the data of changed keys
+// could not be read. The connection can still be used. The next call to Next
+// reads the keys again.
+type UpdateError struct {
+	keys []string
+	err  error
+}
+
+func (e UpdateError) Error() string {
+	return fmt.Sprintf("update %d keys: %v", len(e.keys), e.err)
+}
+
+// Unwrap returns the error, that caused the update to fail.
+func (e UpdateError) Unwrap() error {
+	return e.err
+}
+
+// Keys returns the sorted keys, that could not be updated.
+func (e UpdateError) Keys() []string {
+	return e.keys
+}
+
+// Pending returns the keys, that could not be read in time for the data of the
+// last call to Next. They are read again on the next call to Next.
+func (c *Connection) Pending() []string {
+	return c.pending
+}
+
+// Position returns the position of the data, that was returned by the last
+// call to Next. It is the id of the topic. All values of a message are read at
+// this position.
+func (c *Connection) Position() uint64 {
+	return c.tid
+}
+
+// maxConsistencyRetries is the number of times, the data of one message is read
+// again without a pause, because subscribed keys changed while the data was
+// read. Afterwards, the connection waits before each attempt, so the keys have
+// time to settle. Data of different positions is never returned.
+const maxConsistencyRetries = 3
+
+// maxConsistencyAttempts is the maximum number of attempts to read the data of
+// one message. If the keys still change, Next returns an UpdateError. The keys
+// are read again on the next call to Next.
+const maxConsistencyAttempts = 10
+
+// consistencyError is returned, when subscribed keys changed on each attempt to
+// read the data of one message.
+type consistencyError struct {
+	attempts int
+}
+
+func (e consistencyError) Error() string {
+	return fmt.Sprintf("keys changed on each of %d attempts to read them", e.attempts)
+}
+
+// Pauses between the attempts to read the data of one message after
+// maxConsistencyRetries. The pause is doubled after each attempt.
+const (
+	consistencyPauseMin = 10 * time.Millisecond
+	consistencyPauseMax = time.Second
+)
+
+// consistencyPause waits before the next attempt to read the data. The slot of
+// the scheduler is given to other connections while waiting.
+func (c *Connection) consistencyPause(ctx context.Context, attempt int) error {
+	if attempt < maxConsistencyRetries {
+		return nil
+	}
+
+	pause := consistencyPauseMin
+	for i := maxConsistencyRetries; i < attempt && pause < consistencyPauseMax; i++ {
+		pause *= 2
+	}
+	if pause > consistencyPauseMax {
+		pause = consistencyPauseMax
+	}
+
+	return waitIO(ctx, func() error {
+		select {
+		case <-c.autoupdate.clock.After(pause):
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	})
+}
+
+// firstData returns the data for all keys of the keysbuilder.
+func (c *Connection) firstData(ctx context.Context) (map[string]json.RawMessage, error) {
+	for attempt := 0; ; attempt++ {
+		data, err := c.autoupdate.RestrictedData(ctx, c.uid, c.kb.Keys()...)
+		pending, partial := pendingKeys(err)
+		if err != nil && !partial {
+			return nil, fmt.Errorf("get first time restricted data: %w", err)
+		}
+		c.pending = pending
+
+		updating := c.autoupdate.updating()
+		tid, changed := c.changedSince()
+		if len(changed) == 0 && !updating {
+			return data, nil
+		}
+
+		if attempt+1 >= maxConsistencyAttempts {
+			c.tid = tid
+			return nil, consistencyError{attempts: maxConsistencyAttempts}
+		}
+
+		// Some values could be newer then the position. Read all keys again
+		// at the new position.
+		if err := c.consistencyPause(ctx, attempt); err != nil {
+			return nil, fmt.Errorf("wait for consistent data: %w", err)
+		}
+		c.tid = tid
+		if err := c.kb.Update(ctx); err != nil {
+			return nil, fmt.Errorf("update keysbuilder: %w", err)
+		}
+		c.subscribed = resetSet(c.subscribed)
+		forEachKey(c.kb, func(key string) bool {
+			c.subscribed[key] = true
+			return true
+		})
+		c.subscribeAggregates(c.subscribed)
+	}
+}
+
+// updatedData returns the data for the changed keys and the keys, that are new
+// for the user.
+//
+// If subscribed keys change while the data is read, the data is read again at
+// the new position. So one message does not contain values of different
+// positions.
+func (c *Connection) updatedData(ctx context.Context) (map[string]json.RawMessage, error) {
+	var data map[string]json.RawMessage
+	for attempt := 0; ; attempt++ {
+		var err error
+		data, err = c.readUpdate(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		updating := c.autoupdate.updating()
+		tid, changed := c.changedSince()
+		if len(changed) == 0 && !updating {
+			break
+		}
+
+		if attempt+1 >= maxConsistencyAttempts {
+			c.tid = tid
+			for _, key := range changed {
+				c.changed[key] = true
+			}
+			return nil, consistencyError{attempts: maxConsistencyAttempts}
+		}
+
+		if err := c.consistencyPause(ctx, attempt); err != nil {
+			return nil, fmt.Errorf("wait for consistent data: %w", err)
+		}
+		c.tid = tid
+		for _, key := range changed {
+			c.changed[key] = true
+		}
 	}
+	c.subscribed, c.next = c.next, c.subscribed
 
 	for k, v := range data {
 		// Filter empty values that where empty before.
@@ -103,19 +394,100 @@ func (c *Connection) Next(ctx context.Context) (map[string]json.RawMessage, erro
 	return data, nil
 }
 
-func keysDiff(old []string, new []string) []string {
-	keySet := make(map[string]bool, len(old))
-	for _, key := range old {
-		keySet[key] = true
+// readUpdate updates the keysbuilder and reads the changed keys and the keys,
+// that are new for the user. The new keys are saved in c.next.
+func (c *Connection) readUpdate(ctx context.Context) (map[string]json.RawMessage, error) {
+	// Update keysbuilder get new list of keys
+	if err := c.kb.Update(ctx); err != nil {
+		return nil, fmt.Errorf("update keysbuilder: %w", err)
 	}
 
-	added := []string{}
-	for _, key := range new {
-		if keySet[key] {
-			continue
+	// Start with keys hat are new for the user
+	keys := c.keys[:0]
+	c.next = resetSet(c.next)
+	forEachKey(c.kb, func(key string) bool {
+		c.next[key] = true
+		if !c.subscribed[key] {
+			keys = append(keys, key)
+		}
+		return true
+	})
+
+	c.subscribeAggregates(c.next)
+
+	// Append keys that are old but have been changed.
+	for key := range c.changed {
+		keys = append(keys, key)
+	}
+	c.keys = keys
+
+	data, err := c.autoupdate.restrictedDataInCycle(ctx, c.tid, c.uid, keys...)
+	pending, partial := pendingKeys(err)
+	if err != nil && !partial {
+		return nil, fmt.Errorf("restrict data: %w", err)
+	}
+	c.pending = pending
+	return data, nil
+}
+
+// subscribeAggregates registers the aggregate keys of the set, so the changes
+// of their related objects are published. The keys of the last call are
+// released.
+func (c *Connection) subscribeAggregates(keys map[string]bool) {
+	acquired := c.autoupdate.aggregates.acquire(keys)
+	c.autoupdate.aggregates.release(c.aggregateKeys)
+	c.aggregateKeys = acquired
+}
+
+// Close releases the resources of the connection, that are shared with the
+// service. It has to be called, when the connection is not used anymore.
+func (c *Connection) Close() {
+	c.autoupdate.aggregates.release(c.aggregateKeys)
+	c.aggregateKeys = nil
+}
+
+// changedSince returns the newest id of the topic and the keys of the
+// connection, that changed after c.tid. If the topic does not know c.tid
+// anymore, no keys are returned. The next call to receive handles this case.
+func (c *Connection) changedSince() (uint64, []string) {
+	tid, keys, err := c.autoupdate.topic.since(c.tid)
+	if err != nil {
+		return c.tid, nil
+	}
+
+	var changed []string
+	for _, key := range keys {
+		if c.subscribed[key] || c.next[key] {
+			changed = append(changed, key)
 		}
-		added = append(added, key)
 	}
+	return tid, changed
+}
 
-	return added
+// forEachKey calls f for each key of the keysbuilder. It uses ForEachKey, if
+// the keysbuilder implements it. If f returns false, the iteration stops.
+func forEachKey(kb KeysBuilder, f func(key string) bool) {
+	if it, ok := kb.(keysIterator); ok {
+		it.ForEachKey(f)
+		return
+	}
+
+	for _, key := range kb.Keys() {
+		if !f(key) {
+			return
+		}
+	}
+}
+
+// resetSet removes all values from the set without freeing its memory. If the
+// set is nil, a new one is created.
+func resetSet(set map[string]bool) map[string]bool {
+	if set == nil {
+		return make(map[string]bool)
+	}
+
+	for k := range set {
+		delete(set, k)
+	}
+	return set
 }
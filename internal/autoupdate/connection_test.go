@@ -5,9 +5,13 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"math"
 	"testing"
+	"time"
 
 	"github.com/openslides/openslides-autoupdate-service/internal/autoupdate"
+	"github.com/openslides/openslides-autoupdate-service/internal/clock"
+	"github.com/openslides/openslides-autoupdate-service/internal/restrict"
 	"github.com/openslides/openslides-autoupdate-service/internal/test"
 )
 
@@ -76,12 +80,12 @@ func TestConnectionEmptyData(t *testing.T) {
 		doesExistKey    = "user/1/name"
 	)
 
-	datastore := new(test.MockDatastore)
-
-	datastore.Data = map[string]json.RawMessage{
-		doesExistKey: []byte("exist"),
-	}
-	datastore.OnlyData = true
+	datastore := test.NewMockDatastore(
+		test.WithData(map[string]json.RawMessage{
+			doesExistKey: []byte("exist"),
+		}),
+		test.WithOnlyData(),
+	)
 
 	closed := make(chan struct{})
 	defer close(closed)
@@ -289,3 +293,795 @@ func BenchmarkFilterNotChanging(b *testing.B) {
 		datastore.Send(keys)
 	}
 }
+
+func TestConnectionChaosError(t *testing.T) {
+	datastore := test.NewMockDatastore(test.WithChaos(&test.Chaos{ErrorRate: 1}))
+	closed := make(chan struct{})
+	defer close(closed)
+	s := autoupdate.New(datastore, new(test.MockRestricter), closed)
+	c := s.Connect(1, mockKeysBuilder{keys: test.Str("user/1/name")}, 0)
+
+	if _, err := c.Next(context.Background()); !errors.Is(err, test.ErrChaos) {
+		t.Errorf("c.Next() returned error `%v`, expected `%v`", err, test.ErrChaos)
+	}
+}
+
+func TestConnectionParallelUpdates(t *testing.T) {
+	updates := make(chan map[string]json.RawMessage)
+	defer close(updates)
+	datastore := test.NewMockDatastore(test.WithUpdates(updates))
+	closed := make(chan struct{})
+	defer close(closed)
+	s := autoupdate.New(datastore, new(test.MockRestricter), closed)
+
+	// The group is needed, so the deferred calls run after the parallel tests.
+	t.Run("group", func(t *testing.T) {
+		for i := 0; i < 10; i++ {
+			key := fmt.Sprintf("user/%d/name", i)
+			t.Run(key, func(t *testing.T) {
+				t.Parallel()
+				c := s.Connect(1, mockKeysBuilder{keys: test.Str(key)}, 0)
+				if _, err := c.Next(context.Background()); err != nil {
+					t.Fatalf("c.Next() returned an error: %v", err)
+				}
+
+				updates <- map[string]json.RawMessage{key: []byte(`"new value"`)}
+				data, err := c.Next(context.Background())
+				if err != nil {
+					t.Fatalf("c.Next() returned an error: %v", err)
+				}
+				if got := string(data[key]); got != `"new value"` {
+					t.Errorf("got value `%s`, expected `\"new value\"`", got)
+				}
+			})
+		}
+	})
+}
+
+func TestConnectionIgnoreUnrelatedUpdate(t *testing.T) {
+	datastore := new(test.MockDatastore)
+	closed := make(chan struct{})
+	defer close(closed)
+	s := autoupdate.New(datastore, new(test.MockRestricter), closed)
+	kb := &countingKeysBuilder{keys: test.Str("user/1/name")}
+	c := s.Connect(1, kb, 0)
+	if _, err := c.Next(context.Background()); err != nil {
+		t.Fatalf("c.Next() returned an error: %v", err)
+	}
+
+	datastore.Send(test.Str("motion/1/title"))
+	datastore.Update(map[string]json.RawMessage{"user/1/name": []byte(`"new"`)})
+	datastore.Send(test.Str("user/1/name"))
+
+	data, err := c.Next(context.Background())
+	if err != nil {
+		t.Fatalf("c.Next() returned an error: %v", err)
+	}
+	if got := string(data["user/1/name"]); got != `"new"` {
+		t.Errorf("Got value `%s`, expected `\"new\"`", got)
+	}
+	if kb.updated != 1 {
+		t.Errorf("Keysbuilder was updated %d times, expected 1", kb.updated)
+	}
+}
+
+func BenchmarkUnrelatedUpdates(b *testing.B) {
+	const keyCount = 100
+	datastore := new(test.MockDatastore)
+	closed := make(chan struct{})
+	defer close(closed)
+	s := autoupdate.New(datastore, new(test.MockRestricter), closed)
+
+	keys := make([]string, 0, keyCount)
+	unrelated := make([]string, 0, keyCount)
+	for i := 0; i < keyCount; i++ {
+		keys = append(keys, fmt.Sprintf("user/%d/name", i))
+		unrelated = append(unrelated, fmt.Sprintf("motion/%d/title", i))
+	}
+	c := s.Connect(1, mockKeysBuilder{keys: keys}, 0)
+	ctx := context.Background()
+	c.Next(ctx)
+
+	b.ResetTimer()
+
+	for n := 0; n < b.N; n++ {
+		// Ten unrelated updates followed by one related update.
+		for i := 0; i < 10; i++ {
+			datastore.Send(unrelated)
+		}
+		datastore.Update(map[string]json.RawMessage{keys[0]: []byte(fmt.Sprintf(`"value %d"`, n))})
+		datastore.Send(keys[:1])
+		c.Next(ctx)
+	}
+}
+
+func TestConnectionBatchRestriction(t *testing.T) {
+	datastore := new(test.MockDatastore)
+	restricter := new(countingRestricter)
+	closed := make(chan struct{})
+	defer close(closed)
+	s := autoupdate.New(datastore, restricter, closed)
+
+	kb := mockKeysBuilder{keys: test.Str("user/1/name")}
+	c1 := s.Connect(1, kb, 0)
+	c2 := s.Connect(1, kb, 0)
+	c3 := s.Connect(2, kb, 0)
+	for _, c := range []*autoupdate.Connection{c1, c2, c3} {
+		if _, err := c.Next(context.Background()); err != nil {
+			t.Fatalf("c.Next() returned an error: %v", err)
+		}
+	}
+	before := restricter.count("user/1/name")
+
+	datastore.Update(map[string]json.RawMessage{"user/1/name": []byte(`"new"`)})
+	datastore.Send(test.Str("user/1/name"))
+	for _, c := range []*autoupdate.Connection{c1, c2, c3} {
+		data, err := c.Next(context.Background())
+		if err != nil {
+			t.Fatalf("c.Next() returned an error: %v", err)
+		}
+		if got := string(data["user/1/name"]); got != `"new"` {
+			t.Errorf("Got value `%s`, expected `\"new\"`", got)
+		}
+	}
+
+	// One restriction for user 1 and one for user 2.
+	if got := restricter.count("user/1/name") - before; got != 2 {
+		t.Errorf("Key was restricted %d times, expected 2", got)
+	}
+}
+
+func TestConnectionResync(t *testing.T) {
+	datastore := test.NewMockDatastore(test.WithData(map[string]json.RawMessage{
+		"user/1/name": []byte(`"Hugo"`),
+		"user/1/note": []byte(`"old"`),
+	}), test.WithOnlyData())
+	closed := make(chan struct{})
+	defer close(closed)
+	s := autoupdate.New(datastore, new(test.MockRestricter), closed, autoupdate.WithTopicSize(2))
+
+	kb := mockKeysBuilder{keys: test.Str("user/1/name", "user/1/note")}
+	c := s.Connect(1, kb, 0)
+	if _, err := c.Next(context.Background()); err != nil {
+		t.Fatalf("c.Next() returned an error: %v", err)
+	}
+
+	// The connection does not read the first updates, so they are
+	// overwritten in the topic.
+	datastore.Update(map[string]json.RawMessage{"user/1/note": []byte(`"new"`)})
+	datastore.Send(test.Str("user/1/note"))
+	datastore.Send(test.Str("other/1/key"))
+	datastore.Send(test.Str("other/2/key"))
+
+	data, err := c.Next(context.Background())
+	if err != nil {
+		t.Fatalf("c.Next() returned an error: %v", err)
+	}
+
+	cmpMap(t, data, map[string]json.RawMessage{"user/1/note": []byte(`"new"`)})
+}
+
+func TestConnectionKnownHashes(t *testing.T) {
+	data := map[string]json.RawMessage{
+		"user/1/name":    []byte(`"Hugo"`),
+		"user/2/name":    []byte(`"Gerda"`),
+		"motion/1/title": []byte(`"Title"`),
+	}
+	datastore := test.NewMockDatastore(test.WithData(data), test.WithOnlyData())
+	closed := make(chan struct{})
+	defer close(closed)
+	s := autoupdate.New(datastore, new(test.MockRestricter), closed)
+
+	hashes := autoupdate.CollectionHashes(map[string]json.RawMessage{
+		"user/1/name": []byte(`"Hugo"`),
+		"user/2/name": []byte(`"Gerda"`),
+	})
+	hashes["motion"] = "outdated"
+
+	kb := mockKeysBuilder{keys: test.Str("user/1/name", "user/2/name", "motion/1/title")}
+	c := s.Connect(1, kb, 0)
+	c.SetKnownHashes(hashes)
+
+	got, err := c.Next(context.Background())
+	if err != nil {
+		t.Fatalf("c.Next() returned an error: %v", err)
+	}
+	cmpMap(t, got, map[string]json.RawMessage{"motion/1/title": []byte(`"Title"`)})
+
+	// Values of known collections are still compared on updates.
+	datastore.Update(map[string]json.RawMessage{"user/1/name": []byte(`"Hugo"`), "user/2/name": []byte(`"new"`)})
+	datastore.Send(test.Str("user/1/name", "user/2/name"))
+
+	got, err = c.Next(context.Background())
+	if err != nil {
+		t.Fatalf("c.Next() returned an error: %v", err)
+	}
+	cmpMap(t, got, map[string]json.RawMessage{"user/2/name": []byte(`"new"`)})
+}
+
+func TestConnectionSetPriority(t *testing.T) {
+	closed := make(chan struct{})
+	defer close(closed)
+
+	for _, tt := range []struct {
+		name       string
+		restricter autoupdate.Restricter
+		options    []autoupdate.Option
+		errType    string
+	}{
+		{"allowed", restrict.New(&test.MockPermission{Default: true}, nil), nil, ""},
+		{"not allowed", restrict.New(&test.MockPermission{Default: false}, nil), nil, "PermissionDenied"},
+		{"not supported", new(test.MockRestricter), nil, "PermissionDenied"},
+		{
+			"permitter option",
+			new(test.MockRestricter),
+			[]autoupdate.Option{autoupdate.WithPriorityPermitter(restrict.New(&test.MockPermission{Default: true}, nil))},
+			"",
+		},
+		{
+			"permitter option before restricter",
+			restrict.New(&test.MockPermission{Default: true}, nil),
+			[]autoupdate.Option{autoupdate.WithPriorityPermitter(restrict.New(&test.MockPermission{Default: false}, nil))},
+			"PermissionDenied",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			s := autoupdate.New(new(test.MockDatastore), tt.restricter, closed, tt.options...)
+			c := s.Connect(1, mockKeysBuilder{keys: test.Str("user/1/name")}, 0)
+
+			err := c.SetPriority(context.Background(), autoupdate.PriorityHigh)
+			if tt.errType == "" {
+				if err != nil {
+					t.Errorf("SetPriority returned unexpected error: %v", err)
+				}
+				return
+			}
+
+			var pErr autoupdate.PriorityError
+			if !errors.As(err, &pErr) || pErr.Type() != tt.errType {
+				t.Errorf("Got error %v, expected a PriorityError of type %s", err, tt.errType)
+			}
+		})
+	}
+}
+
+func TestConnectionResume(t *testing.T) {
+	datastore := test.NewMockDatastore(test.WithData(map[string]json.RawMessage{
+		"user/1/name":  []byte(`"Hugo"`),
+		"user/1/note":  []byte(`"old"`),
+		"user/1/title": []byte(`"Dr."`),
+	}), test.WithOnlyData())
+	closed := make(chan struct{})
+	defer close(closed)
+	s := autoupdate.New(datastore, new(test.MockRestricter), closed)
+
+	kb := mockKeysBuilder{keys: test.Str("user/1/name", "user/1/note", "user/1/title")}
+	snapshot, err := s.Snapshot(context.Background(), 1, kb, s.LastID())
+	if err != nil {
+		t.Fatalf("Snapshot returned an error: %v", err)
+	}
+	if len(snapshot.Data) != 3 {
+		t.Errorf("Snapshot has %d keys, expected 3", len(snapshot.Data))
+	}
+
+	datastore.Update(map[string]json.RawMessage{"user/1/note": []byte(`"new"`), "user/1/title": nil})
+	datastore.Send(test.Str("user/1/note", "user/1/title"))
+
+	t.Run("resume", func(t *testing.T) {
+		c := s.Connect(1, kb, s.LastID())
+		c.Resume(snapshot.Position, snapshot.Token)
+
+		data, err := c.Next(context.Background())
+		if err != nil {
+			t.Fatalf("c.Next() returned an error: %v", err)
+		}
+
+		cmpMap(t, data, map[string]json.RawMessage{"user/1/note": []byte(`"new"`), "user/1/title": nil})
+	})
+
+	t.Run("other keys", func(t *testing.T) {
+		otherKB := mockKeysBuilder{keys: test.Str("user/1/name", "user/1/note")}
+		c := s.Connect(1, otherKB, s.LastID())
+		c.Resume(snapshot.Position, snapshot.Token)
+
+		data, err := c.Next(context.Background())
+		if err != nil {
+			t.Fatalf("c.Next() returned an error: %v", err)
+		}
+
+		cmpMap(t, data, map[string]json.RawMessage{"user/1/name": []byte(`"Hugo"`), "user/1/note": []byte(`"new"`)})
+	})
+
+	t.Run("unknown position", func(t *testing.T) {
+		c := s.Connect(1, kb, s.LastID())
+		c.Resume(snapshot.Position+100, snapshot.Token)
+
+		data, err := c.Next(context.Background())
+		if err != nil {
+			t.Fatalf("c.Next() returned an error: %v", err)
+		}
+
+		cmpMap(t, data, map[string]json.RawMessage{"user/1/name": []byte(`"Hugo"`), "user/1/note": []byte(`"new"`)})
+	})
+}
+
+func TestConnectionResumeAfterPermissionChange(t *testing.T) {
+	datastore := test.NewMockDatastore(test.WithData(map[string]json.RawMessage{
+		"user/1/name": []byte(`"Hugo"`),
+		"user/1/note": []byte(`"secret"`),
+	}), test.WithOnlyData())
+	restricter := new(groupRestricter)
+	closed := make(chan struct{})
+	defer close(closed)
+	s := autoupdate.New(datastore, restricter, closed)
+
+	kb := mockKeysBuilder{keys: test.Str("user/1/name", "user/1/note")}
+	snapshot, err := s.Snapshot(context.Background(), 1, kb, s.LastID())
+	if err != nil {
+		t.Fatalf("Snapshot returned an error: %v", err)
+	}
+	cmpMap(t, snapshot.Data, map[string]json.RawMessage{"user/1/name": []byte(`"Hugo"`)})
+
+	// The user gets the permission to see the note. No key of the connection
+	// changes.
+	restricter.setInGroup(true)
+
+	c := s.Connect(1, kb, s.LastID())
+	c.Resume(snapshot.Position, snapshot.Token)
+
+	data, err := c.Next(context.Background())
+	if err != nil {
+		t.Fatalf("c.Next() returned an error: %v", err)
+	}
+
+	cmpMap(t, data, map[string]json.RawMessage{"user/1/name": []byte(`"Hugo"`), "user/1/note": []byte(`"secret"`)})
+
+	// The user loses the permission again. A client, that resumes with the
+	// token of the connection, gets all data without the note.
+	token := c.ResumeToken()
+	restricter.setInGroup(false)
+
+	c = s.Connect(1, kb, s.LastID())
+	c.Resume(s.LastID(), token)
+
+	data, err = c.Next(context.Background())
+	if err != nil {
+		t.Fatalf("c.Next() returned an error: %v", err)
+	}
+
+	cmpMap(t, data, map[string]json.RawMessage{"user/1/name": []byte(`"Hugo"`)})
+}
+
+func TestConnectionConsistentPosition(t *testing.T) {
+	datastore := &changingDatastore{
+		MockDatastore: test.NewMockDatastore(test.WithData(map[string]json.RawMessage{
+			"user/1/name": []byte(`"old"`),
+			"user/2/name": []byte(`"old"`),
+		})),
+		change: map[string]json.RawMessage{
+			"user/1/name": []byte(`"new"`),
+			"user/2/name": []byte(`"new"`),
+		},
+	}
+	closed := make(chan struct{})
+	defer close(closed)
+	s := autoupdate.New(datastore, new(test.MockRestricter), closed)
+	c := s.Connect(1, mockKeysBuilder{keys: test.Str("user/1/name", "user/2/name")}, 0)
+
+	// The values change while the first data is read.
+	data, err := c.Next(context.Background())
+	if err != nil {
+		t.Fatalf("Next returned unexpected error: %v", err)
+	}
+
+	expect := map[string]json.RawMessage{
+		"user/1/name": []byte(`"new"`),
+		"user/2/name": []byte(`"new"`),
+	}
+	cmpMap(t, data, expect)
+
+	if got := c.Position(); got != s.LastID() {
+		t.Errorf("Got position %d, expected %d", got, s.LastID())
+	}
+}
+
+// changingDatastore is a MockDatastore that changes its values after the first
+// call to Get. The first call returns the old values.
+type changingDatastore struct {
+	*test.MockDatastore
+	change  map[string]json.RawMessage
+	changed bool
+}
+
+func (d *changingDatastore) Get(ctx context.Context, keys ...string) ([]json.RawMessage, error) {
+	values, err := d.MockDatastore.Get(ctx, keys...)
+	if err != nil || d.changed {
+		return values, err
+	}
+
+	d.changed = true
+	d.Update(d.change)
+	keys = nil
+	for key := range d.change {
+		keys = append(keys, key)
+	}
+	d.Send(keys)
+	return values, nil
+}
+
+func TestConnectionConsistentAfterRetries(t *testing.T) {
+	// The values change on more reads than the retries without a pause.
+	datastore := &flappingDatastore{MockDatastore: test.NewMockDatastore(), changes: 6}
+	closed := make(chan struct{})
+	defer close(closed)
+	s := autoupdate.New(datastore, new(test.MockRestricter), closed)
+	c := s.Connect(1, mockKeysBuilder{keys: test.Str("user/1/name", "user/2/name")}, 0)
+
+	data, err := c.Next(context.Background())
+	if err != nil {
+		t.Fatalf("Next returned unexpected error: %v", err)
+	}
+
+	if string(data["user/1/name"]) != string(data["user/2/name"]) {
+		t.Errorf("Got values of different positions: %s", data)
+	}
+	if got := string(data["user/1/name"]); got != `"6"` {
+		t.Errorf("Got value %s, expected the value of the last change", got)
+	}
+	if got := c.Position(); got != s.LastID() {
+		t.Errorf("Got position %d, expected %d", got, s.LastID())
+	}
+}
+
+func TestConnectionUpdateBetweenReads(t *testing.T) {
+	datastore := &interleavingDatastore{MockDatastore: test.NewMockDatastore(test.WithData(map[string]json.RawMessage{
+		"user/1/name": []byte(`"1"`),
+		"user/2/name": []byte(`"1"`),
+	}))}
+	closed := make(chan struct{})
+	defer close(closed)
+	s := autoupdate.New(datastore, new(test.MockRestricter), closed)
+	c := s.Connect(1, mockKeysBuilder{keys: test.Str("user/1/name", "user/2/name")}, 0)
+
+	// Both values change between the reads of the two keys. The change is not
+	// in the topic, when the first data is checked.
+	datastore.interleave = true
+	data, err := c.Next(context.Background())
+	if err != nil {
+		t.Fatalf("Next returned unexpected error: %v", err)
+	}
+
+	cmpMap(t, data, map[string]json.RawMessage{
+		"user/1/name": []byte(`"2"`),
+		"user/2/name": []byte(`"2"`),
+	})
+
+	// The same for an update.
+	datastore.interleave = true
+	datastore.Send(test.Str("user/1/name", "user/2/name"))
+	data, err = c.Next(context.Background())
+	if err != nil {
+		t.Fatalf("Next returned unexpected error: %v", err)
+	}
+
+	if string(data["user/1/name"]) != string(data["user/2/name"]) {
+		t.Errorf("Got values of different positions: %s", data)
+	}
+	if got := c.Position(); got != s.LastID() {
+		t.Errorf("Got position %d, expected %d", got, s.LastID())
+	}
+}
+
+// interleavingDatastore is a MockDatastore that reads the keys one by one. If
+// interleave is set, both values are changed after the first key is read. The
+// datastore is updating, until Updating is called the next time. Then the
+// change listeners get the change.
+type interleavingDatastore struct {
+	*test.MockDatastore
+	interleave bool
+	updating   bool
+	count      int
+}
+
+func (d *interleavingDatastore) Get(ctx context.Context, keys ...string) ([]json.RawMessage, error) {
+	values := make([]json.RawMessage, len(keys))
+	for i, key := range keys {
+		v, err := d.MockDatastore.Get(ctx, key)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = v[0]
+
+		if d.interleave {
+			d.interleave = false
+			d.updating = true
+			d.count++
+			value := []byte(fmt.Sprintf(`"%d"`, d.count+1))
+			d.Update(map[string]json.RawMessage{"user/1/name": value, "user/2/name": value})
+		}
+	}
+	return values, nil
+}
+
+func (d *interleavingDatastore) Updating() bool {
+	if !d.updating {
+		return false
+	}
+
+	d.updating = false
+	d.Send(test.Str("user/1/name", "user/2/name"))
+	return true
+}
+
+func TestConnectionContinuousChanges(t *testing.T) {
+	// The values change on each read.
+	datastore := &flappingDatastore{MockDatastore: test.NewMockDatastore(), changes: math.MaxInt32}
+	closed := make(chan struct{})
+	defer close(closed)
+	clk := clock.NewMock(time.Now())
+	s := autoupdate.New(datastore, new(test.MockRestricter), closed, autoupdate.WithClock(clk))
+	c := s.Connect(1, mockKeysBuilder{keys: test.Str("user/1/name", "user/2/name")}, 0)
+
+	// Skip the pauses between the attempts.
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			clk.Add(time.Second)
+			time.Sleep(time.Millisecond)
+		}
+	}()
+
+	_, err := c.Next(context.Background())
+	var updateErr autoupdate.UpdateError
+	if !errors.As(err, &updateErr) {
+		t.Fatalf("Got error %v, expected an UpdateError", err)
+	}
+	if keys := updateErr.Keys(); len(keys) != 2 {
+		t.Errorf("Got keys %v, expected both keys", keys)
+	}
+
+	// The values settle. The next call reads them again.
+	datastore.changes = 0
+	data, err := c.Next(context.Background())
+	if err != nil {
+		t.Fatalf("Next returned unexpected error: %v", err)
+	}
+
+	if string(data["user/1/name"]) != string(data["user/2/name"]) {
+		t.Errorf("Got values of different positions: %s", data)
+	}
+	if got := c.Position(); got != s.LastID() {
+		t.Errorf("Got position %d, expected %d", got, s.LastID())
+	}
+
+	// An update, where the values change on each read, fails in the same way.
+	datastore.changes = math.MaxInt32
+	datastore.count = 0
+	datastore.Send(test.Str("user/1/name"))
+
+	_, err = c.Next(context.Background())
+	if !errors.As(err, &updateErr) {
+		t.Fatalf("Got error %v for the update, expected an UpdateError", err)
+	}
+
+	datastore.changes = 0
+	data, err = c.Next(context.Background())
+	if err != nil {
+		t.Fatalf("Next returned unexpected error: %v", err)
+	}
+	if string(data["user/1/name"]) != string(data["user/2/name"]) {
+		t.Errorf("Got values of different positions: %s", data)
+	}
+}
+
+// flappingDatastore is a MockDatastore that changes both values on each call
+// to Get, until changes is reached. Each call returns a torn result, where
+// only the first key has the new value.
+type flappingDatastore struct {
+	*test.MockDatastore
+	changes int
+	count   int
+}
+
+func (d *flappingDatastore) Get(ctx context.Context, keys ...string) ([]json.RawMessage, error) {
+	if d.count >= d.changes {
+		return d.MockDatastore.Get(ctx, keys...)
+	}
+
+	d.count++
+	value := []byte(fmt.Sprintf(`"%d"`, d.count))
+	d.Update(map[string]json.RawMessage{"user/1/name": value})
+	values, err := d.MockDatastore.Get(ctx, keys...)
+	d.Update(map[string]json.RawMessage{"user/2/name": value})
+	d.Send(test.Str("user/1/name", "user/2/name"))
+	return values, err
+}
+
+func TestConnectionUpdateError(t *testing.T) {
+	datastore := &failingDatastore{MockDatastore: test.NewMockDatastore()}
+	closed := make(chan struct{})
+	defer close(closed)
+	s := autoupdate.New(datastore, new(test.MockRestricter), closed)
+	c := s.Connect(1, mockKeysBuilder{keys: test.Str("user/1/name", "user/2/name")}, 0)
+
+	if _, err := c.Next(context.Background()); err != nil {
+		t.Fatalf("Next returned unexpected error: %v", err)
+	}
+
+	datastore.fail = true
+	datastore.Update(map[string]json.RawMessage{"user/1/name": []byte(`"new"`)})
+	datastore.Send(test.Str("user/1/name"))
+
+	_, err := c.Next(context.Background())
+	var updateErr autoupdate.UpdateError
+	if !errors.As(err, &updateErr) {
+		t.Fatalf("Got error %v, expected an UpdateError", err)
+	}
+	if keys := updateErr.Keys(); len(keys) != 1 || keys[0] != "user/1/name" {
+		t.Errorf("Got failed keys %v, expected [user/1/name]", keys)
+	}
+
+	// The next call reads the failed keys again without a new update.
+	datastore.fail = false
+	data, err := c.Next(context.Background())
+	if err != nil {
+		t.Fatalf("Next returned unexpected error: %v", err)
+	}
+	cmpMap(t, data, map[string]json.RawMessage{"user/1/name": []byte(`"new"`)})
+}
+
+// failingDatastore is a MockDatastore, where Get returns an error, if fail is
+// true.
+type failingDatastore struct {
+	*test.MockDatastore
+	fail bool
+}
+
+func (d *failingDatastore) Get(ctx context.Context, keys ...string) ([]json.RawMessage, error) {
+	if d.fail {
+		return nil, errors.New("datastore is not available")
+	}
+	return d.MockDatastore.Get(ctx, keys...)
+}
+
+func TestConnectionPendingKeys(t *testing.T) {
+	datastore := &slowDatastore{MockDatastore: test.NewMockDatastore(), slow: map[string]bool{"user/2/name": true}}
+	closed := make(chan struct{})
+	defer close(closed)
+	s := autoupdate.New(datastore, new(test.MockRestricter), closed)
+	c := s.Connect(1, mockKeysBuilder{keys: test.Str("user/1/name", "user/2/name")}, 0)
+
+	data, err := c.Next(context.Background())
+	if err != nil {
+		t.Fatalf("Next returned unexpected error: %v", err)
+	}
+	cmpMap(t, data, map[string]json.RawMessage{"user/1/name": []byte(`"Hello World"`)})
+	if pending := c.Pending(); len(pending) != 1 || pending[0] != "user/2/name" {
+		t.Errorf("Got pending keys %v, expected [user/2/name]", pending)
+	}
+
+	// The next call reads the pending keys again without a new update.
+	datastore.slow = nil
+	data, err = c.Next(context.Background())
+	if err != nil {
+		t.Fatalf("Next returned unexpected error: %v", err)
+	}
+	cmpMap(t, data, map[string]json.RawMessage{"user/2/name": []byte(`"Hello World"`)})
+	if pending := c.Pending(); len(pending) != 0 {
+		t.Errorf("Got pending keys %v, expected none", pending)
+	}
+}
+
+// slowDatastore is a MockDatastore, where Get returns a pending error for the
+// keys in slow.
+type slowDatastore struct {
+	*test.MockDatastore
+	slow map[string]bool
+}
+
+func (d *slowDatastore) Get(ctx context.Context, keys ...string) ([]json.RawMessage, error) {
+	values, err := d.MockDatastore.Get(ctx, keys...)
+	if err != nil {
+		return nil, err
+	}
+
+	var pending []string
+	for i, key := range keys {
+		if d.slow[key] {
+			values[i] = nil
+			pending = append(pending, key)
+		}
+	}
+	if len(pending) > 0 {
+		return values, pendingError(pending)
+	}
+	return values, nil
+}
+
+type pendingError []string
+
+func (e pendingError) Error() string         { return "some keys are pending" }
+func (e pendingError) PendingKeys() []string { return e }
+
+func TestConnectionBatchPermissionClass(t *testing.T) {
+	datastore := new(test.MockDatastore)
+	restricter := new(classRestricter)
+	closed := make(chan struct{})
+	defer close(closed)
+	s := autoupdate.New(datastore, restricter, closed)
+
+	kb := mockKeysBuilder{keys: test.Str("user/1/name")}
+	var connections []*autoupdate.Connection
+	for _, uid := range []int{1, 2, 3} {
+		c := s.Connect(uid, kb, 0)
+		if _, err := c.Next(context.Background()); err != nil {
+			t.Fatalf("c.Next() returned an error: %v", err)
+		}
+		connections = append(connections, c)
+	}
+	before := restricter.count("user/1/name")
+
+	datastore.Update(map[string]json.RawMessage{"user/1/name": []byte(`"new"`)})
+	datastore.Send(test.Str("user/1/name"))
+	for _, c := range connections {
+		if _, err := c.Next(context.Background()); err != nil {
+			t.Fatalf("c.Next() returned an error: %v", err)
+		}
+	}
+
+	// All users are in the same class.
+	if got := restricter.count("user/1/name") - before; got != 1 {
+		t.Errorf("Key was restricted %d times, expected 1", got)
+	}
+}
+
+func TestConnectionBatchDoesNotBlockOtherKeys(t *testing.T) {
+	datastore := new(test.MockDatastore)
+	restricter := &classRestricter{
+		started: make(chan struct{}, 1),
+		unblock: make(chan struct{}),
+	}
+	closed := make(chan struct{})
+	defer close(closed)
+	s := autoupdate.New(datastore, restricter, closed)
+
+	blocked := s.Connect(1, mockKeysBuilder{keys: test.Str("user/1/name")}, 0)
+	other := s.Connect(2, mockKeysBuilder{keys: test.Str("user/2/name")}, 0)
+	for _, c := range []*autoupdate.Connection{blocked, other} {
+		if _, err := c.Next(context.Background()); err != nil {
+			t.Fatalf("c.Next() returned an error: %v", err)
+		}
+	}
+
+	restricter.blockKey("user/1/name")
+	datastore.Update(map[string]json.RawMessage{"user/1/name": []byte(`"new"`), "user/2/name": []byte(`"new"`)})
+	datastore.Send(test.Str("user/1/name", "user/2/name"))
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := blocked.Next(context.Background())
+		done <- err
+	}()
+	<-restricter.started
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	data, err := other.Next(ctx)
+	if err != nil {
+		t.Errorf("Connection with other keys was blocked: %v", err)
+	}
+	if got := string(data["user/2/name"]); got != `"new"` {
+		t.Errorf("Got value `%s`, expected `\"new\"`", got)
+	}
+
+	close(restricter.unblock)
+	if err := <-done; err != nil {
+		t.Errorf("c.Next() returned an error: %v", err)
+	}
+}
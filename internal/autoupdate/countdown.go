@@ -0,0 +1,141 @@
+package autoupdate
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The countdown fields are synthetic fields of projector_countdown objects.
+// They are computed with the time of the server, so all clients show the same
+// remaining time, even when their clocks differ.
+//
+// remaining is the number of seconds, the countdown has left at server_time.
+// server_time is the unix time of the server in seconds, when the value was
+// computed. A client counts down from remaining when it receives the value and
+// can use server_time to compute the offset of its own clock.
+const (
+	countdownCollection = "projector_countdown"
+	countdownRemaining  = "remaining"
+	countdownServerTime = "server_time"
+)
+
+// countdownChanged returns the countdown keys, that change because of the
+// changed keys.
+func countdownChanged(keys []string) []string {
+	var out []string
+	for _, key := range keys {
+		fqid, field, ok := countdownKey(key)
+		if !ok || (field != "countdown_time" && field != "running") {
+			continue
+		}
+		out = append(out, fqid+"/"+countdownRemaining, fqid+"/"+countdownServerTime)
+	}
+	return out
+}
+
+// countdownData computes the values of all countdown keys in keys. The values
+// are visible for a user, if the user can see the field countdown_time.
+func (a *Autoupdate) countdownData(ctx context.Context, keys []string) (map[string]aggregateValue, error) {
+	requested := make(map[string][]string)
+	for _, key := range keys {
+		fqid, field, ok := countdownKey(key)
+		if !ok || (field != countdownRemaining && field != countdownServerTime) {
+			continue
+		}
+		requested[fqid] = append(requested[fqid], key)
+	}
+
+	if len(requested) == 0 {
+		return nil, nil
+	}
+
+	sourceKeys := make([]string, 0, 2*len(requested))
+	for fqid := range requested {
+		sourceKeys = append(sourceKeys, fqid+"/countdown_time", fqid+"/running")
+	}
+
+	values, err := a.get(ctx, sourceKeys...)
+	if err != nil {
+		return nil, fmt.Errorf("get countdown fields: %w", err)
+	}
+
+	now := a.clock.Now()
+	out := make(map[string]aggregateValue, len(keys))
+	for i := 0; i < len(sourceKeys); i += 2 {
+		timeKey := sourceKeys[i]
+		fqid := timeKey[:strings.LastIndexByte(timeKey, '/')]
+
+		remaining, err := countdownRemainingTime(values[i], values[i+1], now)
+		if err != nil {
+			return nil, fmt.Errorf("compute countdown of %s: %w", fqid, err)
+		}
+
+		computed := map[string]json.RawMessage{
+			countdownRemaining:  remaining,
+			countdownServerTime: formatSeconds(float64(now.UnixNano()) / float64(time.Second)),
+		}
+		if remaining == nil {
+			computed[countdownServerTime] = nil
+		}
+
+		for _, key := range requested[fqid] {
+			_, field, _ := countdownKey(key)
+			out[key] = aggregateValue{
+				value:   computed[field],
+				sources: map[string]json.RawMessage{timeKey: values[i]},
+			}
+		}
+	}
+	return out, nil
+}
+
+// countdownRemainingTime returns the remaining seconds of a countdown. If the
+// countdown is running, countdown_time is the unix time, when the countdown
+// ends. Otherwise it is the remaining time. Returns nil, if the countdown does
+// not exist.
+func countdownRemainingTime(rawTime, rawRunning json.RawMessage, now time.Time) (json.RawMessage, error) {
+	if rawTime == nil {
+		return nil, nil
+	}
+
+	var countdownTime float64
+	if err := json.Unmarshal(rawTime, &countdownTime); err != nil {
+		return nil, fmt.Errorf("decoding countdown_time: %w", err)
+	}
+
+	var running bool
+	if rawRunning != nil {
+		if err := json.Unmarshal(rawRunning, &running); err != nil {
+			return nil, fmt.Errorf("decoding running: %w", err)
+		}
+	}
+
+	if !running {
+		return formatSeconds(countdownTime), nil
+	}
+	return formatSeconds(countdownTime - float64(now.UnixNano())/float64(time.Second)), nil
+}
+
+// countdownKey splits a key of a projector_countdown object into its fqid and
+// field. Returns false, if the key does not belong to a countdown.
+func countdownKey(key string) (string, string, bool) {
+	if !strings.HasPrefix(key, countdownCollection+"/") {
+		return "", "", false
+	}
+
+	idx := strings.LastIndexByte(key, '/')
+	if idx <= len(countdownCollection) {
+		return "", "", false
+	}
+	return key[:idx], key[idx+1:], true
+}
+
+// formatSeconds encodes seconds as json number with millisecond precision.
+func formatSeconds(s float64) json.RawMessage {
+	return []byte(strconv.FormatFloat(math.Round(s*1000)/1000, 'f', -1, 64))
+}
@@ -0,0 +1,75 @@
+package autoupdate_test
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/openslides/openslides-autoupdate-service/internal/autoupdate"
+	"github.com/openslides/openslides-autoupdate-service/internal/clock"
+	"github.com/openslides/openslides-autoupdate-service/internal/restrict"
+	"github.com/openslides/openslides-autoupdate-service/internal/test"
+)
+
+func TestCountdown(t *testing.T) {
+	datastore := test.NewMockDatastore(test.WithData(map[string]json.RawMessage{
+		"projector_countdown/1/countdown_time": []byte(`1000060`),
+		"projector_countdown/1/running":        []byte(`true`),
+	}), test.WithOnlyData())
+	closed := make(chan struct{})
+	defer close(closed)
+
+	c := clock.NewMock(time.Unix(1000000, 0))
+	s := autoupdate.New(datastore, new(test.MockRestricter), closed, autoupdate.WithClock(c))
+
+	kb := mockKeysBuilder{keys: test.Str("projector_countdown/1/remaining", "projector_countdown/1/server_time")}
+	conn := s.Connect(1, kb, 0)
+
+	data, err := conn.Next(context.Background())
+	if err != nil {
+		t.Fatalf("Next returned an error: %v", err)
+	}
+	cmpMap(t, data, map[string]json.RawMessage{
+		"projector_countdown/1/remaining":   []byte(`60`),
+		"projector_countdown/1/server_time": []byte(`1000000`),
+	})
+
+	// Stopping the countdown sets the remaining time.
+	c.Add(20500 * time.Millisecond)
+	datastore.Update(map[string]json.RawMessage{
+		"projector_countdown/1/countdown_time": []byte(`39.5`),
+		"projector_countdown/1/running":        []byte(`false`),
+	})
+	datastore.Send(test.Str("projector_countdown/1/countdown_time", "projector_countdown/1/running"))
+
+	data, err = conn.Next(context.Background())
+	if err != nil {
+		t.Fatalf("Next returned an error: %v", err)
+	}
+	cmpMap(t, data, map[string]json.RawMessage{
+		"projector_countdown/1/remaining":   []byte(`39.5`),
+		"projector_countdown/1/server_time": []byte(`1000020.5`),
+	})
+}
+
+func TestCountdownRestricted(t *testing.T) {
+	datastore := test.NewMockDatastore(test.WithData(map[string]json.RawMessage{
+		"projector_countdown/1/countdown_time": []byte(`60`),
+		"projector_countdown/1/running":        []byte(`false`),
+	}), test.WithOnlyData())
+	closed := make(chan struct{})
+	defer close(closed)
+
+	perm := &test.MockPermission{Default: false}
+	s := autoupdate.New(datastore, restrict.New(perm, nil), closed)
+
+	data, err := s.RestrictedData(context.Background(), 1, "projector_countdown/1/remaining")
+	if err != nil {
+		t.Fatalf("RestrictedData returned an error: %v", err)
+	}
+
+	if len(data) != 1 || data["projector_countdown/1/remaining"] != nil {
+		t.Errorf("Got %v, expected only an invisible countdown", data)
+	}
+}
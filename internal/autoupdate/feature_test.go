@@ -64,9 +64,7 @@ var dataSet = map[string]json.RawMessage{
 }
 
 func TestFeatures(t *testing.T) {
-	datastore := new(test.MockDatastore)
-	datastore.Data = dataSet
-	datastore.OnlyData = true
+	datastore := test.NewMockDatastore(test.WithData(dataSet), test.WithOnlyData())
 	closed := make(chan struct{})
 	defer close(closed)
 	s := autoupdate.New(datastore, new(test.MockRestricter), closed)
@@ -0,0 +1,61 @@
+package autoupdate
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sort"
+	"strings"
+)
+
+// CollectionHashes returns a hash for the values of each collection in data.
+//
+// The hash is the hex encoded sha256 of all keys of the collection in sorted
+// order, each followed by a colon, the value and a newline. Clients can build
+// the same hash from the data they received to skip unchanged collections on a
+// reconnect.
+func CollectionHashes(data map[string]json.RawMessage) map[string]string {
+	collections := make(map[string][]string)
+	for key := range data {
+		collection := key
+		if i := strings.IndexByte(key, '/'); i != -1 {
+			collection = key[:i]
+		}
+		collections[collection] = append(collections[collection], key)
+	}
+
+	hashes := make(map[string]string, len(collections))
+	for collection, keys := range collections {
+		sort.Strings(keys)
+
+		h := sha256.New()
+		for _, key := range keys {
+			h.Write([]byte(key))
+			h.Write([]byte(":"))
+			h.Write(data[key])
+			h.Write([]byte("\n"))
+		}
+		hashes[collection] = hex.EncodeToString(h.Sum(nil))
+	}
+	return hashes
+}
+
+// removeKnownCollections removes all keys of the collections, where the hash
+// of the client is the same as the hash of the data.
+func removeKnownCollections(data map[string]json.RawMessage, known map[string]string) {
+	if len(known) == 0 {
+		return
+	}
+
+	hashes := CollectionHashes(data)
+	for key := range data {
+		collection := key
+		if i := strings.IndexByte(key, '/'); i != -1 {
+			collection = key[:i]
+		}
+
+		if h, ok := known[collection]; ok && h == hashes[collection] {
+			delete(data, key)
+		}
+	}
+}
@@ -0,0 +1,220 @@
+package autoupdate
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+)
+
+var reFQID = regexp.MustCompile(`^[a-z][a-z0-9_]*/[1-9][0-9]*$`)
+
+// HistoryEntry is the restricted data of an object at one position.
+type HistoryEntry struct {
+	Position int                        `json:"position"`
+	Data     map[string]json.RawMessage `json:"data"`
+}
+
+// History returns the restricted data of an object at each position, where it
+// was changed.
+//
+// The datastore has to implement the HistoryReader interface and the
+// restricter the HistoryPermitter interface. Fields that the user can not see
+// are not in the returned data.
+func (a *Autoupdate) History(ctx context.Context, uid int, fqid string) ([]HistoryEntry, error) {
+	if !reFQID.MatchString(fqid) {
+		return nil, HistoryError{msg: fmt.Sprintf("invalid fqid `%s`", fqid), typ: "InvalidFQID"}
+	}
+
+	reader, ok := a.datastore.(HistoryReader)
+	if !ok {
+		return nil, fmt.Errorf("datastore does not support the history")
+	}
+
+	if err := a.checkHistoryPermission(uid); err != nil {
+		return nil, err
+	}
+
+	positions, err := reader.HistoryPositions(ctx, fqid)
+	if err != nil {
+		return nil, fmt.Errorf("get history positions: %w", err)
+	}
+
+	entries := make([]HistoryEntry, 0, len(positions))
+	for _, position := range positions {
+		data, err := reader.GetPosition(ctx, position, fqid)
+		if err != nil {
+			return nil, fmt.Errorf("get data at position %d: %w", position, err)
+		}
+
+		if err := a.restricter.Restrict(ctx, uid, data); err != nil {
+			return nil, fmt.Errorf("restrict data at position %d: %w", position, err)
+		}
+
+		for k, v := range data {
+			if v == nil {
+				delete(data, k)
+			}
+		}
+
+		entries = append(entries, HistoryEntry{Position: position, Data: data})
+	}
+	return entries, nil
+}
+
+// HistoryData reads the restricted data at an older position. It implements
+// the keysbuilder.DataProvider interface, so a keysbuilder follows the
+// relations as they were at the position.
+//
+// Has to be created with Autoupdate.AtPosition.
+type HistoryData struct {
+	restricter Restricter
+	reader     PositionReader
+	position   int
+}
+
+// AtPosition returns a HistoryData for the given position. The user needs the
+// permission to see the history.
+//
+// The datastore has to implement the PositionReader interface and the
+// restricter the HistoryPermitter interface.
+func (a *Autoupdate) AtPosition(uid int, position int) (*HistoryData, error) {
+	if position < 1 {
+		return nil, HistoryError{msg: fmt.Sprintf("invalid position %d", position), typ: "InvalidPosition"}
+	}
+
+	reader, ok := a.datastore.(PositionReader)
+	if !ok {
+		return nil, fmt.Errorf("datastore does not support reading at a position")
+	}
+
+	if err := a.checkHistoryPermission(uid); err != nil {
+		return nil, err
+	}
+
+	return &HistoryData{restricter: a.restricter, reader: reader, position: position}, nil
+}
+
+// RestrictedData returns the restricted values of the keys at the position.
+// Keys, that did not exist at the position or that the user can not see, have
+// the value nil.
+//
+// The permissions are checked with the current data and not with the data at
+// the position.
+func (h *HistoryData) RestrictedData(ctx context.Context, uid int, keys ...string) (map[string]json.RawMessage, error) {
+	values, err := h.reader.GetAt(ctx, h.position, keys...)
+	if err != nil {
+		return nil, fmt.Errorf("get keys at position %d: %w", h.position, err)
+	}
+
+	data := make(map[string]json.RawMessage, len(keys))
+	for i, key := range keys {
+		data[key] = values[i]
+	}
+
+	if err := h.restricter.Restrict(ctx, uid, data); err != nil {
+		return nil, fmt.Errorf("restrict data at position %d: %w", h.position, err)
+	}
+	return data, nil
+}
+
+// HistoryPosition is a position, where an object was changed. Timestamp is
+// the unix time of the change. UserID is the user, that changed the object. It
+// is 0, if the change was not done by a user or the user is not visible.
+type HistoryPosition struct {
+	Position  int   `json:"position"`
+	Timestamp int64 `json:"timestamp"`
+	UserID    int   `json:"user_id,omitempty"`
+}
+
+// HistoryPositions returns the positions, where an object was changed, with the
+// time and the acting user. It does not return the data of the object. See
+// History for this.
+//
+// The datastore has to implement the HistoryInformer interface and the
+// restricter the HistoryPermitter interface. The acting user is only returned,
+// if the requesting user can see the username of the acting user.
+func (a *Autoupdate) HistoryPositions(ctx context.Context, uid int, fqid string) ([]HistoryPosition, error) {
+	if !reFQID.MatchString(fqid) {
+		return nil, HistoryError{msg: fmt.Sprintf("invalid fqid `%s`", fqid), typ: "InvalidFQID"}
+	}
+
+	informer, ok := a.datastore.(HistoryInformer)
+	if !ok {
+		return nil, fmt.Errorf("datastore does not support the history")
+	}
+
+	if err := a.checkHistoryPermission(uid); err != nil {
+		return nil, err
+	}
+
+	positions := []HistoryPosition{}
+	var userKeys []string
+	seen := make(map[string]bool)
+	err := informer.HistoryInformation(ctx, fqid, func(position int, timestamp int64, userID int) {
+		positions = append(positions, HistoryPosition{Position: position, Timestamp: timestamp, UserID: userID})
+		if key := actingUserKey(userID); userID != 0 && !seen[key] {
+			userKeys = append(userKeys, key)
+			seen[key] = true
+		}
+	})
+	if err != nil {
+		return nil, fmt.Errorf("get history information: %w", err)
+	}
+
+	if len(userKeys) == 0 {
+		return positions, nil
+	}
+
+	// Hide the acting users, that the user can not see.
+	users, err := a.RestrictedData(ctx, uid, userKeys...)
+	if err != nil {
+		return nil, fmt.Errorf("restrict acting users: %w", err)
+	}
+	for i := range positions {
+		if positions[i].UserID != 0 && users[actingUserKey(positions[i].UserID)] == nil {
+			positions[i].UserID = 0
+		}
+	}
+	return positions, nil
+}
+
+// actingUserKey returns the key, that decides if an acting user is visible.
+func actingUserKey(userID int) string {
+	return "user/" + strconv.Itoa(userID) + "/username"
+}
+
+// checkHistoryPermission returns a HistoryError, if the user is not allowed to
+// see the history.
+func (a *Autoupdate) checkHistoryPermission(uid int) error {
+	permitter, ok := a.restricter.(HistoryPermitter)
+	if !ok {
+		return fmt.Errorf("restricter does not support the history")
+	}
+
+	allowed, err := permitter.CanSeeHistory(uid)
+	if err != nil {
+		return fmt.Errorf("check history permission: %w", err)
+	}
+	if !allowed {
+		return HistoryError{msg: "you are not allowed to see the history", typ: "PermissionDenied"}
+	}
+	return nil
+}
+
+// HistoryError is returned by History and HistoryPositions, when the request
+// is invalid or the user is not allowed to see the history.
+type HistoryError struct {
+	msg string
+	typ string
+}
+
+func (e HistoryError) Error() string {
+	return e.msg
+}
+
+// Type returns the name of the error.
+func (e HistoryError) Type() string {
+	return e.typ
+}
@@ -0,0 +1,189 @@
+package autoupdate_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/openslides/openslides-autoupdate-service/internal/autoupdate"
+	"github.com/openslides/openslides-autoupdate-service/internal/keysbuilder"
+	"github.com/openslides/openslides-autoupdate-service/internal/restrict"
+	"github.com/openslides/openslides-autoupdate-service/internal/test"
+)
+
+func TestHistory(t *testing.T) {
+	datastore := &historyDatastore{
+		MockDatastore: new(test.MockDatastore),
+		positions: map[int]map[string]json.RawMessage{
+			2: {"motion/1/title": []byte(`"first"`), "motion/1/secret": []byte(`"x"`)},
+			5: {"motion/1/title": []byte(`"second"`)},
+		},
+	}
+	perm := &test.MockPermission{Default: true, Data: map[string]bool{"motion/1/secret": false}}
+	closed := make(chan struct{})
+	defer close(closed)
+	s := autoupdate.New(datastore, restrict.New(perm, nil), closed)
+
+	entries, err := s.History(context.Background(), 1, "motion/1")
+	if err != nil {
+		t.Fatalf("History returned unexpected error: %v", err)
+	}
+
+	if len(entries) != 2 {
+		t.Fatalf("Got %d entries, expected 2", len(entries))
+	}
+	if entries[0].Position != 2 || entries[1].Position != 5 {
+		t.Errorf("Got positions %d and %d, expected 2 and 5", entries[0].Position, entries[1].Position)
+	}
+	cmpMap(t, entries[0].Data, map[string]json.RawMessage{"motion/1/title": []byte(`"first"`)})
+	cmpMap(t, entries[1].Data, map[string]json.RawMessage{"motion/1/title": []byte(`"second"`)})
+}
+
+func TestHistoryErrors(t *testing.T) {
+	datastore := &historyDatastore{MockDatastore: new(test.MockDatastore)}
+	closed := make(chan struct{})
+	defer close(closed)
+
+	for _, tt := range []struct {
+		name    string
+		allowed bool
+		fqid    string
+		errType string
+	}{
+		{"no permission", false, "motion/1", "PermissionDenied"},
+		{"invalid fqid", true, "motion/1/title", "InvalidFQID"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			perm := &test.MockPermission{Default: tt.allowed}
+			s := autoupdate.New(datastore, restrict.New(perm, nil), closed)
+
+			_, err := s.History(context.Background(), 1, tt.fqid)
+
+			var herr autoupdate.HistoryError
+			if !errors.As(err, &herr) {
+				t.Fatalf("Got error %v, expected a HistoryError", err)
+			}
+			if herr.Type() != tt.errType {
+				t.Errorf("Got error type %s, expected %s", herr.Type(), tt.errType)
+			}
+		})
+	}
+}
+
+func TestHistoryPositions(t *testing.T) {
+	datastore := &historyDatastore{
+		MockDatastore: test.NewMockDatastore(test.WithData(map[string]json.RawMessage{
+			"user/5/username": []byte(`"admin"`),
+			"user/6/username": []byte(`"secret"`),
+		})),
+	}
+	perm := &test.MockPermission{Default: true, Data: map[string]bool{"user/6/username": false}}
+	closed := make(chan struct{})
+	defer close(closed)
+	s := autoupdate.New(datastore, restrict.New(perm, nil), closed)
+
+	positions, err := s.HistoryPositions(context.Background(), 1, "motion/1")
+	if err != nil {
+		t.Fatalf("HistoryPositions returned unexpected error: %v", err)
+	}
+
+	expect := []autoupdate.HistoryPosition{
+		{Position: 2, Timestamp: 1600000200, UserID: 5},
+		{Position: 5, Timestamp: 1600000500, UserID: 0},
+	}
+	if len(positions) != len(expect) || positions[0] != expect[0] || positions[1] != expect[1] {
+		t.Errorf("Got %v, expected %v", positions, expect)
+	}
+}
+
+func TestAtPosition(t *testing.T) {
+	datastore := &historyDatastore{
+		MockDatastore: new(test.MockDatastore),
+		positions: map[int]map[string]json.RawMessage{
+			2: {
+				"motion/1/tag_ids": []byte(`[3]`),
+				"tag/3/name":       []byte(`"old"`),
+				"tag/3/secret":     []byte(`"x"`),
+			},
+			5: {
+				"motion/1/tag_ids": []byte(`[4]`),
+				"tag/4/name":       []byte(`"new"`),
+			},
+		},
+	}
+	perm := &test.MockPermission{Default: true, Data: map[string]bool{"tag/3/secret": false}}
+	closed := make(chan struct{})
+	defer close(closed)
+	s := autoupdate.New(datastore, restrict.New(perm, nil), closed)
+
+	provider, err := s.AtPosition(1, 2)
+	if err != nil {
+		t.Fatalf("AtPosition returned unexpected error: %v", err)
+	}
+
+	body := `[{"ids":[1],"collection":"motion","fields":{"tag_ids":{"type":"relation-list","collection":"tag","fields":{"name":null,"secret":null}}}}]`
+	kb, err := keysbuilder.ManyFromJSON(context.Background(), strings.NewReader(body), provider, 1)
+	if err != nil {
+		t.Fatalf("Can not build keysbuilder: %v", err)
+	}
+
+	data, err := provider.RestrictedData(context.Background(), 1, kb.Keys()...)
+	if err != nil {
+		t.Fatalf("RestrictedData returned unexpected error: %v", err)
+	}
+
+	cmpMap(t, data, map[string]json.RawMessage{
+		"motion/1/tag_ids": []byte(`[3]`),
+		"tag/3/name":       []byte(`"old"`),
+		"tag/3/secret":     nil,
+	})
+}
+
+func TestAtPositionInvalid(t *testing.T) {
+	closed := make(chan struct{})
+	defer close(closed)
+	s := autoupdate.New(&historyDatastore{MockDatastore: new(test.MockDatastore)}, restrict.New(&test.MockPermission{Default: true}, nil), closed)
+
+	_, err := s.AtPosition(1, 0)
+
+	var herr autoupdate.HistoryError
+	if !errors.As(err, &herr) || herr.Type() != "InvalidPosition" {
+		t.Errorf("Got error %v, expected a HistoryError with type InvalidPosition", err)
+	}
+}
+
+// historyDatastore is a MockDatastore that implements the HistoryReader and
+// the HistoryInformer interface. It also implements the PositionReader
+// interface.
+type historyDatastore struct {
+	*test.MockDatastore
+	positions map[int]map[string]json.RawMessage
+}
+
+func (d *historyDatastore) HistoryPositions(ctx context.Context, fqid string) ([]int, error) {
+	return []int{2, 5}, nil
+}
+
+func (d *historyDatastore) GetPosition(ctx context.Context, position int, fqid string) (map[string]json.RawMessage, error) {
+	data := make(map[string]json.RawMessage)
+	for k, v := range d.positions[position] {
+		data[k] = v
+	}
+	return data, nil
+}
+
+func (d *historyDatastore) GetAt(ctx context.Context, position int, keys ...string) ([]json.RawMessage, error) {
+	values := make([]json.RawMessage, len(keys))
+	for i, key := range keys {
+		values[i] = d.positions[position][key]
+	}
+	return values, nil
+}
+
+func (d *historyDatastore) HistoryInformation(ctx context.Context, fqid string, f func(position int, timestamp int64, userID int)) error {
+	f(2, 1600000200, 5)
+	f(5, 1600000500, 6)
+	return nil
+}
@@ -11,10 +11,62 @@ type Datastore interface {
 	RegisterChangeListener(f func(map[string]json.RawMessage) error)
 }
 
+// UpdateIndicator can be implemented by a Datastore. Updating tells, if the
+// datastore returns changed values, but did not call the change listeners with
+// them yet. A read, that ends while the datastore is updating, could contain
+// values of different positions.
+type UpdateIndicator interface {
+	Updating() bool
+}
+
 // Restricter restricts keys.
 type Restricter interface {
 	// Restrict manipulates the values for the user with the given id.
-	Restrict(uid int, data map[string]json.RawMessage) error
+	Restrict(ctx context.Context, uid int, data map[string]json.RawMessage) error
+}
+
+// PermissionClasser can be implemented by a Restricter. Users with the same
+// permission class get the same restricted data. This is used to restrict a
+// value only once for many connections.
+//
+// UserScoped tells, if the restricted value of a key depends on the user
+// itself, for example a personal note. These keys are not shared with the other
+// users of the class.
+type PermissionClasser interface {
+	PermissionClass(ctx context.Context, uid int) (string, error)
+	UserScoped(key string) bool
+}
+
+// HistoryReader can be implemented by a Datastore to read older versions of
+// an object.
+type HistoryReader interface {
+	HistoryPositions(ctx context.Context, fqid string) ([]int, error)
+	GetPosition(ctx context.Context, position int, fqid string) (map[string]json.RawMessage, error)
+}
+
+// PositionReader can be implemented by a Datastore to read keys at an older
+// position.
+type PositionReader interface {
+	GetAt(ctx context.Context, position int, keys ...string) ([]json.RawMessage, error)
+}
+
+// HistoryInformer can be implemented by a Datastore to read, when and by whom
+// an object was changed. f is called for each position of the object.
+type HistoryInformer interface {
+	HistoryInformation(ctx context.Context, fqid string, f func(position int, timestamp int64, userID int)) error
+}
+
+// HistoryPermitter can be implemented by a Restricter. It tells, if a user is
+// allowed to see the history of objects.
+type HistoryPermitter interface {
+	CanSeeHistory(uid int) (bool, error)
+}
+
+// PriorityPermitter tells, if a user is allowed to use a higher priority for
+// the connections. It can be implemented by a Restricter or given with
+// WithPriorityPermitter.
+type PriorityPermitter interface {
+	CanUsePriority(ctx context.Context, uid int) (bool, error)
 }
 
 // KeysBuilder holds the keys that are requested by a user.
@@ -22,3 +74,9 @@ type KeysBuilder interface {
 	Update(ctx context.Context) error
 	Keys() []string
 }
+
+// keysIterator can be implemented by a KeysBuilder to iterate over the keys
+// without copying them.
+type keysIterator interface {
+	ForEachKey(f func(key string) bool)
+}
@@ -2,6 +2,8 @@ package autoupdate_test
 
 import (
 	"context"
+	"encoding/json"
+	"sync"
 
 	"github.com/openslides/openslides-autoupdate-service/internal/autoupdate"
 	"github.com/openslides/openslides-autoupdate-service/internal/test"
@@ -27,3 +29,119 @@ func getConnection(closed <-chan struct{}) (*autoupdate.Connection, *test.MockDa
 
 	return c, datastore
 }
+
+// countingKeysBuilder is like mockKeysBuilder but counts the calls to Update.
+type countingKeysBuilder struct {
+	keys    []string
+	updated int
+}
+
+func (m *countingKeysBuilder) Update(context.Context) error {
+	m.updated++
+	return nil
+}
+
+func (m *countingKeysBuilder) Keys() []string {
+	return m.keys
+}
+
+// countingRestricter is like the test.MockRestricter but counts the restricted
+// keys.
+type countingRestricter struct {
+	mu     sync.Mutex
+	counts map[string]int
+}
+
+func (r *countingRestricter) Restrict(ctx context.Context, uid int, data map[string]json.RawMessage) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.counts == nil {
+		r.counts = make(map[string]int)
+	}
+	for k := range data {
+		r.counts[k]++
+	}
+	return nil
+}
+
+func (r *countingRestricter) count(key string) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.counts[key]
+}
+
+// classRestricter puts all users in the same permission class. After a call
+// to blockKey, Restrict blocks for data with the key until unblock is closed.
+// started gets a message, when Restrict blocks.
+type classRestricter struct {
+	countingRestricter
+	started chan struct{}
+	unblock chan struct{}
+
+	blockMu sync.Mutex
+	block   string
+}
+
+func (r *classRestricter) blockKey(key string) {
+	r.blockMu.Lock()
+	defer r.blockMu.Unlock()
+	r.block = key
+}
+
+func (r *classRestricter) Restrict(ctx context.Context, uid int, data map[string]json.RawMessage) error {
+	r.blockMu.Lock()
+	block := r.block
+	r.blockMu.Unlock()
+
+	if _, ok := data[block]; ok {
+		r.started <- struct{}{}
+		<-r.unblock
+	}
+	return r.countingRestricter.Restrict(ctx, uid, data)
+}
+
+func (r *classRestricter) PermissionClass(ctx context.Context, uid int) (string, error) {
+	return "all", nil
+}
+
+func (r *classRestricter) UserScoped(key string) bool {
+	return false
+}
+
+// groupRestricter hides user/1/note, if the user is not in the group. The
+// permission class is the membership in the group.
+type groupRestricter struct {
+	mu      sync.Mutex
+	inGroup bool
+}
+
+func (r *groupRestricter) setInGroup(inGroup bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.inGroup = inGroup
+}
+
+func (r *groupRestricter) Restrict(ctx context.Context, uid int, data map[string]json.RawMessage) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := data["user/1/note"]; ok && !r.inGroup {
+		data["user/1/note"] = nil
+	}
+	return nil
+}
+
+func (r *groupRestricter) PermissionClass(ctx context.Context, uid int) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.inGroup {
+		return "group", nil
+	}
+	return "other", nil
+}
+
+func (r *groupRestricter) UserScoped(key string) bool {
+	return false
+}
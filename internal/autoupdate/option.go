@@ -0,0 +1,50 @@
+package autoupdate
+
+import "github.com/openslides/openslides-autoupdate-service/internal/clock"
+
+// Option is an optional argument for autoupdate.New().
+type Option func(*Autoupdate)
+
+// WithClock sets the clock that is used for time depending tasks like
+// pruning the topic. The default is clock.Real.
+func WithClock(c clock.Clock) Option {
+	return func(a *Autoupdate) {
+		a.clock = c
+	}
+}
+
+// WithTopicSize sets the number of updates, that are saved in the topic. A
+// connection that is more updates behind has to resync all its keys. The
+// default is 16384.
+func WithTopicSize(size int) Option {
+	return func(a *Autoupdate) {
+		a.ringSize = size
+	}
+}
+
+// WithConcurrency sets the number of connections, that compute their data at
+// the same time. The other connections wait and get the next free slot by
+// their priority. A connection, that waits for the datastore, gives its slot
+// to the other connections. The default is two times the number of CPUs.
+func WithConcurrency(n int) Option {
+	return func(a *Autoupdate) {
+		a.concurrency = n
+	}
+}
+
+// WithPriorityPermitter sets the PriorityPermitter, that decides, if a user can
+// use a higher priority for the connections. The default is the restricter, if
+// it implements the PriorityPermitter interface.
+func WithPriorityPermitter(p PriorityPermitter) Option {
+	return func(a *Autoupdate) {
+		a.priorityPermitter = p
+	}
+}
+
+// WithAggregates adds synthetic fields, that are computed from relation
+// lists. See DefaultAggregates.
+func WithAggregates(defs ...Aggregate) Option {
+	return func(a *Autoupdate) {
+		a.aggregates = newAggregates(defs)
+	}
+}
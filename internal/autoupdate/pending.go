@@ -0,0 +1,34 @@
+package autoupdate
+
+import (
+	"errors"
+	"fmt"
+)
+
+// PendingError is returned by RestrictedData, when the datastore could not read
+// some keys in time. The returned data contains all other keys. The pending
+// keys are not in the data.
+type PendingError struct {
+	keys []string
+}
+
+func (e PendingError) Error() string {
+	return fmt.Sprintf("%d keys could not be read in time", len(e.keys))
+}
+
+// PendingKeys returns the keys, that could not be read in time.
+func (e PendingError) PendingKeys() []string {
+	return e.keys
+}
+
+// pendingKeys returns the pending keys, if err has the method PendingKeys. The
+// second return value is false for all other errors.
+func pendingKeys(err error) ([]string, bool) {
+	var pending interface {
+		PendingKeys() []string
+	}
+	if !errors.As(err, &pending) {
+		return nil, false
+	}
+	return pending.PendingKeys(), true
+}
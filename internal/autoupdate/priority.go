@@ -0,0 +1,207 @@
+package autoupdate
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// Priority of a connection. Under load, the updates of connections with a
+// higher priority are computed first.
+type Priority int
+
+// The priorities of a connection. PriorityHigh is meant for projectors and
+// chairpersons.
+const (
+	PriorityNormal Priority = iota
+	PriorityHigh
+
+	priorityCount
+)
+
+// SetPriority sets the priority of the connection. The default is
+// PriorityNormal.
+//
+// For a higher priority, the user needs the permission of the
+// PriorityPermitter given with WithPriorityPermitter. Without this option, the
+// restricter has to implement the PriorityPermitter interface. Otherwise an
+// error of type PriorityError is returned.
+//
+// Has to be called before the first call to Next.
+func (c *Connection) SetPriority(ctx context.Context, priority Priority) error {
+	if priority < PriorityNormal || priority >= priorityCount {
+		return PriorityError{msg: fmt.Sprintf("unknown priority %d", priority), typ: "InvalidPriority"}
+	}
+
+	if priority == PriorityNormal {
+		c.priority = priority
+		return nil
+	}
+
+	permitter := c.autoupdate.priorityPermitter
+	if permitter == nil {
+		var ok bool
+		permitter, ok = c.autoupdate.restricter.(PriorityPermitter)
+		if !ok {
+			return PriorityError{msg: "priorities are not supported", typ: "PermissionDenied"}
+		}
+	}
+
+	allowed, err := permitter.CanUsePriority(ctx, c.uid)
+	if err != nil {
+		return fmt.Errorf("check priority permission: %w", err)
+	}
+	if !allowed {
+		return PriorityError{msg: "you are not allowed to use a higher priority", typ: "PermissionDenied"}
+	}
+
+	c.priority = priority
+	return nil
+}
+
+// PriorityError is returned by SetPriority, when the priority is invalid or
+// the user is not allowed to use it.
+type PriorityError struct {
+	msg string
+	typ string
+}
+
+func (e PriorityError) Error() string {
+	return e.msg
+}
+
+// Type returns the name of the error.
+func (e PriorityError) Type() string {
+	return e.typ
+}
+
+// scheduler limits the number of connections, that compute their data at the
+// same time. Waiting connections are queued by their priority. The next free
+// slot is given to the oldest waiting connection with the highest priority.
+type scheduler struct {
+	mu     sync.Mutex
+	free   int
+	queues [priorityCount][]chan struct{}
+}
+
+func newScheduler(slots int) *scheduler {
+	return &scheduler{free: slots}
+}
+
+// acquire blocks until the connection gets a slot or the context is done. On
+// success, release has to be called afterwards.
+func (s *scheduler) acquire(ctx context.Context, priority Priority) error {
+	s.mu.Lock()
+	if s.free > 0 {
+		s.free--
+		s.mu.Unlock()
+		return nil
+	}
+
+	wait := make(chan struct{})
+	s.queues[priority] = append(s.queues[priority], wait)
+	s.mu.Unlock()
+
+	select {
+	case <-wait:
+		return nil
+
+	case <-ctx.Done():
+		s.mu.Lock()
+		removed := s.remove(priority, wait)
+		s.mu.Unlock()
+
+		if !removed {
+			// The slot was given to this connection at the same time the
+			// context was done.
+			s.release()
+		}
+		return ctx.Err()
+	}
+}
+
+// release frees a slot or gives it to the next waiting connection.
+func (s *scheduler) release() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for p := len(s.queues) - 1; p >= 0; p-- {
+		if len(s.queues[p]) == 0 {
+			continue
+		}
+
+		wait := s.queues[p][0]
+		s.queues[p][0] = nil
+		s.queues[p] = s.queues[p][1:]
+		close(wait)
+		return
+	}
+	s.free++
+}
+
+// slotKey is the context key for the slot of a connection.
+type slotKey struct{}
+
+// slot is the slot of the scheduler, that is held by a connection while it
+// computes its data. It is only used by the goroutine of the connection.
+type slot struct {
+	s        *scheduler
+	priority Priority
+	held     bool
+}
+
+// hold acquires a slot like acquire. The returned context carries the slot, so
+// it can be given back with waitIO, while the connection waits for the
+// datastore. free has to be called afterwards.
+func (s *scheduler) hold(ctx context.Context, priority Priority) (context.Context, *slot, error) {
+	if err := s.acquire(ctx, priority); err != nil {
+		return ctx, nil, err
+	}
+
+	sl := &slot{s: s, priority: priority, held: true}
+	return context.WithValue(ctx, slotKey{}, sl), sl, nil
+}
+
+// free gives the slot back, if it is held.
+func (sl *slot) free() {
+	if sl.held {
+		sl.held = false
+		sl.s.release()
+	}
+}
+
+// waitIO calls f without the slot of the context. The slot limits the
+// connections, that use the cpu at the same time. A connection, that waits for
+// the datastore or another connection, does not use the cpu, so other
+// connections can use its slot. Afterwards, the slot is acquired again.
+func waitIO(ctx context.Context, f func() error) error {
+	sl, _ := ctx.Value(slotKey{}).(*slot)
+	if sl == nil || !sl.held {
+		return f()
+	}
+
+	sl.free()
+	err := f()
+
+	if aErr := sl.s.acquire(ctx, sl.priority); aErr != nil {
+		if err == nil {
+			err = fmt.Errorf("wait for slot: %w", aErr)
+		}
+		return err
+	}
+	sl.held = true
+	return err
+}
+
+// remove removes a waiting connection from its queue. Returns false, if it was
+// not in the queue anymore.
+func (s *scheduler) remove(priority Priority, wait chan struct{}) bool {
+	queue := s.queues[priority]
+	for i, w := range queue {
+		if w == wait {
+			s.queues[priority] = append(queue[:i], queue[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
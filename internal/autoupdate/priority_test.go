@@ -0,0 +1,117 @@
+package autoupdate
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestSchedulerPriority(t *testing.T) {
+	s := newScheduler(1)
+	ctx := context.Background()
+
+	if err := s.acquire(ctx, PriorityNormal); err != nil {
+		t.Fatalf("acquire returned unexpected error: %v", err)
+	}
+
+	got := make(chan Priority, 2)
+	waitFor := func(p Priority) {
+		go func() {
+			if err := s.acquire(ctx, p); err != nil {
+				t.Errorf("acquire returned unexpected error: %v", err)
+			}
+			got <- p
+		}()
+
+		// Wait until the connection is in the queue.
+		for i := 0; i < 100; i++ {
+			s.mu.Lock()
+			queued := len(s.queues[p])
+			s.mu.Unlock()
+			if queued > 0 {
+				return
+			}
+			time.Sleep(time.Millisecond)
+		}
+		t.Fatalf("Connection with priority %d was not queued", p)
+	}
+
+	waitFor(PriorityNormal)
+	waitFor(PriorityHigh)
+
+	s.release()
+	if p := <-got; p != PriorityHigh {
+		t.Errorf("First slot got priority %d, expected high", p)
+	}
+
+	s.release()
+	if p := <-got; p != PriorityNormal {
+		t.Errorf("Second slot got priority %d, expected normal", p)
+	}
+
+	s.release()
+	if s.free != 1 {
+		t.Errorf("Got %d free slots, expected 1", s.free)
+	}
+}
+
+func TestSchedulerCancel(t *testing.T) {
+	s := newScheduler(1)
+	if err := s.acquire(context.Background(), PriorityNormal); err != nil {
+		t.Fatalf("acquire returned unexpected error: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := s.acquire(ctx, PriorityHigh); err != context.Canceled {
+		t.Errorf("Got error %v, expected context.Canceled", err)
+	}
+
+	if len(s.queues[PriorityHigh]) != 0 {
+		t.Errorf("Canceled connection is still in the queue")
+	}
+
+	s.release()
+	if s.free != 1 {
+		t.Errorf("Got %d free slots, expected 1", s.free)
+	}
+}
+
+func TestSchedulerWaitIO(t *testing.T) {
+	s := newScheduler(1)
+
+	ctx, sl, err := s.hold(context.Background(), PriorityNormal)
+	if err != nil {
+		t.Fatalf("hold returned unexpected error: %v", err)
+	}
+
+	err = waitIO(ctx, func() error {
+		// The slot is free while the connection waits.
+		acquireCtx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		if err := s.acquire(acquireCtx, PriorityNormal); err != nil {
+			t.Errorf("Slot was not given back during io: %v", err)
+			return nil
+		}
+		s.release()
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("waitIO returned unexpected error: %v", err)
+	}
+
+	s.mu.Lock()
+	free := s.free
+	s.mu.Unlock()
+	if !sl.held || free != 0 {
+		t.Errorf("Slot was not acquired again after io")
+	}
+
+	sl.free()
+	s.mu.Lock()
+	free = s.free
+	s.mu.Unlock()
+	if free != 1 {
+		t.Errorf("Got %d free slots after free, expected 1", free)
+	}
+}
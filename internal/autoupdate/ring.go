@@ -0,0 +1,197 @@
+package autoupdate
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// defaultRingSize is the number of generations, the ring holds per default.
+const defaultRingSize = 1 << 14
+
+// ring holds the changed keys of the last generations.
+//
+// Each call to publish creates a new generation with a new id. The generations
+// are saved in a fixed size slice. The position of a generation is its id
+// modulo the size of the ring. So adding a generation and finding the
+// generation of an id is done in constant time.
+//
+// If a reader asks for generations that are already overwritten or pruned, an
+// error of type tooOldError is returned and the reader has to do a full resync.
+//
+// A ring has to be created with newRing().
+type ring struct {
+	mu     sync.RWMutex
+	closed <-chan struct{}
+
+	gens []generation
+
+	// first is the id of the oldest generation that is not pruned. last is the
+	// id of the newest generation. If last is 0, the ring is empty.
+	first uint64
+	last  uint64
+
+	// signal is closed and replaced on each publish to wake up all waiting
+	// receive calls.
+	signal chan struct{}
+}
+
+// generation is one entry in the ring.
+type generation struct {
+	id      uint64
+	created time.Time
+	keys    []string
+}
+
+// newRing initializes a ring with size generations.
+func newRing(size int, closed <-chan struct{}) *ring {
+	return &ring{
+		closed: closed,
+		gens:   make([]generation, size),
+		first:  1,
+		signal: make(chan struct{}),
+	}
+}
+
+// publish adds a new generation with the given keys. Returns the id of the new
+// generation.
+func (r *ring) publish(now time.Time, keys ...string) uint64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.last++
+	r.gens[r.pos(r.last)] = generation{id: r.last, created: now, keys: keys}
+
+	// The oldest generation was overwritten.
+	if size := uint64(len(r.gens)); r.last-r.first >= size {
+		r.first = r.last - size + 1
+	}
+
+	close(r.signal)
+	r.signal = make(chan struct{})
+	return r.last
+}
+
+// receive returns the unique keys of all generations after the given id and
+// the id of the newest generation.
+//
+// If there is no generation after id, receive blocks until there is a new
+// generation, the context is done or the ring is closed. If the ring is
+// closed, an error with the method Closing() is returned.
+//
+// If the generations after id are not in the ring anymore, an error of type
+// tooOldError is returned.
+func (r *ring) receive(ctx context.Context, id uint64) (uint64, []string, error) {
+	for {
+		r.mu.RLock()
+		if id < r.last {
+			break
+		}
+		signal := r.signal
+		r.mu.RUnlock()
+
+		select {
+		case <-signal:
+			continue
+		case <-r.closed:
+		case <-ctx.Done():
+		}
+
+		// New data could be published at the same time the ring or the context
+		// was closed. Return it in this case.
+		if r.lastID() > id {
+			continue
+		}
+
+		if ctx.Err() != nil {
+			return id, nil, ctx.Err()
+		}
+		return id, nil, closingError{}
+	}
+	r.mu.RUnlock()
+
+	return r.since(id)
+}
+
+// since returns the unique keys of all generations after the given id and the
+// id of the newest generation. It does not block, if there is no newer
+// generation.
+//
+// If the generations after id are not in the ring anymore or the id is unknown,
+// an error of type tooOldError is returned.
+func (r *ring) since(id uint64) (uint64, []string, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if id == r.last {
+		return r.last, nil, nil
+	}
+
+	if id+1 < r.first || id > r.last {
+		return r.last, nil, tooOldError{id: id, first: r.first, last: r.last}
+	}
+
+	if id+1 == r.last {
+		// Fast path for a reader, that only missed one generation.
+		return r.last, r.gens[r.pos(r.last)].keys, nil
+	}
+
+	var keys []string
+	seen := make(map[string]bool)
+	for gid := id + 1; gid <= r.last; gid++ {
+		for _, key := range r.gens[r.pos(gid)].keys {
+			if !seen[key] {
+				keys = append(keys, key)
+				seen[key] = true
+			}
+		}
+	}
+	return r.last, keys, nil
+}
+
+// lastID returns the id of the newest generation. Returns 0 for an empty ring.
+func (r *ring) lastID() uint64 {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.last
+}
+
+// prune removes all generations that are older then the given time. The newest
+// generation is never removed.
+func (r *ring) prune(until time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for r.first < r.last {
+		gen := &r.gens[r.pos(r.first)]
+		if !gen.created.Before(until) {
+			return
+		}
+		gen.keys = nil
+		r.first++
+	}
+}
+
+// pos returns the position of a generation id in the ring.
+func (r *ring) pos(id uint64) int {
+	return int(id % uint64(len(r.gens)))
+}
+
+// tooOldError is returned by ring.receive, if the requested generations are
+// not in the ring anymore.
+type tooOldError struct {
+	id    uint64
+	first uint64
+	last  uint64
+}
+
+func (e tooOldError) Error() string {
+	return fmt.Sprintf("id %d is unknown in the topic. Lowest id is %d", e.id, e.first)
+}
+
+// closingError is returned by ring.receive, if the ring was closed.
+type closingError struct{}
+
+func (e closingError) Error() string { return "topic was closed" }
+func (e closingError) Closing()      {}
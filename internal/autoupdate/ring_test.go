@@ -0,0 +1,153 @@
+package autoupdate
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestRingReceive(t *testing.T) {
+	r := newRing(4, nil)
+	now := time.Now()
+	r.publish(now, "a", "b")
+	r.publish(now, "b", "c")
+	r.publish(now, "d")
+
+	for _, tt := range []struct {
+		name   string
+		id     uint64
+		expect []string
+	}{
+		{"from start", 0, []string{"a", "b", "c", "d"}},
+		{"after first", 1, []string{"b", "c", "d"}},
+		{"only last", 2, []string{"d"}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			id, keys, err := r.receive(context.Background(), tt.id)
+			if err != nil {
+				t.Fatalf("receive returned unexpected error: %v", err)
+			}
+			if id != 3 {
+				t.Errorf("Got id %d, expected 3", id)
+			}
+			sort.Strings(keys)
+			if !cmpSlice(keys, tt.expect) {
+				t.Errorf("Got keys %v, expected %v", keys, tt.expect)
+			}
+		})
+	}
+}
+
+func TestRingTooOld(t *testing.T) {
+	r := newRing(2, nil)
+	now := time.Now()
+	r.publish(now, "a")
+	r.publish(now, "b")
+	r.publish(now, "c")
+
+	_, _, err := r.receive(context.Background(), 0)
+	var tooOld tooOldError
+	if !errors.As(err, &tooOld) {
+		t.Fatalf("Got error %v, expected tooOldError", err)
+	}
+
+	_, keys, err := r.receive(context.Background(), 1)
+	if err != nil {
+		t.Fatalf("receive returned unexpected error: %v", err)
+	}
+	if !cmpSlice(keys, []string{"b", "c"}) {
+		t.Errorf("Got keys %v, expected [b c]", keys)
+	}
+}
+
+func TestRingPrune(t *testing.T) {
+	r := newRing(10, nil)
+	start := time.Now()
+	r.publish(start, "a")
+	r.publish(start.Add(time.Minute), "b")
+	r.publish(start.Add(2*time.Minute), "c")
+
+	r.prune(start.Add(90 * time.Second))
+
+	if _, _, err := r.receive(context.Background(), 1); !errors.As(err, new(tooOldError)) {
+		t.Errorf("Got error %v, expected tooOldError", err)
+	}
+	if _, keys, err := r.receive(context.Background(), 2); err != nil || !cmpSlice(keys, []string{"c"}) {
+		t.Errorf("Got keys %v with error %v, expected [c]", keys, err)
+	}
+
+	// The last generation is never pruned.
+	r.prune(start.Add(time.Hour))
+	if id := r.lastID(); id != 3 {
+		t.Errorf("Got last id %d, expected 3", id)
+	}
+}
+
+func TestRingBlocking(t *testing.T) {
+	closed := make(chan struct{})
+	r := newRing(10, closed)
+
+	received := make(chan []string)
+	go func() {
+		_, keys, _ := r.receive(context.Background(), 0)
+		received <- keys
+	}()
+
+	select {
+	case <-received:
+		t.Fatalf("receive did not block")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	r.publish(time.Now(), "a")
+	if keys := <-received; !cmpSlice(keys, []string{"a"}) {
+		t.Errorf("Got keys %v, expected [a]", keys)
+	}
+
+	close(closed)
+	_, _, err := r.receive(context.Background(), 1)
+	var closing interface{ Closing() }
+	if !errors.As(err, &closing) {
+		t.Errorf("Got error %v, expected a closing error", err)
+	}
+}
+
+func BenchmarkRingReceive(b *testing.B) {
+	for _, behind := range []int{1, 10, 1000} {
+		b.Run(strconv.Itoa(behind), func(b *testing.B) {
+			r := newRing(defaultRingSize, nil)
+			now := time.Now()
+			for i := 0; i < behind; i++ {
+				r.publish(now, "user/"+strconv.Itoa(i)+"/name")
+			}
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				r.receive(context.Background(), 0)
+			}
+		})
+	}
+}
+
+func BenchmarkRingPublish(b *testing.B) {
+	r := newRing(defaultRingSize, nil)
+	now := time.Now()
+	for i := 0; i < b.N; i++ {
+		r.publish(now, "user/1/name")
+	}
+}
+
+func cmpSlice(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,166 @@
+package autoupdate
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// Snapshot is the restricted data of a keysbuilder at one position. A client
+// can use it to go offline and resume later with Connection.Resume.
+type Snapshot struct {
+	Position uint64                     `json:"position"`
+	Token    string                     `json:"token"`
+	Data     map[string]json.RawMessage `json:"data"`
+}
+
+// Snapshot returns all restricted data of the keysbuilder. tid has to be the
+// LastID() from before the keysbuilder was created.
+func (a *Autoupdate) Snapshot(ctx context.Context, uid int, kb KeysBuilder, tid uint64) (Snapshot, error) {
+	keys := kb.Keys()
+	class, err := a.permissionClass(ctx, uid)
+	if err != nil {
+		return Snapshot{}, fmt.Errorf("get permission class: %w", err)
+	}
+
+	data, err := a.RestrictedData(ctx, uid, keys...)
+	if err != nil {
+		return Snapshot{}, fmt.Errorf("get restricted data: %w", err)
+	}
+
+	for k, v := range data {
+		if len(v) == 0 {
+			delete(data, k)
+		}
+	}
+
+	return Snapshot{
+		Position: tid,
+		Token:    a.resumeToken(uid, class, keys),
+		Data:     data,
+	}, nil
+}
+
+// Resume tells the connection, that the client has the data of a snapshot.
+// The first response of Next only contains the keys, that changed after the
+// position of the snapshot.
+//
+// If the keys of the keysbuilder, the user or the permission class of the user
+// are not the same as in the snapshot or the position is too old, the first
+// response contains all keys.
+//
+// Has to be called before the first call to Next.
+func (c *Connection) Resume(position uint64, token string) {
+	c.resumePosition = position
+	c.resumeToken = token
+}
+
+// ResumeToken returns the token for the current keys of the connection. A
+// client, that has all data of the connection until Position, can use it to
+// resume with Resume.
+//
+// The token is computed from all keys on each call.
+func (c *Connection) ResumeToken() string {
+	keys := make([]string, 0, len(c.subscribed))
+	for key := range c.subscribed {
+		keys = append(keys, key)
+	}
+	return c.autoupdate.resumeToken(c.uid, c.class, keys)
+}
+
+// updateClass reads the permission class of the user for the resume token.
+func (c *Connection) updateClass(ctx context.Context) error {
+	class, err := c.autoupdate.permissionClass(ctx, c.uid)
+	if err != nil {
+		return fmt.Errorf("get permission class: %w", err)
+	}
+	c.class = class
+	return nil
+}
+
+// removeUnchangedSince removes all keys from data, that did not change after
+// the resume position. Keys that were deleted after the position are added
+// with a nil value. The data is not changed, if the keys of the
+// keysbuilder, the user or its permission class are different then in the
+// snapshot or the position is unknown.
+//
+// The restricted value of a key can change without a change of the key, if the
+// permissions of the user change. The permission class is part of the token,
+// so in this case, all data is sent.
+func (c *Connection) removeUnchangedSince(data map[string]json.RawMessage) error {
+	if c.resumeToken == "" {
+		return nil
+	}
+
+	keys := make([]string, 0, len(c.subscribed))
+	for key := range c.subscribed {
+		keys = append(keys, key)
+	}
+	if c.autoupdate.resumeToken(c.uid, c.class, keys) != c.resumeToken {
+		return nil
+	}
+
+	_, changedKeys, err := c.autoupdate.topic.since(c.resumePosition)
+	if err != nil {
+		var tooOld tooOldError
+		if errors.As(err, &tooOld) {
+			return nil
+		}
+		return fmt.Errorf("get changed keys: %w", err)
+	}
+
+	changed := make(map[string]bool, len(changedKeys))
+	for _, key := range changedKeys {
+		if !c.subscribed[key] {
+			continue
+		}
+		changed[key] = true
+
+		// Empty values are not in the first response. The client has to know,
+		// that the key was deleted.
+		if _, ok := data[key]; !ok {
+			data[key] = nil
+		}
+	}
+
+	for key := range data {
+		if !changed[key] {
+			delete(data, key)
+		}
+	}
+	return nil
+}
+
+// resumeToken returns a token for a list of keys of a user with a permission
+// class. It is only valid for this instance of the service, since the positions
+// of other instances are different.
+func (a *Autoupdate) resumeToken(uid int, class string, keys []string) string {
+	sorted := make([]string, len(keys))
+	copy(sorted, keys)
+	sort.Strings(sorted)
+
+	h := sha256.New()
+	h.Write(a.instance)
+	fmt.Fprintf(h, "%d\n%q\n", uid, class)
+	for _, key := range sorted {
+		h.Write([]byte(key))
+		h.Write([]byte("\n"))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// newInstanceID returns random bytes to identify an instance of the service.
+func newInstanceID() []byte {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		// Without randomness, resume tokens of a restarted instance could be
+		// valid.
+		panic(fmt.Sprintf("can not create instance id: %v", err))
+	}
+	return id
+}
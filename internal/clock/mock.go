@@ -0,0 +1,149 @@
+package clock
+
+import (
+	"sync"
+	"time"
+)
+
+// Mock is a Clock that only changes its time when Add or Set is called.
+//
+// Has to be created with NewMock().
+type Mock struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []*waiter
+
+	// changed is closed and recreated each time a waiter is added.
+	changed chan struct{}
+}
+
+// waiter is a timer or a ticker of the mock clock. A timer has period 0.
+type waiter struct {
+	next    time.Time
+	period  time.Duration
+	c       chan time.Time
+	stopped bool
+}
+
+// NewMock creates a mock clock with the given time.
+func NewMock(now time.Time) *Mock {
+	return &Mock{
+		now:     now,
+		changed: make(chan struct{}),
+	}
+}
+
+// Now returns the current time of the mock.
+func (m *Mock) Now() time.Time {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.now
+}
+
+// After returns a channel that receives the time when the mock time is moved
+// d forward.
+func (m *Mock) After(d time.Duration) <-chan time.Time {
+	return m.add(d, 0).c
+}
+
+// NewTicker creates a ticker that fires each time the mock time is moved d
+// forward. Like a time.Ticker, it drops ticks for slow receivers.
+func (m *Mock) NewTicker(d time.Duration) Ticker {
+	return mockTicker{mock: m, w: m.add(d, d)}
+}
+
+func (m *Mock) add(d, period time.Duration) *waiter {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	w := &waiter{
+		next:   m.now.Add(d),
+		period: period,
+		c:      make(chan time.Time, 1),
+	}
+	m.waiters = append(m.waiters, w)
+	close(m.changed)
+	m.changed = make(chan struct{})
+	m.fire()
+	return w
+}
+
+// Add moves the mock time forward.
+func (m *Mock) Add(d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.now = m.now.Add(d)
+	m.fire()
+}
+
+// Set sets the mock time.
+func (m *Mock) Set(t time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.now = t
+	m.fire()
+}
+
+// WaitForWaiters blocks until at least n timers or tickers are active. This
+// can be used in tests to make sure, that a goroutine created its ticker
+// before the time is moved.
+func (m *Mock) WaitForWaiters(n int) {
+	for {
+		m.mu.Lock()
+		active := len(m.waiters)
+		changed := m.changed
+		m.mu.Unlock()
+
+		if active >= n {
+			return
+		}
+		<-changed
+	}
+}
+
+// fire sends the time to all waiters that are due. Removes timers after they
+// fired and stopped tickers.
+//
+// The mock has to be locked.
+func (m *Mock) fire() {
+	active := m.waiters[:0]
+	for _, w := range m.waiters {
+		if w.stopped {
+			continue
+		}
+
+		if !w.next.After(m.now) {
+			select {
+			case w.c <- m.now:
+			default:
+			}
+
+			if w.period == 0 {
+				continue
+			}
+
+			for !w.next.After(m.now) {
+				w.next = w.next.Add(w.period)
+			}
+		}
+		active = append(active, w)
+	}
+	m.waiters = active
+}
+
+type mockTicker struct {
+	mock *Mock
+	w    *waiter
+}
+
+func (t mockTicker) C() <-chan time.Time {
+	return t.w.c
+}
+
+func (t mockTicker) Stop() {
+	t.mock.mu.Lock()
+	defer t.mock.mu.Unlock()
+	t.w.stopped = true
+}
@@ -0,0 +1,75 @@
+package clock_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/openslides/openslides-autoupdate-service/internal/clock"
+)
+
+func TestMockAfter(t *testing.T) {
+	m := clock.NewMock(time.Unix(0, 0))
+	c := m.After(time.Second)
+
+	m.Add(999 * time.Millisecond)
+	select {
+	case <-c:
+		t.Fatalf("After fired before the time was reached")
+	default:
+	}
+
+	m.Add(time.Millisecond)
+	select {
+	case got := <-c:
+		if !got.Equal(time.Unix(1, 0)) {
+			t.Errorf("After returned %v, expected %v", got, time.Unix(1, 0))
+		}
+	default:
+		t.Errorf("After did not fire")
+	}
+}
+
+func TestMockTicker(t *testing.T) {
+	m := clock.NewMock(time.Unix(0, 0))
+	ticker := m.NewTicker(time.Minute)
+
+	var count int
+	for i := 0; i < 3; i++ {
+		m.Add(time.Minute)
+		select {
+		case <-ticker.C():
+			count++
+		default:
+		}
+	}
+
+	if count != 3 {
+		t.Errorf("Ticker fired %d times, expected 3", count)
+	}
+
+	ticker.Stop()
+	m.Add(time.Minute)
+	select {
+	case <-ticker.C():
+		t.Errorf("Ticker fired after Stop")
+	default:
+	}
+}
+
+func TestMockWaitForWaiters(t *testing.T) {
+	m := clock.NewMock(time.Unix(0, 0))
+
+	done := make(chan struct{})
+	go func() {
+		m.WaitForWaiters(1)
+		close(done)
+	}()
+
+	m.After(time.Second)
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Errorf("WaitForWaiters did not return")
+	}
+}
@@ -0,0 +1,75 @@
+package datastore
+
+const (
+	// arenaChunkSize is the size of one chunk of the arena.
+	arenaChunkSize = 1 << 20
+
+	// arenaMaxValueSize is the maximum size of a value that is saved in the
+	// arena. Bigger values are saved as individual objects.
+	arenaMaxValueSize = 4 << 10
+)
+
+// arenaRef is the position of a value in the arena. It does not contain a
+// pointer, so the garbage collector does not have to scan it.
+type arenaRef struct {
+	chunk  uint32
+	offset uint32
+	length uint32
+}
+
+// arena packs many small values into a few big byte slices.
+//
+// The chunks of an arena are only appended. A value that was returned by get
+// is therefore never changed, even when the arena is compacted.
+type arena struct {
+	chunks [][]byte
+
+	// used is the number of bytes, that are referenced. written is the number
+	// of bytes, that were added since the last compaction.
+	used    int
+	written int
+}
+
+// add copies the value into the arena and returns its reference.
+func (a *arena) add(value []byte) arenaRef {
+	last := len(a.chunks) - 1
+	if last == -1 || len(a.chunks[last])+len(value) > cap(a.chunks[last]) {
+		a.chunks = append(a.chunks, make([]byte, 0, arenaChunkSize))
+		last++
+	}
+
+	offset := len(a.chunks[last])
+	a.chunks[last] = append(a.chunks[last], value...)
+	a.used += len(value)
+	a.written += len(value)
+	return arenaRef{chunk: uint32(last), offset: uint32(offset), length: uint32(len(value))}
+}
+
+// get returns the value of a reference. The returned slice has no capacity
+// behind its length, so an append can not change other values.
+func (a *arena) get(r arenaRef) []byte {
+	start := r.offset
+	end := r.offset + r.length
+	return a.chunks[r.chunk][start:end:end]
+}
+
+// free marks the value of a reference as unused. The memory is released on
+// the next compaction.
+func (a *arena) free(r arenaRef) {
+	a.used -= int(r.length)
+}
+
+// needsCompaction tells, if more then the half of the arena is unused.
+func (a *arena) needsCompaction() bool {
+	return a.written > arenaChunkSize && a.used < a.written/2
+}
+
+// compact copies all values of the given references into new chunks and
+// updates the references.
+func (a *arena) compact(refs map[string]arenaRef) {
+	var fresh arena
+	for key, r := range refs {
+		refs[key] = fresh.add(a.get(r))
+	}
+	*a = fresh
+}
@@ -4,7 +4,9 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"sort"
 	"sync"
 )
 
@@ -28,11 +30,22 @@ type cacheSetFunc func(keys []string) (map[string]json.RawMessage, error)
 //
 // cache.keyState() tells, if a key exist or is pending.
 //
+// Values that are bigger then compressThreshold are saved compressed and
+// decompressed on each read. If compressThreshold is 0, no value is compressed.
+//
+// If arena is not nil, small values are saved in the arena and referenced in
+// small. All other values are saved in data.
+//
 // A new cache instance has to be created with newCache().
 type cache struct {
 	mu      sync.RWMutex
 	data    map[string]json.RawMessage
 	pending map[string]chan struct{}
+
+	compressThreshold int
+
+	arena *arena
+	small map[string]arenaRef
 }
 
 // newCache creates an initialized cache instance.
@@ -40,6 +53,7 @@ func newCache() *cache {
 	return &cache{
 		data:    make(map[string]json.RawMessage),
 		pending: make(map[string]chan struct{}),
+		small:   make(map[string]arenaRef),
 	}
 }
 
@@ -62,11 +76,19 @@ func newCache() *cache {
 //
 // If the context is done, GetOrSet returns. But the set() call is not stopped.
 // Other calls to GetOrSet may wait for its result.
+//
+// If the set function returns a PendingError, the returned values are saved and
+// the pending keys are fetched again on the next call. GetOrSet returns the
+// values of all other keys together with a PendingError.
 func (c *cache) GetOrSet(ctx context.Context, keys []string, set cacheSetFunc) ([]json.RawMessage, error) {
 	c.mu.Lock()
 	missingKeys := c.notExistToPending(keys)
 	c.mu.Unlock()
 
+	// pendingErr is set, if some keys could not be fetched in time. The
+	// other values are returned.
+	var pendingErr PendingError
+
 	// Fetch missing keys.
 	if len(missingKeys) > 0 {
 		// Fetch missing keys in the background. Do not stop the fetching. Even
@@ -79,7 +101,7 @@ func (c *cache) GetOrSet(ctx context.Context, keys []string, set cacheSetFunc) (
 
 		select {
 		case err := <-errChan:
-			if err != nil {
+			if err != nil && !errors.As(err, &pendingErr) {
 				return nil, fmt.Errorf("fetching key: %w", err)
 			}
 		case <-ctx.Done():
@@ -87,44 +109,80 @@ func (c *cache) GetOrSet(ctx context.Context, keys []string, set cacheSetFunc) (
 		}
 	}
 
+	pending := make(map[string]bool, len(pendingErr.keys))
+	for _, key := range pendingErr.keys {
+		pending[key] = true
+	}
+
 	// Build return values. Blocks until pending keys are fetched.
 	values := make([]json.RawMessage, len(keys))
 	c.mu.RLock()
 	for i, key := range keys {
+		if pending[key] {
+			continue
+		}
+
 		switch c.keyState(key) {
 		case stExist:
-			values[i] = c.data[key]
+			value, err := c.value(key)
+			if err != nil {
+				c.mu.RUnlock()
+				return nil, fmt.Errorf("reading key `%s`: %w", key, err)
+			}
+			values[i] = value
 			continue
 		case stInvalid:
+			c.mu.RUnlock()
 			return nil, fmt.Errorf("key `%s` is in invalid state", key)
-		case stNotExist:
-			return nil, fmt.Errorf("key `%s` does not exist in cache", key)
-		}
-		p := c.pending[key]
+		case stPending:
+			p := c.pending[key]
 
-		c.mu.RUnlock()
-		select {
-		case <-ctx.Done():
-			return nil, ctx.Err()
-		case <-p:
+			c.mu.RUnlock()
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-p:
+			}
+			c.mu.RLock()
 		}
-		c.mu.RLock()
 
 		if c.keyState(key) != stExist {
-			// The value is not in the cache after pending was done. This
-			// happens when the request to the datastore of another
-			// GetOrSet-Call returned with an error. Try it once more.
+			// The value is not in the cache. This happens when the request
+			// to the datastore of another GetOrSet-Call returned with an
+			// error or when the key was pruned after it was fetched. Try it
+			// once more.
 			c.mu.RUnlock()
-			_, err := c.GetOrSet(ctx, []string{key}, set)
+			value, err := c.GetOrSet(ctx, []string{key}, set)
 			if err != nil {
-				return nil, fmt.Errorf("fetching keys for a second time: %w", err)
+				if !errors.As(err, new(PendingError)) {
+					return nil, fmt.Errorf("fetching keys for a second time: %w", err)
+				}
+				pending[key] = true
+				c.mu.RLock()
+				continue
 			}
+			values[i] = value[0]
 			c.mu.RLock()
+			continue
 		}
 
-		values[i] = c.data[key]
+		value, err := c.value(key)
+		if err != nil {
+			c.mu.RUnlock()
+			return nil, fmt.Errorf("reading key `%s`: %w", key, err)
+		}
+		values[i] = value
 	}
 	c.mu.RUnlock()
+
+	if len(pending) > 0 {
+		keys := make([]string, 0, len(pending))
+		for key := range pending {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		return values, PendingError{keys: keys}
+	}
 	return values, nil
 }
 
@@ -150,10 +208,18 @@ func (c *cache) fetchMissing(keys []string, set cacheSetFunc) error {
 		}
 	}()
 
-	if err != nil {
+	var pendingErr PendingError
+	if err != nil && !errors.As(err, &pendingErr) {
 		return fmt.Errorf("fetching missing keys: %w", err)
 	}
 
+	// Keys that could not be fetched in time are not saved. They are deleted
+	// from the pending map and fetched again on the next call.
+	pending := make(map[string]bool, len(pendingErr.keys))
+	for _, k := range pendingErr.keys {
+		pending[k] = true
+	}
+
 	for k, v := range data {
 		if c.keyState(k) == stPending {
 			c.set(k, v)
@@ -162,11 +228,11 @@ func (c *cache) fetchMissing(keys []string, set cacheSetFunc) error {
 
 	// Set all keys, that where not returned to not existing.
 	for _, k := range keys {
-		if c.keyState(k) == stPending {
+		if !pending[k] && c.keyState(k) == stPending {
 			c.set(k, nil)
 		}
 	}
-	return nil
+	return err
 }
 
 // SetIfExist updates each the cache with the value in the given map. But keys
@@ -183,6 +249,67 @@ func (c *cache) SetIfExist(data map[string]json.RawMessage) {
 	}
 }
 
+// prune removes keys from the cache until it has at most size keys. Keys
+// without a priority are removed first, then the keys with the lowest
+// priority. Pending keys are not removed.
+//
+// A removed key is fetched again with the next call to GetOrSet.
+func (c *cache) prune(size int, priorities map[string]uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	remove := len(c.data) + len(c.small) - size
+	if remove <= 0 {
+		return
+	}
+
+	var tracked []string
+	removeUntracked := func(key string) {
+		if remove == 0 {
+			return
+		}
+		if _, ok := priorities[key]; ok {
+			tracked = append(tracked, key)
+			return
+		}
+		c.delete(key)
+		remove--
+	}
+	for key := range c.data {
+		removeUntracked(key)
+	}
+	for key := range c.small {
+		removeUntracked(key)
+	}
+
+	sort.Slice(tracked, func(i, j int) bool {
+		return priorities[tracked[i]] < priorities[tracked[j]]
+	})
+	for _, key := range tracked[:min(remove, len(tracked))] {
+		c.delete(key)
+	}
+}
+
+// delete removes an existing key from the cache.
+//
+// The cache has to be in write lock to call this method.
+func (c *cache) delete(key string) {
+	if r, ok := c.small[key]; ok {
+		c.arena.free(r)
+		delete(c.small, key)
+		return
+	}
+	delete(c.data, key)
+}
+
+// Len returns the number of values in the cache. Pending keys are not
+// counted.
+func (c *cache) Len() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return len(c.data) + len(c.small)
+}
+
 // Returns the state of a key.
 //
 // The cache has to be in read lock to call this method.
@@ -194,6 +321,9 @@ func (c *cache) SetIfExist(data map[string]json.RawMessage) {
 // If a key is pending, data[key] does not exist and panding[key] does exist.
 func (c *cache) keyState(key string) int {
 	_, dataOK := c.data[key]
+	if !dataOK {
+		_, dataOK = c.small[key]
+	}
 	_, pendingOK := c.pending[key]
 
 	if dataOK {
@@ -214,13 +344,46 @@ func (c *cache) set(key string, value json.RawMessage) {
 	if bytes.Equal(value, []byte("null")) {
 		value = nil
 	}
-	c.data[key] = value
+	if c.compressThreshold > 0 && len(value) > c.compressThreshold {
+		value = compressValue(value)
+	}
+
+	if r, ok := c.small[key]; ok {
+		c.arena.free(r)
+		delete(c.small, key)
+	}
+
+	if c.arena != nil && len(value) > 0 && len(value) <= arenaMaxValueSize {
+		delete(c.data, key)
+		c.small[key] = c.arena.add(value)
+		if c.arena.needsCompaction() {
+			c.arena.compact(c.small)
+		}
+	} else {
+		c.data[key] = value
+	}
+
 	if p, ok := c.pending[key]; ok {
 		close(p)
 		delete(c.pending, key)
 	}
 }
 
+// value returns the value of a key. Decompresses the value if necessary.
+//
+// The cache has to be in read lock to call this method.
+func (c *cache) value(key string) (json.RawMessage, error) {
+	value := c.data[key]
+	if r, ok := c.small[key]; ok {
+		value = c.arena.get(r)
+	}
+
+	if !isCompressed(value) {
+		return value, nil
+	}
+	return decompressValue(value)
+}
+
 // notExistToPending sets all given keys, that do not exist in the cache, to pending.
 // Returns the list of keys that where set to pending.
 //
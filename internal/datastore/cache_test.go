@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"strings"
 	"testing"
 	"time"
 
@@ -281,3 +282,147 @@ func TestCacheFailInOthetGetOrSetCall(t *testing.T) {
 		t.Errorf("second GetOrSet returned `%v`, expected `value`", data[0])
 	}
 }
+
+func TestCacheInvalidStateUnlocks(t *testing.T) {
+	c := newCache()
+	c.data["key1"] = json.RawMessage(`"value"`)
+	c.pending["key1"] = make(chan struct{})
+
+	if _, err := c.GetOrSet(context.Background(), []string{"key1"}, func([]string) (map[string]json.RawMessage, error) {
+		return nil, nil
+	}); err == nil {
+		t.Fatalf("GetOrSet() did not return an error for a key in invalid state")
+	}
+
+	done := make(chan struct{})
+	go func() {
+		c.SetIfExist(map[string]json.RawMessage{"key2": json.RawMessage(`"value"`)})
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("SetIfExist() is blocked after GetOrSet() returned the error")
+	}
+}
+
+func TestCacheLen(t *testing.T) {
+	c := newCache()
+	c.GetOrSet(context.Background(), []string{"key1", "key2"}, func([]string) (map[string]json.RawMessage, error) {
+		return map[string]json.RawMessage{"key1": json.RawMessage("value")}, nil
+	})
+
+	if got := c.Len(); got != 2 {
+		t.Errorf("Len() returned %d, expected 2", got)
+	}
+}
+
+func TestCachePrune(t *testing.T) {
+	c := newCache()
+	keys := []string{"cold", "warm", "hot", "other"}
+	c.GetOrSet(context.Background(), keys, func(keys []string) (map[string]json.RawMessage, error) {
+		data := make(map[string]json.RawMessage)
+		for _, k := range keys {
+			data[k] = json.RawMessage(`"value"`)
+		}
+		return data, nil
+	})
+
+	c.prune(2, map[string]uint64{"cold": 1, "warm": 5, "hot": 10})
+
+	if got := c.Len(); got != 2 {
+		t.Errorf("Len() returned %d, expected 2", got)
+	}
+
+	var fetched []string
+	got, err := c.GetOrSet(context.Background(), keys, func(keys []string) (map[string]json.RawMessage, error) {
+		fetched = keys
+		return map[string]json.RawMessage{"cold": json.RawMessage(`"new"`), "other": json.RawMessage(`"new"`)}, nil
+	})
+	if err != nil {
+		t.Fatalf("GetOrSet() returned the unexpected error: %v", err)
+	}
+
+	if len(fetched) != 2 || fetched[0] != "cold" || fetched[1] != "other" {
+		t.Errorf("Fetched keys %v, expected [cold other]", fetched)
+	}
+
+	expect := []json.RawMessage{[]byte(`"new"`), []byte(`"value"`), []byte(`"value"`), []byte(`"new"`)}
+	if !test.CmpSliceBytes(got, expect) {
+		t.Errorf("GetOrSet() returned `%s`, expected `%s`", got, expect)
+	}
+}
+
+func TestCacheCompression(t *testing.T) {
+	c := newCache()
+	c.compressThreshold = 10
+
+	small := `"small"`
+	big := `"` + strings.Repeat("big value ", 100) + `"`
+	got, err := c.GetOrSet(context.Background(), []string{"small", "big"}, func([]string) (map[string]json.RawMessage, error) {
+		return map[string]json.RawMessage{
+			"small": json.RawMessage(small),
+			"big":   json.RawMessage(big),
+		}, nil
+	})
+	if err != nil {
+		t.Fatalf("GetOrSet() returned the unexpected error: %v", err)
+	}
+
+	expect := []json.RawMessage{[]byte(small), []byte(big)}
+	if !test.CmpSliceBytes(got, expect) {
+		t.Errorf("GetOrSet() returned `%s`, expected `%s`", got, expect)
+	}
+
+	if isCompressed(c.data["small"]) {
+		t.Errorf("Small value was compressed")
+	}
+	if !isCompressed(c.data["big"]) || len(c.data["big"]) >= len(big) {
+		t.Errorf("Big value was not compressed")
+	}
+}
+
+func TestCacheArena(t *testing.T) {
+	c := newCache()
+	c.arena = new(arena)
+
+	big := `"` + strings.Repeat("x", arenaMaxValueSize) + `"`
+	keys := []string{"small", "big", "null"}
+	c.GetOrSet(context.Background(), keys, func([]string) (map[string]json.RawMessage, error) {
+		return map[string]json.RawMessage{
+			"small": json.RawMessage(`"small"`),
+			"big":   json.RawMessage(big),
+			"null":  json.RawMessage(`null`),
+		}, nil
+	})
+
+	// Update the small value often enough to trigger compactions.
+	for i := 0; i < 3*arenaChunkSize/100; i++ {
+		c.SetIfExist(map[string]json.RawMessage{"small": json.RawMessage(`"` + strings.Repeat("y", 98) + `"`)})
+	}
+	c.SetIfExist(map[string]json.RawMessage{"small": json.RawMessage(`"new"`)})
+
+	got, err := c.GetOrSet(context.Background(), keys, func([]string) (map[string]json.RawMessage, error) {
+		t.Errorf("set function was called")
+		return nil, nil
+	})
+	if err != nil {
+		t.Fatalf("GetOrSet() returned the unexpected error: %v", err)
+	}
+
+	expect := []json.RawMessage{[]byte(`"new"`), []byte(big), nil}
+	if !test.CmpSliceBytes(got, expect) {
+		t.Errorf("GetOrSet() returned `%.20s`, expected `%.20s`", got, expect)
+	}
+
+	if _, ok := c.small["small"]; !ok {
+		t.Errorf("Small value is not in the arena")
+	}
+	if len(c.arena.chunks) != 1 {
+		t.Errorf("Arena has %d chunks after compaction, expected 1", len(c.arena.chunks))
+	}
+	if c.Len() != 3 {
+		t.Errorf("Len() returned %d, expected 3", c.Len())
+	}
+}
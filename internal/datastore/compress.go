@@ -0,0 +1,55 @@
+package datastore
+
+import (
+	"bytes"
+	"compress/flate"
+	"fmt"
+	"io/ioutil"
+	"sync"
+)
+
+// compressedMarker is the first byte of a compressed cache value. A json value
+// can never start with this byte.
+const compressedMarker = 0x00
+
+var flateWriterPool = sync.Pool{
+	New: func() interface{} {
+		w, _ := flate.NewWriter(nil, flate.BestSpeed)
+		return w
+	},
+}
+
+// compressValue returns the compressed value with the compressedMarker as
+// first byte.
+func compressValue(value []byte) []byte {
+	var buf bytes.Buffer
+	buf.Grow(len(value)/2 + 1)
+	buf.WriteByte(compressedMarker)
+
+	w := flateWriterPool.Get().(*flate.Writer)
+	defer flateWriterPool.Put(w)
+	w.Reset(&buf)
+
+	// Writing to a bytes.Buffer can not fail.
+	w.Write(value)
+	w.Close()
+	return buf.Bytes()
+}
+
+// isCompressed tells, if the value was created with compressValue.
+func isCompressed(value []byte) bool {
+	return len(value) > 0 && value[0] == compressedMarker
+}
+
+// decompressValue returns the original value of a value created by
+// compressValue.
+func decompressValue(value []byte) ([]byte, error) {
+	r := flate.NewReader(bytes.NewReader(value[1:]))
+	defer r.Close()
+
+	decompressed, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("decompressing value: %w", err)
+	}
+	return decompressed, nil
+}
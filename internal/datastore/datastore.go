@@ -10,35 +10,120 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
 	"io/ioutil"
 	"net/http"
+	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/openslides/openslides-autoupdate-service/internal/clock"
+	"github.com/openslides/openslides-autoupdate-service/internal/trace"
 )
 
-const urlPath = "/internal/datastore/reader/get_many"
+const (
+	urlPath    = "/internal/datastore/reader/get_many"
+	healthPath = "/internal/datastore/reader/health"
+)
 
 // Datastore can be used to get values from the datastore-service.
 //
 // Has to be created with datastore.New().
 type Datastore struct {
 	url             string
+	baseURL         string
 	cache           *cache
 	keychanger      Updater
 	changeListeners []func(map[string]json.RawMessage) error
 	closed          <-chan struct{}
+	clock           clock.Clock
+	hotKeys         *hotKeys
+	countHotKeys    bool
+	cacheLimit      int
+	requestTimeout  time.Duration
+
+	// updating is true, while changed values are written to the cache and the
+	// change listeners are called.
+	updating atomic.Bool
+
+	// errMu protects updateErr, requestErr, readerReached and
+	// updaterConnected. The errors are the errors of the last call to the
+	// updater and the last request to the datastore.
+	errMu            sync.Mutex
+	updateErr        error
+	requestErr       error
+	readerReached    bool
+	updaterConnected bool
+}
+
+// Option is an optional argument for datastore.New().
+type Option func(*Datastore)
+
+// WithClock sets the clock that is used to wait after an error from the
+// updater. The default is clock.Real.
+func WithClock(c clock.Clock) Option {
+	return func(d *Datastore) {
+		d.clock = c
+	}
+}
+
+// WithCompression compresses all cache values that are bigger then threshold
+// bytes. The values are decompressed on each read. This trades CPU time for
+// less memory. The default is 0, which means that no value is compressed.
+func WithCompression(threshold int) Option {
+	return func(d *Datastore) {
+		d.cache.compressThreshold = threshold
+	}
+}
+
+// WithArena saves small cache values in a few big byte slices instead of many
+// individual objects. This reduces the work of the garbage collector on big
+// instances.
+func WithArena() Option {
+	return func(d *Datastore) {
+		d.cache.arena = new(arena)
+	}
+}
+
+// WithHotKeys counts how often the keys are requested and changed. The result
+// can be read with HotKeys(). Per default, nothing is counted.
+func WithHotKeys() Option {
+	return func(d *Datastore) {
+		d.countHotKeys = true
+	}
+}
+
+// WithCacheLimit limits the cache to about limit keys. If the cache gets
+// bigger, the keys, that were requested least often, are removed and fetched
+// again on the next request. This implies WithHotKeys. The default is 0, which
+// means that the cache is not limited.
+func WithCacheLimit(limit int) Option {
+	return func(d *Datastore) {
+		d.cacheLimit = limit
+	}
 }
 
 // New returns a new Datastore object.
-func New(url string, closed <-chan struct{}, errHandler func(error), keychanger Updater) *Datastore {
+func New(url string, closed <-chan struct{}, errHandler func(error), keychanger Updater, options ...Option) *Datastore {
 	d := &Datastore{
 		cache:      newCache(),
 		url:        url + urlPath,
+		baseURL:    url,
 		keychanger: keychanger,
 		closed:     closed,
+		clock:      clock.Real{},
+	}
+
+	for _, o := range options {
+		o(d)
 	}
 
+	if d.countHotKeys || d.cacheLimit > 0 {
+		d.hotKeys = newHotKeys(d.clock)
+	}
+
+	go d.waitForReader()
 	go d.receiveKeyChanges(errHandler)
 
 	return d
@@ -47,17 +132,104 @@ func New(url string, closed <-chan struct{}, errHandler func(error), keychanger
 // Get returns the value for one or many keys.
 //
 // If a key does not exist, the value nil is returned for that key.
+//
+// If the datastore was created with WithRequestTimeout and some keys could not
+// be read in time, the values of the other keys are returned together with an
+// error of type PendingError. The value of the pending keys is nil.
 func (d *Datastore) Get(ctx context.Context, keys ...string) ([]json.RawMessage, error) {
+	d.hotKeys.request(keys)
+
 	values, err := d.cache.GetOrSet(ctx, keys, func(keys []string) (map[string]json.RawMessage, error) {
-		return d.requestKeys(keys)
+		// The request is shared by all callers, that wait for the keys. So
+		// it can not be canceled by one of them and is not done for a user.
+		request := d.requestKeys
+		if d.requestTimeout > 0 {
+			request = d.requestKeysWithTimeout
+		}
+		data, err := request(trace.Shared(ctx), keys)
+		d.errMu.Lock()
+		d.requestErr = err
+		if err == nil {
+			d.readerReached = true
+		}
+		d.errMu.Unlock()
+		return data, err
 	})
 	if err != nil {
+		var pending PendingError
+		if errors.As(err, &pending) {
+			return values, pending
+		}
 		return nil, fmt.Errorf("getOrSet for keys `%s`: %w", keys, err)
 	}
 
+	d.limitCache()
 	return values, nil
 }
 
+// limitCache removes the keys with the lowest priority from the cache, if it
+// is bigger then the limit. The priority of a key is how often it was
+// requested lately.
+//
+// The cache is reduced to 90% of the limit, so it is not pruned on every
+// request.
+func (d *Datastore) limitCache() {
+	if d.cacheLimit <= 0 || d.cache.Len() <= d.cacheLimit {
+		return
+	}
+
+	d.cache.prune(d.cacheLimit-d.cacheLimit/10, d.hotKeys.requestPriorities())
+}
+
+// CacheSize returns the number of keys in the cache.
+func (d *Datastore) CacheSize() int {
+	return d.cache.Len()
+}
+
+// HotKeys returns the n most requested and the n most changed keys. It returns
+// empty lists, if the datastore was not created with the option WithHotKeys or
+// WithCacheLimit.
+//
+// The counts are approximated and halved every ten minutes.
+func (d *Datastore) HotKeys(n int) HotKeys {
+	return d.hotKeys.top(n)
+}
+
+// Health returns the state of the connections to the datastore reader and to
+// the messaging service. A nil error means, that the last request succeeded.
+func (d *Datastore) Health() map[string]error {
+	d.errMu.Lock()
+	defer d.errMu.Unlock()
+
+	return map[string]error{
+		"datastore": d.requestErr,
+		"messaging": d.updateErr,
+	}
+}
+
+// Ready returns an error, if the last request to the datastore or the last
+// call to the updater failed. It also returns an error, until the datastore
+// reader was reached and the updater is connected.
+func (d *Datastore) Ready() error {
+	d.errMu.Lock()
+	defer d.errMu.Unlock()
+
+	if !d.readerReached {
+		return errors.New("datastore reader not reached yet")
+	}
+	if !d.updaterConnected {
+		return errors.New("updater not connected yet")
+	}
+
+	if d.updateErr != nil {
+		return fmt.Errorf("updater: %w", d.updateErr)
+	}
+	if d.requestErr != nil {
+		return fmt.Errorf("datastore: %w", d.requestErr)
+	}
+	return nil
+}
+
 // RegisterChangeListener registers a function that gets changed data.
 func (d *Datastore) RegisterChangeListener(f func(map[string]json.RawMessage) error) {
 	d.changeListeners = append(d.changeListeners, f)
@@ -66,6 +238,10 @@ func (d *Datastore) RegisterChangeListener(f func(map[string]json.RawMessage) er
 // receiveKeyChanges listens for updates and saves then into the topic. This
 // function blocks until the service is closed.
 func (d *Datastore) receiveKeyChanges(errHandler func(error)) {
+	if !d.connectUpdater(errHandler) {
+		return
+	}
+
 	for {
 		select {
 		case <-d.closed:
@@ -74,36 +250,128 @@ func (d *Datastore) receiveKeyChanges(errHandler func(error)) {
 		}
 
 		data, err := d.keychanger.Update()
+		d.errMu.Lock()
+		d.updateErr = err
+		d.errMu.Unlock()
 		if err != nil {
 			errHandler(fmt.Errorf("update data: %w", err))
-			time.Sleep(time.Second)
+			select {
+			case <-d.clock.After(time.Second):
+			case <-d.closed:
+				return
+			}
 			continue
 		}
 
+		d.updating.Store(true)
 		d.cache.SetIfExist(data)
+		d.hotKeys.change(data)
 
 		for _, f := range d.changeListeners {
 			if err := f(data); err != nil {
 				errHandler(err)
 			}
 		}
+		d.updating.Store(false)
 	}
 }
 
+// Updating tells, if the cache has values, that the change listeners did not
+// get yet.
+func (d *Datastore) Updating() bool {
+	return d.updating.Load()
+}
+
+// waitForReader checks the health of the datastore reader until it is
+// healthy. Blocks until the reader was reached or the service is closed.
+func (d *Datastore) waitForReader() {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go func() {
+		select {
+		case <-d.closed:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	for {
+		if err := d.checkReader(ctx); err == nil {
+			d.errMu.Lock()
+			d.readerReached = true
+			d.errMu.Unlock()
+			return
+		}
+
+		select {
+		case <-d.clock.After(time.Second):
+		case <-d.closed:
+			return
+		}
+	}
+}
+
+// checkReader requests the health route of the datastore reader. Returns an
+// error, if the reader is not reachable or does not answer with status 200.
+func (d *Datastore) checkReader(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", d.baseURL+healthPath, nil)
+	if err != nil {
+		return fmt.Errorf("creating request: %w", err)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("requesting health: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("datastore reader returned status %s", resp.Status)
+	}
+	return nil
+}
+
+// connectUpdater pings the updater until it is connected, if it implements
+// the Pinger interface. Returns false, if the service was closed before.
+func (d *Datastore) connectUpdater(errHandler func(error)) bool {
+	if pinger, ok := d.keychanger.(Pinger); ok {
+		for {
+			err := pinger.Ping()
+			if err == nil {
+				break
+			}
+
+			errHandler(fmt.Errorf("connect updater: %w", err))
+			select {
+			case <-d.clock.After(time.Second):
+			case <-d.closed:
+				return false
+			}
+		}
+	}
+
+	d.errMu.Lock()
+	d.updaterConnected = true
+	d.errMu.Unlock()
+	return true
+}
+
 // requestKeys request a list of keys by the datastore. If an error happens, no
 // key is returned.
-func (d *Datastore) requestKeys(keys []string) (map[string]json.RawMessage, error) {
+func (d *Datastore) requestKeys(ctx context.Context, keys []string) (map[string]json.RawMessage, error) {
 	requestData, err := keysToGetManyRequest(keys)
 	if err != nil {
 		return nil, fmt.Errorf("creating GetManyRequest: %w", err)
 	}
 
-	req, err := http.NewRequest("POST", d.url, bytes.NewReader(requestData))
+	req, err := http.NewRequestWithContext(ctx, "POST", d.url, bytes.NewReader(requestData))
 	if err != nil {
 		return nil, fmt.Errorf("creating request: %w", err)
 	}
 
 	req.Header.Set("Content-Type", "application/json")
+	trace.SetHeader(ctx, req.Header)
 
 	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
@@ -119,7 +387,7 @@ func (d *Datastore) requestKeys(keys []string) (map[string]json.RawMessage, erro
 		return nil, fmt.Errorf("datastore returned status %s: %s", resp.Status, body)
 	}
 
-	responseData, err := getManyResponceToKeyValue(resp.Body)
+	responseData, err := getManyResponceToKeyValue(resp.Body, len(keys))
 	if err != nil {
 		return nil, fmt.Errorf("parse responce: %w", err)
 	}
@@ -134,22 +402,3 @@ func keysToGetManyRequest(keys []string) (json.RawMessage, error) {
 	}{keys}
 	return json.Marshal(request)
 }
-
-// getManyResponceToKeyValue reads the responce from the getMany request and
-// returns the content as key-values.
-func getManyResponceToKeyValue(r io.Reader) (map[string]json.RawMessage, error) {
-	var data map[string]map[string]map[string]json.RawMessage
-	if err := json.NewDecoder(r).Decode(&data); err != nil {
-		return nil, fmt.Errorf("decoding responce: %w", err)
-	}
-
-	keyValue := make(map[string]json.RawMessage)
-	for collection, idField := range data {
-		for id, fieldValue := range idField {
-			for field, value := range fieldValue {
-				keyValue[collection+"/"+id+"/"+field] = value
-			}
-		}
-	}
-	return keyValue, nil
-}
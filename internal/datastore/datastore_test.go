@@ -2,10 +2,20 @@ package datastore_test
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/openslides/openslides-autoupdate-service/internal/clock"
 	"github.com/openslides/openslides-autoupdate-service/internal/datastore"
 	"github.com/openslides/openslides-autoupdate-service/internal/test"
+	"github.com/openslides/openslides-autoupdate-service/internal/trace"
 )
 
 func TestDataStoreGet(t *testing.T) {
@@ -47,3 +57,371 @@ func TestDataStoreGetMultiValue(t *testing.T) {
 		t.Errorf("Got %d requests to the datastore, expected 1", ts.RequestCount)
 	}
 }
+
+func TestDataStoreWaitAfterUpdateError(t *testing.T) {
+	closed := make(chan struct{})
+	defer close(closed)
+	ts := test.NewDatastoreServer()
+	m := clock.NewMock(time.Now())
+	errs := make(chan error, 10)
+	d := datastore.New(ts.TS.URL, closed, func(err error) { errs <- err }, errUpdater{}, datastore.WithClock(m))
+
+	<-errs
+	m.WaitForWaiters(1)
+
+	if err := d.Ready(); err == nil {
+		t.Errorf("Ready() returned no error after the updater failed")
+	}
+
+	select {
+	case <-errs:
+		t.Fatalf("Updater was called again before the clock moved")
+	default:
+	}
+
+	m.Add(time.Second)
+
+	select {
+	case <-errs:
+	case <-time.After(time.Second):
+		t.Errorf("Updater was not called again after the clock moved")
+	}
+}
+
+type errUpdater struct{}
+
+func (errUpdater) Update() (map[string]json.RawMessage, error) {
+	return nil, errors.New("update error")
+}
+
+func TestDataStoreReadyAfterStart(t *testing.T) {
+	closed := make(chan struct{})
+	defer close(closed)
+	ts := test.NewDatastoreServer()
+	d := datastore.New(ts.TS.URL, closed, func(error) {}, test.NewUpdaterMock())
+
+	timeout := time.After(time.Second)
+	for d.Ready() != nil {
+		select {
+		case <-timeout:
+			t.Fatalf("Datastore not ready after one second: %v", d.Ready())
+		case <-time.After(time.Millisecond):
+		}
+	}
+}
+
+func TestDataStoreNotReadyWithoutUpdater(t *testing.T) {
+	closed := make(chan struct{})
+	defer close(closed)
+	ts := test.NewDatastoreServer()
+	m := clock.NewMock(time.Now())
+	errs := make(chan error, 10)
+	d := datastore.New(ts.TS.URL, closed, func(err error) { errs <- err }, pingUpdater{}, datastore.WithClock(m))
+
+	<-errs
+	if err := d.Ready(); err == nil {
+		t.Errorf("Ready() returned no error, while the updater is not connected")
+	}
+}
+
+func TestDataStoreNotReadyWithoutReaderHealth(t *testing.T) {
+	closed := make(chan struct{})
+	defer close(closed)
+	probed := make(chan struct{}, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case probed <- struct{}{}:
+		default:
+		}
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}))
+	defer ts.Close()
+	m := clock.NewMock(time.Now())
+	d := datastore.New(ts.URL, closed, func(error) {}, test.NewUpdaterMock(), datastore.WithClock(m))
+
+	<-probed
+	m.WaitForWaiters(1)
+
+	if err := d.Ready(); err == nil {
+		t.Errorf("Ready() returned no error, while the reader answers with status 405")
+	}
+}
+
+type pingUpdater struct{}
+
+func (pingUpdater) Update() (map[string]json.RawMessage, error) {
+	return nil, nil
+}
+
+func (pingUpdater) Ping() error {
+	return errors.New("no connection")
+}
+
+func TestDataStoreHotKeys(t *testing.T) {
+	closed := make(chan struct{})
+	defer close(closed)
+	ts := test.NewDatastoreServer()
+	updater := test.NewUpdaterMock()
+	d := datastore.New(ts.TS.URL, closed, func(error) {}, updater, datastore.WithHotKeys())
+
+	d.Get(context.Background(), "user/1/name", "user/2/name")
+	d.Get(context.Background(), "user/2/name")
+	d.Get(context.Background(), "user/2/name", "user/3/name")
+
+	got := d.HotKeys(2)
+	expect := []datastore.KeyCount{{Key: "user/2/name", Count: 3}, {Key: "user/1/name", Count: 1}}
+	if len(got.Requested) != 2 || got.Requested[0] != expect[0] || got.Requested[1] != expect[1] {
+		t.Errorf("Got requested keys %v, expected %v", got.Requested, expect)
+	}
+}
+
+func TestDataStoreHotKeysDecay(t *testing.T) {
+	closed := make(chan struct{})
+	defer close(closed)
+	ts := test.NewDatastoreServer()
+	updater := test.NewUpdaterMock()
+	mockClock := clock.NewMock(time.Unix(0, 0))
+	d := datastore.New(ts.TS.URL, closed, func(error) {}, updater, datastore.WithHotKeys(), datastore.WithClock(mockClock))
+
+	for i := 0; i < 4; i++ {
+		d.Get(context.Background(), "user/1/name")
+	}
+
+	mockClock.Add(10 * time.Minute)
+	d.Get(context.Background(), "user/2/name")
+
+	got := d.HotKeys(5)
+	expect := []datastore.KeyCount{{Key: "user/1/name", Count: 2}, {Key: "user/2/name", Count: 1}}
+	if len(got.Requested) != 2 || got.Requested[0] != expect[0] || got.Requested[1] != expect[1] {
+		t.Errorf("Got requested keys %v, expected %v", got.Requested, expect)
+	}
+
+	mockClock.Add(30 * time.Minute)
+	d.Get(context.Background(), "user/3/name")
+
+	got = d.HotKeys(5)
+	expect = []datastore.KeyCount{{Key: "user/3/name", Count: 1}}
+	if len(got.Requested) != 1 || got.Requested[0] != expect[0] {
+		t.Errorf("Got requested keys %v, expected %v", got.Requested, expect)
+	}
+}
+
+func TestDataStoreHotKeysBounded(t *testing.T) {
+	closed := make(chan struct{})
+	defer close(closed)
+	ts := test.NewDatastoreServer()
+	updater := test.NewUpdaterMock()
+	d := datastore.New(ts.TS.URL, closed, func(error) {}, updater, datastore.WithHotKeys())
+
+	for i := 0; i < 10; i++ {
+		d.Get(context.Background(), "user/1/name")
+	}
+
+	keys := make([]string, 10_000)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("user/%d/username", i)
+	}
+	d.Get(context.Background(), keys...)
+
+	got := d.HotKeys(len(keys))
+	if len(got.Requested) >= len(keys) {
+		t.Errorf("Got %d requested keys, expected less then %d", len(got.Requested), len(keys))
+	}
+
+	if got.Requested[0].Key != "user/1/name" {
+		t.Errorf("Got most requested key %s, expected user/1/name", got.Requested[0].Key)
+	}
+}
+
+func TestDataStoreCacheLimit(t *testing.T) {
+	closed := make(chan struct{})
+	defer close(closed)
+	ts := test.NewDatastoreServer()
+	updater := test.NewUpdaterMock()
+	d := datastore.New(ts.TS.URL, closed, func(error) {}, updater, datastore.WithCacheLimit(10))
+
+	for i := 0; i < 3; i++ {
+		d.Get(context.Background(), "user/1/name")
+	}
+
+	for i := 0; i < 20; i++ {
+		d.Get(context.Background(), fmt.Sprintf("user/%d/username", i))
+	}
+
+	if got := d.CacheSize(); got > 10 {
+		t.Errorf("Got cache size %d, expected at most 10", got)
+	}
+
+	ts.RequestCount = 0
+	d.Get(context.Background(), "user/1/name")
+	if ts.RequestCount != 0 {
+		t.Errorf("The most requested key was removed from the cache")
+	}
+}
+
+func TestDataStoreHistory(t *testing.T) {
+	closed := make(chan struct{})
+	defer close(closed)
+	ts := test.NewDatastoreServer()
+	ts.AddPosition(3, map[string]string{"motion/1/title": `"first"`})
+	ts.AddPosition(7, map[string]string{"motion/1/title": `"second"`, "motion/1/text": `"text"`})
+	ts.AddPosition(9, map[string]string{"motion/2/title": `"other"`})
+	d := datastore.New(ts.TS.URL, closed, func(error) {}, test.NewUpdaterMock())
+
+	positions, err := d.HistoryPositions(context.Background(), "motion/1")
+	if err != nil {
+		t.Fatalf("HistoryPositions returned unexpected error: %v", err)
+	}
+	if len(positions) != 2 || positions[0] != 3 || positions[1] != 7 {
+		t.Errorf("Got positions %v, expected [3 7]", positions)
+	}
+
+	ts.SetPositionInfo(7, 1600000000, 5)
+	var users []int
+	var timestamps []int64
+	err = d.HistoryInformation(context.Background(), "motion/1", func(position int, timestamp int64, userID int) {
+		users = append(users, userID)
+		timestamps = append(timestamps, timestamp)
+	})
+	if err != nil {
+		t.Fatalf("HistoryInformation returned unexpected error: %v", err)
+	}
+	if len(users) != 2 || users[1] != 5 || timestamps[1] != 1600000000 {
+		t.Errorf("Got users %v and timestamps %v, expected user 5 at 1600000000 for position 7", users, timestamps)
+	}
+
+	data, err := d.GetPosition(context.Background(), 7, "motion/1")
+	if err != nil {
+		t.Fatalf("GetPosition returned unexpected error: %v", err)
+	}
+	if len(data) != 2 || string(data["motion/1/title"]) != `"second"` || string(data["motion/1/text"]) != `"text"` {
+		t.Errorf("Got data %s, expected title and text of position 7", data)
+	}
+
+	values, err := d.GetAt(context.Background(), 3, "motion/1/title", "motion/1/text")
+	if err != nil {
+		t.Fatalf("GetAt returned unexpected error: %v", err)
+	}
+	if len(values) != 2 || string(values[0]) != `"first"` || values[1] != nil {
+		t.Errorf("Got values %s, expected the title of position 3 and no text", values)
+	}
+}
+
+func TestDataStoreTraceHeaders(t *testing.T) {
+	headers := make(chan http.Header, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			// Ignore the health check of the reader.
+			return
+		}
+		headers <- r.Header
+		w.Write([]byte(`{}`))
+	}))
+	defer ts.Close()
+
+	closed := make(chan struct{})
+	defer close(closed)
+	d := datastore.New(ts.URL, closed, func(error) {}, test.NewUpdaterMock())
+
+	info := trace.Info{TraceID: "0af7651916cd43dd8448eb211c80319c", Flags: "01", RequestID: "my-request", UserID: 5}
+	if _, err := d.Get(trace.NewContext(context.Background(), info), "user/1/name"); err != nil {
+		t.Fatalf("Get returned unexpected error: %v", err)
+	}
+
+	h := <-headers
+	if got := h.Get(trace.RequestIDHeader); got != "my-request" {
+		t.Errorf("Got request id `%s`, expected `my-request`", got)
+	}
+	if got := h.Get(trace.TraceParentHeader); !strings.Contains(got, info.TraceID) {
+		t.Errorf("Got traceparent `%s`, expected trace id %s", got, info.TraceID)
+	}
+	if got := h.Get(trace.UserIDHeader); got != "" {
+		t.Errorf("Got user id `%s` for a cached request, expected none", got)
+	}
+}
+
+func TestDataStoreRequestTimeout(t *testing.T) {
+	slow := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if strings.Contains(string(body), "motion/") {
+			select {
+			case <-slow:
+			case <-r.Context().Done():
+				return
+			}
+			w.Write([]byte(`{"motion":{"1":{"title":"my motion"}}}`))
+			return
+		}
+		w.Write([]byte(`{"user":{"1":{"name":"hugo"}}}`))
+	}))
+	defer ts.Close()
+
+	closed := make(chan struct{})
+	defer close(closed)
+	d := datastore.New(ts.URL, closed, func(error) {}, test.NewUpdaterMock(), datastore.WithRequestTimeout(50*time.Millisecond))
+
+	got, err := d.Get(context.Background(), "user/1/name", "motion/1/title")
+	var pending datastore.PendingError
+	if !errors.As(err, &pending) {
+		t.Fatalf("Got error %v, expected a PendingError", err)
+	}
+	if keys := pending.PendingKeys(); len(keys) != 1 || keys[0] != "motion/1/title" {
+		t.Errorf("Got pending keys %v, expected [motion/1/title]", keys)
+	}
+	if len(got) != 2 || string(got[0]) != `"hugo"` || got[1] != nil {
+		t.Errorf("Got %s, expected the value of user/1/name", got)
+	}
+
+	// The pending key is read again on the next call.
+	close(slow)
+	got, err = d.Get(context.Background(), "user/1/name", "motion/1/title")
+	if err != nil {
+		t.Fatalf("Get returned unexpected error: %v", err)
+	}
+	if len(got) != 2 || string(got[0]) != `"hugo"` || string(got[1]) != `"my motion"` {
+		t.Errorf("Got %s, expected both values", got)
+	}
+}
+
+func TestDataStoreUpdating(t *testing.T) {
+	closed := make(chan struct{})
+	defer close(closed)
+	ts := test.NewDatastoreServer()
+	updater := test.NewUpdaterMock()
+	d := datastore.New(ts.TS.URL, closed, func(error) {}, updater)
+
+	if _, err := d.Get(context.Background(), "user/1/name"); err != nil {
+		t.Fatalf("Get returned unexpected error: %v", err)
+	}
+
+	type state struct {
+		updating bool
+		value    string
+	}
+	states := make(chan state, 1)
+	d.RegisterChangeListener(func(map[string]json.RawMessage) error {
+		values, _ := d.Get(context.Background(), "user/1/name")
+		states <- state{updating: d.Updating(), value: string(values[0])}
+		return nil
+	})
+
+	updater.Send(map[string]json.RawMessage{"user/1/name": []byte(`"new"`)})
+
+	// The cache has the new value, before the listeners get it. So the
+	// datastore is updating, until all listeners are called.
+	got := <-states
+	if !got.updating {
+		t.Errorf("Updating() returned false while the listeners were called")
+	}
+	if got.value != `"new"` {
+		t.Errorf("Got value %s in the listener, expected the new value", got.value)
+	}
+
+	for i := 0; d.Updating(); i++ {
+		if i > 100 {
+			t.Fatalf("Updating() still returns true after the listeners were called")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,204 @@
+package datastore
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// maxPooledBufferSize is the maximum size of a buffer that is put back into
+// the pool. Bigger buffers are freed to not hold too much memory.
+const maxPooledBufferSize = 16 << 20
+
+var responceBufPool = sync.Pool{
+	New: func() interface{} {
+		return new(bytes.Buffer)
+	},
+}
+
+// getManyResponceToKeyValue reads the responce from the getMany request and
+// returns the content as key-values. size is the expected number of keys.
+//
+// The responce is read into a reused buffer and scanned without building the
+// nested maps of the responce. Only the keys and the values are allocated.
+func getManyResponceToKeyValue(r io.Reader, size int) (map[string]json.RawMessage, error) {
+	buf := responceBufPool.Get().(*bytes.Buffer)
+	buf.Reset()
+	defer func() {
+		if buf.Cap() <= maxPooledBufferSize {
+			responceBufPool.Put(buf)
+		}
+	}()
+
+	if _, err := buf.ReadFrom(r); err != nil {
+		return nil, fmt.Errorf("reading responce: %w", err)
+	}
+
+	if !json.Valid(buf.Bytes()) {
+		return nil, fmt.Errorf("decoding responce: invalid json")
+	}
+
+	keyValue := make(map[string]json.RawMessage, size)
+	s := scanner{data: buf.Bytes()}
+
+	// key is reused for all keys to build them without temporary strings.
+	var key []byte
+
+	err := s.object(func(collection []byte) error {
+		return s.object(func(id []byte) error {
+			return s.object(func(field []byte) error {
+				raw := s.value()
+				value := make(json.RawMessage, len(raw))
+				copy(value, raw)
+
+				key = append(key[:0], collection...)
+				key = append(key, '/')
+				key = append(key, id...)
+				key = append(key, '/')
+				key = append(key, field...)
+				keyValue[string(key)] = value
+				return nil
+			})
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("decoding responce: %w", err)
+	}
+	return keyValue, nil
+}
+
+// scanner reads valid json. It does not validate the data. Use json.Valid
+// before.
+type scanner struct {
+	data []byte
+	pos  int
+}
+
+// object reads a json object and calls f for each attribute. f has to read the
+// value of the attribute. The name is only valid until f returns.
+func (s *scanner) object(f func(name []byte) error) error {
+	if err := s.expect('{'); err != nil {
+		return err
+	}
+
+	s.skipSpace()
+	if s.data[s.pos] == '}' {
+		s.pos++
+		return nil
+	}
+
+	for {
+		name, err := s.str()
+		if err != nil {
+			return fmt.Errorf("reading attribute name: %w", err)
+		}
+
+		if err := s.expect(':'); err != nil {
+			return err
+		}
+
+		if err := f(name); err != nil {
+			return err
+		}
+
+		s.skipSpace()
+		if s.data[s.pos] == ',' {
+			s.pos++
+			continue
+		}
+		return s.expect('}')
+	}
+}
+
+// str reads a json string and returns its content.
+func (s *scanner) str() ([]byte, error) {
+	s.skipSpace()
+	raw := s.value()
+	if len(raw) == 0 || raw[0] != '"' {
+		return nil, fmt.Errorf("got %s, expected a string", raw)
+	}
+
+	if bytes.IndexByte(raw, '\\') == -1 {
+		return raw[1 : len(raw)-1], nil
+	}
+
+	// Only strings with escape sequences need to be decoded.
+	var decoded string
+	if err := json.Unmarshal(raw, &decoded); err != nil {
+		return nil, fmt.Errorf("decoding string: %w", err)
+	}
+	return []byte(decoded), nil
+}
+
+// value reads the next json value and returns it.
+func (s *scanner) value() []byte {
+	s.skipSpace()
+	start := s.pos
+
+	switch s.data[s.pos] {
+	case '"':
+		s.skipString()
+
+	case '{', '[':
+		depth := 0
+		for {
+			switch s.data[s.pos] {
+			case '"':
+				s.skipString()
+				continue
+			case '{', '[':
+				depth++
+			case '}', ']':
+				depth--
+			}
+			s.pos++
+			if depth == 0 {
+				break
+			}
+		}
+
+	default:
+		// Numbers, true, false and null.
+		for s.pos < len(s.data) && bytes.IndexByte([]byte(",}] \t\r\n"), s.data[s.pos]) == -1 {
+			s.pos++
+		}
+	}
+	return s.data[start:s.pos]
+}
+
+// skipString moves the position after the string at the current position.
+func (s *scanner) skipString() {
+	s.pos++
+	for s.data[s.pos] != '"' {
+		if s.data[s.pos] == '\\' {
+			s.pos++
+		}
+		s.pos++
+	}
+	s.pos++
+}
+
+// expect reads the next non space character and returns an error, if it is
+// not c.
+func (s *scanner) expect(c byte) error {
+	s.skipSpace()
+	if s.pos >= len(s.data) || s.data[s.pos] != c {
+		return fmt.Errorf("invalid character at position %d, expected %c", s.pos, c)
+	}
+	s.pos++
+	return nil
+}
+
+// skipSpace moves the position to the next non space character.
+func (s *scanner) skipSpace() {
+	for s.pos < len(s.data) {
+		switch s.data[s.pos] {
+		case ' ', '\t', '\r', '\n':
+			s.pos++
+		default:
+			return
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package datastore
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"testing"
+)
+
+func TestGetManyResponceToKeyValue(t *testing.T) {
+	body := `{
+		"user": {
+			"1": {"name": "Hugo", "group_ids": [1, 2]},
+			"2": {"name": null}
+		},
+		"motion": {
+			"5": {"text": "<p>{\"x\"}</p>", "meta": {"a": 1}}
+		}
+	}`
+
+	got, err := getManyResponceToKeyValue(bytes.NewReader([]byte(body)), 0)
+	if err != nil {
+		t.Fatalf("getManyResponceToKeyValue returned unexpected error: %v", err)
+	}
+
+	expect := map[string]string{
+		"user/1/name":      `"Hugo"`,
+		"user/1/group_ids": `[1, 2]`,
+		"user/2/name":      `null`,
+		"motion/5/text":    `"<p>{\"x\"}</p>"`,
+		"motion/5/meta":    `{"a": 1}`,
+	}
+	if len(got) != len(expect) {
+		t.Errorf("Got %d keys, expected %d: %v", len(got), len(expect), got)
+	}
+	for k, v := range expect {
+		if string(got[k]) != v {
+			t.Errorf("Got %s for key %s, expected %s", got[k], k, v)
+		}
+	}
+}
+
+func TestGetManyResponceToKeyValueInvalid(t *testing.T) {
+	for _, body := range []string{
+		``,
+		`[]`,
+		`{"user": []}`,
+		`{"user": {"1": "name"}}`,
+		`{"user": {"1": {"name": }}}`,
+		`{"user": {"1": {"name": "Hugo"}}`,
+	} {
+		if _, err := getManyResponceToKeyValue(bytes.NewReader([]byte(body)), 0); err == nil {
+			t.Errorf("Got no error for invalid body `%s`", body)
+		}
+	}
+}
+
+func BenchmarkGetManyResponceToKeyValue(b *testing.B) {
+	const count = 50_000
+	data := make(map[string]map[string]map[string]json.RawMessage)
+	data["motion"] = make(map[string]map[string]json.RawMessage)
+	for i := 0; i < count/5; i++ {
+		data["motion"][fmt.Sprint(i)] = map[string]json.RawMessage{
+			"title":      []byte(`"Motion title"`),
+			"text":       []byte(`"<p>Some text of the motion</p>"`),
+			"state_id":   []byte(`5`),
+			"sequential": []byte(`true`),
+			"tag_ids":    []byte(`[1,2,3]`),
+		}
+	}
+	body, err := json.Marshal(data)
+	if err != nil {
+		b.Fatalf("Can not create body: %v", err)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := getManyResponceToKeyValue(bytes.NewReader(body), count); err != nil {
+			b.Fatalf("getManyResponceToKeyValue returned error: %v", err)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package datastore
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/openslides/openslides-autoupdate-service/internal/trace"
+)
+
+const historyPath = "/internal/datastore/reader/history_information"
+
+// HistoryPositions returns the positions, where the object with the given
+// fqid was changed. The values are not cached.
+func (d *Datastore) HistoryPositions(ctx context.Context, fqid string) ([]int, error) {
+	var positions []int
+	err := d.HistoryInformation(ctx, fqid, func(position int, timestamp int64, userID int) {
+		positions = append(positions, position)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return positions, nil
+}
+
+// HistoryInformation calls f for each position, where the object with the
+// given fqid was changed, with the unix time of the change and the id of the
+// user, that changed it. The user id is 0, if the change was not done by a
+// user. The values are not cached.
+func (d *Datastore) HistoryInformation(ctx context.Context, fqid string, f func(position int, timestamp int64, userID int)) error {
+	request := struct {
+		FQIDs []string `json:"fqids"`
+	}{[]string{fqid}}
+
+	var response map[string][]struct {
+		Position  int   `json:"position"`
+		Timestamp int64 `json:"timestamp"`
+		UserID    int   `json:"user_id"`
+	}
+	if err := d.post(ctx, historyPath, request, &response); err != nil {
+		return fmt.Errorf("requesting history information: %w", err)
+	}
+
+	for _, info := range response[fqid] {
+		f(info.Position, info.Timestamp, info.UserID)
+	}
+	return nil
+}
+
+// GetPosition returns all fields of an object at the given position. The
+// values are not cached.
+func (d *Datastore) GetPosition(ctx context.Context, position int, fqid string) (map[string]json.RawMessage, error) {
+	request := struct {
+		Requests []string `json:"requests"`
+		Position int      `json:"position"`
+	}{[]string{fqid}, position}
+
+	var response json.RawMessage
+	if err := d.post(ctx, urlPath, request, &response); err != nil {
+		return nil, fmt.Errorf("requesting position %d: %w", position, err)
+	}
+
+	data, err := getManyResponceToKeyValue(bytes.NewReader(response), 0)
+	if err != nil {
+		return nil, fmt.Errorf("parse responce: %w", err)
+	}
+	return data, nil
+}
+
+// GetAt returns the values of the keys at the given position in the same
+// order as the keys. Keys, that did not exist at the position, have the value
+// nil. The values are not cached.
+func (d *Datastore) GetAt(ctx context.Context, position int, keys ...string) ([]json.RawMessage, error) {
+	request := struct {
+		Requests []string `json:"requests"`
+		Position int      `json:"position"`
+	}{keys, position}
+
+	var response json.RawMessage
+	if err := d.post(ctx, urlPath, request, &response); err != nil {
+		return nil, fmt.Errorf("requesting keys at position %d: %w", position, err)
+	}
+
+	data, err := getManyResponceToKeyValue(bytes.NewReader(response), len(keys))
+	if err != nil {
+		return nil, fmt.Errorf("parse responce: %w", err)
+	}
+
+	values := make([]json.RawMessage, len(keys))
+	for i, key := range keys {
+		values[i] = data[key]
+	}
+	return values, nil
+}
+
+// post sends the request as json to the given path of the datastore reader and
+// decodes the responce into v.
+func (d *Datastore) post(ctx context.Context, path string, request, v interface{}) error {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return fmt.Errorf("encoding request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", d.baseURL+path, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("creating request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	trace.SetHeader(ctx, req.Header)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("sending request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return fmt.Errorf("datastore returned status %s", resp.Status)
+		}
+		return fmt.Errorf("datastore returned status %s: %s", resp.Status, body)
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("decoding responce: %w", err)
+	}
+	return nil
+}
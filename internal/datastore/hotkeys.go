@@ -0,0 +1,260 @@
+package datastore
+
+import (
+	"container/heap"
+	"encoding/json"
+	"hash/maphash"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/openslides/openslides-autoupdate-service/internal/clock"
+)
+
+const (
+	// hotKeyShards is the number of independent counters for each kind of
+	// hot keys. Each shard has its own lock, so parallel requests do not wait
+	// for each other.
+	hotKeyShards = 16
+
+	// hotKeyShardSize is the number of keys, that are counted by one shard.
+	hotKeyShardSize = 256
+
+	// hotKeyHalfLife is the time after which all counters are halved. So keys,
+	// that were hot a long time ago, are replaced by the keys that are hot
+	// now.
+	hotKeyHalfLife = 10 * time.Minute
+)
+
+// KeyCount is a key with a counter.
+type KeyCount struct {
+	Key   string `json:"key"`
+	Count uint64 `json:"count"`
+}
+
+// HotKeys are the keys that are requested or changed most often.
+type HotKeys struct {
+	Requested []KeyCount `json:"requested"`
+	Changed   []KeyCount `json:"changed"`
+}
+
+// hotKeys counts how often the keys are requested and changed.
+//
+// A nil hotKeys does nothing.
+type hotKeys struct {
+	clock     clock.Clock
+	requested *keyCounter
+	changed   *keyCounter
+}
+
+func newHotKeys(c clock.Clock) *hotKeys {
+	seed := maphash.MakeSeed()
+	return &hotKeys{
+		clock:     c,
+		requested: newKeyCounter(seed, c.Now()),
+		changed:   newKeyCounter(seed, c.Now()),
+	}
+}
+
+// request counts the given keys as requested.
+func (h *hotKeys) request(keys []string) {
+	if h == nil {
+		return
+	}
+
+	now := h.clock.Now()
+	for _, key := range keys {
+		h.requested.add(key, now)
+	}
+}
+
+// change counts the keys of the given map as changed.
+func (h *hotKeys) change(data map[string]json.RawMessage) {
+	if h == nil {
+		return
+	}
+
+	now := h.clock.Now()
+	for key := range data {
+		h.changed.add(key, now)
+	}
+}
+
+// top returns the n most requested and the n most changed keys.
+func (h *hotKeys) top(n int) HotKeys {
+	if h == nil {
+		return HotKeys{}
+	}
+
+	now := h.clock.Now()
+	return HotKeys{
+		Requested: topCounts(h.requested.counts(now), n),
+		Changed:   topCounts(h.changed.counts(now), n),
+	}
+}
+
+// requestPriorities returns the counter of each requested key, that is
+// currently tracked. Returns nil, if h is nil.
+func (h *hotKeys) requestPriorities() map[string]uint64 {
+	if h == nil {
+		return nil
+	}
+
+	counts := h.requested.counts(h.clock.Now())
+	priorities := make(map[string]uint64, len(counts))
+	for _, kc := range counts {
+		priorities[kc.Key] = kc.Count
+	}
+	return priorities
+}
+
+// topCounts returns the n keys with the highest count. Keys with the same
+// count are sorted by name.
+func topCounts(all []KeyCount, n int) []KeyCount {
+	sort.Slice(all, func(i, j int) bool {
+		if all[i].Count != all[j].Count {
+			return all[i].Count > all[j].Count
+		}
+		return all[i].Key < all[j].Key
+	})
+
+	if len(all) > n {
+		all = all[:n]
+	}
+	return all
+}
+
+// keyCounter approximates the most frequent keys with a fixed amount of
+// memory.
+//
+// The keys are distributed to shards by their hash. Each shard is a
+// space-saving sketch: It counts at most hotKeyShardSize keys. A new key
+// replaces the key with the lowest count and inherits its count. So a count
+// can be too high, but a key, that is more frequent then the replaced keys, is
+// always tracked.
+type keyCounter struct {
+	seed   maphash.Seed
+	shards [hotKeyShards]keySketch
+}
+
+func newKeyCounter(seed maphash.Seed, now time.Time) *keyCounter {
+	kc := &keyCounter{seed: seed}
+	for i := range kc.shards {
+		kc.shards[i].index = make(map[string]int)
+		kc.shards[i].decayed = now
+	}
+	return kc
+}
+
+// add counts the key once.
+func (kc *keyCounter) add(key string, now time.Time) {
+	kc.shards[maphash.String(kc.seed, key)%hotKeyShards].add(key, now)
+}
+
+// counts returns all tracked keys with their counts in no special order.
+func (kc *keyCounter) counts(now time.Time) []KeyCount {
+	all := make([]KeyCount, 0, hotKeyShards*hotKeyShardSize)
+	for i := range kc.shards {
+		all = kc.shards[i].appendCounts(all, now)
+	}
+	return all
+}
+
+// keySketch is one shard of a keyCounter.
+//
+// The entries are a min-heap ordered by their count. index is the position of
+// each key in entries.
+type keySketch struct {
+	mu      sync.Mutex
+	entries []KeyCount
+	index   map[string]int
+	decayed time.Time
+}
+
+// add counts the key once.
+func (s *keySketch) add(key string, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.decay(now)
+
+	if i, ok := s.index[key]; ok {
+		s.entries[i].Count++
+		heap.Fix(s, i)
+		return
+	}
+
+	if len(s.entries) < hotKeyShardSize {
+		heap.Push(s, KeyCount{Key: key, Count: 1})
+		return
+	}
+
+	// Replace the key with the lowest count.
+	delete(s.index, s.entries[0].Key)
+	s.entries[0].Key = key
+	s.entries[0].Count++
+	s.index[key] = 0
+	heap.Fix(s, 0)
+}
+
+// decay halves all counts for each hotKeyHalfLife since the last decay. Keys
+// with a count of 0 are removed.
+//
+// The sketch has to be locked to call this method.
+func (s *keySketch) decay(now time.Time) {
+	periods := now.Sub(s.decayed) / hotKeyHalfLife
+	if periods <= 0 {
+		return
+	}
+	s.decayed = s.decayed.Add(periods * hotKeyHalfLife)
+
+	shift := min(uint(periods), 63)
+	for i := range s.entries {
+		s.entries[i].Count >>= shift
+	}
+
+	// Halving keeps the order of the heap. So the keys with a count of 0 are
+	// at the top.
+	for len(s.entries) > 0 && s.entries[0].Count == 0 {
+		heap.Pop(s)
+	}
+}
+
+// appendCounts appends the decayed counts of the sketch to all.
+func (s *keySketch) appendCounts(all []KeyCount, now time.Time) []KeyCount {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.decay(now)
+	return append(all, s.entries...)
+}
+
+// Len, Less, Swap, Push and Pop implement heap.Interface. They are only
+// called, when the sketch is locked.
+
+func (s *keySketch) Len() int {
+	return len(s.entries)
+}
+
+func (s *keySketch) Less(i, j int) bool {
+	return s.entries[i].Count < s.entries[j].Count
+}
+
+func (s *keySketch) Swap(i, j int) {
+	s.entries[i], s.entries[j] = s.entries[j], s.entries[i]
+	s.index[s.entries[i].Key] = i
+	s.index[s.entries[j].Key] = j
+}
+
+func (s *keySketch) Push(x any) {
+	kc := x.(KeyCount)
+	s.index[kc.Key] = len(s.entries)
+	s.entries = append(s.entries, kc)
+}
+
+func (s *keySketch) Pop() any {
+	last := s.entries[len(s.entries)-1]
+	s.entries = s.entries[:len(s.entries)-1]
+	delete(s.index, last.Key)
+	return last
+}
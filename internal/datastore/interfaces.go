@@ -7,3 +7,9 @@ import "encoding/json"
 type Updater interface {
 	Update() (map[string]json.RawMessage, error)
 }
+
+// Pinger is an optional interface of an Updater. If the Updater implements it,
+// the datastore is only ready after Ping returned without an error.
+type Pinger interface {
+	Ping() error
+}
@@ -0,0 +1,97 @@
+package datastore
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// WithRequestTimeout sets a timeout for the requests to the datastore. The keys
+// are requested with one request per collection. If the request for a
+// collection does not return in time, the values of the other collections are
+// returned together with an error of type PendingError. Per default, there is
+// no timeout and all keys are requested together.
+func WithRequestTimeout(timeout time.Duration) Option {
+	return func(d *Datastore) {
+		d.requestTimeout = timeout
+	}
+}
+
+// PendingError is returned by Get, when some keys could not be read in time.
+// The values of all other keys are returned together with the error.
+type PendingError struct {
+	keys []string
+}
+
+func (e PendingError) Error() string {
+	return fmt.Sprintf("%d keys could not be read in time", len(e.keys))
+}
+
+// PendingKeys returns the sorted keys, that could not be read in time.
+func (e PendingError) PendingKeys() []string {
+	return e.keys
+}
+
+// requestKeysWithTimeout requests the keys with one request per collection.
+// The requests for collections that do not return in time are ignored and
+// there keys are returned with an PendingError. All other errors are returned
+// without data.
+func (d *Datastore) requestKeysWithTimeout(ctx context.Context, keys []string) (map[string]json.RawMessage, error) {
+	shards := make(map[string][]string)
+	for _, key := range keys {
+		collection := key
+		if i := strings.IndexByte(key, '/'); i >= 0 {
+			collection = key[:i]
+		}
+		shards[collection] = append(shards[collection], key)
+	}
+
+	type result struct {
+		keys []string
+		data map[string]json.RawMessage
+		err  error
+	}
+
+	results := make(chan result, len(shards))
+	var wg sync.WaitGroup
+	for _, shardKeys := range shards {
+		wg.Add(1)
+		go func(shardKeys []string) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(ctx, d.requestTimeout)
+			defer cancel()
+
+			data, err := d.requestKeys(ctx, shardKeys)
+			results <- result{keys: shardKeys, data: data, err: err}
+		}(shardKeys)
+	}
+	wg.Wait()
+	close(results)
+
+	data := make(map[string]json.RawMessage, len(keys))
+	var pending []string
+	for r := range results {
+		if r.err != nil {
+			if !errors.Is(r.err, context.DeadlineExceeded) {
+				return nil, r.err
+			}
+			pending = append(pending, r.keys...)
+			continue
+		}
+
+		for k, v := range r.data {
+			data[k] = v
+		}
+	}
+
+	if len(pending) > 0 {
+		sort.Strings(pending)
+		return data, PendingError{keys: pending}
+	}
+	return data, nil
+}
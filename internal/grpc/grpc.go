@@ -0,0 +1,130 @@
+// Package grpc implements the internal grpc api of the autoupdate service,
+// that is defined in proto/autoupdate.proto.
+//
+// The api has no authentication. The user id is sent by the calling service,
+// so the server must only be reachable by other services.
+package grpc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/openslides/openslides-autoupdate-service/internal/autoupdate"
+	"github.com/openslides/openslides-autoupdate-service/internal/keysbuilder"
+	"github.com/openslides/openslides-autoupdate-service/internal/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Server implements the rpcs of the service Autoupdate.
+type Server struct {
+	proto.UnimplementedAutoupdateServer
+
+	s         *autoupdate.Autoupdate
+	kbOptions []keysbuilder.Option
+}
+
+// New creates a Server. The options are used for each keysbuilder request of
+// Subscribe.
+func New(s *autoupdate.Autoupdate, kbOptions ...keysbuilder.Option) *Server {
+	return &Server{s: s, kbOptions: kbOptions}
+}
+
+// GetRestricted returns the restricted values of the keys.
+func (srv *Server) GetRestricted(ctx context.Context, req *proto.GetRestrictedRequest) (*proto.Data, error) {
+	if len(req.GetKeys()) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "no keys")
+	}
+
+	data, err := srv.s.RestrictedData(ctx, int(req.GetUserId()), req.GetKeys()...)
+	if err != nil {
+		return nil, toStatus(fmt.Errorf("get restricted data: %w", err))
+	}
+	return toData(data), nil
+}
+
+// Subscribe sends the data of the keysbuilder request and afterwards the
+// changed data on each update. It returns, when the client closes the stream
+// or the service shuts down.
+func (srv *Server) Subscribe(req *proto.SubscribeRequest, stream proto.Autoupdate_SubscribeServer) error {
+	ctx := stream.Context()
+	uid := int(req.GetUserId())
+
+	kb, err := keysbuilder.ManyFromJSON(ctx, bytes.NewReader(req.GetRequest()), srv.s, uid, srv.kbOptions...)
+	if err != nil {
+		return toStatus(fmt.Errorf("build keysbuilder: %w", err))
+	}
+
+	conn := srv.s.Connect(uid, kb, 0)
+	defer conn.Close()
+	for {
+		data, err := conn.Next(ctx)
+		if err != nil {
+			return toStatus(fmt.Errorf("read data: %w", err))
+		}
+		if data == nil {
+			// The service shuts down.
+			return nil
+		}
+
+		if err := stream.Send(toData(data)); err != nil {
+			return fmt.Errorf("sending data: %w", err)
+		}
+	}
+}
+
+// Serve starts the grpc server on addr. It blocks until closed is closed or
+// the server fails.
+func Serve(closed <-chan struct{}, addr string, srv *Server) error {
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("listen on %s: %w", addr, err)
+	}
+
+	gs := grpc.NewServer()
+	proto.RegisterAutoupdateServer(gs, srv)
+
+	go func() {
+		<-closed
+		gs.GracefulStop()
+	}()
+
+	if err := gs.Serve(lis); err != nil {
+		return fmt.Errorf("serve grpc: %w", err)
+	}
+	return nil
+}
+
+// toData converts a map of restricted values. Keys without a value get an
+// empty value.
+func toData(data map[string]json.RawMessage) *proto.Data {
+	values := make(map[string][]byte, len(data))
+	for k, v := range data {
+		values[k] = v
+	}
+	return &proto.Data{Values: values}
+}
+
+// toStatus converts an error to a grpc status. Errors of an invalid request
+// have a type like the errors of the http handler.
+func toStatus(err error) error {
+	var invalid interface {
+		Type() string
+	}
+
+	switch {
+	case errors.Is(err, context.Canceled):
+		return status.Error(codes.Canceled, err.Error())
+	case errors.Is(err, context.DeadlineExceeded):
+		return status.Error(codes.DeadlineExceeded, err.Error())
+	case errors.As(err, &invalid):
+		return status.Error(codes.InvalidArgument, err.Error())
+	default:
+		return status.Error(codes.Internal, err.Error())
+	}
+}
@@ -0,0 +1,111 @@
+package grpc_test
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"testing"
+
+	"github.com/openslides/openslides-autoupdate-service/internal/autoupdate"
+	autoupdateGrpc "github.com/openslides/openslides-autoupdate-service/internal/grpc"
+	"github.com/openslides/openslides-autoupdate-service/internal/proto"
+	"github.com/openslides/openslides-autoupdate-service/internal/test"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func getClient(t *testing.T, closed <-chan struct{}) (proto.AutoupdateClient, *test.MockDatastore) {
+	t.Helper()
+
+	datastore := test.NewMockDatastore()
+	s := autoupdate.New(datastore, new(test.MockRestricter), closed)
+
+	lis := bufconn.Listen(1 << 20)
+	gs := grpc.NewServer()
+	proto.RegisterAutoupdateServer(gs, autoupdateGrpc.New(s))
+	go gs.Serve(lis)
+	t.Cleanup(gs.Stop)
+
+	conn, err := grpc.NewClient(
+		"passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatalf("Can not create client: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	return proto.NewAutoupdateClient(conn), datastore
+}
+
+func TestGetRestricted(t *testing.T) {
+	closed := make(chan struct{})
+	defer close(closed)
+	client, _ := getClient(t, closed)
+
+	data, err := client.GetRestricted(context.Background(), &proto.GetRestrictedRequest{UserId: 1, Keys: []string{"user/1/name", "user/1/note_id"}})
+	if err != nil {
+		t.Fatalf("GetRestricted returned unexpected error: %v", err)
+	}
+
+	if got := string(data.GetValues()["user/1/note_id"]); got != "1" {
+		t.Errorf("Got value %q for user/1/note_id, expected 1", got)
+	}
+	if _, ok := data.GetValues()["user/1/name"]; !ok {
+		t.Errorf("Value for user/1/name is missing")
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	closed := make(chan struct{})
+	defer close(closed)
+	client, datastore := getClient(t, closed)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	request := `[{"ids":[1],"collection":"user","fields":{"name":null}}]`
+	stream, err := client.Subscribe(ctx, &proto.SubscribeRequest{UserId: 1, Request: []byte(request)})
+	if err != nil {
+		t.Fatalf("Subscribe returned unexpected error: %v", err)
+	}
+
+	first, err := stream.Recv()
+	if err != nil {
+		t.Fatalf("Receiving first data: %v", err)
+	}
+	if _, ok := first.GetValues()["user/1/name"]; !ok {
+		t.Errorf("First data %v does not contain user/1/name", first.GetValues())
+	}
+
+	datastore.Update(map[string]json.RawMessage{"user/1/name": []byte(`"new value"`)})
+	datastore.Send(test.Str("user/1/name"))
+
+	second, err := stream.Recv()
+	if err != nil {
+		t.Fatalf("Receiving second data: %v", err)
+	}
+	if got := string(second.GetValues()["user/1/name"]); got != `"new value"` {
+		t.Errorf("Got %s for user/1/name, expected \"new value\"", got)
+	}
+}
+
+func TestSubscribeInvalid(t *testing.T) {
+	closed := make(chan struct{})
+	defer close(closed)
+	client, _ := getClient(t, closed)
+
+	stream, err := client.Subscribe(context.Background(), &proto.SubscribeRequest{UserId: 1, Request: []byte(`[{"ids":[1]}]`)})
+	if err != nil {
+		t.Fatalf("Subscribe returned unexpected error: %v", err)
+	}
+
+	_, err = stream.Recv()
+	if got := status.Code(err); got != codes.InvalidArgument {
+		t.Errorf("Got code %s, expected %s", got, codes.InvalidArgument)
+	}
+}
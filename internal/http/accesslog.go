@@ -0,0 +1,193 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/openslides/openslides-autoupdate-service/internal/trace"
+)
+
+// The reasons, why an autoupdate connection was closed.
+const (
+	// closeDone means, that the handler finished without an error, for
+	// example with the capability single.
+	closeDone = "done"
+
+	// closeClient means, that the client closed the connection.
+	closeClient = "client"
+
+	// closeServer means, that the connection was closed by the service, for
+	// example by the admin handler or a missing acknowledgment.
+	closeServer = "closed"
+
+	// closeShutdown means, that the service was shut down.
+	closeShutdown = "shutdown"
+
+	// closeReconnect means, that the client was asked to reconnect to another
+	// instance.
+	closeReconnect = "reconnect"
+
+	// closeInvalid means, that the request of the client was invalid.
+	closeInvalid = "invalid"
+
+	// closeRejected means, that the request was rejected before the
+	// connection was opened, for example by a connection limit.
+	closeRejected = "rejected"
+
+	// closeError means, that the connection was closed because of an
+	// internal error.
+	closeError = "error"
+)
+
+// accessLog writes one json line for each autoupdate connection, after the
+// connection was closed.
+type accessLog struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// accessRecord is the log line of one connection. The handler fills it, while
+// the connection is open. All methods can be called on a nil accessRecord.
+type accessRecord struct {
+	Time       time.Time `json:"time"`
+	RequestID  string    `json:"request_id,omitempty"`
+	Method     string    `json:"method"`
+	Path       string    `json:"path"`
+	RemoteAddr string    `json:"remote_addr"`
+	Status     int       `json:"status"`
+	UserID     int       `json:"user_id"`
+	Duration   float64   `json:"duration_seconds"`
+	Messages   uint64    `json:"messages"`
+	Bytes      uint64    `json:"bytes"`
+	Reason     string    `json:"close_reason"`
+	Error      string    `json:"error,omitempty"`
+
+	info *connectionInfo
+}
+
+type accessRecordKey struct{}
+
+// accessRecordFromContext returns the record of the request or nil, if the
+// access log is not enabled.
+func accessRecordFromContext(ctx context.Context) *accessRecord {
+	record, _ := ctx.Value(accessRecordKey{}).(*accessRecord)
+	return record
+}
+
+// connected saves the user and the connection, after it was opened.
+func (a *accessRecord) connected(uid int, info *connectionInfo) {
+	if a == nil {
+		return
+	}
+	a.UserID = uid
+	a.info = info
+}
+
+// sent counts a message, that was sent to the client.
+func (a *accessRecord) sent() {
+	if a == nil {
+		return
+	}
+	a.Messages++
+}
+
+// closing sets the reason, why the connection is closed.
+func (a *accessRecord) closing(reason string) {
+	if a == nil {
+		return
+	}
+	a.Reason = reason
+}
+
+// finish sets the close reason from the error of the connection, if it is
+// not already set. ctx is the context of the request.
+func (a *accessRecord) finish(ctx context.Context, err error) {
+	if a == nil || a.Reason != "" {
+		return
+	}
+
+	var closing interface {
+		Closing()
+	}
+	var derr DefinedError
+
+	switch {
+	case err == nil:
+		a.Reason = closeDone
+	case ctx.Err() != nil:
+		a.Reason = closeClient
+	case errors.As(err, &closing):
+		a.Reason = closeShutdown
+	case errors.Is(err, context.Canceled):
+		a.Reason = closeServer
+	case errors.As(err, &derr):
+		a.Reason = closeInvalid
+		a.Error = err.Error()
+	default:
+		a.Reason = closeError
+		a.Error = err.Error()
+	}
+}
+
+// middleware writes the access log line of each request to the next handler.
+// Requests, that are rejected by another middleware, get the close reason
+// rejected.
+func (l *accessLog) middleware(h http.Handler) http.Handler {
+	if l == nil {
+		return h
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		record := &accessRecord{
+			Time:       time.Now(),
+			Method:     r.Method,
+			Path:       r.URL.Path,
+			RemoteAddr: r.RemoteAddr,
+		}
+		if info, ok := trace.FromContext(r.Context()); ok {
+			record.RequestID = info.RequestID
+		}
+
+		rec := &metricsRecorder{ResponseWriter: w, code: http.StatusOK}
+		h.ServeHTTP(rec, r.WithContext(context.WithValue(r.Context(), accessRecordKey{}, record)))
+
+		record.Status = rec.code
+		record.Duration = time.Since(record.Time).Seconds()
+		if record.info != nil {
+			record.Bytes = atomic.LoadUint64(&record.info.bytes)
+		}
+		if record.Reason == "" {
+			record.Reason = closeDone
+			if rec.code >= 400 {
+				record.Reason = closeRejected
+			}
+		}
+
+		if err := l.write(record); err != nil {
+			log.Printf("Error: %v", err)
+		}
+	})
+}
+
+// write writes one record as json line.
+func (l *accessLog) write(record *accessRecord) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("encoding access log: %w", err)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, err := l.w.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("writing access log: %w", err)
+	}
+	return nil
+}
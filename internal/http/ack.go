@@ -0,0 +1,75 @@
+package http
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// ConnectionHeader is the http header, where the server sends the id of a
+// connection with the capability ack. The client uses the id to acknowledge,
+// that it is still alive.
+const ConnectionHeader = "X-Autoupdate-Connection"
+
+// defaultAckInterval is the interval of the capability ack, if the client does
+// not send one.
+const defaultAckInterval = 10 * time.Second
+
+// missedAcks is the number of intervals without an acknowledgment, after which
+// a connection is closed.
+const missedAcks = 2
+
+// watchAcks closes a connection with cancel, if the client did not send an
+// acknowledgment for missedAcks intervals. Blocks until the context is done.
+//
+// Without acknowledgments, a dead client is only noticed, when a write to the
+// connection times out. Until then, the connection holds its keys and its
+// data.
+func watchAcks(ctx context.Context, info *connectionInfo, interval time.Duration, cancel context.CancelFunc) {
+	atomic.StoreInt64(&info.lastAck, time.Now().UnixNano())
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			lastAck := time.Unix(0, atomic.LoadInt64(&info.lastAck))
+			if now.Sub(lastAck) > missedAcks*interval {
+				cancel()
+				return
+			}
+		}
+	}
+}
+
+// ack saves an acknowledgment of a client for one of its connections. The id
+// of the connection is the url argument connection.
+func (h *Handler) ack(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, errTypeInvalidRequest, "Only POST requests are supported")
+		return nil
+	}
+
+	uid, err := h.auth.Authenticate(r.Context(), r)
+	if err != nil {
+		return fmt.Errorf("authenticate request: %w", err)
+	}
+
+	id, err := strconv.ParseUint(r.URL.Query().Get("connection"), 10, 64)
+	if err != nil {
+		return invalidRequestError{fmt.Errorf("invalid connection: %w", err)}
+	}
+
+	if !h.registry.ack(id, uid, time.Now()) {
+		return invalidRequestError{fmt.Errorf("unknown connection %d", id)}
+	}
+
+	fmt.Fprintln(w, `{"ack": true}`)
+	return nil
+}
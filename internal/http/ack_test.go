@@ -0,0 +1,62 @@
+package http
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestWatchAcksClosesDeadConnection(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	done := make(chan struct{})
+	go func() {
+		watchAcks(ctx, new(connectionInfo), 10*time.Millisecond, cancel)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("watchAcks did not close the connection without acks")
+	}
+
+	if ctx.Err() == nil {
+		t.Errorf("Context was not canceled")
+	}
+}
+
+func TestWatchAcksKeepsAliveConnection(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var r registry
+	info := &connectionInfo{uid: 1, cancel: cancel}
+	id := r.add(info)
+
+	go watchAcks(ctx, info, 50*time.Millisecond, cancel)
+
+	for i := 0; i < 20; i++ {
+		time.Sleep(5 * time.Millisecond)
+		if !r.ack(id, 1, time.Now()) {
+			t.Fatalf("ack returned false for an existing connection")
+		}
+	}
+
+	if ctx.Err() != nil {
+		t.Errorf("Connection was closed, although the client sent acks")
+	}
+}
+
+func TestRegistryAckOtherUser(t *testing.T) {
+	var r registry
+	id := r.add(&connectionInfo{uid: 1})
+
+	if r.ack(id, 2, time.Now()) {
+		t.Errorf("ack returned true for the connection of another user")
+	}
+	if r.ack(id+1, 1, time.Now()) {
+		t.Errorf("ack returned true for an unknown connection")
+	}
+}
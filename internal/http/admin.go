@@ -0,0 +1,157 @@
+package http
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// AdminHandler returns a handler for administrative tasks. It is meant to be
+// served on an internal address. Each request needs the header
+// `Authorization: Bearer <token>`.
+//
+// POST /disconnect?user_id=5 closes all connections of the user. The client
+// has to reconnect and is authenticated again. Instead of the user, the
+// connections can be selected with session_id or meeting_id. Several arguments
+// select the connections, that match all of them. POST
+// /disconnect?connection_id=3 only closes one connection.
+//
+// GET /connections lists all open connections. With ?user_id=5 only the
+// connections of the user are listed. The arguments session_id and meeting_id
+// can be used in the same way.
+//
+// GET /bandwidth returns the sent bytes in total, per user and per meeting.
+//
+// GET /presets lists the names of the keysbuilder presets. POST /presets
+// replaces all presets with the definition in the request body.
+//
+// POST /restrictions replaces the restriction definition with the request
+// body. See WithRestrictionReload.
+func (h *Handler) AdminHandler(token string) http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/disconnect", errHandleFunc(h.adminDisconnect))
+	mux.Handle("/connections", errHandleFunc(h.adminConnections))
+	mux.Handle("/bandwidth", errHandleFunc(h.adminBandwidth))
+	mux.Handle("/presets", errHandleFunc(h.adminPresets))
+	mux.Handle("/restrictions", errHandleFunc(h.adminRestrictions))
+	return requireToken(token, mux)
+}
+
+// adminConnections returns the open connections as json.
+func (h *Handler) adminConnections(w http.ResponseWriter, r *http.Request) error {
+	filter, err := parseConnectionFilter(r.URL.Query())
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(h.registry.list(filter)); err != nil {
+		return fmt.Errorf("encoding connections: %w", err)
+	}
+	return nil
+}
+
+// adminBandwidth returns the sent bytes as json.
+func (h *Handler) adminBandwidth(w http.ResponseWriter, r *http.Request) error {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(h.bandwidth.usage()); err != nil {
+		return fmt.Errorf("encoding bandwidth: %w", err)
+	}
+	return nil
+}
+
+// adminDisconnect closes all connections of a user or one connection.
+func (h *Handler) adminDisconnect(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Only POST requests are supported", http.StatusMethodNotAllowed)
+		return nil
+	}
+
+	if v := r.URL.Query().Get("connection_id"); v != "" {
+		id, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			return invalidRequestError{fmt.Errorf("invalid connection_id: %w", err)}
+		}
+
+		var closed int
+		if h.registry.closeConnection(id) {
+			closed = 1
+		}
+		fmt.Fprintf(w, `{"closed": %d}`+"\n", closed)
+		return nil
+	}
+
+	filter, err := parseConnectionFilter(r.URL.Query())
+	if err != nil {
+		return err
+	}
+
+	if filter.empty() {
+		return invalidRequestError{fmt.Errorf("one of connection_id, user_id, session_id or meeting_id is needed")}
+	}
+
+	closed := h.registry.closeMatching(filter)
+	fmt.Fprintf(w, `{"closed": %d}`+"\n", closed)
+	return nil
+}
+
+// parseConnectionFilter reads the url arguments user_id, session_id and
+// meeting_id. They are used to disconnect and to list connections.
+func parseConnectionFilter(query url.Values) (connectionFilter, error) {
+	var filter connectionFilter
+	if v := query.Get("user_id"); v != "" {
+		uid, err := strconv.Atoi(v)
+		if err != nil || uid <= 0 {
+			return filter, invalidRequestError{fmt.Errorf("invalid user_id: %q", v)}
+		}
+		filter.uid = uid
+	}
+
+	filter.session = query.Get("session_id")
+
+	if v := query.Get("meeting_id"); v != "" {
+		meeting, err := strconv.Atoi(v)
+		if err != nil || meeting <= 0 {
+			return filter, invalidRequestError{fmt.Errorf("invalid meeting_id: %q", v)}
+		}
+		filter.meeting = meeting
+	}
+	return filter, nil
+}
+
+// adminRestrictions replaces the restriction definition with the request body.
+// Open connections use the new definition for the next message.
+func (h *Handler) adminRestrictions(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Only POST requests are supported", http.StatusMethodNotAllowed)
+		return nil
+	}
+
+	if h.reloadRestrictions == nil {
+		http.Error(w, "Reloading the restrictions is not supported", http.StatusNotFound)
+		return nil
+	}
+
+	if err := h.reloadRestrictions(r.Body); err != nil {
+		return invalidRequestError{fmt.Errorf("invalid restriction definition: %w", err)}
+	}
+	fmt.Fprintln(w, `{"reloaded": true}`)
+	return nil
+}
+
+// requireToken only calls the handler, if the request has the given bearer
+// token.
+func requireToken(token string, h http.Handler) http.Handler {
+	expected := []byte("Bearer " + token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got := []byte(r.Header.Get("Authorization"))
+		if token == "" || subtle.ConstantTimeCompare(got, expected) != 1 {
+			http.Error(w, "Invalid token", http.StatusUnauthorized)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
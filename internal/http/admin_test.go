@@ -0,0 +1,356 @@
+package http_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/openslides/openslides-autoupdate-service/internal/autoupdate"
+	ahttp "github.com/openslides/openslides-autoupdate-service/internal/http"
+	"github.com/openslides/openslides-autoupdate-service/internal/test"
+)
+
+func TestAdminDisconnect(t *testing.T) {
+	closed := make(chan struct{})
+	defer close(closed)
+	s := autoupdate.New(new(test.MockDatastore), new(test.MockRestricter), closed)
+	handler := ahttp.New(s, &test.MockAuth{Default: 1})
+	srv := httptest.NewUnstartedServer(handler)
+	srv.EnableHTTP2 = true
+	srv.StartTLS()
+	defer srv.Close()
+
+	admin := httptest.NewServer(handler.AdminHandler("secret"))
+	defer admin.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/system/autoupdate/keys?user/1/name", nil)
+	if err != nil {
+		t.Fatalf("Can not create request: %v", err)
+	}
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatalf("Can not send request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body := bufio.NewReader(resp.Body)
+	if _, err := body.ReadBytes('\n'); err != nil {
+		t.Fatalf("Can not read first message: %v", err)
+	}
+
+	for _, tt := range []struct {
+		name   string
+		token  string
+		status int
+	}{
+		{"wrong token", "wrong", http.StatusUnauthorized},
+		{"correct token", "secret", http.StatusOK},
+	} {
+		req, err := http.NewRequest(http.MethodPost, admin.URL+"/disconnect?user_id=1", nil)
+		if err != nil {
+			t.Fatalf("Can not create request: %v", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+tt.token)
+		adminResp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Can not send admin request: %v", err)
+		}
+		adminResp.Body.Close()
+
+		if adminResp.StatusCode != tt.status {
+			t.Errorf("%s: got status %s, expected %d", tt.name, adminResp.Status, tt.status)
+		}
+	}
+
+	if _, err := body.ReadBytes('\n'); err != io.EOF {
+		t.Errorf("Connection was not closed, got error %v, expected EOF", err)
+	}
+}
+
+func TestAdminDisconnectConnection(t *testing.T) {
+	closed := make(chan struct{})
+	defer close(closed)
+	s := autoupdate.New(new(test.MockDatastore), new(test.MockRestricter), closed)
+	handler := ahttp.New(s, &test.MockAuth{Default: 1})
+	srv := httptest.NewUnstartedServer(handler)
+	srv.EnableHTTP2 = true
+	srv.StartTLS()
+	defer srv.Close()
+
+	admin := httptest.NewServer(handler.AdminHandler("secret"))
+	defer admin.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/system/autoupdate/keys?user/1/name", nil)
+	if err != nil {
+		t.Fatalf("Can not create request: %v", err)
+	}
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatalf("Can not send request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body := bufio.NewReader(resp.Body)
+	if _, err := body.ReadBytes('\n'); err != nil {
+		t.Fatalf("Can not read first message: %v", err)
+	}
+
+	adminRequest := func(method, url string) []byte {
+		req, err := http.NewRequest(method, admin.URL+url, nil)
+		if err != nil {
+			t.Fatalf("Can not create request: %v", err)
+		}
+		req.Header.Set("Authorization", "Bearer secret")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Can not send admin request: %v", err)
+		}
+		defer resp.Body.Close()
+
+		data, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatalf("Can not read admin response: %v", err)
+		}
+		return data
+	}
+
+	var conns []ahttp.ConnectionInfo
+	if err := json.Unmarshal(adminRequest(http.MethodGet, "/connections"), &conns); err != nil {
+		t.Fatalf("Can not decode connections: %v", err)
+	}
+	if len(conns) != 1 {
+		t.Fatalf("Got %d connections, expected 1", len(conns))
+	}
+
+	if got := strings.TrimSpace(string(adminRequest(http.MethodPost, fmt.Sprintf("/disconnect?connection_id=%d", conns[0].ID+1)))); got != `{"closed": 0}` {
+		t.Errorf("Got `%s` for an unknown connection, expected `{\"closed\": 0}`", got)
+	}
+
+	if got := strings.TrimSpace(string(adminRequest(http.MethodPost, fmt.Sprintf("/disconnect?connection_id=%d", conns[0].ID)))); got != `{"closed": 1}` {
+		t.Errorf("Got `%s`, expected `{\"closed\": 1}`", got)
+	}
+
+	if _, err := body.ReadBytes('\n'); err != io.EOF {
+		t.Errorf("Connection was not closed, got error %v, expected EOF", err)
+	}
+}
+
+func TestAdminDisconnectFilter(t *testing.T) {
+	closed := make(chan struct{})
+	defer close(closed)
+	s := autoupdate.New(new(test.MockDatastore), new(test.MockRestricter), closed)
+	auth := new(test.MockAuth)
+	auth.AddSession("laptop", 1, "session-1")
+	auth.AddSession("projector", 1, "session-2")
+	handler := ahttp.New(s, auth)
+	srv := httptest.NewUnstartedServer(handler)
+	srv.EnableHTTP2 = true
+	srv.StartTLS()
+	defer srv.Close()
+
+	admin := httptest.NewServer(handler.AdminHandler("secret"))
+	defer admin.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	connect := func(token, keys string) *bufio.Reader {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/system/autoupdate/keys?"+keys, nil)
+		if err != nil {
+			t.Fatalf("Can not create request: %v", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := srv.Client().Do(req)
+		if err != nil {
+			t.Fatalf("Can not send request: %v", err)
+		}
+		t.Cleanup(func() { resp.Body.Close() })
+
+		body := bufio.NewReader(resp.Body)
+		if _, err := body.ReadBytes('\n'); err != nil {
+			t.Fatalf("Can not read first message: %v", err)
+		}
+		return body
+	}
+
+	disconnect := func(query string) string {
+		req, err := http.NewRequest(http.MethodPost, admin.URL+"/disconnect"+query, nil)
+		if err != nil {
+			t.Fatalf("Can not create request: %v", err)
+		}
+		req.Header.Set("Authorization", "Bearer secret")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Can not send admin request: %v", err)
+		}
+		defer resp.Body.Close()
+
+		data, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatalf("Can not read admin response: %v", err)
+		}
+		return strings.TrimSpace(string(data))
+	}
+
+	laptop := connect("laptop", "meeting/1/name")
+	projector := connect("projector", "meeting/2/name")
+
+	if got := disconnect("?session_id=session-1"); got != `{"closed": 1}` {
+		t.Errorf("Got `%s` for the session, expected `{\"closed\": 1}`", got)
+	}
+	if _, err := laptop.ReadBytes('\n'); err != io.EOF {
+		t.Errorf("Connection of the session was not closed, got error %v, expected EOF", err)
+	}
+
+	if got := disconnect("?meeting_id=2"); got != `{"closed": 1}` {
+		t.Errorf("Got `%s` for the meeting, expected `{\"closed\": 1}`", got)
+	}
+	if _, err := projector.ReadBytes('\n'); err != io.EOF {
+		t.Errorf("Connection of the meeting was not closed, got error %v, expected EOF", err)
+	}
+
+	if got := disconnect(""); !strings.Contains(got, "InvalidRequest") {
+		t.Errorf("Got `%s` without a filter, expected an invalid request", got)
+	}
+}
+
+func TestAdminConnections(t *testing.T) {
+	closed := make(chan struct{})
+	defer close(closed)
+	s := autoupdate.New(new(test.MockDatastore), new(test.MockRestricter), closed)
+	handler := ahttp.New(s, &test.MockAuth{Default: 1})
+	srv := httptest.NewUnstartedServer(handler)
+	srv.EnableHTTP2 = true
+	srv.StartTLS()
+	defer srv.Close()
+
+	admin := httptest.NewServer(handler.AdminHandler("secret"))
+	defer admin.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/system/autoupdate/keys?user/1/name,meeting/1/name", nil)
+	if err != nil {
+		t.Fatalf("Can not create request: %v", err)
+	}
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatalf("Can not send request: %v", err)
+	}
+	defer resp.Body.Close()
+	if _, err := bufio.NewReader(resp.Body).ReadBytes('\n'); err != nil {
+		t.Fatalf("Can not read first message: %v", err)
+	}
+
+	list := func(query string) []ahttp.ConnectionInfo {
+		req, err := http.NewRequest(http.MethodGet, admin.URL+"/connections"+query, nil)
+		if err != nil {
+			t.Fatalf("Can not create request: %v", err)
+		}
+		req.Header.Set("Authorization", "Bearer secret")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Can not send admin request: %v", err)
+		}
+		defer resp.Body.Close()
+
+		var conns []ahttp.ConnectionInfo
+		if err := json.NewDecoder(resp.Body).Decode(&conns); err != nil {
+			t.Fatalf("Can not decode connections: %v", err)
+		}
+		return conns
+	}
+
+	// The sent bytes are counted after the message was written.
+	var conns []ahttp.ConnectionInfo
+	for i := 0; i < 100; i++ {
+		conns = list("?user_id=1")
+		if len(conns) == 1 && conns[0].BytesSent > 0 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if len(conns) != 1 {
+		t.Fatalf("Got %d connections, expected 1", len(conns))
+	}
+	if c := conns[0]; c.UserID != 1 || c.Keys != 2 || c.Bodies != 1 || c.BytesSent == 0 || c.Age <= 0 {
+		t.Errorf("Got connection %+v, expected user 1 with 2 keys, 1 body, sent bytes and an age", c)
+	}
+
+	if conns := list("?user_id=2"); len(conns) != 0 {
+		t.Errorf("Got %d connections for user 2, expected 0", len(conns))
+	}
+
+	if conns := list("?meeting_id=1"); len(conns) != 1 || len(conns[0].Meetings) != 1 || conns[0].Meetings[0] != 1 {
+		t.Errorf("Got connections %+v for meeting 1, expected one connection of meeting 1", conns)
+	}
+
+	if conns := list("?meeting_id=2"); len(conns) != 0 {
+		t.Errorf("Got %d connections for meeting 2, expected 0", len(conns))
+	}
+}
+
+func TestAdminRestrictions(t *testing.T) {
+	closed := make(chan struct{})
+	defer close(closed)
+	s := autoupdate.New(new(test.MockDatastore), new(test.MockRestricter), closed)
+
+	var loaded string
+	reload := func(r io.Reader) error {
+		body, err := ioutil.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		if string(body) == "invalid" {
+			return errors.New("invalid definition")
+		}
+		loaded = string(body)
+		return nil
+	}
+	handler := ahttp.New(s, &test.MockAuth{Default: 1}, ahttp.WithRestrictionReload(reload))
+
+	admin := httptest.NewServer(handler.AdminHandler("secret"))
+	defer admin.Close()
+
+	for _, tt := range []struct {
+		name   string
+		body   string
+		status int
+		loaded string
+	}{
+		{"valid", `{"motion/tag_ids":"tag"}`, http.StatusOK, `{"motion/tag_ids":"tag"}`},
+		{"invalid", "invalid", http.StatusBadRequest, `{"motion/tag_ids":"tag"}`},
+	} {
+		req, err := http.NewRequest(http.MethodPost, admin.URL+"/restrictions", strings.NewReader(tt.body))
+		if err != nil {
+			t.Fatalf("Can not create request: %v", err)
+		}
+		req.Header.Set("Authorization", "Bearer secret")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Can not send admin request: %v", err)
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode != tt.status {
+			t.Errorf("%s: got status %s, expected %d", tt.name, resp.Status, tt.status)
+		}
+		if loaded != tt.loaded {
+			t.Errorf("%s: loaded definition `%s`, expected `%s`", tt.name, loaded, tt.loaded)
+		}
+	}
+}
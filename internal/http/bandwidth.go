@@ -0,0 +1,108 @@
+package http
+
+import (
+	"sync"
+	"time"
+)
+
+// Bandwidth is the number of bytes, that were sent in total, to each user and
+// to each meeting since the start of the service.
+type Bandwidth struct {
+	Total    uint64         `json:"total"`
+	Users    map[int]uint64 `json:"users"`
+	Meetings map[int]uint64 `json:"meetings"`
+}
+
+// bandwidth counts the sent bytes per user and per meeting.
+//
+// The meetings are the ids from connectionMeetings. They are derived by the
+// server and only contain existing meetings, so the number of counters is
+// bounded by the meetings in the datastore.
+//
+// If userLimit is not 0, it is a soft limit for the bytes per second of a
+// user. Connections of a user, that received more bytes, are throttled.
+type bandwidth struct {
+	userLimit int
+
+	mu       sync.Mutex
+	total    uint64
+	users    map[int]*userUsage
+	meetings map[int]uint64
+}
+
+// userUsage is the usage of one user.
+//
+// total is the number of bytes since the start. window are the bytes of the
+// current window, that started at windowStart. A window is at least one
+// second long.
+type userUsage struct {
+	total       uint64
+	windowStart time.Time
+	window      int
+}
+
+// sent counts bytes that were sent to a user. The bytes are counted for each
+// meeting of the connection. unknownMeeting is not counted.
+//
+// Returns the time, the next message to the user should be delayed to keep the
+// user below the limit. Users below the limit are not delayed.
+func (b *bandwidth) sent(uid int, meetings []int, bytes int, now time.Time) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.users == nil {
+		b.users = make(map[int]*userUsage)
+		b.meetings = make(map[int]uint64)
+	}
+
+	b.total += uint64(bytes)
+	for _, meeting := range meetings {
+		if meeting != unknownMeeting {
+			b.meetings[meeting] += uint64(bytes)
+		}
+	}
+
+	u := b.users[uid]
+	if u == nil {
+		u = &userUsage{windowStart: now}
+		b.users[uid] = u
+	}
+	u.total += uint64(bytes)
+
+	if b.userLimit <= 0 {
+		return 0
+	}
+
+	if now.Sub(u.windowStart) >= time.Second {
+		u.windowStart = now
+		u.window = 0
+	}
+	u.window += bytes
+
+	if u.window <= b.userLimit {
+		return 0
+	}
+
+	// With the limit, the bytes of the window need this time to be sent.
+	needed := time.Duration(float64(u.window) / float64(b.userLimit) * float64(time.Second))
+	return u.windowStart.Add(needed).Sub(now)
+}
+
+// usage returns a copy of the counted bytes.
+func (b *bandwidth) usage() Bandwidth {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	bw := Bandwidth{
+		Total:    b.total,
+		Users:    make(map[int]uint64, len(b.users)),
+		Meetings: make(map[int]uint64, len(b.meetings)),
+	}
+	for uid, u := range b.users {
+		bw.Users[uid] = u.total
+	}
+	for meeting, bytes := range b.meetings {
+		bw.Meetings[meeting] = bytes
+	}
+	return bw
+}
@@ -0,0 +1,48 @@
+package http
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBandwidthUsage(t *testing.T) {
+	var b bandwidth
+	now := time.Now()
+
+	b.sent(1, []int{5}, 100, now)
+	b.sent(1, []int{unknownMeeting}, 50, now)
+	b.sent(2, []int{5, 6}, 10, now)
+
+	got := b.usage()
+	if got.Total != 160 {
+		t.Errorf("Got total %d, expected 160", got.Total)
+	}
+	if got.Users[1] != 150 || got.Users[2] != 10 {
+		t.Errorf("Got users %v, expected 1:150 and 2:10", got.Users)
+	}
+	if len(got.Meetings) != 2 || got.Meetings[5] != 110 || got.Meetings[6] != 10 {
+		t.Errorf("Got meetings %v, expected 5:110 and 6:10", got.Meetings)
+	}
+}
+
+func TestBandwidthLimit(t *testing.T) {
+	b := bandwidth{userLimit: 100}
+	start := time.Now()
+
+	if delay := b.sent(1, nil, 50, start); delay != 0 {
+		t.Errorf("Got delay %v below the limit, expected 0", delay)
+	}
+
+	if delay := b.sent(1, nil, 150, start.Add(500*time.Millisecond)); delay != 1500*time.Millisecond {
+		t.Errorf("Got delay %v, expected 1.5s", delay)
+	}
+
+	if delay := b.sent(2, nil, 10, start); delay != 0 {
+		t.Errorf("Other user got delay %v, expected 0", delay)
+	}
+
+	// A new window starts after one second.
+	if delay := b.sent(1, nil, 150, start.Add(2*time.Second)); delay != 1500*time.Millisecond {
+		t.Errorf("Got delay %v in new window, expected 1.5s", delay)
+	}
+}
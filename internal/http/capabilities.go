@@ -0,0 +1,188 @@
+package http
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CapabilitiesHeader is the http header, where the client and the server agree
+// on the features of the protocol.
+//
+// The client sends a comma separated list of the features it supports. A
+// feature can have a parameter after a `=`. The server answers with the same
+// header containing the features, that are used for the connection. Unknown
+// features are ignored, so new clients can connect to old servers.
+const CapabilitiesHeader = "X-Autoupdate-Capabilities"
+
+// The known features of the protocol.
+const (
+	capNested   = "nested"
+	capGzip     = "gzip"
+	capDelta    = "delta"
+	capPosition = "position"
+	capErrors   = "errors"
+	capAck      = "ack"
+	capSingle   = "single"
+	capTyped    = "typed"
+)
+
+// capabilities are the features of the protocol that are used for one
+// connection.
+type capabilities struct {
+	nested   bool
+	gzip     bool
+	position bool
+	errors   bool
+
+	// delta is the threshold of the delta encoding. -1 means, that the delta
+	// encoding is not used.
+	delta int
+
+	// version is the version of the protocol. It is set by the url and not
+	// by the header.
+	version int
+
+	// sse is true, if the messages are sent as server-sent events. It is set
+	// by the Accept header and not by the CapabilitiesHeader.
+	sse bool
+
+	// ack is the interval, in which the client acknowledges, that it is still
+	// alive. 0 means, that the client does not send acks.
+	ack time.Duration
+
+	// single is true, if the connection is closed after the first message.
+	single bool
+
+	// typed is true, if each message has a field type, that tells the kind of
+	// the message.
+	typed bool
+}
+
+// parseCapabilities reads the capabilities from the value of the
+// CapabilitiesHeader.
+func parseCapabilities(header string) (capabilities, error) {
+	caps := capabilities{delta: -1}
+	if header == "" {
+		return caps, nil
+	}
+
+	for _, feature := range strings.Split(header, ",") {
+		name, param := feature, ""
+		if i := strings.IndexByte(feature, '='); i >= 0 {
+			name, param = feature[:i], strings.TrimSpace(feature[i+1:])
+		}
+
+		switch strings.TrimSpace(name) {
+		case capNested:
+			caps.nested = true
+
+		case capGzip:
+			caps.gzip = true
+
+		case capPosition:
+			caps.position = true
+
+		case capErrors:
+			caps.errors = true
+
+		case capSingle:
+			caps.single = true
+
+		case capTyped:
+			caps.typed = true
+
+		case capAck:
+			caps.ack = defaultAckInterval
+			if param != "" {
+				seconds, err := strconv.Atoi(param)
+				if err != nil || seconds < 1 {
+					return caps, invalidRequestError{fmt.Errorf("invalid parameter for feature %s: %q", capAck, param)}
+				}
+				caps.ack = time.Duration(seconds) * time.Second
+			}
+
+		case capDelta:
+			caps.delta = defaultDeltaThreshold
+			if param != "" {
+				threshold, err := strconv.Atoi(param)
+				if err != nil || threshold < 0 {
+					return caps, invalidRequestError{fmt.Errorf("invalid parameter for feature %s: %q", capDelta, param)}
+				}
+				caps.delta = threshold
+			}
+		}
+	}
+	return caps, nil
+}
+
+// String returns the capabilities in the format of the CapabilitiesHeader.
+func (c capabilities) String() string {
+	var features []string
+	if c.nested {
+		features = append(features, capNested)
+	}
+	if c.gzip {
+		features = append(features, capGzip)
+	}
+	if c.delta >= 0 {
+		features = append(features, capDelta+"="+strconv.Itoa(c.delta))
+	}
+	if c.position {
+		features = append(features, capPosition)
+	}
+	if c.errors {
+		features = append(features, capErrors)
+	}
+	if c.ack > 0 {
+		features = append(features, capAck+"="+strconv.Itoa(int(c.ack/time.Second)))
+	}
+	if c.single {
+		features = append(features, capSingle)
+	}
+	if c.typed {
+		features = append(features, capTyped)
+	}
+	return strings.Join(features, ",")
+}
+
+// writeNested writes the data as nested json object from the collection to the
+// id to the field.
+//
+// The keys are sorted, so the same data is always written with the same bytes.
+// In the sorted list, all keys of one collection and of one object are next to
+// each other.
+func writeNested(buf *bytes.Buffer, data map[string]json.RawMessage) {
+	var collection, id string
+	buf.WriteByte('{')
+	for _, key := range sortedKeys(data) {
+		// The keys are validated by the keysbuilder, so they always have three
+		// parts.
+		parts := strings.SplitN(key, "/", 3)
+		if len(parts) != 3 {
+			continue
+		}
+
+		switch {
+		case collection == "":
+			buf.WriteString(`"` + parts[0] + `":{"` + parts[1] + `":{`)
+		case parts[0] != collection:
+			buf.WriteString(`}},"` + parts[0] + `":{"` + parts[1] + `":{`)
+		case parts[1] != id:
+			buf.WriteString(`},"` + parts[1] + `":{`)
+		default:
+			buf.WriteByte(',')
+		}
+		collection, id = parts[0], parts[1]
+
+		buf.WriteString(`"` + parts[2] + `":`)
+		writeValue(buf, data[key])
+	}
+	if collection != "" {
+		buf.WriteString("}}")
+	}
+	buf.WriteByte('}')
+}
@@ -0,0 +1,148 @@
+package http
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestParseCapabilities(t *testing.T) {
+	for _, tt := range []struct {
+		header string
+		expect capabilities
+	}{
+		{"", capabilities{delta: -1}},
+		{"nested", capabilities{nested: true, delta: -1}},
+		{"gzip, nested", capabilities{nested: true, gzip: true, delta: -1}},
+		{"delta", capabilities{delta: defaultDeltaThreshold}},
+		{"delta=100", capabilities{delta: 100}},
+		{"unknown, delta = 0", capabilities{delta: 0}},
+		{"position", capabilities{position: true, delta: -1}},
+		{"errors", capabilities{errors: true, delta: -1}},
+		{"ack", capabilities{ack: defaultAckInterval, delta: -1}},
+		{"ack=3", capabilities{ack: 3 * time.Second, delta: -1}},
+		{"single", capabilities{single: true, delta: -1}},
+		{"typed", capabilities{typed: true, delta: -1}},
+	} {
+		t.Run(tt.header, func(t *testing.T) {
+			got, err := parseCapabilities(tt.header)
+			if err != nil {
+				t.Fatalf("parseCapabilities returned unexpected error: %v", err)
+			}
+
+			if got != tt.expect {
+				t.Errorf("Got %+v, expected %+v", got, tt.expect)
+			}
+		})
+	}
+}
+
+func TestParseCapabilitiesInvalid(t *testing.T) {
+	_, err := parseCapabilities("delta=many")
+
+	var invalid invalidRequestError
+	if !errors.As(err, &invalid) {
+		t.Errorf("Got error %v, expected an invalidRequestError", err)
+	}
+}
+
+func TestSendPosition(t *testing.T) {
+	var buf flushBuffer
+	data := map[string]json.RawMessage{"user/1/name": []byte(`"hugo"`)}
+	if _, err := send(&buf, data, capabilities{position: true, delta: -1}, nil, 7, nil); err != nil {
+		t.Fatalf("send returned unexpected error: %v", err)
+	}
+
+	expect := `{"data":{"user/1/name":"hugo"},"position":7}` + "\n"
+	if got := buf.String(); got != expect {
+		t.Errorf("Got %q, expected %q", got, expect)
+	}
+}
+
+func TestSendError(t *testing.T) {
+	var buf flushBuffer
+	if _, err := sendError(&buf, []string{"user/1/name"}, 2*time.Second, false); err != nil {
+		t.Fatalf("sendError returned unexpected error: %v", err)
+	}
+
+	expect := `{"error":{"type":"UpdateError","code":503,"msg":"Ups, some keys could not be updated","keys":["user/1/name"],"retry_in":2}}` + "\n"
+	if got := buf.String(); got != expect {
+		t.Errorf("Got %q, expected %q", got, expect)
+	}
+}
+
+func TestNextRetry(t *testing.T) {
+	var retry time.Duration
+	var got []time.Duration
+	for i := 0; i < 7; i++ {
+		retry = nextRetry(retry)
+		got = append(got, retry)
+	}
+
+	expect := []time.Duration{1, 2, 4, 8, 16, 30, 30}
+	for i := range expect {
+		if got[i] != expect[i]*time.Second {
+			t.Errorf("Got retries %v, expected %v seconds", got, expect)
+			break
+		}
+	}
+}
+
+// flushBuffer is a bytes.Buffer that implements http.Flusher.
+type flushBuffer struct {
+	bytes.Buffer
+}
+
+func (b *flushBuffer) Flush() {}
+
+func TestWriteNested(t *testing.T) {
+	var buf bytes.Buffer
+	writeNested(&buf, map[string]json.RawMessage{
+		"user/1/name":     []byte(`"hugo"`),
+		"user/1/username": nil,
+		"motion/5/title":  []byte(`"title"`),
+	})
+
+	var got map[string]map[string]map[string]json.RawMessage
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("Got invalid json `%s`: %v", buf.String(), err)
+	}
+
+	if v := string(got["user"]["1"]["name"]); v != `"hugo"` {
+		t.Errorf("Got user/1/name %s, expected \"hugo\"", v)
+	}
+	if v := string(got["user"]["1"]["username"]); v != `null` {
+		t.Errorf("Got user/1/username %s, expected null", v)
+	}
+	if v := string(got["motion"]["5"]["title"]); v != `"title"` {
+		t.Errorf("Got motion/5/title %s, expected \"title\"", v)
+	}
+}
+
+func TestWriteSorted(t *testing.T) {
+	data := map[string]json.RawMessage{
+		"user/10/name":     []byte(`"ten"`),
+		"user/1/username":  nil,
+		"user/1/name":      []byte(`"hugo"`),
+		"motion_poll/5/id": []byte(`5`),
+		"motion/5/title":   []byte(`"title"`),
+	}
+
+	for i := 0; i < 20; i++ {
+		var buf bytes.Buffer
+		writeNested(&buf, data)
+		expect := `{"motion":{"5":{"title":"title"}},"motion_poll":{"5":{"id":5}},"user":{"1":{"name":"hugo","username":null},"10":{"name":"ten"}}}`
+		if got := buf.String(); got != expect {
+			t.Fatalf("writeNested wrote\n%s\nexpected\n%s", got, expect)
+		}
+
+		buf.Reset()
+		writeObject(&buf, data)
+		expect = `{"motion/5/title":"title","motion_poll/5/id":5,"user/1/name":"hugo","user/1/username":null,"user/10/name":"ten"}`
+		if got := buf.String(); got != expect {
+			t.Fatalf("writeObject wrote\n%s\nexpected\n%s", got, expect)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package http
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/openslides/openslides-autoupdate-service/internal/trace"
+)
+
+// DefaultCORSHeaders are the request headers, that are allowed from other
+// origins, if WithCORS is called without headers.
+var DefaultCORSHeaders = []string{
+	"Authorization",
+	"Content-Type",
+	CapabilitiesHeader,
+	HashesHeader,
+	ResumeHeader,
+	PriorityHeader,
+	trace.TraceParentHeader,
+	trace.TraceStateHeader,
+	trace.RequestIDHeader,
+}
+
+// DefaultCORSMethods are the methods, that are allowed from other origins, if
+// WithCORS is called without methods.
+var DefaultCORSMethods = []string{http.MethodGet, http.MethodPost}
+
+// corsExposedHeaders are the response headers, that can be read by a client
+// from another origin.
+var corsExposedHeaders = []string{
+	CapabilitiesHeader,
+	ConnectionHeader,
+	trace.RequestIDHeader,
+}
+
+// cors adds the headers for cross-origin resource sharing to the responses.
+type cors struct {
+	origins map[string]bool
+	any     bool
+	headers string
+	methods string
+}
+
+func newCORS(origins, headers, methods []string) *cors {
+	if len(headers) == 0 {
+		headers = DefaultCORSHeaders
+	}
+	if len(methods) == 0 {
+		methods = DefaultCORSMethods
+	}
+
+	c := &cors{
+		origins: make(map[string]bool, len(origins)),
+		headers: strings.Join(headers, ", "),
+		methods: strings.Join(methods, ", "),
+	}
+	for _, origin := range origins {
+		if origin == "*" {
+			c.any = true
+		}
+		c.origins[origin] = true
+	}
+	return c
+}
+
+// middleware sets the cors headers for allowed origins and answers preflight
+// requests. The preflight requests are not passed to the next handler.
+//
+// The origin of the request is sent back instead of `*`, so the browser also
+// sends the cookies and the authorization header.
+func (c *cors) middleware(h http.Handler) http.Handler {
+	if c == nil {
+		return h
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Origin")
+
+		origin := r.Header.Get("Origin")
+		if origin == "" || !c.allowed(origin) {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		w.Header().Set("Access-Control-Allow-Credentials", "true")
+
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			// Preflight request.
+			w.Header().Add("Vary", "Access-Control-Request-Method")
+			w.Header().Add("Vary", "Access-Control-Request-Headers")
+			w.Header().Set("Access-Control-Allow-Methods", c.methods)
+			w.Header().Set("Access-Control-Allow-Headers", c.headers)
+			w.Header().Set("Access-Control-Max-Age", "600")
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		w.Header().Set("Access-Control-Expose-Headers", strings.Join(corsExposedHeaders, ", "))
+		h.ServeHTTP(w, r)
+	})
+}
+
+// allowed tells, if the origin is in the allow-list. Without cors, no other
+// origin is allowed.
+func (c *cors) allowed(origin string) bool {
+	if c == nil {
+		return false
+	}
+	return c.any || c.origins[origin]
+}
@@ -0,0 +1,162 @@
+package http
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// defaultDeltaThreshold is the minimal size of a value in bytes, that is sent
+// as patch, if the client does not set a threshold.
+const defaultDeltaThreshold = 4096
+
+// patchOp is one operation of a json patch (RFC 6902).
+type patchOp struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// deltaEncoder sends big values as json patch relative to the value, that was
+// sent before.
+//
+// Each message is a json object with the fields `data` and `patches`. `data`
+// contains the full values like in the normal format. `patches` contains a
+// json patch for each key, that has to be applied to the last value of the
+// key.
+type deltaEncoder struct {
+	threshold int
+	last      map[string]json.RawMessage
+}
+
+func newDeltaEncoder(threshold int) *deltaEncoder {
+	return &deltaEncoder{
+		threshold: threshold,
+		last:      make(map[string]json.RawMessage),
+	}
+}
+
+// encode moves the values, that can be sent as patch, from data to the
+// returned patches.
+func (d *deltaEncoder) encode(data map[string]json.RawMessage) (map[string]json.RawMessage, error) {
+	patches := make(map[string]json.RawMessage)
+	for key, value := range data {
+		if len(value) < d.threshold {
+			delete(d.last, key)
+			continue
+		}
+
+		old, ok := d.last[key]
+		d.last[key] = value
+		if !ok {
+			continue
+		}
+
+		ops, err := jsonDiff(old, value)
+		if err != nil {
+			return nil, fmt.Errorf("creating patch for key %s: %w", key, err)
+		}
+
+		patch, err := json.Marshal(ops)
+		if err != nil {
+			return nil, fmt.Errorf("encoding patch for key %s: %w", key, err)
+		}
+
+		if len(patch) >= len(value) {
+			// The patch is not smaller then the value.
+			continue
+		}
+
+		patches[key] = patch
+		delete(data, key)
+	}
+	return patches, nil
+}
+
+// jsonDiff returns the json patch operations to change the json value old to
+// new.
+func jsonDiff(old, new json.RawMessage) ([]patchOp, error) {
+	var o, n interface{}
+	if err := json.Unmarshal(old, &o); err != nil {
+		return nil, fmt.Errorf("decoding old value: %w", err)
+	}
+	if err := json.Unmarshal(new, &n); err != nil {
+		return nil, fmt.Errorf("decoding new value: %w", err)
+	}
+
+	var ops []patchOp
+	if err := diffValue(&ops, "", o, n); err != nil {
+		return nil, err
+	}
+	return ops, nil
+}
+
+// diffValue appends the operations to change o to n at the given path.
+//
+// Objects are compared by their attributes. Arrays with the same length are
+// compared by their elements. All other changes replace the value.
+func diffValue(ops *[]patchOp, path string, o, n interface{}) error {
+	switch ov := o.(type) {
+	case map[string]interface{}:
+		nv, ok := n.(map[string]interface{})
+		if !ok {
+			return replaceOp(ops, path, n)
+		}
+
+		for k := range ov {
+			if _, ok := nv[k]; !ok {
+				*ops = append(*ops, patchOp{Op: "remove", Path: path + "/" + escapePointer(k)})
+			}
+		}
+		for k, v := range nv {
+			p := path + "/" + escapePointer(k)
+			old, ok := ov[k]
+			if !ok {
+				value, err := json.Marshal(v)
+				if err != nil {
+					return fmt.Errorf("encoding value: %w", err)
+				}
+				*ops = append(*ops, patchOp{Op: "add", Path: p, Value: value})
+				continue
+			}
+			if err := diffValue(ops, p, old, v); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case []interface{}:
+		nv, ok := n.([]interface{})
+		if !ok || len(nv) != len(ov) {
+			return replaceOp(ops, path, n)
+		}
+
+		for i := range ov {
+			if err := diffValue(ops, path+"/"+strconv.Itoa(i), ov[i], nv[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	default:
+		if o != n {
+			return replaceOp(ops, path, n)
+		}
+		return nil
+	}
+}
+
+func replaceOp(ops *[]patchOp, path string, v interface{}) error {
+	value, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("encoding value: %w", err)
+	}
+	*ops = append(*ops, patchOp{Op: "replace", Path: path, Value: value})
+	return nil
+}
+
+// escapePointer escapes a key for a json pointer (RFC 6901).
+func escapePointer(key string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(key, "~", "~0"), "/", "~1")
+}
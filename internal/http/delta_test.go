@@ -0,0 +1,104 @@
+package http
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestJSONDiff(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		old    string
+		new    string
+		expect []patchOp
+	}{
+		{
+			"equal",
+			`{"a":1,"b":[1,2]}`,
+			`{"a":1,"b":[1,2]}`,
+			nil,
+		},
+		{
+			"scalar",
+			`1`,
+			`2`,
+			[]patchOp{{Op: "replace", Path: "", Value: json.RawMessage(`2`)}},
+		},
+		{
+			"object attribute",
+			`{"a":1,"b":2}`,
+			`{"a":1,"b":3}`,
+			[]patchOp{{Op: "replace", Path: "/b", Value: json.RawMessage(`3`)}},
+		},
+		{
+			"add and remove",
+			`{"a":1}`,
+			`{"b":2}`,
+			[]patchOp{
+				{Op: "remove", Path: "/a"},
+				{Op: "add", Path: "/b", Value: json.RawMessage(`2`)},
+			},
+		},
+		{
+			"array element",
+			`{"a":[1,2,3]}`,
+			`{"a":[1,5,3]}`,
+			[]patchOp{{Op: "replace", Path: "/a/1", Value: json.RawMessage(`5`)}},
+		},
+		{
+			"array length",
+			`{"a":[1,2]}`,
+			`{"a":[1,2,3]}`,
+			[]patchOp{{Op: "replace", Path: "/a", Value: json.RawMessage(`[1,2,3]`)}},
+		},
+		{
+			"escaped key",
+			`{"a/b":1,"c~d":1}`,
+			`{"a/b":2,"c~d":1}`,
+			[]patchOp{{Op: "replace", Path: "/a~1b", Value: json.RawMessage(`2`)}},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := jsonDiff(json.RawMessage(tt.old), json.RawMessage(tt.new))
+			if err != nil {
+				t.Fatalf("jsonDiff returned unexpected error: %v", err)
+			}
+
+			if !reflect.DeepEqual(got, tt.expect) {
+				t.Errorf("Got %v, expected %v", got, tt.expect)
+			}
+		})
+	}
+}
+
+func TestDeltaEncoder(t *testing.T) {
+	d := newDeltaEncoder(20)
+	big1 := json.RawMessage(`{"text":"this is a long text, that is much bigger then the patch","number":1}`)
+	big2 := json.RawMessage(`{"text":"this is a long text, that is much bigger then the patch","number":2}`)
+
+	data := map[string]json.RawMessage{"a/1/f": big1, "a/1/small": []byte(`1`)}
+	patches, err := d.encode(data)
+	if err != nil {
+		t.Fatalf("encode returned unexpected error: %v", err)
+	}
+	if len(patches) != 0 || len(data) != 2 {
+		t.Errorf("First encode returned patches %v and data %v, expected no patches", patches, data)
+	}
+
+	data = map[string]json.RawMessage{"a/1/f": big2, "a/1/small": []byte(`2`)}
+	patches, err = d.encode(data)
+	if err != nil {
+		t.Fatalf("encode returned unexpected error: %v", err)
+	}
+
+	if got, expect := string(patches["a/1/f"]), `[{"op":"replace","path":"/number","value":2}]`; got != expect {
+		t.Errorf("Got patch %s, expected %s", got, expect)
+	}
+	if _, ok := data["a/1/f"]; ok {
+		t.Errorf("Patched key is still in the data")
+	}
+	if string(data["a/1/small"]) != "2" {
+		t.Errorf("Small value was not sent in full: %v", data)
+	}
+}
@@ -0,0 +1,139 @@
+package http
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+)
+
+// Encoder compresses the stream of a connection. Flush has to write all data,
+// that was written so far, so the client can decode each message as soon as it
+// arrives. Close writes the end of the stream.
+type Encoder interface {
+	io.Writer
+	Flush() error
+	Close() error
+}
+
+// encoding is a content encoding, that can be negotiated with the
+// Accept-Encoding header.
+type encoding struct {
+	name       string
+	newEncoder func(io.Writer) Encoder
+}
+
+// gzipEncoding is always supported.
+var gzipEncoding = encoding{
+	name: "gzip",
+	newEncoder: func(w io.Writer) Encoder {
+		return gzip.NewWriter(w)
+	},
+}
+
+// NewBrotliEncoder creates an Encoder for the content encoding `br`. It can be
+// used with WithEncoding. The default quality is used, since a higher quality
+// costs much cpu time for each flushed message.
+func NewBrotliEncoder(w io.Writer) Encoder {
+	return brotli.NewWriterLevel(w, brotli.DefaultCompression)
+}
+
+// WithAcceptEncoding compresses the autoupdate stream, if the client accepts a
+// supported encoding in the Accept-Encoding header. Each message is flushed,
+// so the compression does not delay updates. Without this option, the stream
+// is only compressed with the capability gzip.
+//
+// Each compressed connection needs some hundred KiB of memory.
+func WithAcceptEncoding() Option {
+	return func(h *Handler) {
+		h.acceptEncoding = true
+	}
+}
+
+// WithEncoding adds a content encoding with the given name, for example `br`
+// for brotli. It is used with WithAcceptEncoding, if the client accepts it.
+// With the same quality in the Accept-Encoding header, encodings that are
+// added first are preferred. gzip is always supported and only used, if the
+// client does not accept one of the added encodings.
+func WithEncoding(name string, newEncoder func(io.Writer) Encoder) Option {
+	return func(h *Handler) {
+		h.encodings = append(h.encodings, encoding{name: name, newEncoder: newEncoder})
+	}
+}
+
+// chooseEncoding returns the encoding with the highest quality in the value of
+// an Accept-Encoding header. Returns false, if the client accepts none of the
+// encodings. An encoding with the quality 0 is not accepted.
+func chooseEncoding(header string, encodings []encoding) (encoding, bool) {
+	quality := make(map[string]float64)
+	for _, part := range strings.Split(header, ",") {
+		params := strings.Split(part, ";")
+		name := strings.TrimSpace(params[0])
+		if name == "" {
+			continue
+		}
+
+		q := 1.0
+		for _, param := range params[1:] {
+			param = strings.TrimSpace(param)
+			if !strings.HasPrefix(param, "q=") {
+				continue
+			}
+			if v, err := strconv.ParseFloat(param[2:], 64); err == nil {
+				q = v
+			}
+		}
+		quality[name] = q
+	}
+
+	var best encoding
+	var bestQuality float64
+	for _, enc := range encodings {
+		q, ok := quality[enc.name]
+		if !ok {
+			q = quality["*"]
+		}
+		if q > bestQuality {
+			best, bestQuality = enc, q
+		}
+	}
+	return best, bestQuality > 0
+}
+
+// encodingResponseWriter compresses the response. Each call to Flush sends the
+// compressed data, that was written so far.
+type encodingResponseWriter struct {
+	http.ResponseWriter
+	enc Encoder
+}
+
+func newEncodingResponseWriter(w http.ResponseWriter, enc encoding) *encodingResponseWriter {
+	w.Header().Set("Content-Encoding", enc.name)
+	return &encodingResponseWriter{
+		ResponseWriter: w,
+		enc:            enc.newEncoder(w),
+	}
+}
+
+func (w *encodingResponseWriter) Write(p []byte) (int, error) {
+	return w.enc.Write(p)
+}
+
+// Flush writes the compressed data to the client.
+func (w *encodingResponseWriter) Flush() {
+	if err := w.enc.Flush(); err != nil {
+		return
+	}
+
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Close writes the end of the compressed stream.
+func (w *encodingResponseWriter) Close() error {
+	return w.enc.Close()
+}
@@ -0,0 +1,33 @@
+package http
+
+import "testing"
+
+func TestChooseEncoding(t *testing.T) {
+	encodings := []encoding{{name: "br"}, gzipEncoding}
+
+	for _, tt := range []struct {
+		header string
+		expect string
+	}{
+		{"", ""},
+		{"gzip", "gzip"},
+		{"deflate, gzip;q=0.5", "gzip"},
+		{"gzip, br", "br"},
+		{"gzip, br;q=0.5", "gzip"},
+		{"deflate", ""},
+		{"gzip;q=0", ""},
+		{"*", "br"},
+		{"br;q=0, *", "gzip"},
+		{"identity, *;q=0", ""},
+	} {
+		t.Run(tt.header, func(t *testing.T) {
+			enc, ok := chooseEncoding(tt.header, encodings)
+			if !ok {
+				enc.name = ""
+			}
+			if enc.name != tt.expect {
+				t.Errorf("chooseEncoding(%q) = `%s`, expected `%s`", tt.header, enc.name, tt.expect)
+			}
+		})
+	}
+}
@@ -1,5 +1,13 @@
 package http
 
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
 // noStatusCodeError helps the errorHandler do decide, if an status code can be
 // set.
 type noStatusCodeError struct {
@@ -9,3 +17,118 @@ type noStatusCodeError struct {
 func (e noStatusCodeError) Error() string {
 	return e.wrapped.Error()
 }
+
+// invalidRequestError is returned, when the request of the client is invalid.
+type invalidRequestError struct {
+	err error
+}
+
+func (e invalidRequestError) Error() string {
+	return e.err.Error()
+}
+
+func (e invalidRequestError) Type() string {
+	return errTypeInvalidRequest
+}
+
+// Types of the errors, that are not returned by another package.
+const (
+	errTypeInternal       = "InternalError"
+	errTypeUpdate         = "UpdateError"
+	errTypeInvalidRequest = "InvalidRequest"
+	errTypeForbidden      = "Forbidden"
+	errTypeTooMany        = "TooManyConnections"
+	errTypeTooLarge       = "RequestTooLarge"
+	errTypeTimeout        = "Timeout"
+)
+
+// errorBody is the content of the json error envelope
+// `{"error": {"type": "...", "code": 400, "msg": "..."}}`.
+//
+// All errors, that are sent to the client, use this envelope. The code is the
+// http status of the error. It is also part of the body, since errors in the
+// middle of a stream can not set the status.
+type errorBody struct {
+	Type    string   `json:"type"`
+	Code    int      `json:"code"`
+	Msg     string   `json:"msg"`
+	Keys    []string `json:"keys,omitempty"`
+	RetryIn float64  `json:"retry_in,omitempty"`
+}
+
+// encodeError returns the error envelope as json line. With the capability
+// typed, the envelope has the type error.
+func encodeError(body errorBody, typed bool) []byte {
+	frame := struct {
+		Type  string    `json:"type,omitempty"`
+		Error errorBody `json:"error"`
+	}{Error: body}
+	if typed {
+		frame.Type = messageError
+	}
+
+	line, err := json.Marshal(frame)
+	if err != nil {
+		// The body only contains strings and numbers.
+		panic(fmt.Sprintf("encoding error envelope: %v", err))
+	}
+	return append(line, '\n')
+}
+
+// writeError sets the status and writes the error envelope. It is used instead
+// of http.Error.
+func writeError(w http.ResponseWriter, status int, typ string, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	w.Write(encodeError(errorBody{Type: typ, Code: status, Msg: msg}, false))
+}
+
+// errorStatus returns the http status of an error, that is sent to the client.
+// Errors can define their status with a method StatusCode. Other errors with a
+// type are caused by the client.
+func errorStatus(err DefinedError) int {
+	var withStatus interface {
+		StatusCode() int
+	}
+	if errors.As(err, &withStatus) {
+		return withStatus.StatusCode()
+	}
+	return http.StatusBadRequest
+}
+
+// bodyTooLargeError is returned, when the body of a request is bigger then
+// the limit of the handler.
+type bodyTooLargeError struct {
+	max int64
+}
+
+func (e bodyTooLargeError) Error() string {
+	return fmt.Sprintf("the request body is bigger then %d bytes", e.max)
+}
+
+func (e bodyTooLargeError) Type() string {
+	return errTypeTooLarge
+}
+
+func (e bodyTooLargeError) StatusCode() int {
+	return http.StatusRequestEntityTooLarge
+}
+
+// firstResponseTimeoutError is returned, when the first response of a
+// connection could not be created in time.
+type firstResponseTimeoutError struct {
+	timeout time.Duration
+}
+
+func (e firstResponseTimeoutError) Error() string {
+	return fmt.Sprintf("the first response could not be created in %s", e.timeout)
+}
+
+func (e firstResponseTimeoutError) Type() string {
+	return errTypeTimeout
+}
+
+func (e firstResponseTimeoutError) StatusCode() int {
+	return http.StatusGatewayTimeout
+}
@@ -0,0 +1,78 @@
+package http
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+)
+
+// singleWriter buffers the response of a request with the capability single.
+// When the handler is done, the response is sent with an ETag computed from
+// the body. If the client already has the body, it only gets the status 304.
+//
+// The ETag is computed from the bytes, that are sent, so the same data with
+// another encoding or another format gets another ETag.
+type singleWriter struct {
+	http.ResponseWriter
+	buf  bytes.Buffer
+	code int
+}
+
+func newSingleWriter(w http.ResponseWriter) *singleWriter {
+	return &singleWriter{ResponseWriter: w}
+}
+
+func (w *singleWriter) WriteHeader(code int) {
+	if w.code == 0 {
+		w.code = code
+	}
+}
+
+func (w *singleWriter) Write(p []byte) (int, error) {
+	if w.code == 0 {
+		w.code = http.StatusOK
+	}
+	return w.buf.Write(p)
+}
+
+// Flush does nothing. The body is sent by finish.
+func (w *singleWriter) Flush() {}
+
+// finish sends the buffered response. Only successful responses get an ETag.
+func (w *singleWriter) finish(r *http.Request) {
+	if w.code == 0 {
+		w.code = http.StatusOK
+	}
+
+	if w.code == http.StatusOK {
+		sum := sha256.Sum256(w.buf.Bytes())
+		etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+		w.Header().Set("ETag", etag)
+
+		if etagMatches(r.Header.Get("If-None-Match"), etag) {
+			w.ResponseWriter.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+
+	w.ResponseWriter.WriteHeader(w.code)
+	w.ResponseWriter.Write(w.buf.Bytes())
+}
+
+// etagMatches tells, if the value of the header If-None-Match contains the
+// etag.
+func etagMatches(header, etag string) bool {
+	if header == "" {
+		return false
+	}
+
+	for _, v := range strings.Split(header, ",") {
+		v = strings.TrimSpace(v)
+		if v == "*" || strings.TrimPrefix(v, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
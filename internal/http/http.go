@@ -2,109 +2,1071 @@
 package http
 
 import (
+	"bytes"
 	"context"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"log"
+	"net"
 	"net/http"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/openslides/openslides-autoupdate-service/internal/autoupdate"
 	"github.com/openslides/openslides-autoupdate-service/internal/keysbuilder"
+	"github.com/openslides/openslides-autoupdate-service/internal/trace"
 )
 
+// HashesHeader is the http header, where a client can send the hashes of the
+// collections it already knows as json object. See
+// autoupdate.CollectionHashes.
+const HashesHeader = "X-Autoupdate-Hashes"
+
+// ResumeHeader is the http header, where a client can send the position and the
+// token of a snapshot as json object. The first response only contains the
+// data, that changed after the snapshot.
+const ResumeHeader = "X-Autoupdate-Resume"
+
+// PriorityHeader is the http header, where a client can request a higher
+// priority for the connection. The only supported value is `high`. The user
+// needs the permission for it.
+const PriorityHeader = "X-Autoupdate-Priority"
+
 // Handler is an http handler for the autoupdate service.
 type Handler struct {
+	// connections and messages are used with atomic and therefore have to be
+	// the first fields in the struct.
+	connections int64
+	messages    uint64
+	draining    int32
+
 	s    *autoupdate.Autoupdate
 	mux  *http.ServeMux
 	auth Authenticator
+
+	modelsMu      sync.RWMutex
+	models        []byte
+	modelsVersion string
+	ready         func() error
+	dependencies  func() map[string]error
+	registry      registry
+	quota         *quota
+	ipFilter      *ipFilter
+	ipLimit       *ipLimit
+	ipRate        *ipRate
+	proxies       *trustedProxies
+	connLimit     *connLimit
+	cors          *cors
+	metrics       *metrics
+	accessLog     *accessLog
+	bandwidth     bandwidth
+
+	presetsMu sync.RWMutex
+	presets   *keysbuilder.Presets
+
+	// kbOptions are used for each keysbuilder, that is created from a
+	// request.
+	kbOptions []keysbuilder.Option
+
+	reloadRestrictions func(io.Reader) error
+	acceptEncoding     bool
+	encodings          []encoding
+	allowHTTP1         bool
+	keepalive          time.Duration
+	maxBodySize        int64
+	firstTimeout       time.Duration
+
+	// root is the mux together with the middlewares for all urls.
+	root http.Handler
+}
+
+// Option is an optional argument for http.New().
+type Option func(*Handler)
+
+// WithModels serves the given model metadata as json on the url
+// /system/autoupdate/models. The version is sent as ETag, so clients can detect
+// changes of the models.
+func WithModels(models []byte, version string) Option {
+	return func(h *Handler) {
+		h.models = models
+		h.modelsVersion = version
+	}
+}
+
+// WithSchema sets the fields of the models, so keysbuilder requests can
+// request all fields of an object.
+func WithSchema(schema *keysbuilder.Schema) Option {
+	return func(h *Handler) {
+		h.kbOptions = append(h.kbOptions, keysbuilder.WithSchema(schema))
+	}
+}
+
+// WithKeysbuilderMaxDepth limits the nested levels of keysbuilder requests.
+// Each level needs a request to the datastore. Deeper requests are rejected.
+// A value of 0 means no limit.
+func WithKeysbuilderMaxDepth(max int) Option {
+	return func(h *Handler) {
+		h.kbOptions = append(h.kbOptions, keysbuilder.WithMaxDepth(max))
+	}
+}
+
+// WithKeysbuilderMaxKeys limits the number of keys of a keysbuilder request.
+// Requests with more keys are rejected. A value of 0 means no limit.
+func WithKeysbuilderMaxKeys(max int) Option {
+	return func(h *Handler) {
+		h.kbOptions = append(h.kbOptions, keysbuilder.WithMaxKeys(max))
+	}
+}
+
+// WithHTTP1 allows the autoupdate urls to be used with http 1.1. Per default,
+// they only support http2. This is needed, if the service is used without TLS
+// behind a proxy, that terminates TLS.
+func WithHTTP1() Option {
+	return func(h *Handler) {
+		h.allowHTTP1 = true
+	}
+}
+
+// WithMaxBodySize limits the size of the request bodies. Bigger requests are
+// rejected with the status 413. A value of 0 means no limit.
+func WithMaxBodySize(max int64) Option {
+	return func(h *Handler) {
+		h.maxBodySize = max
+	}
+}
+
+// WithFirstResponseTimeout limits the time to create the first response of a
+// connection. This includes building the keysbuilder, the first restriction
+// and the first datastore request. If the time is exceeded, the connection is
+// closed with an error with the code 504. A value of 0 means no limit.
+func WithFirstResponseTimeout(timeout time.Duration) Option {
+	return func(h *Handler) {
+		h.firstTimeout = timeout
+	}
+}
+
+// WithRestrictionReload sets a function, that replaces the restriction
+// definition with the content of the given reader. It is called by the admin
+// handler on POST /restrictions. If it returns an error, the old definition has
+// to be kept.
+func WithRestrictionReload(reload func(io.Reader) error) Option {
+	return func(h *Handler) {
+		h.reloadRestrictions = reload
+	}
+}
+
+// SetModels replaces the model metadata. It only has an effect, if the handler
+// was created with WithModels.
+func (h *Handler) SetModels(models []byte, version string) {
+	h.modelsMu.Lock()
+	defer h.modelsMu.Unlock()
+	h.models = models
+	h.modelsVersion = version
+}
+
+// WithReadiness sets a function that is called on the url /readyz. If it
+// returns an error, the service is not ready.
+func WithReadiness(ready func() error) Option {
+	return func(h *Handler) {
+		h.ready = ready
+	}
+}
+
+// WithHealth sets a function that is called on the url
+// /system/autoupdate/health. It returns the state of each dependency of the
+// service. A nil error means, that the dependency is healthy.
+func WithHealth(health func() map[string]error) Option {
+	return func(h *Handler) {
+		h.dependencies = health
+	}
+}
+
+// WithMeetingQuota limits the connections and the sent bytes per second for
+// each meeting. If a meeting reaches one of the limits, new connections of
+// this meeting are rejected. A value of 0 means no limit.
+//
+// The meetings of a connection are the existing meetings of the requested keys
+// or the meetings of the user. The connections without a meeting share one
+// quota.
+func WithMeetingQuota(maxConnections, maxBytesPerSecond int) Option {
+	return func(h *Handler) {
+		h.quota = &quota{maxConns: maxConnections, maxBytes: maxBytesPerSecond}
+	}
+}
+
+// WithUserBandwidthLimit sets a soft limit for the bytes per second, that are
+// sent to all connections of a user. If a user receives more data, the next
+// messages are delayed. The changes in this time are sent together.
+func WithUserBandwidthLimit(bytesPerSecond int) Option {
+	return func(h *Handler) {
+		h.bandwidth.userLimit = bytesPerSecond
+	}
+}
+
+// WithKeepalive sends an empty message on each connection, that had no
+// message for the interval. This keeps the connection open behind load
+// balancers, that close idle connections.
+func WithKeepalive(interval time.Duration) Option {
+	return func(h *Handler) {
+		h.keepalive = interval
+	}
+}
+
+// WithIPFilter only allows connections to the autoupdate urls from the allowed
+// networks. Networks in deny are always rejected. If allow is empty, all
+// networks, that are not denied, are allowed. If both are empty, the addresses
+// are not checked, so requests without an ip address, for example over a unix
+// socket, are also allowed.
+func WithIPFilter(allow, deny []*net.IPNet) Option {
+	return func(h *Handler) {
+		if len(allow) > 0 || len(deny) > 0 {
+			h.ipFilter = &ipFilter{allow: allow, deny: deny}
+		}
+	}
+}
+
+// WithTrustedProxies reads the address of the client from the headers
+// Forwarded or X-Forwarded-For, if the request comes from one of the networks.
+// The address is used by the ip filter, the limits per client address and the
+// access log. Without trusted proxies, the headers are ignored.
+func WithTrustedProxies(networks []*net.IPNet) Option {
+	return func(h *Handler) {
+		if len(networks) > 0 {
+			h.proxies = &trustedProxies{nets: networks}
+		}
+	}
+}
+
+// WithIPConnectionLimit limits the number of open autoupdate connections per
+// client address. More connections are rejected with the status 429. A value
+// of 0 means no limit.
+func WithIPConnectionLimit(max int) Option {
+	return func(h *Handler) {
+		if max > 0 {
+			h.ipLimit = &ipLimit{max: max}
+		}
+	}
+}
+
+// WithIPRateLimit limits the number of new autoupdate connections per client
+// address. Each address can open burst connections at once and afterwards
+// perSecond connections per second. More connections are rejected with the
+// status 429 and the header Retry-After. A perSecond of 0 means no limit.
+func WithIPRateLimit(perSecond float64, burst int) Option {
+	return func(h *Handler) {
+		if perSecond > 0 {
+			if burst < 1 {
+				burst = 1
+			}
+			h.ipRate = &ipRate{rate: perSecond, burst: float64(burst)}
+		}
+	}
+}
+
+// WithConnectionLimit limits the number of open autoupdate connections of the
+// handler. More connections are rejected with the status 503 and the header
+// Retry-After. A value of 0 means no limit.
+func WithConnectionLimit(max int) Option {
+	return func(h *Handler) {
+		if max > 0 {
+			h.connLimit = &connLimit{max: int64(max)}
+		}
+	}
+}
+
+// WithCORS allows requests from other origins. An origin `*` allows all
+// origins. If headers or methods are empty, DefaultCORSHeaders and
+// DefaultCORSMethods are used.
+func WithCORS(origins, headers, methods []string) Option {
+	return func(h *Handler) {
+		if len(origins) > 0 {
+			h.cors = newCORS(origins, headers, methods)
+		}
+	}
+}
+
+// WithMetrics counts the requests to the handler. The metrics are written
+// with WriteMetrics.
+func WithMetrics() Option {
+	return func(h *Handler) {
+		h.metrics = new(metrics)
+	}
+}
+
+// WithAccessLog writes one json line for each autoupdate connection to w,
+// after the connection was closed. The line contains the user id, the duration,
+// the number of messages and bytes and the reason, why the connection was
+// closed.
+func WithAccessLog(w io.Writer) Option {
+	return func(h *Handler) {
+		h.accessLog = &accessLog{w: w}
+	}
 }
 
 // New create a new Handler with the correct urls.
-func New(s *autoupdate.Autoupdate, auth Authenticator) *Handler {
+func New(s *autoupdate.Autoupdate, auth Authenticator, options ...Option) *Handler {
 	h := &Handler{
 		s:    s,
 		mux:  http.NewServeMux(),
 		auth: auth,
 	}
-	h.mux.Handle("/system/autoupdate", validRequest(h.autoupdate(h.complex)))
-	h.mux.Handle("/system/autoupdate/keys", validRequest(h.autoupdate(h.simple)))
-	h.mux.Handle("/system/autoupdate/health", validRequest(http.HandlerFunc(h.health)))
+
+	for _, o := range options {
+		o(h)
+	}
+
+	// gzip is the last choice of the encodings.
+	h.encodings = append(h.encodings, gzipEncoding)
+
+	h.mux.Handle("/system/autoupdate", h.autoupdateChain(protocolV1, h.validRequest(h.autoupdate(h.complex, protocolV1))))
+	h.mux.Handle("/system/autoupdate/keys", h.autoupdateChain(protocolV1, h.validRequest(h.autoupdate(h.simple, protocolV1))))
+	h.mux.Handle("/system/autoupdate/v2", h.autoupdateChain(protocolV2, h.validRequest(h.autoupdate(h.complex, protocolV2))))
+	h.mux.Handle("/system/autoupdate/preset", h.autoupdateChain(protocolV1, h.validRequest(h.autoupdate(h.preset, protocolV1))))
+	h.mux.Handle("/system/autoupdate/v2/keys", h.autoupdateChain(protocolV2, h.validRequest(h.autoupdate(h.simple, protocolV2))))
+	h.mux.Handle("/system/autoupdate/v2/preset", h.autoupdateChain(protocolV2, h.validRequest(h.autoupdate(h.preset, protocolV2))))
+	h.mux.Handle("/system/autoupdate/ws", h.autoupdateChain(protocolV1, h.websocket(protocolV1)))
+	h.mux.Handle("/system/autoupdate/v2/ws", h.autoupdateChain(protocolV2, h.websocket(protocolV2)))
+	h.mux.Handle("/system/autoupdate/ack", h.ipFilter.middleware(h.validRequest(errHandleFunc(h.ack))))
+	h.mux.HandleFunc("/system/autoupdate/health", h.health)
+	h.mux.Handle("/system/autoupdate/snapshot", h.autoupdateChain(protocolV1, h.validRequest(errHandleFunc(h.snapshot))))
+	h.mux.Handle("/system/autoupdate/resolve", h.autoupdateChain(protocolV1, h.validRequest(errHandleFunc(h.resolve))))
+	h.mux.Handle("/system/autoupdate/history", h.autoupdateChain(protocolV1, h.validRequest(errHandleFunc(h.history))))
+	h.mux.Handle("/system/autoupdate/history/positions", h.autoupdateChain(protocolV1, h.validRequest(errHandleFunc(h.historyPositions))))
+	h.mux.Handle("/system/autoupdate/history/data", h.autoupdateChain(protocolV1, h.validRequest(errHandleFunc(h.historyData))))
+	h.mux.HandleFunc("/healthz", h.healthz)
+	h.mux.HandleFunc("/readyz", h.readyz)
+	if h.models != nil {
+		h.mux.Handle("/system/autoupdate/models", h.validRequest(http.HandlerFunc(h.serveModels)))
+	}
+
+	h.root = h.mux
+	if h.metrics != nil {
+		h.root = h.metrics.middleware(h.mux)
+	}
+	h.root = h.cors.middleware(h.root)
+	h.root = h.proxies.middleware(h.root)
 	return h
 }
 
+// ServeHTTP reads the trace context from the request and serves the request.
+// The request id is returned to the client, so it can be used in bug reports.
 func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	h.mux.ServeHTTP(w, r)
+	info := trace.FromHeader(r.Header)
+	w.Header().Set(trace.RequestIDHeader, info.RequestID)
+	h.root.ServeHTTP(w, r.WithContext(trace.NewContext(r.Context(), info)))
 }
 
-// autoupdate creates a Handler for a specific Keysbuilder.
-func (h *Handler) autoupdate(kbg func(*http.Request, int) (autoupdate.KeysBuilder, error)) errHandleFunc {
-	return func(w http.ResponseWriter, r *http.Request) error {
-		w.Header().Set("Content-Type", "application/octet-stream")
+// Drain marks the service as not ready. Existing connections are not closed.
+// It should be called before the shutdown, so no new connections are sent to
+// this instance.
+func (h *Handler) Drain() {
+	atomic.StoreInt32(&h.draining, 1)
+}
+
+// ConnectionCount returns the number of currently open autoupdate
+// connections.
+func (h *Handler) ConnectionCount() int {
+	return int(atomic.LoadInt64(&h.connections))
+}
+
+// MessageCount returns the number of messages that were sent to the clients
+// since the handler was created.
+func (h *Handler) MessageCount() uint64 {
+	return atomic.LoadUint64(&h.messages)
+}
+
+// Bandwidth returns the number of bytes, that were sent in total, to each user
+// and to each meeting since the handler was created.
+func (h *Handler) Bandwidth() Bandwidth {
+	return h.bandwidth.usage()
+}
 
-		uid, err := h.auth.Authenticate(r.Context(), r)
+// autoupdate creates a Handler for a specific Keysbuilder and version of the
+// protocol.
+func (h *Handler) autoupdate(kbg func(*http.Request, int) (autoupdate.KeysBuilder, error), version int) errHandleFunc {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		caps, err := streamCapabilities(r, version)
 		if err != nil {
-			return fmt.Errorf("authenticate request: %w", err)
+			return err
+		}
+		w.Header().Set(CapabilitiesHeader, caps.String())
+
+		var enc encoding
+		compress := caps.gzip
+		if compress {
+			enc = gzipEncoding
+		} else if h.acceptEncoding {
+			w.Header().Add("Vary", "Accept-Encoding")
+			enc, compress = chooseEncoding(r.Header.Get("Accept-Encoding"), h.encodings)
+		}
+
+		// connect closes the access log record with the error of the
+		// connection.
+		connect := func(w http.ResponseWriter, r *http.Request) error {
+			err := h.connect(w, r, kbg, caps)
+			accessRecordFromContext(r.Context()).finish(r.Context(), err)
+			return err
+		}
+
+		if !compress && !caps.sse && !caps.single {
+			return connect(w, r)
+		}
+
+		// All responses, also the errors, have to be written through the
+		// buffer of a single request, the compression and the event writer.
+		if caps.single {
+			sw := newSingleWriter(w)
+			defer sw.finish(r)
+			w = sw
+		}
+		if compress {
+			ew := newEncodingResponseWriter(w, enc)
+			defer ew.Close()
+			w = ew
+		}
+		if caps.sse {
+			w = newSSEWriter(w)
+		}
+		errHandleFunc(connect).ServeHTTP(w, r)
+		return nil
+	}
+}
+
+// streamCapabilities returns the capabilities of a request to an autoupdate
+// url with the given version of the protocol.
+func streamCapabilities(r *http.Request, version int) (capabilities, error) {
+	caps, err := parseCapabilities(r.Header.Get(CapabilitiesHeader))
+	if err != nil {
+		return caps, err
+	}
+
+	caps.version = version
+	caps.sse = isEventStream(r)
+	if version == protocolV2 {
+		// The format of version 2 is always nested, contains the
+		// position and keeps the connection open on errors.
+		caps.nested = true
+		caps.position = true
+		caps.errors = true
+	}
+	return caps, nil
+}
+
+// streamContentType returns the content type of the autoupdate stream.
+func streamContentType(caps capabilities) string {
+	switch {
+	case caps.sse:
+		return "text/event-stream"
+	case caps.typed:
+		return "application/x-ndjson"
+	default:
+		return "application/octet-stream"
+	}
+}
+
+// connect streams the data of a keysbuilder to the client.
+func (h *Handler) connect(w http.ResponseWriter, r *http.Request, kbg func(*http.Request, int) (autoupdate.KeysBuilder, error), caps capabilities) error {
+	w.Header().Set("Content-Type", streamContentType(caps))
+
+	uid, session, err := h.authenticateSession(r)
+	if err != nil {
+		return fmt.Errorf("authenticate request: %w", err)
+	}
+
+	// Save tid before the keybuilder is generated. If the datastore gets an
+	// update, the update can be handeled.
+	tid := h.s.LastID()
+
+	// firstCtx is used until the first response is created.
+	firstCtx, firstDone := context.WithCancel(r.Context())
+	if h.firstTimeout > 0 {
+		firstCtx, firstDone = context.WithTimeout(r.Context(), h.firstTimeout)
+	}
+	defer firstDone()
+
+	kb, err := kbg(r.WithContext(firstCtx), uid)
+	if err != nil {
+		if firstTimedOut(r.Context(), firstCtx) {
+			return firstResponseTimeoutError{timeout: h.firstTimeout}
 		}
+		return fmt.Errorf("build keysbuilder: %w", err)
+	}
 
-		// Save tid before the keybuilder is generated. If the datastore gets an
-		// update, the update can be handeled.
-		tid := h.s.LastID()
+	meetings, err := h.connectionMeetings(firstCtx, uid, kb)
+	if err != nil {
+		if firstTimedOut(r.Context(), firstCtx) {
+			return firstResponseTimeoutError{timeout: h.firstTimeout}
+		}
+		return fmt.Errorf("find meetings of connection: %w", err)
+	}
 
-		kb, err := kbg(r, uid)
+	if h.quota != nil {
+		if err := h.quota.acquire(meetings, time.Now()); err != nil {
+			return err
+		}
+		defer h.quota.release(meetings)
+	}
+
+	defer func() {
+		// After this line, it is not allowed for the handler to set a
+		// status error.
 		if err != nil {
-			return fmt.Errorf("build keysbuilder: %w", err)
+			err = noStatusCodeError{err}
 		}
+	}()
+
+	connection := h.s.Connect(uid, kb, tid)
+	defer connection.Close()
+	if v := r.Header.Get(HashesHeader); v != "" {
+		var hashes map[string]string
+		if err := json.Unmarshal([]byte(v), &hashes); err != nil {
+			return invalidRequestError{fmt.Errorf("invalid header %s: %w", HashesHeader, err)}
+		}
+		connection.SetKnownHashes(hashes)
+	}
 
+	if v := r.Header.Get(ResumeHeader); v != "" {
+		var resume struct {
+			Position uint64 `json:"position"`
+			Token    string `json:"token"`
+		}
+		if err := json.Unmarshal([]byte(v), &resume); err != nil {
+			return invalidRequestError{fmt.Errorf("invalid header %s: %w", ResumeHeader, err)}
+		}
+		connection.Resume(resume.Position, resume.Token)
+	} else if v := r.Header.Get("Last-Event-ID"); v != "" && caps.sse {
+		if err := resumeFromEventID(connection, v); err != nil {
+			return err
+		}
+	}
+
+	switch v := r.Header.Get(PriorityHeader); v {
+	case "":
+	case "high":
+		if err := connection.SetPriority(r.Context(), autoupdate.PriorityHigh); err != nil {
+			return fmt.Errorf("set priority: %w", err)
+		}
+	default:
+		return invalidRequestError{fmt.Errorf("invalid header %s: %q", PriorityHeader, v)}
+	}
+
+	var delta *deltaEncoder
+	if caps.delta >= 0 {
+		delta = newDeltaEncoder(caps.delta)
+	}
+
+	atomic.AddInt64(&h.connections, 1)
+	defer atomic.AddInt64(&h.connections, -1)
+
+	// The context can be canceled to close the connection from outside.
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	// nextCtx is canceled, when the connection has to reconnect to another
+	// instance.
+	nextCtx, reconnectNext := context.WithCancel(ctx)
+	defer reconnectNext()
+	info := &connectionInfo{uid: uid, session: session, meetings: meetings, created: time.Now(), bodies: 1, cancel: cancel, reconnect: reconnectNext}
+	if bc, ok := kb.(interface{ BodyCount() int }); ok {
+		info.bodies = bc.BodyCount()
+	}
+	kc, _ := kb.(interface{ KeyCount() int })
+	connID := h.registry.add(info)
+	defer h.registry.remove(connID)
+
+	record := accessRecordFromContext(r.Context())
+	record.connected(uid, info)
+
+	if caps.ack > 0 {
+		w.Header().Set(ConnectionHeader, strconv.FormatUint(connID, 10))
+		go watchAcks(ctx, info, caps.ack, cancel)
+	}
+
+	var ka *keepalive
+	// The response of a single request is buffered, so a keepalive message
+	// would not reach the client.
+	if h.keepalive > 0 && !caps.single {
+		message := keepaliveMessage
+		if caps.typed {
+			message = typedKeepaliveMessage
+		}
+		ka = newKeepalive(w, message)
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			ka.run(ctx, h.keepalive, cancel)
+		}()
+
+		// The response writer can not be used after the handler returns.
 		defer func() {
-			// After this line, it is not allowed for the handler to set a
-			// status error.
-			if err != nil {
-				err = noStatusCodeError{err}
-			}
+			cancel()
+			<-done
 		}()
+	}
 
-		connection := h.s.Connect(uid, kb, tid)
+	// The first call to Next uses the timeout of the first response.
+	firstNextCtx, firstNextDone := context.WithCancel(nextCtx)
+	if h.firstTimeout > 0 {
+		deadline, _ := firstCtx.Deadline()
+		firstNextCtx, firstNextDone = context.WithDeadline(nextCtx, deadline)
+	}
+	defer firstNextDone()
 
-		for {
-			// connection.Next() blocks, until there is new data or the client context
-			// or the server is closed.
-			data, err := connection.Next(r.Context())
+	var retry time.Duration
+	var lastPosition uint64
+	first := true
+	for {
+		readCtx := nextCtx
+		if first {
+			readCtx = firstNextCtx
+		}
+
+		// connection.Next() blocks, until there is new data or the client context
+		// or the server is closed.
+		data, err := connection.Next(readCtx)
+		if first && err != nil && firstTimedOut(nextCtx, firstNextCtx) {
+			return firstResponseTimeoutError{timeout: h.firstTimeout}
+		}
+		first = false
+		if err != nil {
+			if nextCtx.Err() != nil && ctx.Err() == nil {
+				var resume resumePosition
+				if lastPosition > 0 {
+					resume = resumePosition{Position: lastPosition, Token: connection.ResumeToken()}
+				}
+				record.closing(closeReconnect)
+				return reconnect(ctx, w, ka, info, resume, caps.typed)
+			}
+
+			var updateErr autoupdate.UpdateError
+			if !caps.errors || !errors.As(err, &updateErr) || ctx.Err() != nil {
+				return err
+			}
+
+			// Keep the connection open and try the keys again later.
+			retry = nextRetry(retry)
+			logRequestError(r, err)
+			err := ka.write(func() error {
+				_, err := sendError(w, updateErr.Keys(), retry, caps.typed)
+				return err
+			})
 			if err != nil {
 				return err
 			}
 
-			if err := sendData(w, data); err != nil {
+			timer := time.NewTimer(retry)
+			select {
+			case <-timer.C:
+			case <-nextCtx.Done():
+				// Closed or reconnect. Next notices it.
+				timer.Stop()
+			}
+			continue
+		}
+		retry = 0
+
+		// The keysbuilder is only used in this goroutine, so it is save
+		// to read the number of keys here.
+		if kc != nil {
+			atomic.StoreInt64(&info.keys, int64(kc.KeyCount()))
+		}
+
+		var written int
+		err = ka.write(func() error {
+			if sw, ok := w.(*sseWriter); ok {
+				sw.id = eventID(connection.Position(), connection.ResumeToken())
+			}
+
+			var err error
+			written, err = send(w, data, caps, delta, connection.Position(), connection.Pending())
+			if err != nil || !caps.typed {
 				return err
 			}
+
+			n, err := sendPosition(w, resumePosition{Position: connection.Position(), Token: connection.ResumeToken()})
+			written += n
+			return err
+		})
+		atomic.AddUint64(&info.bytes, uint64(written))
+		if h.quota != nil {
+			h.quota.sent(meetings, written, time.Now())
+		}
+		if err != nil {
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				// The client closed the connection.
+				return ctxErr
+			}
+			return err
+		}
+		atomic.AddUint64(&h.messages, 1)
+		record.sent()
+		lastPosition = connection.Position()
+
+		if caps.single && len(connection.Pending()) == 0 {
+			// The client only wants the current data.
+			return nil
+		}
+
+		if delay := h.bandwidth.sent(uid, meetings, written, time.Now()); delay > 0 {
+			// The user reached the bandwidth limit.
+			timer := time.NewTimer(delay)
+			select {
+			case <-timer.C:
+			case <-nextCtx.Done():
+				// Closed or reconnect. Next notices it.
+				timer.Stop()
+			}
 		}
 	}
 }
 
+// authenticateSession authenticates the request. If the Authenticator
+// implements SessionAuthenticator, also the session id is returned.
+func (h *Handler) authenticateSession(r *http.Request) (int, string, error) {
+	if sa, ok := h.auth.(SessionAuthenticator); ok {
+		return sa.AuthenticateSession(r.Context(), r)
+	}
+
+	uid, err := h.auth.Authenticate(r.Context(), r)
+	return uid, "", err
+}
+
+// firstTimedOut tells, if the timeout of the first response was reached. It is
+// false, if the parent context is done, for example because the client closed
+// the connection.
+func firstTimedOut(parent, first context.Context) bool {
+	return parent.Err() == nil && errors.Is(first.Err(), context.DeadlineExceeded)
+}
+
 // complex builds a keysbuilder from the body of a request. The body has to be
 // in the format specified in the keysbuilder package.
+//
+// If the url argument k is set, it is used as comma separated list of keys
+// instead of the body. If the url argument request is set, it is used instead
+// of the body. This is needed for clients, that can not send a body, like the
+// EventSource API of the browsers.
 func (h *Handler) complex(r *http.Request, uid int) (autoupdate.KeysBuilder, error) {
 	defer r.Body.Close()
-	return keysbuilder.ManyFromJSON(r.Context(), r.Body, h.s, uid)
+	if keys := r.URL.Query().Get("k"); keys != "" {
+		return keyList(keys)
+	}
+
+	if v := r.URL.Query().Get("request"); v != "" {
+		body, err := decodeRequestArgument(v)
+		if err != nil {
+			return nil, err
+		}
+
+		if h.maxBodySize > 0 && int64(len(body)) > h.maxBodySize {
+			return nil, bodyTooLargeError{max: h.maxBodySize}
+		}
+		return keysbuilder.ManyFromJSON(r.Context(), bytes.NewReader(body), h.s, uid, h.kbOptions...)
+	}
+
+	return keysbuilder.ManyFromJSON(r.Context(), r.Body, h.s, uid, h.kbOptions...)
+}
+
+// decodeRequestArgument decodes the url argument request. It is the json of a
+// keysbuilder request, that is url encoded or base64 encoded. Base64 can use
+// the url or the standard alphabet with or without padding.
+func decodeRequestArgument(value string) ([]byte, error) {
+	value = strings.TrimSpace(value)
+	if strings.HasPrefix(value, "[") || strings.HasPrefix(value, "{") {
+		return []byte(value), nil
+	}
+
+	value = strings.TrimRight(value, "=")
+	if body, err := base64.RawURLEncoding.DecodeString(value); err == nil {
+		return body, nil
+	}
+
+	// An unescaped + in the url is decoded as space.
+	body, err := base64.RawStdEncoding.DecodeString(strings.ReplaceAll(value, " ", "+"))
+	if err != nil {
+		return nil, invalidRequestError{errors.New("invalid argument request: it is neither json nor base64")}
+	}
+	return body, nil
 }
 
 // simple builds a keysbuilder from the url query. It expects a comma separated
 // list of keysname.
 func (h *Handler) simple(r *http.Request, uid int) (autoupdate.KeysBuilder, error) {
-	keys := strings.Split(r.URL.RawQuery, ",")
-	kb := &keysbuilder.Simple{K: keys}
+	return keyList(r.URL.RawQuery)
+}
+
+// keyList builds a keysbuilder from a comma separated list of keys.
+func keyList(list string) (autoupdate.KeysBuilder, error) {
+	kb := &keysbuilder.Simple{K: strings.Split(list, ",")}
 	if err := kb.Validate(); err != nil {
 		return nil, err
 	}
 	return kb, nil
 }
 
+// health tells, if the service and its dependencies are healthy. The state of
+// each dependency is part of the body.
 func (h *Handler) health(w http.ResponseWriter, r *http.Request) {
+	type dependency struct {
+		Healthy bool   `json:"healthy"`
+		Error   string `json:"error,omitempty"`
+	}
+
+	body := struct {
+		Healthy      bool                  `json:"healthy"`
+		Dependencies map[string]dependency `json:"dependencies,omitempty"`
+	}{Healthy: true}
+
+	if h.dependencies != nil {
+		body.Dependencies = make(map[string]dependency)
+		for name, err := range h.dependencies() {
+			if err != nil {
+				body.Healthy = false
+				body.Dependencies[name] = dependency{Error: err.Error()}
+				continue
+			}
+			body.Dependencies[name] = dependency{Healthy: true}
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if !body.Healthy {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		logRequestError(r, fmt.Errorf("encoding health: %w", err))
+	}
+}
+
+// history returns the restricted history of the object given by the url
+// argument fqid.
+func (h *Handler) history(w http.ResponseWriter, r *http.Request) error {
+	uid, err := h.auth.Authenticate(r.Context(), r)
+	if err != nil {
+		return fmt.Errorf("authenticate request: %w", err)
+	}
+
+	ctx := trace.WithUserID(r.Context(), uid)
+	entries, err := h.s.History(ctx, uid, r.URL.Query().Get("fqid"))
+	if err != nil {
+		return fmt.Errorf("get history: %w", err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(entries); err != nil {
+		return fmt.Errorf("encoding history: %w", err)
+	}
+	return nil
+}
+
+// historyPositions returns the positions of the object given by the url
+// argument fqid with the time and the acting user. Instead of fqid, the url
+// argument meeting can be used for the positions of a meeting.
+func (h *Handler) historyPositions(w http.ResponseWriter, r *http.Request) error {
+	uid, err := h.auth.Authenticate(r.Context(), r)
+	if err != nil {
+		return fmt.Errorf("authenticate request: %w", err)
+	}
+
+	fqid := r.URL.Query().Get("fqid")
+	if meetingID := r.URL.Query().Get("meeting"); meetingID != "" {
+		if fqid != "" {
+			return invalidRequestError{fmt.Errorf("only one of fqid and meeting can be used")}
+		}
+		fqid = "meeting/" + meetingID
+	}
+
+	ctx := trace.WithUserID(r.Context(), uid)
+	positions, err := h.s.HistoryPositions(ctx, uid, fqid)
+	if err != nil {
+		return fmt.Errorf("get history positions: %w", err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(positions); err != nil {
+		return fmt.Errorf("encoding history positions: %w", err)
+	}
+	return nil
+}
+
+// historyData returns the restricted data of the request body at the position
+// given by the url argument position. The relations of the body are followed
+// with the data at the position.
+func (h *Handler) historyData(w http.ResponseWriter, r *http.Request) error {
+	defer r.Body.Close()
+
+	uid, err := h.auth.Authenticate(r.Context(), r)
+	if err != nil {
+		return fmt.Errorf("authenticate request: %w", err)
+	}
+
+	position, err := strconv.Atoi(r.URL.Query().Get("position"))
+	if err != nil {
+		return invalidRequestError{fmt.Errorf("invalid position: %w", err)}
+	}
+
+	ctx := trace.WithUserID(r.Context(), uid)
+	provider, err := h.s.AtPosition(uid, position)
+	if err != nil {
+		return fmt.Errorf("read position %d: %w", position, err)
+	}
+
+	kb, err := keysbuilder.ManyFromJSON(ctx, r.Body, provider, uid, h.kbOptions...)
+	if err != nil {
+		return fmt.Errorf("build keysbuilder: %w", err)
+	}
+
+	data, err := provider.RestrictedData(ctx, uid, kb.Keys()...)
+	if err != nil {
+		return fmt.Errorf("get data at position %d: %w", position, err)
+	}
+
+	for k, v := range data {
+		if v == nil {
+			delete(data, k)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	entry := autoupdate.HistoryEntry{Position: position, Data: data}
+	if err := json.NewEncoder(w).Encode(entry); err != nil {
+		return fmt.Errorf("encoding history data: %w", err)
+	}
+	return nil
+}
+
+// snapshot returns all data of the request body as json together with the
+// position of the data. See autoupdate.Snapshot.
+//
+// The response is compressed with the encoding, that is chosen from the header
+// Accept-Encoding like for the autoupdate stream.
+func (h *Handler) snapshot(w http.ResponseWriter, r *http.Request) error {
+	uid, err := h.auth.Authenticate(r.Context(), r)
+	if err != nil {
+		return fmt.Errorf("authenticate request: %w", err)
+	}
+
+	tid := h.s.LastID()
+	kb, err := h.complex(r, uid)
+	if err != nil {
+		return fmt.Errorf("build keysbuilder: %w", err)
+	}
+
+	snapshot, err := h.s.Snapshot(r.Context(), uid, kb, tid)
+	if err != nil {
+		return fmt.Errorf("create snapshot: %w", err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	var enc encoding
+	var compress bool
+	if h.acceptEncoding {
+		w.Header().Add("Vary", "Accept-Encoding")
+		enc, compress = chooseEncoding(r.Header.Get("Accept-Encoding"), h.encodings)
+	}
+
+	if !compress {
+		if err := json.NewEncoder(w).Encode(snapshot); err != nil {
+			return fmt.Errorf("encoding snapshot: %w", err)
+		}
+		return nil
+	}
+
+	ew := newEncodingResponseWriter(w, enc)
+	if err := json.NewEncoder(ew).Encode(snapshot); err != nil {
+		return fmt.Errorf("encoding snapshot: %w", err)
+	}
+	if err := ew.Close(); err != nil {
+		return fmt.Errorf("compressing snapshot: %w", err)
+	}
+	return nil
+}
+
+// resolve returns the sorted list of keys of the request body without the
+// data. It uses the permissions of the user, so it shows, which keys a
+// connection with the same body would subscribe.
+func (h *Handler) resolve(w http.ResponseWriter, r *http.Request) error {
+	uid, err := h.auth.Authenticate(r.Context(), r)
+	if err != nil {
+		return fmt.Errorf("authenticate request: %w", err)
+	}
+
+	kb, err := h.complex(r, uid)
+	if err != nil {
+		return fmt.Errorf("build keysbuilder: %w", err)
+	}
+
+	keys := kb.Keys()
+	sort.Strings(keys)
+
+	w.Header().Set("Content-Type", "application/json")
+	response := struct {
+		Keys []string `json:"keys"`
+	}{keys}
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		return fmt.Errorf("encoding keys: %w", err)
+	}
+	return nil
+}
+
+// healthz tells, that the process is alive. It does not check any
+// dependencies.
+func (h *Handler) healthz(w http.ResponseWriter, r *http.Request) {
 	fmt.Fprintln(w, `{"healthy": true}`)
 }
 
+// readyz tells, if the service can handle new connections. It is not ready,
+// when it is draining or when the readiness function returns an error.
+func (h *Handler) readyz(w http.ResponseWriter, r *http.Request) {
+	body := struct {
+		Ready  bool   `json:"ready"`
+		Reason string `json:"reason,omitempty"`
+	}{Ready: true}
+
+	if atomic.LoadInt32(&h.draining) == 1 {
+		body.Ready = false
+		body.Reason = "draining"
+	} else if h.ready != nil {
+		if err := h.ready(); err != nil {
+			body.Ready = false
+			body.Reason = err.Error()
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if !body.Ready {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		logRequestError(r, fmt.Errorf("encoding readiness: %w", err))
+	}
+}
+
+// serveModels returns the model metadata. If the client sends the current
+// version in the If-None-Match header, only the status 304 is returned.
+func (h *Handler) serveModels(w http.ResponseWriter, r *http.Request) {
+	h.modelsMu.RLock()
+	models, version := h.models, h.modelsVersion
+	h.modelsMu.RUnlock()
+
+	etag := `"` + version + `"`
+	w.Header().Set("ETag", etag)
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(models)
+}
+
 // errHandleFunc is like a http.Handler, but has a error as return value.
 //
 // If the returned error implements the DefinedError interface, then the error
@@ -128,65 +1090,236 @@ func (f errHandleFunc) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
+		body := errorBody{Type: errTypeInternal, Code: http.StatusInternalServerError, Msg: "Ups, something went wrong!"}
 		var derr DefinedError
 		if errors.As(err, &derr) {
-			if status {
-				w.WriteHeader(http.StatusBadRequest)
-			}
-			fmt.Fprintf(w, `{"error": {"type": "%s", "msg": "%s"}}`, derr.Type(), quote(derr.Error()))
-			return
+			body = errorBody{Type: derr.Type(), Code: errorStatus(derr), Msg: derr.Error()}
+		} else {
+			logRequestError(r, err)
 		}
 
 		if status {
-			w.WriteHeader(http.StatusInternalServerError)
+			w.WriteHeader(body.Code)
 		}
-		log.Printf("Internal Error: %v", err)
-		fmt.Fprintln(w, `{"error": {"type": "InternalError", "msg": "Ups, something went wrong!"}}`)
+		// The capabilities of a connection are in the response header.
+		caps, _ := parseCapabilities(w.Header().Get(CapabilitiesHeader))
+		w.Write(encodeError(body, caps.typed))
 	}
 }
 
-// quote decodes changes quotation marks with a backslash to make sure, they are
-// valid json.
-func quote(s string) string {
-	return strings.ReplaceAll(s, `"`, `\"`)
+// logRequestError logs an internal error together with the id of the request.
+func logRequestError(r *http.Request, err error) {
+	if info, ok := trace.FromContext(r.Context()); ok {
+		log.Printf("Internal Error (request %s): %v", info.RequestID, err)
+		return
+	}
+	log.Printf("Internal Error: %v", err)
 }
 
-func sendData(w io.Writer, data map[string]json.RawMessage) error {
-	// TODO: Handle errors
-	first := true
-	w.Write([]byte("{"))
-	for key, value := range data {
-		if !first {
-			w.Write([]byte{','})
+// The delay before keys are read again after an UpdateError. It is doubled
+// after each error in a row.
+const (
+	minRetry = time.Second
+	maxRetry = 30 * time.Second
+)
+
+// nextRetry returns the delay after an error, when last was the delay before.
+func nextRetry(last time.Duration) time.Duration {
+	if last == 0 {
+		return minRetry
+	}
+	if last*2 > maxRetry {
+		return maxRetry
+	}
+	return last * 2
+}
+
+// sendError writes an error frame for keys, that could not be updated. The
+// details of the error are only logged. retry is the time, after which the
+// server reads the keys again.
+func sendError(w io.Writer, keys []string, retry time.Duration, typed bool) (int, error) {
+	line := encodeError(errorBody{
+		Type:    errTypeUpdate,
+		Code:    http.StatusServiceUnavailable,
+		Msg:     "Ups, some keys could not be updated",
+		Keys:    keys,
+		RetryIn: retry.Seconds(),
+	}, typed)
+
+	written, err := w.Write(line)
+	if err != nil {
+		return written, fmt.Errorf("writing error frame: %w", err)
+	}
+	w.(http.Flusher).Flush()
+	return written, nil
+}
+
+// maxPooledBufferSize is the maximum capacity of a buffer, that is put back
+// into the buffer pool. Bigger buffers are freed to not hold to much memory.
+const maxPooledBufferSize = 1 << 20
+
+// bufPool holds the buffers that are used to serialize the messages.
+var bufPool = sync.Pool{
+	New: func() interface{} {
+		return new(bytes.Buffer)
+	},
+}
+
+// send writes the data as one json object in one line and flushes it to the
+// client.
+//
+// The format is chosen by the version of the protocol and the capabilities. If
+// delta is not nil, the delta format is used. pending are the keys, that are
+// missing in the data. They are only sent with version 2.
+//
+// Returns the number of written bytes.
+func send(w io.Writer, data map[string]json.RawMessage, caps capabilities, delta *deltaEncoder, position uint64, pending []string) (int, error) {
+	var patches map[string]json.RawMessage
+	if delta != nil {
+		var err error
+		patches, err = delta.encode(data)
+		if err != nil {
+			return 0, fmt.Errorf("encoding delta: %w", err)
 		}
-		first = false
-		w.Write([]byte{'"'})
-		w.Write([]byte(key))
-		w.Write([]byte{'"', ':'})
-		if value == nil {
-			value = []byte("null")
+	}
+
+	buf := bufPool.Get().(*bytes.Buffer)
+	buf.Reset()
+	defer func() {
+		if buf.Cap() <= maxPooledBufferSize {
+			bufPool.Put(buf)
 		}
-		w.Write(value)
+	}()
+
+	if caps.version == protocolV2 {
+		encodeV2(buf, data, patches, caps, position, pending)
+	} else {
+		encodeV1(buf, data, patches, caps, position)
+	}
+	buf.WriteByte('\n')
+
+	written, err := w.Write(buf.Bytes())
+	if err != nil {
+		return written, fmt.Errorf("writing data: %w", err)
 	}
-	w.Write([]byte("}\n"))
 	w.(http.Flusher).Flush()
-	return nil
+	return written, nil
+}
+
+// writeObject writes the map as json object. nil values are written as null.
+//
+// The keys are sorted, so the same data is always written with the same bytes.
+func writeObject(buf *bytes.Buffer, data map[string]json.RawMessage) {
+	buf.WriteByte('{')
+	for i, key := range sortedKeys(data) {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		buf.WriteByte('"')
+		buf.WriteString(key)
+		buf.WriteString(`":`)
+		writeValue(buf, data[key])
+	}
+	buf.WriteByte('}')
+}
+
+// writeValue writes a json value. nil is written as null.
+func writeValue(buf *bytes.Buffer, value json.RawMessage) {
+	if value == nil {
+		value = []byte("null")
+	}
+	buf.Write(value)
+}
+
+// sortedKeys returns the keys of the map in sorted order.
+func sortedKeys(data map[string]json.RawMessage) []string {
+	keys := make([]string, 0, len(data))
+	for key := range data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
 }
 
-func validRequest(h http.Handler) http.Handler {
+// streamMethods are the methods, that the autoupdate urls support.
+const streamMethods = "GET, POST, HEAD, OPTIONS"
+
+// headOrOptions answers HEAD and OPTIONS requests to the autoupdate urls
+// without opening a connection. Other requests are passed to next.
+//
+// OPTIONS returns the supported methods. Preflight requests of allowed origins
+// are already answered by the cors middleware.
+//
+// HEAD authenticates the request and returns the headers of the stream. So a
+// client or a health check can test the token and the capabilities.
+func (h *Handler) headOrOptions(version int, next http.Handler) http.Handler {
+	head := errHandleFunc(func(w http.ResponseWriter, r *http.Request) error {
+		caps, err := streamCapabilities(r, version)
+		if err != nil {
+			return err
+		}
+		w.Header().Set(CapabilitiesHeader, caps.String())
+
+		if _, err := h.auth.Authenticate(r.Context(), r); err != nil {
+			return fmt.Errorf("authenticate request: %w", err)
+		}
+
+		w.Header().Set("Content-Type", streamContentType(caps))
+		w.WriteHeader(http.StatusOK)
+		return nil
+	})
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodOptions:
+			w.Header().Set("Allow", streamMethods)
+			w.WriteHeader(http.StatusNoContent)
+		case http.MethodHead:
+			head.ServeHTTP(w, r)
+		default:
+			next.ServeHTTP(w, r)
+		}
+	})
+}
+
+// autoupdateChain wraps an autoupdate url with the middlewares, that limit the
+// connections. All autoupdate urls and the urls, that read the same data
+// without opening a connection, use it. So the limits can not be bypassed with
+// one of the urls.
+//
+// The version of the protocol is used for the HEAD requests. next has to check
+// the request itself, for example with validRequest. The websocket urls check
+// their handshake instead.
+func (h *Handler) autoupdateChain(version int, next http.Handler) http.Handler {
+	return h.accessLog.middleware(h.ipFilter.middleware(h.headOrOptions(version, h.ipRate.middleware(h.connLimit.middleware(h.ipLimit.middleware(next))))))
+}
+
+// validRequest only calls next, if the request is a GET or POST request. The
+// request has to use http2, unless the handler was created with WithHTTP1.
+// The body of the request is limited to the size of WithMaxBodySize.
+func (h *Handler) validRequest(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Only allow http2 requests.
-		if !r.ProtoAtLeast(2, 0) {
-			http.Error(w, "Only http2 is supported", http.StatusBadRequest)
+		if !h.allowHTTP1 && !r.ProtoAtLeast(2, 0) {
+			writeError(w, http.StatusBadRequest, errTypeInvalidRequest, "Only http2 is supported")
 			return
 		}
 
 		// Only allow GET or POST requests.
 		if !(r.Method == http.MethodPost || r.Method == http.MethodGet) {
-			http.Error(w, "Only GET or POST requests are supported", http.StatusMethodNotAllowed)
+			writeError(w, http.StatusMethodNotAllowed, errTypeInvalidRequest, "Only GET or POST requests are supported")
 			return
 		}
 
-		h.ServeHTTP(w, r)
+		if h.maxBodySize > 0 {
+			if r.ContentLength > h.maxBodySize {
+				err := bodyTooLargeError{max: h.maxBodySize}
+				writeError(w, err.StatusCode(), err.Type(), err.Error())
+				return
+			}
+			r.Body = &limitedBody{ReadCloser: r.Body, max: h.maxBodySize}
+		}
+
+		next.ServeHTTP(w, r)
 	})
 }
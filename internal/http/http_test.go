@@ -1,25 +1,37 @@
 package http_test
 
 import (
+	"bufio"
 	"bytes"
+	"compress/flate"
+	"compress/gzip"
 	"context"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
+	"io"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/andybalholm/brotli"
 	"github.com/openslides/openslides-autoupdate-service/internal/autoupdate"
 	ahttp "github.com/openslides/openslides-autoupdate-service/internal/http"
 	"github.com/openslides/openslides-autoupdate-service/internal/test"
+	"github.com/openslides/openslides-autoupdate-service/internal/trace"
 )
 
 func TestHandlerTestURLs(t *testing.T) {
+	request := `[{"ids":[1],"collection":"user","fields":{"name":null}}]`
 	closed := make(chan struct{})
 	defer close(closed)
 	s := autoupdate.New(new(test.MockDatastore), new(test.MockRestricter), closed)
-	srv := httptest.NewUnstartedServer(ahttp.New(s, mockAuth{1}))
+	srv := httptest.NewUnstartedServer(ahttp.New(s, &test.MockAuth{Default: 1}))
 	srv.EnableHTTP2 = true
 	srv.StartTLS()
 	defer srv.Close()
@@ -30,7 +42,15 @@ func TestHandlerTestURLs(t *testing.T) {
 	}{
 		{"", http.StatusNotFound},
 		{"/system/autoupdate", http.StatusBadRequest},
+		{"/system/autoupdate?k=user/1/name,user/2/name", http.StatusOK},
+		{"/system/autoupdate?k=user/1", http.StatusBadRequest},
+		{"/system/autoupdate?request=" + url.QueryEscape(request), http.StatusOK},
+		{"/system/autoupdate?request=" + base64.RawURLEncoding.EncodeToString([]byte(request)), http.StatusOK},
+		{"/system/autoupdate?request=" + url.QueryEscape(base64.StdEncoding.EncodeToString([]byte(request))), http.StatusOK},
+		{"/system/autoupdate?request=invalid!", http.StatusBadRequest},
 		{"/system/autoupdate/keys?user/1/name", http.StatusOK},
+		{"/system/autoupdate/v2", http.StatusBadRequest},
+		{"/system/autoupdate/v2/keys?user/1/name", http.StatusOK},
 		{"/system/autoupdate/health", http.StatusOK},
 	}
 
@@ -65,7 +85,7 @@ func TestSimple(t *testing.T) {
 	closed := make(chan struct{})
 	defer close(closed)
 	s := autoupdate.New(new(test.MockDatastore), new(test.MockRestricter), closed)
-	srv := httptest.NewUnstartedServer(ahttp.New(s, mockAuth{1}))
+	srv := httptest.NewUnstartedServer(ahttp.New(s, &test.MockAuth{Default: 1}))
 	srv.EnableHTTP2 = true
 	srv.StartTLS()
 	defer srv.Close()
@@ -105,13 +125,17 @@ func TestSimple(t *testing.T) {
 			}
 
 			if tt.errMsg != "" {
-				var body map[string]map[string]string
+				var body map[string]map[string]interface{}
 				if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
 					t.Errorf("Got invalid json: %v", err)
 				}
 
 				if v := body["error"]["msg"]; v != tt.errMsg {
-					t.Errorf("Got error message `%s`, expected `%s`", v, tt.errMsg)
+					t.Errorf("Got error message `%v`, expected `%s`", v, tt.errMsg)
+				}
+
+				if v := body["error"]["code"]; v != float64(tt.status) {
+					t.Errorf("Got error code %v, expected %d", v, tt.status)
 				}
 				return
 			}
@@ -141,7 +165,7 @@ func TestErrors(t *testing.T) {
 	closed := make(chan struct{})
 	defer close(closed)
 	s := autoupdate.New(new(test.MockDatastore), new(test.MockRestricter), closed)
-	srv := httptest.NewUnstartedServer(ahttp.New(s, mockAuth{1}))
+	srv := httptest.NewUnstartedServer(ahttp.New(s, &test.MockAuth{Default: 1}))
 	srv.EnableHTTP2 = true
 	srv.StartTLS()
 	defer srv.Close()
@@ -217,7 +241,7 @@ func TestErrors(t *testing.T) {
 			)),
 			400,
 			`SyntaxError`,
-			"wrong type at field `ids`. Got string, expected number",
+			"wrong type at field `ids.0`. Got string, expected number",
 		},
 		{
 			"Wrong field value",
@@ -280,3 +304,1265 @@ func TestErrors(t *testing.T) {
 		})
 	}
 }
+
+func TestAuth(t *testing.T) {
+	closed := make(chan struct{})
+	defer close(closed)
+	s := autoupdate.New(new(test.MockDatastore), new(test.MockRestricter), closed)
+	auth := new(test.MockAuth)
+	auth.AddUser("valid", 1)
+	auth.AddUser("expired", 2)
+	auth.Expire("expired")
+	srv := httptest.NewUnstartedServer(ahttp.New(s, auth))
+	srv.EnableHTTP2 = true
+	srv.StartTLS()
+	defer srv.Close()
+
+	for _, tt := range []struct {
+		name   string
+		token  string
+		outage bool
+		status int
+	}{
+		{"anonymous", "", false, http.StatusOK},
+		{"valid token", "valid", false, http.StatusOK},
+		{"expired token", "expired", false, http.StatusBadRequest},
+		{"unknown token", "unknown", false, http.StatusBadRequest},
+		{"outage", "valid", true, http.StatusInternalServerError},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			auth.Outage(tt.outage)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/system/autoupdate/keys?user/1/name", nil)
+			if err != nil {
+				t.Fatalf("Can not create request: %v", err)
+			}
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+
+			resp, err := srv.Client().Do(req)
+			if err != nil {
+				t.Fatalf("Can not send request: %v", err)
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != tt.status {
+				t.Errorf("Got status %s, expected %s", resp.Status, http.StatusText(tt.status))
+			}
+		})
+	}
+}
+
+func TestModels(t *testing.T) {
+	closed := make(chan struct{})
+	defer close(closed)
+	s := autoupdate.New(new(test.MockDatastore), new(test.MockRestricter), closed)
+	models := []byte(`{"version":"v1","collections":{}}`)
+	srv := httptest.NewUnstartedServer(ahttp.New(s, &test.MockAuth{Default: 1}, ahttp.WithModels(models, "v1")))
+	srv.EnableHTTP2 = true
+	srv.StartTLS()
+	defer srv.Close()
+
+	for _, tt := range []struct {
+		name        string
+		ifNoneMatch string
+		status      int
+		body        string
+	}{
+		{"first request", "", http.StatusOK, string(models)},
+		{"same version", `"v1"`, http.StatusNotModified, ""},
+		{"other version", `"v0"`, http.StatusOK, string(models)},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, srv.URL+"/system/autoupdate/models", nil)
+			if err != nil {
+				t.Fatalf("Can not create request: %v", err)
+			}
+			if tt.ifNoneMatch != "" {
+				req.Header.Set("If-None-Match", tt.ifNoneMatch)
+			}
+
+			resp, err := srv.Client().Do(req)
+			if err != nil {
+				t.Fatalf("Can not send request: %v", err)
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != tt.status {
+				t.Errorf("Got status %s, expected %d", resp.Status, tt.status)
+			}
+			if got := resp.Header.Get("ETag"); got != `"v1"` {
+				t.Errorf("Got ETag %s, expected \"v1\"", got)
+			}
+			body, _ := ioutil.ReadAll(resp.Body)
+			if string(body) != tt.body {
+				t.Errorf("Got body `%s`, expected `%s`", body, tt.body)
+			}
+		})
+	}
+}
+
+func TestReadiness(t *testing.T) {
+	closed := make(chan struct{})
+	defer close(closed)
+	s := autoupdate.New(new(test.MockDatastore), new(test.MockRestricter), closed)
+
+	var readyErr error
+	handler := ahttp.New(s, &test.MockAuth{Default: 1}, ahttp.WithReadiness(func() error { return readyErr }))
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+
+	status := func(url string) int {
+		resp, err := http.Get(srv.URL + url)
+		if err != nil {
+			t.Fatalf("Can not send request: %v", err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	if got := status("/readyz"); got != http.StatusOK {
+		t.Errorf("Got status %d on ready service, expected 200", got)
+	}
+
+	readyErr = errors.New("datastore: \"reader\" is not available\nC:\\path")
+	if got := status("/readyz"); got != http.StatusServiceUnavailable {
+		t.Errorf("Got status %d with readiness error, expected 503", got)
+	}
+
+	resp, err := http.Get(srv.URL + "/readyz")
+	if err != nil {
+		t.Fatalf("Can not send request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		Ready  bool   `json:"ready"`
+		Reason string `json:"reason"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatalf("Got invalid json: %v", err)
+	}
+	if body.Ready || body.Reason != readyErr.Error() {
+		t.Errorf("Got %+v, expected not ready with reason %q", body, readyErr.Error())
+	}
+
+	readyErr = nil
+	handler.Drain()
+	if got := status("/readyz"); got != http.StatusServiceUnavailable {
+		t.Errorf("Got status %d on draining service, expected 503", got)
+	}
+
+	if got := status("/healthz"); got != http.StatusOK {
+		t.Errorf("Got status %d on /healthz, expected 200", got)
+	}
+}
+
+func TestHealth(t *testing.T) {
+	closed := make(chan struct{})
+	defer close(closed)
+	s := autoupdate.New(new(test.MockDatastore), new(test.MockRestricter), closed)
+
+	var messagingErr error
+	health := func() map[string]error {
+		return map[string]error{"datastore": nil, "messaging": messagingErr}
+	}
+	srv := httptest.NewServer(ahttp.New(s, &test.MockAuth{Default: 1}, ahttp.WithHealth(health)))
+	defer srv.Close()
+
+	for _, tt := range []struct {
+		name   string
+		err    error
+		status int
+		expect string
+	}{
+		{"healthy", nil, http.StatusOK, `{"healthy":true,"dependencies":{"datastore":{"healthy":true},"messaging":{"healthy":true}}}`},
+		{"unhealthy", errors.New("redis is not available"), http.StatusServiceUnavailable, `{"healthy":false,"dependencies":{"datastore":{"healthy":true},"messaging":{"healthy":false,"error":"redis is not available"}}}`},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			messagingErr = tt.err
+
+			resp, err := http.Get(srv.URL + "/system/autoupdate/health")
+			if err != nil {
+				t.Fatalf("Can not send request: %v", err)
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != tt.status {
+				t.Errorf("Got status %s, expected %d", resp.Status, tt.status)
+			}
+
+			body, err := ioutil.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatalf("Can not read body: %v", err)
+			}
+			if got := string(bytes.TrimSpace(body)); got != tt.expect {
+				t.Errorf("Got `%s`, expected `%s`", got, tt.expect)
+			}
+		})
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	closed := make(chan struct{})
+	defer close(closed)
+	s := autoupdate.New(new(test.MockDatastore), new(test.MockRestricter), closed)
+	srv := httptest.NewServer(ahttp.New(s, &test.MockAuth{Default: 1}))
+	defer srv.Close()
+
+	req, err := http.NewRequest(http.MethodGet, srv.URL+"/healthz", nil)
+	if err != nil {
+		t.Fatalf("Can not create request: %v", err)
+	}
+	req.Header.Set(trace.RequestIDHeader, "my-request")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Can not send request: %v", err)
+	}
+	resp.Body.Close()
+
+	if got := resp.Header.Get(trace.RequestIDHeader); got != "my-request" {
+		t.Errorf("Got request id `%s`, expected `my-request`", got)
+	}
+}
+
+func TestIPFilter(t *testing.T) {
+	network := func(cidr string) []*net.IPNet {
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			t.Fatalf("Invalid network %s: %v", cidr, err)
+		}
+		return []*net.IPNet{n}
+	}
+
+	for _, tt := range []struct {
+		name   string
+		allow  []*net.IPNet
+		deny   []*net.IPNet
+		status int
+	}{
+		{"no filter", nil, nil, http.StatusOK},
+		{"allowed", network("127.0.0.0/8"), nil, http.StatusOK},
+		{"not allowed", network("10.0.0.0/8"), nil, http.StatusForbidden},
+		{"denied", nil, network("127.0.0.1/32"), http.StatusForbidden},
+		{"allowed and denied", network("127.0.0.0/8"), network("127.0.0.1/32"), http.StatusForbidden},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			closed := make(chan struct{})
+			defer close(closed)
+			s := autoupdate.New(new(test.MockDatastore), new(test.MockRestricter), closed)
+			srv := httptest.NewUnstartedServer(ahttp.New(s, &test.MockAuth{Default: 1}, ahttp.WithIPFilter(tt.allow, tt.deny)))
+			srv.EnableHTTP2 = true
+			srv.StartTLS()
+			defer srv.Close()
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/system/autoupdate/keys?user/1/name", nil)
+			if err != nil {
+				t.Fatalf("Can not create request: %v", err)
+			}
+			resp, err := srv.Client().Do(req)
+			if err != nil {
+				t.Fatalf("Can not send request: %v", err)
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != tt.status {
+				t.Errorf("Got status %s, expected %d", resp.Status, tt.status)
+			}
+		})
+	}
+}
+
+func TestIPConnectionLimit(t *testing.T) {
+	closed := make(chan struct{})
+	defer close(closed)
+	s := autoupdate.New(new(test.MockDatastore), new(test.MockRestricter), closed)
+	srv := httptest.NewUnstartedServer(ahttp.New(s, &test.MockAuth{Default: 1}, ahttp.WithIPConnectionLimit(1)))
+	srv.EnableHTTP2 = true
+	srv.StartTLS()
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	connect := func() *http.Response {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/system/autoupdate/keys?user/1/name", nil)
+		if err != nil {
+			t.Fatalf("Can not create request: %v", err)
+		}
+		resp, err := srv.Client().Do(req)
+		if err != nil {
+			t.Fatalf("Can not send request: %v", err)
+		}
+		return resp
+	}
+
+	first := connect()
+	defer first.Body.Close()
+	if first.StatusCode != http.StatusOK {
+		t.Fatalf("Got status %s for the first connection, expected 200", first.Status)
+	}
+
+	second := connect()
+	defer second.Body.Close()
+	if second.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("Got status %s for the second connection, expected 429", second.Status)
+	}
+}
+
+func TestConnectionLimit(t *testing.T) {
+	closed := make(chan struct{})
+	defer close(closed)
+	s := autoupdate.New(new(test.MockDatastore), new(test.MockRestricter), closed)
+	srv := httptest.NewUnstartedServer(ahttp.New(s, &test.MockAuth{Default: 1}, ahttp.WithConnectionLimit(1)))
+	srv.EnableHTTP2 = true
+	srv.StartTLS()
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	connect := func() *http.Response {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/system/autoupdate/keys?user/1/name", nil)
+		if err != nil {
+			t.Fatalf("Can not create request: %v", err)
+		}
+		resp, err := srv.Client().Do(req)
+		if err != nil {
+			t.Fatalf("Can not send request: %v", err)
+		}
+		return resp
+	}
+
+	first := connect()
+	defer first.Body.Close()
+	if first.StatusCode != http.StatusOK {
+		t.Fatalf("Got status %s for the first connection, expected 200", first.Status)
+	}
+
+	second := connect()
+	defer second.Body.Close()
+	if second.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("Got status %s for the second connection, expected 503", second.Status)
+	}
+	if second.Header.Get("Retry-After") == "" {
+		t.Errorf("Got no Retry-After header")
+	}
+}
+
+func TestFirstResponseTimeout(t *testing.T) {
+	closed := make(chan struct{})
+	defer close(closed)
+	datastore := test.NewMockDatastore(test.WithLatency(time.Second))
+	s := autoupdate.New(datastore, new(test.MockRestricter), closed)
+	srv := httptest.NewUnstartedServer(ahttp.New(s, &test.MockAuth{Default: 1}, ahttp.WithFirstResponseTimeout(10*time.Millisecond)))
+	srv.EnableHTTP2 = true
+	srv.StartTLS()
+	defer srv.Close()
+
+	for _, tt := range []struct {
+		name string
+		body string
+	}{
+		{
+			"first data",
+			`[{"ids":[1],"collection":"user","fields":{"name":null}}]`,
+		},
+		{
+			"keysbuilder",
+			`[{"ids":[1],"collection":"user","fields":{"group_ids":{"type":"relation-list","collection":"group","fields":{"name":null}}}}]`,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+			defer cancel()
+
+			req, err := http.NewRequestWithContext(ctx, http.MethodPost, srv.URL+"/system/autoupdate", strings.NewReader(tt.body))
+			if err != nil {
+				t.Fatalf("Can not create request: %v", err)
+			}
+
+			resp, err := srv.Client().Do(req)
+			if err != nil {
+				t.Fatalf("Can not send request: %v", err)
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != http.StatusGatewayTimeout {
+				t.Errorf("Got status %s, expected 504", resp.Status)
+			}
+
+			var body struct {
+				Error struct {
+					Type string `json:"type"`
+					Code int    `json:"code"`
+				} `json:"error"`
+			}
+			if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+				t.Fatalf("Can not decode body: %v", err)
+			}
+
+			if body.Error.Type != "Timeout" || body.Error.Code != http.StatusGatewayTimeout {
+				t.Errorf("Got error %v, expected type Timeout with code 504", body.Error)
+			}
+		})
+	}
+}
+
+func TestHeadAndOptions(t *testing.T) {
+	closed := make(chan struct{})
+	defer close(closed)
+	s := autoupdate.New(new(test.MockDatastore), new(test.MockRestricter), closed)
+	auth := &test.MockAuth{Default: 1}
+	handler := ahttp.New(s, auth)
+	srv := httptest.NewUnstartedServer(handler)
+	srv.EnableHTTP2 = true
+	srv.StartTLS()
+	defer srv.Close()
+
+	for _, tt := range []struct {
+		name   string
+		method string
+		token  string
+		status int
+	}{
+		{"options", http.MethodOptions, "", http.StatusNoContent},
+		{"head", http.MethodHead, "", http.StatusOK},
+		{"head invalid token", http.MethodHead, "invalid", http.StatusBadRequest},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(tt.method, srv.URL+"/system/autoupdate/v2?k=user/1/name", nil)
+			if err != nil {
+				t.Fatalf("Can not create request: %v", err)
+			}
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+
+			resp, err := srv.Client().Do(req)
+			if err != nil {
+				t.Fatalf("Can not send request: %v", err)
+			}
+			resp.Body.Close()
+
+			if resp.StatusCode != tt.status {
+				t.Errorf("Got status %s, expected %d", resp.Status, tt.status)
+			}
+
+			if count := handler.ConnectionCount(); count != 0 {
+				t.Errorf("Got %d open connections, expected 0", count)
+			}
+
+			switch tt.method {
+			case http.MethodOptions:
+				if got := resp.Header.Get("Allow"); got != "GET, POST, HEAD, OPTIONS" {
+					t.Errorf("Got Allow header `%s`, expected `GET, POST, HEAD, OPTIONS`", got)
+				}
+			case http.MethodHead:
+				if got := resp.Header.Get(ahttp.CapabilitiesHeader); !strings.Contains(got, "nested") {
+					t.Errorf("Got capabilities `%s`, expected the capabilities of version 2", got)
+				}
+			}
+		})
+	}
+}
+
+func TestMaxBodySize(t *testing.T) {
+	closed := make(chan struct{})
+	defer close(closed)
+	s := autoupdate.New(new(test.MockDatastore), new(test.MockRestricter), closed)
+	srv := httptest.NewUnstartedServer(ahttp.New(s, &test.MockAuth{Default: 1}, ahttp.WithMaxBodySize(100)))
+	srv.EnableHTTP2 = true
+	srv.StartTLS()
+	defer srv.Close()
+
+	body := `[{"ids":[1],"collection":"user","fields":{"name":null}}]`
+	big := `[{"ids":[1],"collection":"user","fields":{"name":null,"` + strings.Repeat("x", 100) + `":null}}]`
+
+	for _, tt := range []struct {
+		name   string
+		body   io.Reader
+		status int
+	}{
+		{"small", strings.NewReader(body), http.StatusOK},
+		{"with content length", strings.NewReader(big), http.StatusRequestEntityTooLarge},
+		{"without content length", ioutil.NopCloser(strings.NewReader(big)), http.StatusRequestEntityTooLarge},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			req, err := http.NewRequestWithContext(ctx, http.MethodPost, srv.URL+"/system/autoupdate", tt.body)
+			if err != nil {
+				t.Fatalf("Can not create request: %v", err)
+			}
+
+			resp, err := srv.Client().Do(req)
+			if err != nil {
+				t.Fatalf("Can not send request: %v", err)
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != tt.status {
+				t.Errorf("Got status %s, expected %d", resp.Status, tt.status)
+			}
+		})
+	}
+}
+
+func TestCapabilities(t *testing.T) {
+	closed := make(chan struct{})
+	defer close(closed)
+	s := autoupdate.New(new(test.MockDatastore), new(test.MockRestricter), closed)
+	srv := httptest.NewUnstartedServer(ahttp.New(s, &test.MockAuth{Default: 1}))
+	srv.EnableHTTP2 = true
+	srv.StartTLS()
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/system/autoupdate/keys?user/1/name", nil)
+	if err != nil {
+		t.Fatalf("Can not create request: %v", err)
+	}
+	req.Header.Set(ahttp.CapabilitiesHeader, "nested, gzip, unknown")
+
+	// Setting the header manually tells the client to not decompress the body.
+	req.Header.Set("Accept-Encoding", "gzip")
+
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatalf("Can not send request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if got := resp.Header.Get(ahttp.CapabilitiesHeader); got != "nested,gzip" {
+		t.Errorf("Got capabilities `%s`, expected `nested,gzip`", got)
+	}
+
+	if got := resp.Header.Get("Content-Encoding"); got != "gzip" {
+		t.Errorf("Got content-encoding `%s`, expected `gzip`", got)
+	}
+
+	gz, err := gzip.NewReader(resp.Body)
+	if err != nil {
+		t.Fatalf("Can not read gzip body: %v", err)
+	}
+
+	var body map[string]map[string]map[string]json.RawMessage
+	if err := json.NewDecoder(gz).Decode(&body); err != nil {
+		t.Fatalf("Got invalid json: %v", err)
+	}
+
+	if _, ok := body["user"]["1"]["name"]; !ok {
+		t.Errorf("Got %v, expected nested key user/1/name", body)
+	}
+}
+
+func TestAcceptEncoding(t *testing.T) {
+	closed := make(chan struct{})
+	defer close(closed)
+	s := autoupdate.New(new(test.MockDatastore), new(test.MockRestricter), closed)
+	srv := httptest.NewUnstartedServer(ahttp.New(s, &test.MockAuth{Default: 1}, ahttp.WithAcceptEncoding()))
+	srv.EnableHTTP2 = true
+	srv.StartTLS()
+	defer srv.Close()
+
+	for _, tt := range []struct {
+		acceptEncoding string
+		expect         string
+	}{
+		{"gzip, deflate", "gzip"},
+		{"gzip;q=0", ""},
+	} {
+		t.Run(tt.acceptEncoding, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/system/autoupdate/keys?user/1/name", nil)
+			if err != nil {
+				t.Fatalf("Can not create request: %v", err)
+			}
+			req.Header.Set("Accept-Encoding", tt.acceptEncoding)
+
+			resp, err := srv.Client().Do(req)
+			if err != nil {
+				t.Fatalf("Can not send request: %v", err)
+			}
+			defer resp.Body.Close()
+
+			if got := resp.Header.Get("Content-Encoding"); got != tt.expect {
+				t.Errorf("Got content-encoding `%s`, expected `%s`", got, tt.expect)
+			}
+
+			var body io.Reader = resp.Body
+			if tt.expect == "gzip" {
+				gz, err := gzip.NewReader(resp.Body)
+				if err != nil {
+					t.Fatalf("Can not read gzip body: %v", err)
+				}
+				body = gz
+			}
+
+			var data map[string]json.RawMessage
+			if err := json.NewDecoder(body).Decode(&data); err != nil {
+				t.Fatalf("Got invalid json: %v", err)
+			}
+			if _, ok := data["user/1/name"]; !ok {
+				t.Errorf("Got %v, expected key user/1/name", data)
+			}
+		})
+	}
+}
+
+func TestWithEncoding(t *testing.T) {
+	closed := make(chan struct{})
+	defer close(closed)
+	s := autoupdate.New(new(test.MockDatastore), new(test.MockRestricter), closed)
+	deflate := func(w io.Writer) ahttp.Encoder {
+		fw, _ := flate.NewWriter(w, flate.BestSpeed)
+		return fw
+	}
+	srv := httptest.NewUnstartedServer(ahttp.New(s, &test.MockAuth{Default: 1}, ahttp.WithAcceptEncoding(), ahttp.WithEncoding("deflate", deflate)))
+	srv.EnableHTTP2 = true
+	srv.StartTLS()
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/system/autoupdate/keys?user/1/name", nil)
+	if err != nil {
+		t.Fatalf("Can not create request: %v", err)
+	}
+	req.Header.Set("Accept-Encoding", "gzip, deflate")
+
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatalf("Can not send request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if got := resp.Header.Get("Content-Encoding"); got != "deflate" {
+		t.Errorf("Got content-encoding `%s`, expected `deflate`", got)
+	}
+
+	var data map[string]json.RawMessage
+	if err := json.NewDecoder(flate.NewReader(resp.Body)).Decode(&data); err != nil {
+		t.Fatalf("Got invalid json: %v", err)
+	}
+	if _, ok := data["user/1/name"]; !ok {
+		t.Errorf("Got %v, expected key user/1/name", data)
+	}
+}
+
+func TestBrotliEncoding(t *testing.T) {
+	closed := make(chan struct{})
+	defer close(closed)
+	s := autoupdate.New(new(test.MockDatastore), new(test.MockRestricter), closed)
+	srv := httptest.NewUnstartedServer(ahttp.New(s, &test.MockAuth{Default: 1}, ahttp.WithAcceptEncoding(), ahttp.WithEncoding("br", ahttp.NewBrotliEncoder)))
+	srv.EnableHTTP2 = true
+	srv.StartTLS()
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/system/autoupdate/keys?user/1/name", nil)
+	if err != nil {
+		t.Fatalf("Can not create request: %v", err)
+	}
+	req.Header.Set("Accept-Encoding", "gzip, deflate, br")
+
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatalf("Can not send request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if got := resp.Header.Get("Content-Encoding"); got != "br" {
+		t.Errorf("Got content-encoding `%s`, expected `br`", got)
+	}
+
+	var data map[string]json.RawMessage
+	if err := json.NewDecoder(brotli.NewReader(resp.Body)).Decode(&data); err != nil {
+		t.Fatalf("Got invalid json: %v", err)
+	}
+	if _, ok := data["user/1/name"]; !ok {
+		t.Errorf("Got %v, expected key user/1/name", data)
+	}
+}
+
+func TestSnapshotEncoding(t *testing.T) {
+	closed := make(chan struct{})
+	defer close(closed)
+	s := autoupdate.New(new(test.MockDatastore), new(test.MockRestricter), closed)
+	srv := httptest.NewUnstartedServer(ahttp.New(s, &test.MockAuth{Default: 1}, ahttp.WithAcceptEncoding(), ahttp.WithEncoding("br", ahttp.NewBrotliEncoder)))
+	srv.EnableHTTP2 = true
+	srv.StartTLS()
+	defer srv.Close()
+
+	for _, tt := range []struct {
+		name           string
+		acceptEncoding string
+		expect         string
+		reader         func(io.Reader) (io.Reader, error)
+	}{
+		{
+			"brotli",
+			"gzip, br",
+			"br",
+			func(r io.Reader) (io.Reader, error) { return brotli.NewReader(r), nil },
+		},
+		{
+			"gzip",
+			"gzip",
+			"gzip",
+			func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) },
+		},
+		{
+			"uncompressed",
+			"identity",
+			"",
+			func(r io.Reader) (io.Reader, error) { return r, nil },
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, srv.URL+"/system/autoupdate/snapshot?k=user/1/name", nil)
+			if err != nil {
+				t.Fatalf("Can not create request: %v", err)
+			}
+			req.Header.Set("Accept-Encoding", tt.acceptEncoding)
+
+			resp, err := srv.Client().Do(req)
+			if err != nil {
+				t.Fatalf("Can not send request: %v", err)
+			}
+			defer resp.Body.Close()
+
+			if got := resp.Header.Get("Content-Encoding"); got != tt.expect {
+				t.Errorf("Got content-encoding `%s`, expected `%s`", got, tt.expect)
+			}
+
+			body, err := tt.reader(resp.Body)
+			if err != nil {
+				t.Fatalf("Can not read body: %v", err)
+			}
+
+			var snapshot autoupdate.Snapshot
+			if err := json.NewDecoder(body).Decode(&snapshot); err != nil {
+				t.Fatalf("Got invalid json: %v", err)
+			}
+			if _, ok := snapshot.Data["user/1/name"]; !ok {
+				t.Errorf("Got %v, expected key user/1/name", snapshot.Data)
+			}
+		})
+	}
+}
+
+func TestHTTP1(t *testing.T) {
+	closed := make(chan struct{})
+	defer close(closed)
+	s := autoupdate.New(new(test.MockDatastore), new(test.MockRestricter), closed)
+
+	for _, tt := range []struct {
+		name    string
+		options []ahttp.Option
+		status  int
+	}{
+		{"without option", nil, http.StatusBadRequest},
+		{"with option", []ahttp.Option{ahttp.WithHTTP1()}, http.StatusOK},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(ahttp.New(s, &test.MockAuth{Default: 1}, tt.options...))
+			defer srv.Close()
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/system/autoupdate/keys?user/1/name", nil)
+			if err != nil {
+				t.Fatalf("Can not create request: %v", err)
+			}
+
+			resp, err := srv.Client().Do(req)
+			if err != nil {
+				t.Fatalf("Can not send request: %v", err)
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != tt.status {
+				t.Errorf("Got status %s, expected %d", resp.Status, tt.status)
+			}
+		})
+	}
+}
+
+func TestKeepalive(t *testing.T) {
+	closed := make(chan struct{})
+	defer close(closed)
+	s := autoupdate.New(new(test.MockDatastore), new(test.MockRestricter), closed)
+	srv := httptest.NewUnstartedServer(ahttp.New(s, &test.MockAuth{Default: 1}, ahttp.WithKeepalive(time.Millisecond)))
+	srv.EnableHTTP2 = true
+	srv.StartTLS()
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/system/autoupdate/keys?user/1/name", nil)
+	if err != nil {
+		t.Fatalf("Can not create request: %v", err)
+	}
+
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatalf("Can not send request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	scanner := bufio.NewScanner(resp.Body)
+	for i, expect := range []string{`{"user/1/name":"Hello World"}`, `{}`, `{}`} {
+		if !scanner.Scan() {
+			t.Fatalf("Can not read message %d: %v", i, scanner.Err())
+		}
+		if got := scanner.Text(); got != expect {
+			t.Errorf("Got message %d `%s`, expected `%s`", i, got, expect)
+		}
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	closed := make(chan struct{})
+	defer close(closed)
+	s := autoupdate.New(new(test.MockDatastore), new(test.MockRestricter), closed)
+	handler := ahttp.New(s, &test.MockAuth{Default: 1})
+	srv := httptest.NewUnstartedServer(handler)
+	srv.EnableHTTP2 = true
+	srv.StartTLS()
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/system/autoupdate/keys?user/1/name", nil)
+	if err != nil {
+		t.Fatalf("Can not create request: %v", err)
+	}
+
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatalf("Can not send request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	scanner := bufio.NewScanner(resp.Body)
+	if !scanner.Scan() {
+		t.Fatalf("Can not read first message: %v", scanner.Err())
+	}
+
+	shutdownErr := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		shutdownErr <- handler.Shutdown(ctx)
+	}()
+
+	if !scanner.Scan() {
+		t.Fatalf("Can not read reconnect message: %v", scanner.Err())
+	}
+	var msg map[string]json.RawMessage
+	if err := json.Unmarshal(scanner.Bytes(), &msg); err != nil {
+		t.Fatalf("Got invalid json `%s`: %v", scanner.Bytes(), err)
+	}
+	if _, ok := msg["reconnect"]; !ok {
+		t.Errorf("Got message `%s`, expected a reconnect message", scanner.Bytes())
+	}
+
+	// The client closes the connection, so Shutdown returns without an error.
+	cancel()
+	if err := <-shutdownErr; err != nil {
+		t.Errorf("Shutdown returned: %v", err)
+	}
+}
+
+func TestCORS(t *testing.T) {
+	closed := make(chan struct{})
+	defer close(closed)
+	s := autoupdate.New(new(test.MockDatastore), new(test.MockRestricter), closed)
+	srv := httptest.NewUnstartedServer(ahttp.New(s, &test.MockAuth{Default: 1}, ahttp.WithCORS([]string{"https://example.com"}, nil, nil)))
+	srv.EnableHTTP2 = true
+	srv.StartTLS()
+	defer srv.Close()
+
+	for _, tt := range []struct {
+		name          string
+		method        string
+		origin        string
+		status        int
+		allowOrigin   string
+		allowsMethods bool
+	}{
+		{"preflight", http.MethodOptions, "https://example.com", http.StatusNoContent, "https://example.com", true},
+		{"preflight from other origin", http.MethodOptions, "https://other.com", http.StatusNoContent, "", false},
+		{"request", http.MethodGet, "https://example.com", http.StatusOK, "https://example.com", false},
+		{"request from other origin", http.MethodGet, "https://other.com", http.StatusOK, "", false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			req, err := http.NewRequestWithContext(ctx, tt.method, srv.URL+"/system/autoupdate", nil)
+			if err != nil {
+				t.Fatalf("Can not create request: %v", err)
+			}
+			req.Header.Set("Origin", tt.origin)
+			if tt.method == http.MethodOptions {
+				req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+			} else {
+				req.URL.Path += "/keys"
+				req.URL.RawQuery = "user/1/name"
+			}
+
+			resp, err := srv.Client().Do(req)
+			if err != nil {
+				t.Fatalf("Can not send request: %v", err)
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != tt.status {
+				t.Errorf("Got status %s, expected %d", resp.Status, tt.status)
+			}
+
+			if got := resp.Header.Get("Access-Control-Allow-Origin"); got != tt.allowOrigin {
+				t.Errorf("Got Access-Control-Allow-Origin `%s`, expected `%s`", got, tt.allowOrigin)
+			}
+
+			if got := resp.Header.Get("Access-Control-Allow-Methods") != ""; got != tt.allowsMethods {
+				t.Errorf("Got Access-Control-Allow-Methods: %t, expected %t", got, tt.allowsMethods)
+			}
+		})
+	}
+}
+
+func TestSingleRequest(t *testing.T) {
+	closed := make(chan struct{})
+	defer close(closed)
+	s := autoupdate.New(new(test.MockDatastore), new(test.MockRestricter), closed)
+	srv := httptest.NewUnstartedServer(ahttp.New(s, &test.MockAuth{Default: 1}))
+	srv.EnableHTTP2 = true
+	srv.StartTLS()
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/system/autoupdate/keys?user/1/name", nil)
+	if err != nil {
+		t.Fatalf("Can not create request: %v", err)
+	}
+	req.Header.Set(ahttp.CapabilitiesHeader, "single")
+
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatalf("Can not send request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	// ReadAll only returns, when the server closes the connection.
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("Can not read body: %v", err)
+	}
+
+	expect := `{"user/1/name":"Hello World"}` + "\n"
+	if got := string(body); got != expect {
+		t.Errorf("Got `%s`, expected `%s`", got, expect)
+	}
+}
+
+func TestSingleRequestETag(t *testing.T) {
+	closed := make(chan struct{})
+	defer close(closed)
+	s := autoupdate.New(new(test.MockDatastore), new(test.MockRestricter), closed)
+	srv := httptest.NewUnstartedServer(ahttp.New(s, &test.MockAuth{Default: 1}))
+	srv.EnableHTTP2 = true
+	srv.StartTLS()
+	defer srv.Close()
+
+	get := func(etag string) *http.Response {
+		req, err := http.NewRequest(http.MethodGet, srv.URL+"/system/autoupdate/keys?user/1/name,user/2/name,user/3/name,motion/1/title,motion/2/title", nil)
+		if err != nil {
+			t.Fatalf("Can not create request: %v", err)
+		}
+		req.Header.Set(ahttp.CapabilitiesHeader, "single")
+		if etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+
+		resp, err := srv.Client().Do(req)
+		if err != nil {
+			t.Fatalf("Can not send request: %v", err)
+		}
+		ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		return resp
+	}
+
+	etag := get("").Header.Get("ETag")
+	if etag == "" {
+		t.Fatalf("Response has no ETag")
+	}
+
+	// The data is a map. Repeat the request, so a random order of the keys
+	// would be found.
+	for i := 0; i < 20; i++ {
+		if resp := get(etag); resp.StatusCode != http.StatusNotModified {
+			t.Fatalf("Got status %s with the same ETag, expected 304", resp.Status)
+		}
+	}
+
+	if resp := get(`"other"`); resp.StatusCode != http.StatusOK {
+		t.Errorf("Got status %s with another ETag, expected 200", resp.Status)
+	}
+}
+
+func TestTypedMessages(t *testing.T) {
+	closed := make(chan struct{})
+	defer close(closed)
+	s := autoupdate.New(new(test.MockDatastore), new(test.MockRestricter), closed)
+	srv := httptest.NewUnstartedServer(ahttp.New(s, &test.MockAuth{Default: 1}))
+	srv.EnableHTTP2 = true
+	srv.StartTLS()
+	defer srv.Close()
+
+	req, err := http.NewRequest(http.MethodGet, srv.URL+"/system/autoupdate/v2/keys?user/1/name", nil)
+	if err != nil {
+		t.Fatalf("Can not create request: %v", err)
+	}
+	req.Header.Set(ahttp.CapabilitiesHeader, "typed, single")
+
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatalf("Can not send request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if got := resp.Header.Get("Content-Type"); got != "application/x-ndjson" {
+		t.Errorf("Got content-type %s, expected application/x-ndjson", got)
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("Can not read body: %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(string(body)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Got %d lines, expected a data and a position message: %s", len(lines), body)
+	}
+
+	if expect := `{"type":"data","data":{"user":{"1":{"name":"Hello World"}}}}`; lines[0] != expect {
+		t.Errorf("Got `%s`, expected `%s`", lines[0], expect)
+	}
+
+	var position struct {
+		Type     string `json:"type"`
+		Position uint64 `json:"position"`
+	}
+	if err := json.Unmarshal([]byte(lines[1]), &position); err != nil {
+		t.Fatalf("Can not decode position message `%s`: %v", lines[1], err)
+	}
+	if position.Type != "position" {
+		t.Errorf("Got message type `%s`, expected `position`", position.Type)
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	closed := make(chan struct{})
+	defer close(closed)
+	s := autoupdate.New(new(test.MockDatastore), new(test.MockRestricter), closed)
+	handler := ahttp.New(s, &test.MockAuth{Default: 1}, ahttp.WithMetrics())
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/healthz")
+	if err != nil {
+		t.Fatalf("Can not send request: %v", err)
+	}
+	resp.Body.Close()
+
+	var buf bytes.Buffer
+	handler.WriteMetrics(&buf)
+
+	for _, expect := range []string{
+		"autoupdate_connections 0\n",
+		`autoupdate_http_requests_total{path="/healthz",code="200"} 1` + "\n",
+		`autoupdate_http_request_duration_seconds_count{path="/healthz"} 1` + "\n",
+		`autoupdate_http_response_size_bytes_sum{path="/healthz"} 18` + "\n",
+	} {
+		if !strings.Contains(buf.String(), expect) {
+			t.Errorf("Metrics do not contain `%s`:\n%s", strings.TrimSpace(expect), buf.String())
+		}
+	}
+}
+
+// lineWriter sends each written line to a channel.
+type lineWriter chan []byte
+
+func (w lineWriter) Write(p []byte) (int, error) {
+	w <- append([]byte(nil), p...)
+	return len(p), nil
+}
+
+func TestAccessLog(t *testing.T) {
+	closed := make(chan struct{})
+	defer close(closed)
+	s := autoupdate.New(new(test.MockDatastore), new(test.MockRestricter), closed)
+	lines := make(lineWriter, 1)
+	srv := httptest.NewUnstartedServer(ahttp.New(s, &test.MockAuth{Default: 1}, ahttp.WithAccessLog(lines)))
+	srv.EnableHTTP2 = true
+	srv.StartTLS()
+	defer srv.Close()
+
+	req, err := http.NewRequest(http.MethodGet, srv.URL+"/system/autoupdate/keys?user/1/name", nil)
+	if err != nil {
+		t.Fatalf("Can not create request: %v", err)
+	}
+	req.Header.Set(ahttp.CapabilitiesHeader, "single")
+	req.Header.Set(trace.RequestIDHeader, "my-request")
+
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatalf("Can not send request: %v", err)
+	}
+	if _, err := ioutil.ReadAll(resp.Body); err != nil {
+		t.Fatalf("Can not read body: %v", err)
+	}
+	resp.Body.Close()
+
+	var line []byte
+	select {
+	case line = <-lines:
+	case <-time.After(time.Second):
+		t.Fatalf("No access log line was written")
+	}
+
+	var record struct {
+		RequestID string `json:"request_id"`
+		Path      string `json:"path"`
+		Status    int    `json:"status"`
+		UserID    int    `json:"user_id"`
+		Messages  int    `json:"messages"`
+		Bytes     int    `json:"bytes"`
+		Reason    string `json:"close_reason"`
+	}
+	if err := json.Unmarshal(line, &record); err != nil {
+		t.Fatalf("Can not decode access log line `%s`: %v", line, err)
+	}
+
+	if record.RequestID != "my-request" || record.Path != "/system/autoupdate/keys" || record.Status != 200 {
+		t.Errorf("Got request `%s`, path `%s`, status %d", record.RequestID, record.Path, record.Status)
+	}
+
+	if record.UserID != 1 || record.Messages != 1 || record.Bytes == 0 {
+		t.Errorf("Got user %d, %d messages and %d bytes, expected user 1, 1 message and some bytes", record.UserID, record.Messages, record.Bytes)
+	}
+
+	if record.Reason != "done" {
+		t.Errorf("Got close reason `%s`, expected `done`", record.Reason)
+	}
+}
+
+func TestResolve(t *testing.T) {
+	closed := make(chan struct{})
+	defer close(closed)
+	s := autoupdate.New(new(test.MockDatastore), new(test.MockRestricter), closed)
+	srv := httptest.NewUnstartedServer(ahttp.New(s, &test.MockAuth{Default: 1}))
+	srv.EnableHTTP2 = true
+	srv.StartTLS()
+	defer srv.Close()
+
+	for _, tt := range []struct {
+		name   string
+		body   string
+		status int
+		keys   []string
+	}{
+		{
+			"relation",
+			`[{"ids":[1],"collection":"user","fields":{"name":null,"note_id":{"type":"relation","collection":"note","fields":{"important":null}}}}]`,
+			http.StatusOK,
+			[]string{"note/1/important", "user/1/name", "user/1/note_id"},
+		},
+		{
+			"invalid",
+			`[{"ids":[1],"collection":"user"}]`,
+			http.StatusBadRequest,
+			nil,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := srv.Client().Post(srv.URL+"/system/autoupdate/resolve", "application/json", strings.NewReader(tt.body))
+			if err != nil {
+				t.Fatalf("Can not send request: %v", err)
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != tt.status {
+				t.Fatalf("Got status %s, expected %d", resp.Status, tt.status)
+			}
+
+			if tt.status != http.StatusOK {
+				return
+			}
+
+			var got struct {
+				Keys []string `json:"keys"`
+			}
+			if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+				t.Fatalf("Can not decode response: %v", err)
+			}
+
+			if strings.Join(got.Keys, ",") != strings.Join(tt.keys, ",") {
+				t.Errorf("Got keys %v, expected %v", got.Keys, tt.keys)
+			}
+		})
+	}
+}
+
+func TestLimitsOnAutoupdateURLs(t *testing.T) {
+	for _, path := range []string{
+		"/system/autoupdate",
+		"/system/autoupdate/v2",
+		"/system/autoupdate/ws",
+		"/system/autoupdate/v2/ws",
+		"/system/autoupdate/snapshot",
+		"/system/autoupdate/resolve",
+		"/system/autoupdate/history",
+		"/system/autoupdate/history/positions",
+		"/system/autoupdate/history/data",
+	} {
+		t.Run(path, func(t *testing.T) {
+			closed := make(chan struct{})
+			defer close(closed)
+			s := autoupdate.New(new(test.MockDatastore), new(test.MockRestricter), closed)
+			srv := httptest.NewUnstartedServer(ahttp.New(s, &test.MockAuth{Default: 1}, ahttp.WithIPRateLimit(0.001, 1)))
+			srv.EnableHTTP2 = true
+			srv.StartTLS()
+			defer srv.Close()
+
+			var status int
+			for i := 0; i < 2; i++ {
+				resp, err := srv.Client().Post(srv.URL+path, "application/json", strings.NewReader(`[{"ids":[1],"collection":"user","fields":{"name":null}}]`))
+				if err != nil {
+					t.Fatalf("Can not send request: %v", err)
+				}
+				resp.Body.Close()
+				status = resp.StatusCode
+			}
+
+			if status != http.StatusTooManyRequests {
+				t.Errorf("Got status %d for the second request, expected 429", status)
+			}
+		})
+	}
+}
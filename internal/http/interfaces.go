@@ -11,6 +11,14 @@ type Authenticator interface {
 	Authenticate(context.Context, *http.Request) (int, error)
 }
 
+// SessionAuthenticator can be implemented by an Authenticator. It returns the
+// id of the session of the request together with the user id. The session id
+// is used to close all connections of a session. An empty string means, that
+// the request has no session.
+type SessionAuthenticator interface {
+	AuthenticateSession(context.Context, *http.Request) (uid int, session string, err error)
+}
+
 // DefinedError is an expected error that are returned to the client.
 type DefinedError interface {
 	Type() string
@@ -0,0 +1,62 @@
+package http
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// InternalHandler returns a handler for other backend services. It is meant
+// to be served on an internal address. The requests are not authenticated, so
+// it must not be reachable by the clients.
+//
+// POST /data?user_id=5 returns the restricted data of the keysbuilder in the
+// request body for the user. It does not open a connection. The response has
+// the same format as the url /system/autoupdate/snapshot, but is not
+// compressed. Instead of the body, the argument k can be used as comma
+// separated list of keys. The user_id 0 is the anonymous user.
+func (h *Handler) InternalHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/data", errHandleFunc(h.internalData))
+	return mux
+}
+
+// internalData returns the restricted data for the user in the argument
+// user_id.
+func (h *Handler) internalData(w http.ResponseWriter, r *http.Request) error {
+	if !(r.Method == http.MethodPost || r.Method == http.MethodGet) {
+		return invalidRequestError{errors.New("only GET or POST requests are supported")}
+	}
+
+	v := r.URL.Query().Get("user_id")
+	if v == "" {
+		return invalidRequestError{errors.New("the argument user_id is required")}
+	}
+	uid, err := strconv.Atoi(v)
+	if err != nil || uid < 0 {
+		return invalidRequestError{fmt.Errorf("invalid user_id: %q", v)}
+	}
+
+	if h.maxBodySize > 0 {
+		r.Body = &limitedBody{ReadCloser: r.Body, max: h.maxBodySize}
+	}
+
+	tid := h.s.LastID()
+	kb, err := h.complex(r, uid)
+	if err != nil {
+		return fmt.Errorf("build keysbuilder: %w", err)
+	}
+
+	snapshot, err := h.s.Snapshot(r.Context(), uid, kb, tid)
+	if err != nil {
+		return fmt.Errorf("create snapshot: %w", err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(snapshot); err != nil {
+		return fmt.Errorf("encoding snapshot: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package http_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/openslides/openslides-autoupdate-service/internal/autoupdate"
+	ahttp "github.com/openslides/openslides-autoupdate-service/internal/http"
+	"github.com/openslides/openslides-autoupdate-service/internal/test"
+)
+
+// userRestricter removes all values, that the user can not see. A user can
+// only see the keys of the own user object.
+type userRestricter struct{}
+
+func (userRestricter) Restrict(ctx context.Context, uid int, data map[string]json.RawMessage) error {
+	for k := range data {
+		if !strings.HasPrefix(k, "user/"+strconv.Itoa(uid)+"/") {
+			data[k] = nil
+		}
+	}
+	return nil
+}
+
+func TestInternalData(t *testing.T) {
+	closed := make(chan struct{})
+	defer close(closed)
+	s := autoupdate.New(new(test.MockDatastore), userRestricter{}, closed)
+	handler := ahttp.New(s, &test.MockAuth{Default: 1})
+	srv := httptest.NewServer(handler.InternalHandler())
+	defer srv.Close()
+
+	for _, tt := range []struct {
+		name   string
+		url    string
+		status int
+		data   map[string]json.RawMessage
+	}{
+		{
+			"user 1",
+			"/data?user_id=1",
+			http.StatusOK,
+			map[string]json.RawMessage{"user/1/name": []byte(`"Hello World"`)},
+		},
+		{
+			"user 2",
+			"/data?user_id=2",
+			http.StatusOK,
+			map[string]json.RawMessage{"user/2/name": []byte(`"Hello World"`)},
+		},
+		{
+			"without user",
+			"/data",
+			http.StatusBadRequest,
+			nil,
+		},
+		{
+			"invalid user",
+			"/data?user_id=hugo",
+			http.StatusBadRequest,
+			nil,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			body := `[{"ids":[1,2],"collection":"user","fields":{"name":null}}]`
+			resp, err := http.Post(srv.URL+tt.url, "application/json", strings.NewReader(body))
+			if err != nil {
+				t.Fatalf("Can not send request: %v", err)
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != tt.status {
+				t.Fatalf("Got status %s, expected %d", resp.Status, tt.status)
+			}
+
+			if tt.data == nil {
+				return
+			}
+
+			var snapshot autoupdate.Snapshot
+			if err := json.NewDecoder(resp.Body).Decode(&snapshot); err != nil {
+				t.Fatalf("Can not decode response: %v", err)
+			}
+
+			if len(snapshot.Data) != len(tt.data) {
+				t.Errorf("Got data %v, expected %v", snapshot.Data, tt.data)
+			}
+			for k, v := range tt.data {
+				if string(snapshot.Data[k]) != string(v) {
+					t.Errorf("Got %s=%s, expected %s", k, snapshot.Data[k], v)
+				}
+			}
+		})
+	}
+}
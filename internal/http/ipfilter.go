@@ -0,0 +1,58 @@
+package http
+
+import (
+	"net"
+	"net/http"
+)
+
+// ipFilter decides, which client addresses are allowed.
+//
+// An address in deny is always rejected. If allow is not empty, only addresses
+// in allow are accepted.
+type ipFilter struct {
+	allow []*net.IPNet
+	deny  []*net.IPNet
+}
+
+// allowed tells, if the given remote address (ip:port) is allowed.
+func (f *ipFilter) allowed(remoteAddr string) bool {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+
+	if containsIP(f.deny, ip) {
+		return false
+	}
+	return len(f.allow) == 0 || containsIP(f.allow, ip)
+}
+
+// middleware rejects requests from addresses that are not allowed. It is
+// called before the authentication.
+func (f *ipFilter) middleware(h http.Handler) http.Handler {
+	if f == nil {
+		return h
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !f.allowed(r.RemoteAddr) {
+			writeError(w, http.StatusForbidden, errTypeForbidden, "Your address is not allowed")
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+func containsIP(nets []*net.IPNet, ip net.IP) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,86 @@
+package http
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// keepaliveMessage is sent on a connection, that had no message for the
+// keepalive interval. It is an empty update, so clients can handle it like
+// any other message.
+var keepaliveMessage = []byte("{}\n")
+
+// typedKeepaliveMessage is the keepalive message with the capability typed.
+var typedKeepaliveMessage = []byte(`{"type":"` + messageKeepalive + `"}` + "\n")
+
+// keepalive writes a keepalive message to a connection, if nothing was
+// written for some time. Some load balancers close connections without traffic.
+//
+// All writes to the connection have to be done with write, so the keepalive
+// message is never written in the middle of another message.
+type keepalive struct {
+	mu      sync.Mutex
+	w       http.ResponseWriter
+	message []byte
+	last    time.Time
+}
+
+func newKeepalive(w http.ResponseWriter, message []byte) *keepalive {
+	return &keepalive{w: w, message: message, last: time.Now()}
+}
+
+// write calls fn while no keepalive message can be written. It is save to
+// call write on a nil keepalive.
+func (k *keepalive) write(fn func() error) error {
+	if k == nil {
+		return fn()
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.last = time.Now()
+	return fn()
+}
+
+// run writes the keepalive message, if there was no other write for the interval.
+// If the message can not be written, the connection is closed with cancel.
+// Blocks until the context is done.
+func (k *keepalive) run(ctx context.Context, interval time.Duration, cancel context.CancelFunc) {
+	timer := time.NewTimer(interval)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+
+		wait, err := k.send(interval)
+		if err != nil {
+			cancel()
+			return
+		}
+		timer.Reset(wait)
+	}
+}
+
+// send writes the keepalive message, if there was no write for the interval. It
+// returns the time until the next message is due.
+func (k *keepalive) send(interval time.Duration) (time.Duration, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if wait := interval - time.Since(k.last); wait > 0 {
+		return wait, nil
+	}
+
+	if _, err := k.w.Write(k.message); err != nil {
+		return 0, err
+	}
+	k.w.(http.Flusher).Flush()
+	k.last = time.Now()
+	return interval, nil
+}
@@ -0,0 +1,130 @@
+package http
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// connLimitRetryAfter is the time, after which a client should try again, when
+// the service has reached its connection limit.
+const connLimitRetryAfter = 10 * time.Second
+
+// connLimit limits the number of open autoupdate connections of the process.
+type connLimit struct {
+	conns int64
+	max   int64
+}
+
+// middleware rejects requests with the status 503, if the service has too many
+// open connections. The header Retry-After tells the client, when to try
+// again. The connection is counted until the next handler returns.
+func (l *connLimit) middleware(h http.Handler) http.Handler {
+	if l == nil {
+		return h
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer atomic.AddInt64(&l.conns, -1)
+		if atomic.AddInt64(&l.conns, 1) > l.max {
+			w.Header().Set("Retry-After", strconv.Itoa(int(connLimitRetryAfter.Seconds())))
+			writeError(w, http.StatusServiceUnavailable, errTypeTooMany, "The service has reached its maximum of connections")
+			return
+		}
+
+		h.ServeHTTP(w, r)
+	})
+}
+
+// ipLimit limits the number of open autoupdate connections per client
+// address.
+type ipLimit struct {
+	max int
+
+	mu    sync.Mutex
+	conns map[string]int
+}
+
+// acquire registers a connection of the address. Returns false, if the
+// address has reached the limit.
+func (l *ipLimit) acquire(ip string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.conns == nil {
+		l.conns = make(map[string]int)
+	}
+
+	if l.conns[ip] >= l.max {
+		return false
+	}
+	l.conns[ip]++
+	return true
+}
+
+// release unregisters a connection of the address.
+func (l *ipLimit) release(ip string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.conns[ip]--
+	if l.conns[ip] <= 0 {
+		delete(l.conns, ip)
+	}
+}
+
+// middleware rejects requests with the status 429, if the client address has
+// too many open connections. The connection is counted until the next handler
+// returns.
+func (l *ipLimit) middleware(h http.Handler) http.Handler {
+	if l == nil {
+		return h
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip := remoteIP(r)
+		if !l.acquire(ip) {
+			writeError(w, http.StatusTooManyRequests, errTypeTooMany, fmt.Sprintf("%s has reached the maximum of %d connections", ip, l.max))
+			return
+		}
+		defer l.release(ip)
+
+		h.ServeHTTP(w, r)
+	})
+}
+
+// remoteIP returns the address of the client without the port.
+func remoteIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return ip
+}
+
+// limitedBody is a request body, that returns a bodyTooLargeError, if more
+// then max bytes are read.
+//
+// The bytes after max are not returned. Otherwise a json decoder could decode
+// a complete value and ignore the error.
+type limitedBody struct {
+	io.ReadCloser
+	max  int64
+	read int64
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if b.read+int64(n) > b.max {
+		n = int(b.max - b.read)
+		b.read = b.max
+		return n, bodyTooLargeError{max: b.max}
+	}
+	b.read += int64(n)
+	return n, err
+}
@@ -0,0 +1,117 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/openslides/openslides-autoupdate-service/internal/autoupdate"
+)
+
+// unknownMeeting is used for connections, where no meeting could be found.
+// For example the connections of the anonymous user or of a user without
+// meetings.
+const unknownMeeting = 0
+
+// connectionMeetings returns the ids of the meetings of a connection.
+//
+// They are the meetings of the keys `meeting/<id>/...`, that the keysbuilder
+// resolved. The ids of these keys come from the request of the client, so only
+// the meetings, that exist and that the user can see, are used. If the keys
+// contain no meeting, the meetings of the user are used.
+//
+// If there is no meeting at all, unknownMeeting is returned.
+func (h *Handler) connectionMeetings(ctx context.Context, uid int, kb autoupdate.KeysBuilder) ([]int, error) {
+	requested := make(map[int]bool)
+	for _, key := range kb.Keys() {
+		if id := meetingOfKey(key); id != 0 {
+			requested[id] = true
+		}
+	}
+
+	var meetings []int
+	if len(requested) > 0 {
+		keys := make([]string, 0, len(requested))
+		for id := range requested {
+			keys = append(keys, fmt.Sprintf("meeting/%d/id", id))
+		}
+
+		data, err := h.s.RestrictedData(ctx, uid, keys...)
+		if err != nil {
+			return nil, fmt.Errorf("get requested meetings: %w", err)
+		}
+
+		for id := range requested {
+			if data[fmt.Sprintf("meeting/%d/id", id)] != nil {
+				meetings = append(meetings, id)
+			}
+		}
+	}
+
+	if len(meetings) == 0 && uid != 0 {
+		var err error
+		meetings, err = h.userMeetings(ctx, uid)
+		if err != nil {
+			return nil, fmt.Errorf("get meetings of user %d: %w", uid, err)
+		}
+	}
+
+	if len(meetings) == 0 {
+		return []int{unknownMeeting}, nil
+	}
+
+	sort.Ints(meetings)
+	return meetings, nil
+}
+
+// userMeetings returns the ids of the meetings, where the user is in a group.
+func (h *Handler) userMeetings(ctx context.Context, uid int) ([]int, error) {
+	key := fmt.Sprintf("user/%d/group_$_ids", uid)
+	data, err := h.s.RestrictedData(ctx, uid, key)
+	if err != nil {
+		return nil, fmt.Errorf("get %s: %w", key, err)
+	}
+
+	if data[key] == nil {
+		return nil, nil
+	}
+
+	// Template fields hold the replacements as strings.
+	var replacements []string
+	if err := json.Unmarshal(data[key], &replacements); err != nil {
+		return nil, fmt.Errorf("decoding %s: %w", key, err)
+	}
+
+	meetings := make([]int, 0, len(replacements))
+	for _, r := range replacements {
+		id, err := strconv.Atoi(r)
+		if err != nil || id <= 0 {
+			return nil, fmt.Errorf("invalid meeting id %q in %s", r, key)
+		}
+		meetings = append(meetings, id)
+	}
+	return meetings, nil
+}
+
+// meetingOfKey returns the id of a key `meeting/<id>/field`. Returns 0 for all
+// other keys.
+func meetingOfKey(key string) int {
+	rest := strings.TrimPrefix(key, "meeting/")
+	if rest == key {
+		return 0
+	}
+
+	idx := strings.IndexByte(rest, '/')
+	if idx == -1 {
+		return 0
+	}
+
+	id, err := strconv.Atoi(rest[:idx])
+	if err != nil || id <= 0 {
+		return 0
+	}
+	return id
+}
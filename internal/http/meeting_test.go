@@ -0,0 +1,85 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/openslides/openslides-autoupdate-service/internal/autoupdate"
+	"github.com/openslides/openslides-autoupdate-service/internal/test"
+)
+
+type staticKeys []string
+
+func (k staticKeys) Update(context.Context) error { return nil }
+func (k staticKeys) Keys() []string               { return k }
+
+func TestConnectionMeetings(t *testing.T) {
+	closed := make(chan struct{})
+	defer close(closed)
+
+	datastore := test.NewMockDatastore(test.WithOnlyData(), test.WithData(map[string]json.RawMessage{
+		"meeting/1/id":          []byte(`1`),
+		"meeting/2/id":          []byte(`2`),
+		"user/5/group_$_ids":    []byte(`["3"]`),
+		"user/6/group_$_ids":    []byte(`[]`),
+		"motion/1/title":        []byte(`"title"`),
+		"meeting/1/name":        []byte(`"name"`),
+		"meeting/2/name":        []byte(`"name"`),
+		"meeting/404/name":      []byte(`"name"`),
+		"user/5/username":       []byte(`"user"`),
+		"user/6/username":       []byte(`"user"`),
+		"meeting/1/motions_ids": []byte(`[1]`),
+	}))
+	h := New(autoupdate.New(datastore, new(test.MockRestricter), closed), new(test.MockAuth))
+
+	for _, tt := range []struct {
+		name   string
+		uid    int
+		keys   []string
+		expect []int
+	}{
+		{
+			"meetings of the keys",
+			5,
+			[]string{"meeting/2/name", "meeting/1/name", "meeting/1/motions_ids", "motion/1/title"},
+			[]int{1, 2},
+		},
+		{
+			"not existing meeting",
+			5,
+			[]string{"meeting/404/name"},
+			[]int{3},
+		},
+		{
+			"meetings of the user",
+			5,
+			[]string{"motion/1/title"},
+			[]int{3},
+		},
+		{
+			"user without meetings",
+			6,
+			[]string{"user/6/username"},
+			[]int{unknownMeeting},
+		},
+		{
+			"anonymous",
+			0,
+			[]string{"motion/1/title"},
+			[]int{unknownMeeting},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := h.connectionMeetings(context.Background(), tt.uid, staticKeys(tt.keys))
+			if err != nil {
+				t.Fatalf("connectionMeetings returned unexpected error: %v", err)
+			}
+
+			if !reflect.DeepEqual(got, tt.expect) {
+				t.Errorf("Got meetings %v, expected %v", got, tt.expect)
+			}
+		})
+	}
+}
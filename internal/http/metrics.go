@@ -0,0 +1,205 @@
+package http
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// The upper bounds of the histogram buckets. Autoupdate connections are open
+// for a long time, so the durations go up to one hour.
+var (
+	durationBuckets = []float64{0.005, 0.01, 0.05, 0.1, 0.5, 1, 5, 10, 60, 300, 3600}
+	sizeBuckets     = []float64{100, 1000, 10_000, 100_000, 1_000_000, 10_000_000}
+)
+
+// metrics counts the requests to the handler.
+type metrics struct {
+	mu     sync.Mutex
+	routes map[string]*routeMetrics
+}
+
+// routeMetrics are the metrics of one url pattern of the handler.
+type routeMetrics struct {
+	codes    map[int]uint64
+	duration histogram
+	size     histogram
+}
+
+// histogram counts observations in buckets like a prometheus histogram.
+type histogram struct {
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+func (h *histogram) observe(bounds []float64, v float64) {
+	if h.counts == nil {
+		h.counts = make([]uint64, len(bounds))
+	}
+
+	for i, bound := range bounds {
+		if v <= bound {
+			h.counts[i]++
+		}
+	}
+	h.sum += v
+	h.count++
+}
+
+// write writes the histogram in the prometheus text format.
+func (h *histogram) write(w io.Writer, name string, bounds []float64, route string) {
+	for i, bound := range bounds {
+		fmt.Fprintf(w, "%s_bucket{path=%q,le=%q} %d\n", name, route, strconv.FormatFloat(bound, 'g', -1, 64), h.counts[i])
+	}
+	fmt.Fprintf(w, "%s_bucket{path=%q,le=\"+Inf\"} %d\n", name, route, h.count)
+	fmt.Fprintf(w, "%s_sum{path=%q} %g\n", name, route, h.sum)
+	fmt.Fprintf(w, "%s_count{path=%q} %d\n", name, route, h.count)
+}
+
+// observe saves the metrics of one request. route is the url pattern, that
+// handled the request.
+func (m *metrics) observe(route string, code int, duration time.Duration, size int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.routes == nil {
+		m.routes = make(map[string]*routeMetrics)
+	}
+
+	rm := m.routes[route]
+	if rm == nil {
+		rm = &routeMetrics{codes: make(map[int]uint64)}
+		m.routes[route] = rm
+	}
+
+	rm.codes[code]++
+	rm.duration.observe(durationBuckets, duration.Seconds())
+	rm.size.observe(sizeBuckets, float64(size))
+}
+
+// middleware measures each request. The url pattern of the mux is used as
+// label, so unknown urls do not create new metrics.
+func (m *metrics) middleware(mux *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, route := mux.Handler(r)
+		if route == "" {
+			route = "unknown"
+		}
+
+		rec := &metricsRecorder{ResponseWriter: w, code: http.StatusOK}
+		start := time.Now()
+		mux.ServeHTTP(rec, r)
+		m.observe(route, rec.code, time.Since(start), rec.size)
+	})
+}
+
+// write writes the request metrics in the prometheus text format.
+func (m *metrics) write(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	routes := make([]string, 0, len(m.routes))
+	for route := range m.routes {
+		routes = append(routes, route)
+	}
+	sort.Strings(routes)
+
+	fmt.Fprintln(w, "# HELP autoupdate_http_requests_total Number of finished http requests.")
+	fmt.Fprintln(w, "# TYPE autoupdate_http_requests_total counter")
+	for _, route := range routes {
+		codes := make([]int, 0, len(m.routes[route].codes))
+		for code := range m.routes[route].codes {
+			codes = append(codes, code)
+		}
+		sort.Ints(codes)
+
+		for _, code := range codes {
+			fmt.Fprintf(w, "autoupdate_http_requests_total{path=%q,code=\"%d\"} %d\n", route, code, m.routes[route].codes[code])
+		}
+	}
+
+	fmt.Fprintln(w, "# HELP autoupdate_http_request_duration_seconds Duration of finished http requests.")
+	fmt.Fprintln(w, "# TYPE autoupdate_http_request_duration_seconds histogram")
+	for _, route := range routes {
+		m.routes[route].duration.write(w, "autoupdate_http_request_duration_seconds", durationBuckets, route)
+	}
+
+	fmt.Fprintln(w, "# HELP autoupdate_http_response_size_bytes Size of the bodies of finished http requests.")
+	fmt.Fprintln(w, "# TYPE autoupdate_http_response_size_bytes histogram")
+	for _, route := range routes {
+		m.routes[route].size.write(w, "autoupdate_http_response_size_bytes", sizeBuckets, route)
+	}
+}
+
+// metricsRecorder saves the status code and the size of a response. It is
+// also used by the access log.
+type metricsRecorder struct {
+	http.ResponseWriter
+	code        int
+	size        int64
+	wroteHeader bool
+}
+
+func (r *metricsRecorder) WriteHeader(code int) {
+	if !r.wroteHeader {
+		r.code = code
+		r.wroteHeader = true
+	}
+	r.ResponseWriter.WriteHeader(code)
+}
+
+func (r *metricsRecorder) Write(p []byte) (int, error) {
+	r.wroteHeader = true
+	n, err := r.ResponseWriter.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+// Flush sends the buffered data to the client.
+func (r *metricsRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack is used by the websocket connections. The status of a hijacked
+// connection is 101.
+func (r *metricsRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := r.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("the connection can not be hijacked")
+	}
+
+	r.code = http.StatusSwitchingProtocols
+	r.wroteHeader = true
+	return hijacker.Hijack()
+}
+
+// WriteMetrics writes the metrics of the handler in the prometheus text
+// format. The metrics of the requests are only written, if the handler was
+// created with WithMetrics.
+func (h *Handler) WriteMetrics(w io.Writer) {
+	fmt.Fprintln(w, "# HELP autoupdate_connections Number of open autoupdate connections.")
+	fmt.Fprintln(w, "# TYPE autoupdate_connections gauge")
+	fmt.Fprintf(w, "autoupdate_connections %d\n", h.ConnectionCount())
+
+	fmt.Fprintln(w, "# HELP autoupdate_messages_total Number of messages sent to the clients.")
+	fmt.Fprintln(w, "# TYPE autoupdate_messages_total counter")
+	fmt.Fprintf(w, "autoupdate_messages_total %d\n", h.MessageCount())
+
+	fmt.Fprintln(w, "# HELP autoupdate_bytes_sent_total Number of bytes sent to the clients.")
+	fmt.Fprintln(w, "# TYPE autoupdate_bytes_sent_total counter")
+	fmt.Fprintf(w, "autoupdate_bytes_sent_total %d\n", h.Bandwidth().Total)
+
+	if h.metrics != nil {
+		h.metrics.write(w)
+	}
+}
@@ -1,7 +1,6 @@
 package http_test
 
 import (
-	"context"
 	"net/http"
 	"sort"
 )
@@ -13,14 +12,6 @@ func mustRequest(r *http.Request, err error) *http.Request {
 	return r
 }
 
-type mockAuth struct {
-	uid int
-}
-
-func (a mockAuth) Authenticate(context.Context, *http.Request) (int, error) {
-	return a.uid, nil
-}
-
 func keys(ks ...string) []string {
 	return ks
 }
@@ -0,0 +1,97 @@
+package http
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/openslides/openslides-autoupdate-service/internal/autoupdate"
+	"github.com/openslides/openslides-autoupdate-service/internal/keysbuilder"
+)
+
+// WithPresets sets the named keys requests, that clients can use on the url
+// /system/autoupdate/preset. The presets can be replaced at runtime with
+// SetPresets.
+func WithPresets(presets *keysbuilder.Presets) Option {
+	return func(h *Handler) {
+		h.presets = presets
+	}
+}
+
+// SetPresets replaces the presets. Open connections keep the keys of the old
+// presets.
+func (h *Handler) SetPresets(presets *keysbuilder.Presets) {
+	h.presetsMu.Lock()
+	defer h.presetsMu.Unlock()
+	h.presets = presets
+}
+
+// preset builds a keysbuilder from a preset. The url argument name is the name
+// of the preset. All other url arguments are the parameters of the preset as
+// comma separated list of ids.
+func (h *Handler) preset(r *http.Request, uid int) (autoupdate.KeysBuilder, error) {
+	query := r.URL.Query()
+	name := query.Get("name")
+	if name == "" {
+		return nil, invalidRequestError{fmt.Errorf("url argument name is missing")}
+	}
+
+	params := make(map[string][]int, len(query)-1)
+	for param, values := range query {
+		if param == "name" {
+			continue
+		}
+
+		for _, value := range values {
+			for _, rawID := range strings.Split(value, ",") {
+				id, err := strconv.Atoi(rawID)
+				if err != nil || id <= 0 {
+					return nil, invalidRequestError{fmt.Errorf("invalid id `%s` for parameter %s", rawID, param)}
+				}
+				params[param] = append(params[param], id)
+			}
+		}
+	}
+
+	h.presetsMu.RLock()
+	presets := h.presets
+	h.presetsMu.RUnlock()
+
+	return presets.Builder(r.Context(), name, params, h.s, uid, h.kbOptions...)
+}
+
+// adminPresets lists the names of the presets on GET and replaces the presets
+// with the request body on POST.
+func (h *Handler) adminPresets(w http.ResponseWriter, r *http.Request) error {
+	switch r.Method {
+	case http.MethodGet:
+		h.presetsMu.RLock()
+		names := h.presets.Names()
+		h.presetsMu.RUnlock()
+
+		if names == nil {
+			names = []string{}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(names); err != nil {
+			return fmt.Errorf("encoding presets: %w", err)
+		}
+		return nil
+
+	case http.MethodPost:
+		presets, err := keysbuilder.ParsePresets(r.Body)
+		if err != nil {
+			return invalidRequestError{fmt.Errorf("invalid presets: %w", err)}
+		}
+		h.SetPresets(presets)
+		fmt.Fprintf(w, `{"presets": %d}`+"\n", len(presets.Names()))
+		return nil
+
+	default:
+		http.Error(w, "Only GET or POST requests are supported", http.StatusMethodNotAllowed)
+		return nil
+	}
+}
@@ -0,0 +1,93 @@
+package http_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/openslides/openslides-autoupdate-service/internal/autoupdate"
+	ahttp "github.com/openslides/openslides-autoupdate-service/internal/http"
+	"github.com/openslides/openslides-autoupdate-service/internal/test"
+)
+
+func TestPreset(t *testing.T) {
+	closed := make(chan struct{})
+	defer close(closed)
+	s := autoupdate.New(new(test.MockDatastore), new(test.MockRestricter), closed)
+	handler := ahttp.New(s, &test.MockAuth{Default: 1})
+	srv := httptest.NewUnstartedServer(handler)
+	srv.EnableHTTP2 = true
+	srv.StartTLS()
+	defer srv.Close()
+
+	admin := httptest.NewServer(handler.AdminHandler("secret"))
+	defer admin.Close()
+
+	// Register the presets on the admin handler.
+	req, err := http.NewRequest(http.MethodPost, admin.URL+"/presets", strings.NewReader(`{
+		"motion-detail": [{"collection": "motion", "fields": {"title": null}}]
+	}`))
+	if err != nil {
+		t.Fatalf("Can not create request: %v", err)
+	}
+	req.Header.Set("Authorization", "Bearer secret")
+	adminResp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Can not send admin request: %v", err)
+	}
+	adminResp.Body.Close()
+	if adminResp.StatusCode != http.StatusOK {
+		t.Fatalf("Got status %s for new presets, expected 200", adminResp.Status)
+	}
+
+	for _, tt := range []struct {
+		name   string
+		url    string
+		status int
+	}{
+		{"valid", "/system/autoupdate/preset?name=motion-detail&ids=1,2", http.StatusOK},
+		{"unknown", "/system/autoupdate/preset?name=unknown&ids=1", http.StatusBadRequest},
+		{"missing param", "/system/autoupdate/preset?name=motion-detail", http.StatusBadRequest},
+		{"invalid id", "/system/autoupdate/preset?name=motion-detail&ids=one", http.StatusBadRequest},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+tt.url, nil)
+			if err != nil {
+				t.Fatalf("Can not create request: %v", err)
+			}
+			resp, err := srv.Client().Do(req)
+			if err != nil {
+				t.Fatalf("Can not send request: %v", err)
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != tt.status {
+				t.Fatalf("Got status %s, expected %d", resp.Status, tt.status)
+			}
+
+			if tt.status != http.StatusOK {
+				return
+			}
+
+			line, err := bufio.NewReader(resp.Body).ReadBytes('\n')
+			if err != nil {
+				t.Fatalf("Can not read first message: %v", err)
+			}
+
+			var data map[string]json.RawMessage
+			if err := json.Unmarshal(line, &data); err != nil {
+				t.Fatalf("Got invalid json: %v", err)
+			}
+			if _, ok := data["motion/2/title"]; !ok || len(data) != 2 {
+				t.Errorf("Got %s, expected the title of motion 1 and 2", line)
+			}
+		})
+	}
+}
@@ -0,0 +1,147 @@
+package http
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+)
+
+// The versions of the protocol. Each version has its own urls and its own
+// encoder. Both versions use the same connection logic and the same
+// capabilities.
+//
+// Version 1 is served under /system/autoupdate. Each message is a flat json
+// object from the key to the value. Deleted keys have the value null. The
+// capabilities nested, delta and position change the format.
+//
+// Version 2 is served under /system/autoupdate/v2. Each message is a json
+// object with the fields position, data and deleted. data is always nested and
+// only contains existing values. deleted is a sorted list of the deleted keys
+// and is missing, if no key was deleted. pending is a sorted list of the keys,
+// that could not be read in time. They are sent in a later message. With the
+// capability delta, the object also contains patches.
+const (
+	protocolV1 = 1
+	protocolV2 = 2
+)
+
+// The types of the messages with the capability typed. Each message is a json
+// object with the field type. data messages contain the data in the format of
+// the protocol version without the position. Each data message is followed by
+// a position message with the position and the resume token. The other types
+// are control messages.
+const (
+	messageData      = "data"
+	messagePosition  = "position"
+	messageKeepalive = "keepalive"
+	messageError     = "error"
+	messageReconnect = "reconnect"
+)
+
+// encodeV1 writes a message in the format of version 1.
+func encodeV1(buf *bytes.Buffer, data, patches map[string]json.RawMessage, caps capabilities, position uint64) {
+	envelope := patches != nil || caps.position || caps.typed
+	if envelope {
+		buf.WriteByte('{')
+		if caps.typed {
+			buf.WriteString(`"type":"` + messageData + `",`)
+		}
+		buf.WriteString(`"data":`)
+	}
+
+	if caps.nested {
+		writeNested(buf, data)
+	} else {
+		writeObject(buf, data)
+	}
+
+	if patches != nil {
+		buf.WriteString(`,"patches":`)
+		writeObject(buf, patches)
+	}
+	if caps.position && !caps.typed {
+		buf.WriteString(`,"position":`)
+		buf.WriteString(strconv.FormatUint(position, 10))
+	}
+	if envelope {
+		buf.WriteByte('}')
+	}
+}
+
+// encodeV2 writes a message in the format of version 2.
+func encodeV2(buf *bytes.Buffer, data, patches map[string]json.RawMessage, caps capabilities, position uint64, pending []string) {
+	existing := make(map[string]json.RawMessage, len(data))
+	var deleted []string
+	for key, value := range data {
+		if value == nil {
+			deleted = append(deleted, key)
+			continue
+		}
+		existing[key] = value
+	}
+
+	if caps.typed {
+		buf.WriteString(`{"type":"` + messageData + `"`)
+	} else {
+		buf.WriteString(`{"position":`)
+		buf.WriteString(strconv.FormatUint(position, 10))
+	}
+
+	buf.WriteString(`,"data":`)
+	writeNested(buf, existing)
+
+	if len(deleted) > 0 {
+		sort.Strings(deleted)
+		buf.WriteString(`,"deleted":`)
+		writeKeys(buf, deleted)
+	}
+
+	if len(pending) > 0 {
+		buf.WriteString(`,"pending":`)
+		writeKeys(buf, pending)
+	}
+
+	if patches != nil {
+		buf.WriteString(`,"patches":`)
+		writeObject(buf, patches)
+	}
+	buf.WriteByte('}')
+}
+
+// writeKeys writes the keys as json list.
+func writeKeys(buf *bytes.Buffer, keys []string) {
+	buf.WriteByte('[')
+	for i, key := range keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		buf.WriteByte('"')
+		buf.WriteString(key)
+		buf.WriteByte('"')
+	}
+	buf.WriteByte(']')
+}
+
+// sendPosition writes the position message of the capability typed.
+func sendPosition(w io.Writer, resume resumePosition) (int, error) {
+	frame := struct {
+		Type string `json:"type"`
+		resumePosition
+	}{messagePosition, resume}
+
+	line, err := json.Marshal(frame)
+	if err != nil {
+		return 0, fmt.Errorf("encoding position message: %w", err)
+	}
+
+	written, err := w.Write(append(line, '\n'))
+	if err != nil {
+		return written, fmt.Errorf("writing position message: %w", err)
+	}
+	w.(http.Flusher).Flush()
+	return written, nil
+}
@@ -0,0 +1,98 @@
+package http
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+func TestEncodeV1(t *testing.T) {
+	data := map[string]json.RawMessage{"user/1/name": []byte(`"hugo"`), "user/2/name": nil}
+
+	var buf bytes.Buffer
+	encodeV1(&buf, data, nil, capabilities{delta: -1}, 5)
+
+	var got map[string]json.RawMessage
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("Got invalid json `%s`: %v", buf.String(), err)
+	}
+	if len(got) != 2 || string(got["user/1/name"]) != `"hugo"` || string(got["user/2/name"]) != "null" {
+		t.Errorf("Got %s, expected the flat format", buf.String())
+	}
+}
+
+func TestEncodeV2(t *testing.T) {
+	data := map[string]json.RawMessage{
+		"user/1/name":    []byte(`"hugo"`),
+		"user/2/name":    nil,
+		"motion/1/title": nil,
+	}
+
+	var buf bytes.Buffer
+	encodeV2(&buf, data, nil, capabilities{delta: -1, version: protocolV2}, 5, nil)
+
+	expect := `{"position":5,"data":{"user":{"1":{"name":"hugo"}}},"deleted":["motion/1/title","user/2/name"]}`
+	if got := buf.String(); got != expect {
+		t.Errorf("Got `%s`, expected `%s`", got, expect)
+	}
+}
+
+func TestEncodeV2WithoutDeleted(t *testing.T) {
+	var buf bytes.Buffer
+	encodeV2(&buf, map[string]json.RawMessage{}, map[string]json.RawMessage{}, capabilities{version: protocolV2}, 1, nil)
+
+	expect := `{"position":1,"data":{},"patches":{}}`
+	if got := buf.String(); got != expect {
+		t.Errorf("Got `%s`, expected `%s`", got, expect)
+	}
+}
+
+func TestEncodeV2WithPending(t *testing.T) {
+	data := map[string]json.RawMessage{"user/1/name": []byte(`"hugo"`)}
+
+	var buf bytes.Buffer
+	encodeV2(&buf, data, nil, capabilities{delta: -1, version: protocolV2}, 3, []string{"motion/1/title"})
+
+	expect := `{"position":3,"data":{"user":{"1":{"name":"hugo"}}},"pending":["motion/1/title"]}`
+	if got := buf.String(); got != expect {
+		t.Errorf("Got `%s`, expected `%s`", got, expect)
+	}
+}
+
+func TestEncodeTyped(t *testing.T) {
+	data := map[string]json.RawMessage{"user/1/name": []byte(`"hugo"`)}
+
+	for _, tt := range []struct {
+		name   string
+		caps   capabilities
+		expect string
+	}{
+		{"version 1", capabilities{delta: -1, typed: true, position: true}, `{"type":"data","data":{"user/1/name":"hugo"}}`},
+		{"version 2", capabilities{delta: -1, typed: true, version: protocolV2}, `{"type":"data","data":{"user":{"1":{"name":"hugo"}}}}`},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if tt.caps.version == protocolV2 {
+				encodeV2(&buf, data, nil, tt.caps, 5, nil)
+			} else {
+				encodeV1(&buf, data, nil, tt.caps, 5)
+			}
+
+			if got := buf.String(); got != tt.expect {
+				t.Errorf("Got `%s`, expected `%s`", got, tt.expect)
+			}
+		})
+	}
+}
+
+func TestSendPositionMessage(t *testing.T) {
+	var buf flushBuffer
+	if _, err := sendPosition(&buf, resumePosition{Position: 5, Token: "abc"}); err != nil {
+		t.Fatalf("sendPosition returned unexpected error: %v", err)
+	}
+
+	expect := `{"type":"position","position":5,"token":"abc"}` + "\n"
+	if got := buf.String(); got != expect {
+		t.Errorf("Got `%s`, expected `%s`", got, expect)
+	}
+}
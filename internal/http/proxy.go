@@ -0,0 +1,104 @@
+package http
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// trustedProxies replaces the remote address of a request with the address of
+// the client, if the request comes from a trusted proxy. The address is read
+// from the header Forwarded or, if it is not set, from X-Forwarded-For.
+//
+// The addresses in the headers are read from right to left. The first address,
+// that is not a trusted proxy, is the client. So a client can not fake its
+// address by sending the header itself.
+type trustedProxies struct {
+	nets []*net.IPNet
+}
+
+// trusted tells, if the address is a trusted proxy.
+func (p *trustedProxies) trusted(addr string) bool {
+	ip := net.ParseIP(addr)
+	return ip != nil && containsIP(p.nets, ip)
+}
+
+// clientIP returns the address of the client. peer is the address of the
+// connection without the port. If the peer is not a trusted proxy, the
+// headers are ignored.
+func (p *trustedProxies) clientIP(peer string, header http.Header) string {
+	if !p.trusted(peer) {
+		return peer
+	}
+
+	addrs := forwardedFor(header.Values("Forwarded"))
+	if len(addrs) == 0 {
+		addrs = xForwardedFor(header.Values("X-Forwarded-For"))
+	}
+
+	client := peer
+	for i := len(addrs) - 1; i >= 0; i-- {
+		if net.ParseIP(addrs[i]) == nil {
+			// Obfuscated identifiers like `unknown` or `_hidden` can not be
+			// used as client address.
+			break
+		}
+
+		client = addrs[i]
+		if !p.trusted(client) {
+			break
+		}
+	}
+	return client
+}
+
+// middleware sets the remote address of each request to the address of the
+// client. The port is removed, since it is the port of the proxy.
+func (p *trustedProxies) middleware(h http.Handler) http.Handler {
+	if p == nil {
+		return h
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if client := p.clientIP(remoteIP(r), r.Header); client != remoteIP(r) {
+			r.RemoteAddr = client
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// xForwardedFor returns the addresses of the header X-Forwarded-For in the
+// order of the header.
+func xForwardedFor(values []string) []string {
+	var addrs []string
+	for _, value := range values {
+		for _, addr := range strings.Split(value, ",") {
+			addrs = append(addrs, strings.TrimSpace(addr))
+		}
+	}
+	return addrs
+}
+
+// forwardedFor returns the for parameters of the header Forwarded (RFC 7239)
+// in the order of the header. The ports and the brackets of ipv6 addresses are
+// removed.
+func forwardedFor(values []string) []string {
+	var addrs []string
+	for _, value := range values {
+		for _, element := range strings.Split(value, ",") {
+			for _, pair := range strings.Split(element, ";") {
+				pair = strings.TrimSpace(pair)
+				if len(pair) < 4 || !strings.EqualFold(pair[:4], "for=") {
+					continue
+				}
+
+				addr := strings.Trim(pair[4:], `"`)
+				if host, _, err := net.SplitHostPort(addr); err == nil {
+					addr = host
+				}
+				addrs = append(addrs, strings.Trim(addr, "[]"))
+			}
+		}
+	}
+	return addrs
+}
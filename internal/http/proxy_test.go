@@ -0,0 +1,89 @@
+package http
+
+import (
+	"net"
+	"net/http"
+	"testing"
+)
+
+func TestTrustedProxiesClientIP(t *testing.T) {
+	_, proxies, err := net.ParseCIDR("10.0.0.0/8")
+	if err != nil {
+		t.Fatalf("Invalid network: %v", err)
+	}
+	p := trustedProxies{nets: []*net.IPNet{proxies}}
+
+	for _, tt := range []struct {
+		name   string
+		peer   string
+		header http.Header
+		expect string
+	}{
+		{
+			"without header",
+			"10.0.0.1",
+			nil,
+			"10.0.0.1",
+		},
+		{
+			"untrusted peer",
+			"1.2.3.4",
+			http.Header{"X-Forwarded-For": {"5.6.7.8"}},
+			"1.2.3.4",
+		},
+		{
+			"x-forwarded-for",
+			"10.0.0.1",
+			http.Header{"X-Forwarded-For": {"5.6.7.8"}},
+			"5.6.7.8",
+		},
+		{
+			"x-forwarded-for with many proxies",
+			"10.0.0.1",
+			http.Header{"X-Forwarded-For": {"1.1.1.1, 5.6.7.8, 10.0.0.2"}},
+			"5.6.7.8",
+		},
+		{
+			"x-forwarded-for in many lines",
+			"10.0.0.1",
+			http.Header{"X-Forwarded-For": {"1.1.1.1, 5.6.7.8", "10.0.0.2"}},
+			"5.6.7.8",
+		},
+		{
+			"forwarded",
+			"10.0.0.1",
+			http.Header{"Forwarded": {`for=5.6.7.8;proto=https, for="10.0.0.2:1234"`}},
+			"5.6.7.8",
+		},
+		{
+			"forwarded ipv6",
+			"10.0.0.1",
+			http.Header{"Forwarded": {`For="[2001:db8:cafe::17]:4711"`}},
+			"2001:db8:cafe::17",
+		},
+		{
+			"forwarded before x-forwarded-for",
+			"10.0.0.1",
+			http.Header{"Forwarded": {"for=5.6.7.8"}, "X-Forwarded-For": {"1.1.1.1"}},
+			"5.6.7.8",
+		},
+		{
+			"obfuscated",
+			"10.0.0.1",
+			http.Header{"Forwarded": {"for=1.1.1.1, for=unknown"}},
+			"10.0.0.1",
+		},
+		{
+			"only proxies",
+			"10.0.0.1",
+			http.Header{"X-Forwarded-For": {"10.0.0.3, 10.0.0.2"}},
+			"10.0.0.3",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := p.clientIP(tt.peer, tt.header); got != tt.expect {
+				t.Errorf("Got %s, expected %s", got, tt.expect)
+			}
+		})
+	}
+}
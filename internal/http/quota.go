@@ -0,0 +1,147 @@
+package http
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// quota limits the connections and the bandwidth per meeting.
+//
+// The meetings of a connection are found by connectionMeetings. A connection
+// counts for each of its meetings. All connections without a meeting share the
+// quota of unknownMeeting.
+//
+// A zero value for maxConns or maxBytes means no limit.
+type quota struct {
+	maxConns int
+	maxBytes int
+
+	mu       sync.Mutex
+	meetings map[int]*meetingUsage
+}
+
+// meetingUsage is the current usage of one meeting.
+//
+// The bandwidth is measured in windows of one second. bytes are the sent bytes
+// in the current window and lastBytes in the window before.
+type meetingUsage struct {
+	conns       int
+	windowStart time.Time
+	bytes       int
+	lastBytes   int
+}
+
+// acquire registers a new connection for its meetings. Returns an error, if
+// one of the meetings reached one of its limits. In this case, the connection
+// is not registered for any meeting.
+func (q *quota) acquire(meetings []int, now time.Time) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.meetings == nil {
+		q.meetings = make(map[int]*meetingUsage)
+	}
+
+	for _, meeting := range meetings {
+		u := q.meetings[meeting]
+		if u == nil {
+			continue
+		}
+		u.advance(now)
+
+		if q.maxConns > 0 && u.conns >= q.maxConns {
+			return quotaError{fmt.Sprintf("%s has reached the maximum of %d connections", meetingName(meeting), q.maxConns)}
+		}
+
+		if q.maxBytes > 0 && u.lastBytes > q.maxBytes {
+			return quotaError{fmt.Sprintf("%s has reached the maximum of %d bytes per second", meetingName(meeting), q.maxBytes)}
+		}
+	}
+
+	for _, meeting := range meetings {
+		u := q.meetings[meeting]
+		if u == nil {
+			u = &meetingUsage{windowStart: now}
+			q.meetings[meeting] = u
+		}
+		u.conns++
+	}
+	return nil
+}
+
+// release unregisters a connection of its meetings.
+func (q *quota) release(meetings []int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for _, meeting := range meetings {
+		u := q.meetings[meeting]
+		if u == nil {
+			continue
+		}
+
+		u.conns--
+		if u.conns <= 0 {
+			delete(q.meetings, meeting)
+		}
+	}
+}
+
+// sent counts bytes that were sent to a connection of the meetings.
+func (q *quota) sent(meetings []int, bytes int, now time.Time) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for _, meeting := range meetings {
+		u := q.meetings[meeting]
+		if u == nil {
+			continue
+		}
+		u.advance(now)
+		u.bytes += bytes
+	}
+}
+
+// meetingName describes a meeting for an error message.
+func meetingName(meeting int) string {
+	if meeting == unknownMeeting {
+		return "the connections without a meeting"
+	}
+	return fmt.Sprintf("meeting %d", meeting)
+}
+
+// advance starts a new window, if the current window is older then one
+// second.
+func (u *meetingUsage) advance(now time.Time) {
+	elapsed := now.Sub(u.windowStart)
+	if elapsed < time.Second {
+		return
+	}
+
+	u.lastBytes = u.bytes
+	if elapsed >= 2*time.Second {
+		// There was no traffic in the last window.
+		u.lastBytes = 0
+	}
+	u.bytes = 0
+	u.windowStart = now
+}
+
+// quotaError is returned, when a meeting reached one of its limits.
+type quotaError struct {
+	msg string
+}
+
+func (e quotaError) Error() string {
+	return e.msg
+}
+
+func (e quotaError) Type() string {
+	return "QuotaExceeded"
+}
+
+func (e quotaError) StatusCode() int {
+	return http.StatusTooManyRequests
+}
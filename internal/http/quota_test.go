@@ -0,0 +1,86 @@
+package http
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestQuotaConnections(t *testing.T) {
+	q := quota{maxConns: 2}
+	now := time.Now()
+
+	for i := 0; i < 2; i++ {
+		if err := q.acquire([]int{1}, now); err != nil {
+			t.Fatalf("acquire %d returned unexpected error: %v", i, err)
+		}
+	}
+
+	var qErr quotaError
+	if err := q.acquire([]int{1}, now); !errors.As(err, &qErr) {
+		t.Errorf("Got error %v, expected a quotaError", err)
+	}
+
+	if err := q.acquire([]int{2}, now); err != nil {
+		t.Errorf("Other meeting got error: %v", err)
+	}
+
+	q.release([]int{1})
+	if err := q.acquire([]int{1}, now); err != nil {
+		t.Errorf("acquire after release returned error: %v", err)
+	}
+}
+
+func TestQuotaBandwidth(t *testing.T) {
+	q := quota{maxBytes: 100}
+	start := time.Now()
+
+	if err := q.acquire([]int{1}, start); err != nil {
+		t.Fatalf("acquire returned unexpected error: %v", err)
+	}
+	q.sent([]int{1}, 150, start)
+
+	// The current window is not finished yet.
+	if err := q.acquire([]int{1}, start.Add(500*time.Millisecond)); err != nil {
+		t.Errorf("acquire in the same window returned error: %v", err)
+	}
+
+	if err := q.acquire([]int{1}, start.Add(1500*time.Millisecond)); err == nil {
+		t.Errorf("acquire after a window with too many bytes returned no error")
+	}
+
+	if err := q.acquire([]int{1}, start.Add(3*time.Second)); err != nil {
+		t.Errorf("acquire after a window without traffic returned error: %v", err)
+	}
+}
+
+func TestQuotaSeveralMeetings(t *testing.T) {
+	q := quota{maxConns: 1}
+	now := time.Now()
+
+	if err := q.acquire([]int{1}, now); err != nil {
+		t.Fatalf("acquire returned unexpected error: %v", err)
+	}
+
+	if err := q.acquire([]int{2, 1}, now); err == nil {
+		t.Errorf("acquire with a full meeting returned no error")
+	}
+
+	// The failed connection was not registered for meeting 2.
+	if err := q.acquire([]int{2}, now); err != nil {
+		t.Errorf("acquire of meeting 2 returned error: %v", err)
+	}
+}
+
+func TestQuotaUnknownMeeting(t *testing.T) {
+	q := quota{maxConns: 1}
+	now := time.Now()
+
+	if err := q.acquire([]int{unknownMeeting}, now); err != nil {
+		t.Fatalf("acquire returned unexpected error: %v", err)
+	}
+
+	if err := q.acquire([]int{unknownMeeting}, now); err == nil {
+		t.Errorf("second connection without a meeting returned no error")
+	}
+}
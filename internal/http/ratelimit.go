@@ -0,0 +1,110 @@
+package http
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ipRatePruneInterval is the time between two runs, that remove the buckets of
+// clients, that did not connect for a while.
+const ipRatePruneInterval = time.Minute
+
+// ipRate limits the number of new autoupdate connections per client address
+// with a token bucket. Each address can open burst connections at once.
+// Afterwards, it gets rate new connections per second.
+//
+// Unlike ipLimit, it does not count open connections but connection
+// attempts. So a client, that reconnects in a loop, is slowed down, even if it
+// only has one connection at a time.
+type ipRate struct {
+	rate  float64
+	burst float64
+
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastPrune time.Time
+}
+
+// tokenBucket are the tokens of one address at the time last.
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// allow takes a token of the address. If there is no token, it returns false
+// and the time until the next token is available.
+func (l *ipRate) allow(ip string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.buckets == nil {
+		l.buckets = make(map[string]*tokenBucket)
+		l.lastPrune = now
+	}
+
+	if now.Sub(l.lastPrune) >= ipRatePruneInterval {
+		l.prune(now)
+	}
+
+	b := l.buckets[ip]
+	if b == nil {
+		b = &tokenBucket{tokens: l.burst, last: now}
+		l.buckets[ip] = b
+	}
+
+	b.tokens = l.tokens(b, now)
+	b.last = now
+
+	if b.tokens < 1 {
+		wait := time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+		return false, wait
+	}
+	b.tokens--
+	return true, 0
+}
+
+// tokens returns the tokens of the bucket at the time now.
+func (l *ipRate) tokens(b *tokenBucket, now time.Time) float64 {
+	elapsed := now.Sub(b.last).Seconds()
+	if elapsed < 0 {
+		elapsed = 0
+	}
+	return math.Min(l.burst, b.tokens+elapsed*l.rate)
+}
+
+// prune removes all buckets, that are full. They are the same as a new bucket.
+//
+// Has to be called with the lock.
+func (l *ipRate) prune(now time.Time) {
+	for ip, b := range l.buckets {
+		if l.tokens(b, now) >= l.burst {
+			delete(l.buckets, ip)
+		}
+	}
+	l.lastPrune = now
+}
+
+// middleware rejects requests with the status 429, if the client address
+// opened too many connections in a short time. The header Retry-After tells
+// the client, when to try again.
+func (l *ipRate) middleware(h http.Handler) http.Handler {
+	if l == nil {
+		return h
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip := remoteIP(r)
+		ok, wait := l.allow(ip, time.Now())
+		if !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			writeError(w, http.StatusTooManyRequests, errTypeTooMany, fmt.Sprintf("%s opened too many connections in a short time", ip))
+			return
+		}
+
+		h.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,49 @@
+package http
+
+import (
+	"testing"
+	"time"
+)
+
+func TestIPRate(t *testing.T) {
+	l := ipRate{rate: 2, burst: 3}
+	start := time.Now()
+
+	for i := 0; i < 3; i++ {
+		if ok, _ := l.allow("1.2.3.4", start); !ok {
+			t.Fatalf("Connection %d was rejected, expected the burst to be allowed", i+1)
+		}
+	}
+
+	ok, wait := l.allow("1.2.3.4", start)
+	if ok {
+		t.Fatalf("Connection after the burst was allowed")
+	}
+	if wait != 500*time.Millisecond {
+		t.Errorf("Got wait %v, expected 500ms", wait)
+	}
+
+	if ok, _ := l.allow("5.6.7.8", start); !ok {
+		t.Errorf("Connection of another address was rejected")
+	}
+
+	if ok, _ := l.allow("1.2.3.4", start.Add(500*time.Millisecond)); !ok {
+		t.Errorf("Connection after the wait was rejected")
+	}
+
+	if ok, _ := l.allow("1.2.3.4", start.Add(500*time.Millisecond)); ok {
+		t.Errorf("Second connection after the wait was allowed")
+	}
+}
+
+func TestIPRatePrune(t *testing.T) {
+	l := ipRate{rate: 1, burst: 1}
+	start := time.Now()
+
+	l.allow("1.2.3.4", start)
+	l.allow("5.6.7.8", start.Add(ipRatePruneInterval))
+
+	if len(l.buckets) != 1 {
+		t.Errorf("Got %d buckets, expected 1", len(l.buckets))
+	}
+}
@@ -0,0 +1,217 @@
+package http
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// connectionInfo holds the data of one open autoupdate connection.
+//
+// keys, bytes and lastAck are used with atomic and therefore have to be the
+// first fields in the struct.
+type connectionInfo struct {
+	keys    int64
+	bytes   uint64
+	lastAck int64
+
+	uid      int
+	session  string
+	meetings []int
+	created  time.Time
+	bodies   int
+	cancel   context.CancelFunc
+
+	// reconnect asks the connection to send the reconnect message.
+	reconnect context.CancelFunc
+}
+
+// ConnectionInfo describes an open autoupdate connection.
+type ConnectionInfo struct {
+	ID        uint64    `json:"id"`
+	UserID    int       `json:"user_id"`
+	SessionID string    `json:"session_id,omitempty"`
+	Meetings  []int     `json:"meeting_ids"`
+	Created   time.Time `json:"created"`
+	Age       float64   `json:"age_seconds"`
+	Bodies    int       `json:"bodies"`
+	Keys      int       `json:"keys"`
+	BytesSent uint64    `json:"bytes_sent"`
+}
+
+// registry holds all open autoupdate connections, so they can be closed from
+// outside.
+type registry struct {
+	mu     sync.Mutex
+	nextID uint64
+	conns  map[uint64]*connectionInfo
+
+	// reconnecting is true after reconnectAll was called.
+	reconnecting bool
+}
+
+// add registers a connection and returns its id.
+func (r *registry) add(info *connectionInfo) uint64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.conns == nil {
+		r.conns = make(map[uint64]*connectionInfo)
+	}
+
+	r.nextID++
+	r.conns[r.nextID] = info
+	if r.reconnecting {
+		info.reconnect()
+	}
+	return r.nextID
+}
+
+// remove unregisters a connection.
+func (r *registry) remove(id uint64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.conns, id)
+}
+
+// ack saves the time of an acknowledgment of a connection. Returns false, if
+// the connection does not exist or belongs to another user.
+func (r *registry) ack(id uint64, uid int, now time.Time) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	info, ok := r.conns[id]
+	if !ok || info.uid != uid {
+		return false
+	}
+	atomic.StoreInt64(&info.lastAck, now.UnixNano())
+	return true
+}
+
+// connectionFilter selects connections of the registry. A field with the zero
+// value matches all connections.
+type connectionFilter struct {
+	uid     int
+	session string
+	meeting int
+}
+
+// empty tells, if the filter matches all connections.
+func (f connectionFilter) empty() bool {
+	return f == connectionFilter{}
+}
+
+// match tells, if the connection is selected by the filter.
+func (f connectionFilter) match(info *connectionInfo) bool {
+	if f.uid != 0 && info.uid != f.uid {
+		return false
+	}
+
+	if f.session != "" && info.session != f.session {
+		return false
+	}
+
+	if f.meeting != 0 {
+		for _, meeting := range info.meetings {
+			if meeting == f.meeting {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// closeMatching closes all connections selected by the filter. Returns the
+// number of closed connections.
+func (r *registry) closeMatching(filter connectionFilter) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var count int
+	for _, info := range r.conns {
+		if filter.match(info) {
+			info.cancel()
+			count++
+		}
+	}
+	return count
+}
+
+// closeConnection closes the connection with the id. Returns false, if the
+// connection does not exist.
+func (r *registry) closeConnection(id uint64) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	info, ok := r.conns[id]
+	if !ok {
+		return false
+	}
+	info.cancel()
+	return true
+}
+
+// reconnectAll asks all connections, also the ones that are added later, to
+// send the reconnect message.
+func (r *registry) reconnectAll() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.reconnecting = true
+	for _, info := range r.conns {
+		info.reconnect()
+	}
+}
+
+// closeAll closes all connections.
+func (r *registry) closeAll() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, info := range r.conns {
+		info.cancel()
+	}
+}
+
+// list returns the connections selected by the filter. The list is sorted by
+// id.
+func (r *registry) list(filter connectionFilter) []ConnectionInfo {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	list := make([]ConnectionInfo, 0, len(r.conns))
+	for id, info := range r.conns {
+		if !filter.match(info) {
+			continue
+		}
+
+		// unknownMeeting is not a real meeting.
+		meetings := make([]int, 0, len(info.meetings))
+		for _, meeting := range info.meetings {
+			if meeting != unknownMeeting {
+				meetings = append(meetings, meeting)
+			}
+		}
+
+		list = append(list, ConnectionInfo{
+			ID:        id,
+			UserID:    info.uid,
+			SessionID: info.session,
+			Meetings:  meetings,
+			Created:   info.created,
+			Age:       now.Sub(info.created).Seconds(),
+			Bodies:    info.bodies,
+			Keys:      int(atomic.LoadInt64(&info.keys)),
+			BytesSent: atomic.LoadUint64(&info.bytes),
+		})
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].ID < list[j].ID
+	})
+	return list
+}
@@ -0,0 +1,90 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// shutdownPollInterval is the interval, in which Shutdown checks, if all
+// connections are closed.
+const shutdownPollInterval = 100 * time.Millisecond
+
+// Shutdown asks all open connections to reconnect to another instance. Each
+// connection gets a last message with its position, that can be used with the
+// ResumeHeader. New connections get the message right away.
+//
+// Shutdown waits until the clients closed all connections or the context is
+// done. Then the remaining connections are closed. Returns the error of the
+// context, if connections had to be closed.
+func (h *Handler) Shutdown(ctx context.Context) error {
+	h.registry.reconnectAll()
+
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+
+	for {
+		if h.ConnectionCount() == 0 {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			h.registry.closeAll()
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// resumePosition is the position of the last message of a connection.
+type resumePosition struct {
+	Position uint64 `json:"position,omitempty"`
+	Token    string `json:"token,omitempty"`
+}
+
+// sendReconnect writes the reconnect message. If no message was sent on the
+// connection, the position is empty and the client has to reconnect without
+// the ResumeHeader. With the capability typed, the message has the type
+// reconnect.
+func sendReconnect(w io.Writer, resume resumePosition, typed bool) (int, error) {
+	frame := struct {
+		Type      string         `json:"type,omitempty"`
+		Reconnect resumePosition `json:"reconnect"`
+	}{Reconnect: resume}
+	if typed {
+		frame.Type = messageReconnect
+	}
+
+	line, err := json.Marshal(frame)
+	if err != nil {
+		return 0, fmt.Errorf("encoding reconnect message: %w", err)
+	}
+
+	written, err := w.Write(append(line, '\n'))
+	if err != nil {
+		return written, fmt.Errorf("writing reconnect message: %w", err)
+	}
+	w.(http.Flusher).Flush()
+	return written, nil
+}
+
+// reconnect sends the reconnect message and waits, until the client or
+// Shutdown closes the connection.
+func reconnect(ctx context.Context, w io.Writer, ka *keepalive, info *connectionInfo, resume resumePosition, typed bool) error {
+	err := ka.write(func() error {
+		written, err := sendReconnect(w, resume, typed)
+		atomic.AddUint64(&info.bytes, uint64(written))
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	<-ctx.Done()
+	return ctx.Err()
+}
@@ -0,0 +1,89 @@
+package http
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/openslides/openslides-autoupdate-service/internal/autoupdate"
+)
+
+// Server-sent events are used, if the client sends the header
+// `Accept: text/event-stream`. This is done by the EventSource API of the
+// browsers. Each message is sent as one event. The id of the event is the
+// position and the resume token of the connection. When the EventSource
+// reconnects, it sends the id in the Last-Event-ID header and the connection
+// is resumed like with the ResumeHeader.
+
+// isEventStream tells, if the client wants server-sent events.
+func isEventStream(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), "text/event-stream")
+}
+
+// sseWriter sends each call to Write as one event. The autoupdate handler
+// writes each message with one call to Write.
+type sseWriter struct {
+	http.ResponseWriter
+
+	// id is the id of the next event. If it is empty, the event is sent
+	// without an id.
+	id string
+}
+
+func newSSEWriter(w http.ResponseWriter) *sseWriter {
+	w.Header().Set("Cache-Control", "no-cache")
+	return &sseWriter{ResponseWriter: w}
+}
+
+// Write sends p as one event. Each line of p is sent as its own data field.
+func (w *sseWriter) Write(p []byte) (int, error) {
+	var buf bytes.Buffer
+	if w.id != "" {
+		buf.WriteString("id: ")
+		buf.WriteString(w.id)
+		buf.WriteByte('\n')
+	}
+
+	for _, line := range bytes.Split(bytes.TrimRight(p, "\n"), []byte("\n")) {
+		buf.WriteString("data: ")
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+	buf.WriteByte('\n')
+
+	if _, err := w.ResponseWriter.Write(buf.Bytes()); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Flush sends the events to the client.
+func (w *sseWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// eventID returns the id of an event.
+func eventID(position uint64, token string) string {
+	return strconv.FormatUint(position, 10) + "-" + token
+}
+
+// resumeFromEventID resumes the connection from the value of the
+// Last-Event-ID header.
+func resumeFromEventID(connection *autoupdate.Connection, id string) error {
+	parts := strings.SplitN(id, "-", 2)
+	if len(parts) != 2 {
+		return invalidRequestError{fmt.Errorf("invalid header Last-Event-ID")}
+	}
+
+	position, err := strconv.ParseUint(parts[0], 10, 64)
+	if err != nil {
+		return invalidRequestError{fmt.Errorf("invalid position in header Last-Event-ID: %w", err)}
+	}
+
+	connection.Resume(position, parts[1])
+	return nil
+}
@@ -0,0 +1,82 @@
+package http_test
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/openslides/openslides-autoupdate-service/internal/autoupdate"
+	ahttp "github.com/openslides/openslides-autoupdate-service/internal/http"
+	"github.com/openslides/openslides-autoupdate-service/internal/test"
+)
+
+func TestServerSentEvents(t *testing.T) {
+	closed := make(chan struct{})
+	defer close(closed)
+	s := autoupdate.New(test.NewMockDatastore(), new(test.MockRestricter), closed)
+	srv := httptest.NewUnstartedServer(ahttp.New(s, &test.MockAuth{Default: 1}))
+	srv.EnableHTTP2 = true
+	srv.StartTLS()
+	defer srv.Close()
+
+	// readEvent connects with the EventSource headers and returns the id and
+	// the data of the first event.
+	readEvent := func(lastEventID string) (string, string) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/system/autoupdate/keys?user/1/name", nil)
+		if err != nil {
+			t.Fatalf("Can not create request: %v", err)
+		}
+		req.Header.Set("Accept", "text/event-stream")
+		if lastEventID != "" {
+			req.Header.Set("Last-Event-ID", lastEventID)
+		}
+
+		resp, err := srv.Client().Do(req)
+		if err != nil {
+			t.Fatalf("Can not send request: %v", err)
+		}
+		defer resp.Body.Close()
+
+		if got := resp.Header.Get("Content-Type"); got != "text/event-stream" {
+			t.Errorf("Got content-type `%s`, expected `text/event-stream`", got)
+		}
+
+		var id, data string
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			line := scanner.Text()
+			if line == "" {
+				break
+			}
+
+			switch {
+			case strings.HasPrefix(line, "id: "):
+				id = strings.TrimPrefix(line, "id: ")
+			case strings.HasPrefix(line, "data: "):
+				data += strings.TrimPrefix(line, "data: ")
+			default:
+				t.Errorf("Got unexpected line `%s`", line)
+			}
+		}
+		return id, data
+	}
+
+	id, data := readEvent("")
+	if id == "" {
+		t.Errorf("Got event without id")
+	}
+	if data != `{"user/1/name":"Hello World"}` {
+		t.Errorf("Got data `%s`, expected the value of user/1/name", data)
+	}
+
+	// After a reconnect, only the changed data is sent.
+	_, data = readEvent(id)
+	if data != `{}` {
+		t.Errorf("Got data `%s` after reconnect, expected `{}`", data)
+	}
+}
@@ -0,0 +1,342 @@
+package http
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The websocket transport (RFC 6455) is an alternative to the http2 stream for
+// clients behind proxies, that break streaming responses.
+//
+// After the handshake, the client sends the keysbuilder request as the first
+// text message. The server sends each message of the autoupdate as one text
+// message in the same format as the http2 stream. Browsers can not set headers
+// on a websocket request, so the capabilities are sent in the url argument
+// capabilities. The capability gzip is not supported.
+//
+// Other messages of the client are ignored. The connection is closed, when the
+// client sends a close frame or the connection breaks.
+//
+// Browsers do not use CORS for websockets, so the handshake checks the header
+// Origin itself. Only the own origin and the origins of WithCORS are allowed.
+// Requests without the header are not sent by a browser and are allowed.
+
+// websocketGUID is used to create the Sec-WebSocket-Accept header.
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// websocketWriteTimeout is the time after which a write to a websocket
+// connection fails.
+const websocketWriteTimeout = 30 * time.Second
+
+// Opcodes of websocket frames.
+const (
+	wsContinuation = 0x0
+	wsText         = 0x1
+	wsBinary       = 0x2
+	wsClose        = 0x8
+	wsPing         = 0x9
+	wsPong         = 0xA
+)
+
+// websocket creates a handler, that serves the autoupdate with the given
+// version of the protocol over a websocket connection.
+func (h *Handler) websocket(version int) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Sec-WebSocket-Key")
+		if !headerContains(r.Header, "Connection", "upgrade") || !headerContains(r.Header, "Upgrade", "websocket") || key == "" {
+			writeError(w, http.StatusBadRequest, errTypeInvalidRequest, "Expected a websocket handshake")
+			return
+		}
+
+		if r.Header.Get("Sec-WebSocket-Version") != "13" {
+			w.Header().Set("Sec-WebSocket-Version", "13")
+			writeError(w, http.StatusUpgradeRequired, errTypeInvalidRequest, "Unsupported websocket version")
+			return
+		}
+
+		if origin := r.Header.Get("Origin"); origin != "" && !sameOrigin(origin, r.Host) && !h.cors.allowed(origin) {
+			writeError(w, http.StatusForbidden, errTypeForbidden, "The origin is not allowed")
+			return
+		}
+
+		hijacker, ok := w.(http.Hijacker)
+		if !ok {
+			writeError(w, http.StatusInternalServerError, errTypeInternal, "Websockets are not supported")
+			return
+		}
+
+		netConn, rw, err := hijacker.Hijack()
+		if err != nil {
+			logRequestError(r, fmt.Errorf("hijack connection: %w", err))
+			return
+		}
+		defer netConn.Close()
+
+		header := w.Header().Clone()
+		header.Set("Upgrade", "websocket")
+		header.Set("Connection", "Upgrade")
+		header.Set("Sec-WebSocket-Accept", websocketAccept(key))
+
+		rw.WriteString("HTTP/1.1 101 Switching Protocols\r\n")
+		header.Write(rw)
+		rw.WriteString("\r\n")
+		if err := rw.Flush(); err != nil {
+			return
+		}
+
+		conn := &wsConn{conn: netConn, r: rw.Reader, max: h.maxBodySize}
+
+		request, err := conn.readMessage()
+		if err != nil {
+			return
+		}
+
+		// The connection is closed, when the client closes it. The
+		// messages of the client after the request are ignored.
+		ctx, cancel := context.WithCancel(r.Context())
+		defer cancel()
+		go func() {
+			defer cancel()
+			for {
+				if _, err := conn.readMessage(); err != nil {
+					return
+				}
+			}
+		}()
+
+		r = r.Clone(ctx)
+		r.Method = http.MethodPost
+		r.Body = ioutil.NopCloser(bytes.NewReader(request))
+		r.Header.Set(CapabilitiesHeader, withoutGzip(r.URL.Query().Get("capabilities")))
+		r.Header.Del("Accept-Encoding")
+
+		h.autoupdate(h.complex, version).ServeHTTP(&wsResponseWriter{conn: conn, header: make(http.Header)}, r)
+		conn.writeFrame(wsClose, nil)
+	}
+}
+
+// websocketAccept returns the value of the Sec-WebSocket-Accept header for
+// the key of the client.
+func websocketAccept(key string) string {
+	hash := sha1.Sum([]byte(key + websocketGUID))
+	return base64.StdEncoding.EncodeToString(hash[:])
+}
+
+// sameOrigin tells, if the origin of a request is the host, the request was
+// sent to.
+func sameOrigin(origin, host string) bool {
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(u.Host, host)
+}
+
+// headerContains tells, if the comma separated header contains the value. The
+// comparison is case insensitive.
+func headerContains(header http.Header, name, value string) bool {
+	for _, v := range header.Values(name) {
+		for _, part := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(part), value) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// withoutGzip removes the capability gzip from a capabilities list.
+func withoutGzip(capabilities string) string {
+	var features []string
+	for _, feature := range strings.Split(capabilities, ",") {
+		name := strings.TrimSpace(strings.SplitN(feature, "=", 2)[0])
+		if name == capGzip {
+			continue
+		}
+		features = append(features, feature)
+	}
+	return strings.Join(features, ",")
+}
+
+// wsConn reads and writes websocket frames.
+type wsConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+
+	// max is the maximum size of a message from the client in bytes. It is
+	// the value of WithMaxBodySize. 0 means no limit.
+	max int64
+
+	// mu makes sure, that only one frame is written at the same time.
+	mu sync.Mutex
+}
+
+// readMessage reads the next text or binary message. Fragmented messages are
+// joined. Ping frames are answered. If the client sends a close frame, it is
+// answered and io.EOF is returned.
+func (c *wsConn) readMessage() ([]byte, error) {
+	var message []byte
+	for {
+		fin, opcode, payload, err := c.readFrame()
+		if err != nil {
+			return nil, err
+		}
+
+		switch opcode {
+		case wsPing:
+			if err := c.writeFrame(wsPong, payload); err != nil {
+				return nil, err
+			}
+			continue
+
+		case wsPong:
+			continue
+
+		case wsClose:
+			c.writeFrame(wsClose, nil)
+			return nil, io.EOF
+
+		case wsText, wsBinary, wsContinuation:
+
+		default:
+			return nil, fmt.Errorf("unknown opcode %d", opcode)
+		}
+
+		message = append(message, payload...)
+		if c.max > 0 && int64(len(message)) > c.max {
+			return nil, fmt.Errorf("message is bigger then %d bytes", c.max)
+		}
+
+		if fin {
+			return message, nil
+		}
+	}
+}
+
+// readFrame reads one frame from the client.
+func (c *wsConn) readFrame() (fin bool, opcode byte, payload []byte, err error) {
+	var head [2]byte
+	if _, err := io.ReadFull(c.r, head[:]); err != nil {
+		return false, 0, nil, fmt.Errorf("reading frame header: %w", err)
+	}
+
+	fin = head[0]&0x80 != 0
+	opcode = head[0] & 0x0F
+	masked := head[1]&0x80 != 0
+	length := uint64(head[1] & 0x7F)
+
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.r, ext[:]); err != nil {
+			return false, 0, nil, fmt.Errorf("reading frame length: %w", err)
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.r, ext[:]); err != nil {
+			return false, 0, nil, fmt.Errorf("reading frame length: %w", err)
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+
+	if !masked {
+		return false, 0, nil, errors.New("frame of the client is not masked")
+	}
+
+	if length > math.MaxInt64 {
+		return false, 0, nil, errors.New("invalid frame length")
+	}
+
+	if c.max > 0 && length > uint64(c.max) {
+		return false, 0, nil, fmt.Errorf("frame is bigger then %d bytes", c.max)
+	}
+
+	var mask [4]byte
+	if _, err := io.ReadFull(c.r, mask[:]); err != nil {
+		return false, 0, nil, fmt.Errorf("reading frame mask: %w", err)
+	}
+
+	// The payload is read as it arrives, so a client without a limit can not
+	// allocate memory with the length of a frame alone.
+	payload, err = io.ReadAll(io.LimitReader(c.r, int64(length)))
+	if err != nil {
+		return false, 0, nil, fmt.Errorf("reading frame payload: %w", err)
+	}
+	if uint64(len(payload)) < length {
+		return false, 0, nil, fmt.Errorf("reading frame payload: %w", io.ErrUnexpectedEOF)
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return fin, opcode, payload, nil
+}
+
+// writeFrame writes one unfragmented frame to the client.
+func (c *wsConn) writeFrame(opcode byte, payload []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	frame := make([]byte, 0, len(payload)+10)
+	frame = append(frame, 0x80|opcode)
+	switch {
+	case len(payload) < 126:
+		frame = append(frame, byte(len(payload)))
+
+	case len(payload) <= 0xFFFF:
+		frame = append(frame, 126, 0, 0)
+		binary.BigEndian.PutUint16(frame[2:], uint16(len(payload)))
+
+	default:
+		frame = append(frame, 127, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(frame[2:], uint64(len(payload)))
+	}
+	frame = append(frame, payload...)
+
+	c.conn.SetWriteDeadline(time.Now().Add(websocketWriteTimeout))
+	if _, err := c.conn.Write(frame); err != nil {
+		return fmt.Errorf("writing frame: %w", err)
+	}
+	return nil
+}
+
+// wsResponseWriter sends each call to Write as one text message. The
+// autoupdate handler writes each message with one call to Write.
+type wsResponseWriter struct {
+	conn   *wsConn
+	header http.Header
+}
+
+func (w *wsResponseWriter) Header() http.Header {
+	return w.header
+}
+
+// WriteHeader does nothing. The status of a websocket connection was already
+// sent with the handshake.
+func (w *wsResponseWriter) WriteHeader(int) {}
+
+func (w *wsResponseWriter) Write(p []byte) (int, error) {
+	if err := w.conn.writeFrame(wsText, bytes.TrimRight(p, "\n")); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Flush does nothing. Each message is written directly.
+func (w *wsResponseWriter) Flush() {}
@@ -0,0 +1,200 @@
+package http_test
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/openslides/openslides-autoupdate-service/internal/autoupdate"
+	ahttp "github.com/openslides/openslides-autoupdate-service/internal/http"
+	"github.com/openslides/openslides-autoupdate-service/internal/test"
+)
+
+func TestWebsocket(t *testing.T) {
+	closed := make(chan struct{})
+	defer close(closed)
+	datastore := test.NewMockDatastore()
+	s := autoupdate.New(datastore, new(test.MockRestricter), closed)
+	srv := httptest.NewServer(ahttp.New(s, &test.MockAuth{Default: 1}))
+	defer srv.Close()
+
+	conn, r := dialWebsocket(t, srv.URL+"/system/autoupdate/ws?capabilities=nested")
+	defer conn.Close()
+
+	writeClientFrame(t, conn, 0x1, []byte(`[{"ids":[1],"collection":"user","fields":{"name":null}}]`))
+
+	if got := readServerFrame(t, r); got != `{"user":{"1":{"name":"Hello World"}}}` {
+		t.Errorf("Got first message `%s`, expected the nested value of user/1/name", got)
+	}
+
+	datastore.Update(map[string]json.RawMessage{"user/1/name": []byte(`"new"`)})
+	datastore.Send(test.Str("user/1/name"))
+
+	if got := readServerFrame(t, r); got != `{"user":{"1":{"name":"new"}}}` {
+		t.Errorf("Got second message `%s`, expected the new value of user/1/name", got)
+	}
+}
+
+func TestWebsocketInvalidHandshake(t *testing.T) {
+	closed := make(chan struct{})
+	defer close(closed)
+	s := autoupdate.New(test.NewMockDatastore(), new(test.MockRestricter), closed)
+	srv := httptest.NewServer(ahttp.New(s, &test.MockAuth{Default: 1}))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/system/autoupdate/ws")
+	if err != nil {
+		t.Fatalf("Can not send request: %v", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Got status %s, expected %d", resp.Status, http.StatusBadRequest)
+	}
+}
+
+func TestWebsocketOrigin(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		options []ahttp.Option
+		origin  string
+		expect  int
+	}{
+		{"no origin", nil, "", http.StatusSwitchingProtocols},
+		{"same origin", nil, "http://HOST", http.StatusSwitchingProtocols},
+		{"other origin", nil, "http://example.com", http.StatusForbidden},
+		{"allowed origin", []ahttp.Option{ahttp.WithCORS([]string{"http://example.com"}, nil, nil)}, "http://example.com", http.StatusSwitchingProtocols},
+		{"any origin", []ahttp.Option{ahttp.WithCORS([]string{"*"}, nil, nil)}, "http://example.com", http.StatusSwitchingProtocols},
+		{"not allowed origin", []ahttp.Option{ahttp.WithCORS([]string{"http://example.com"}, nil, nil)}, "http://example.org", http.StatusForbidden},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			closed := make(chan struct{})
+			defer close(closed)
+			s := autoupdate.New(test.NewMockDatastore(), new(test.MockRestricter), closed)
+			srv := httptest.NewServer(ahttp.New(s, &test.MockAuth{Default: 1}, tt.options...))
+			defer srv.Close()
+
+			var header string
+			if tt.origin != "" {
+				header = "Origin: " + strings.Replace(tt.origin, "HOST", strings.TrimPrefix(srv.URL, "http://"), 1) + "\r\n"
+			}
+
+			conn, resp, _ := handshake(t, srv.URL+"/system/autoupdate/ws", header)
+			defer conn.Close()
+
+			if resp.StatusCode != tt.expect {
+				t.Errorf("Got status %s, expected %d", resp.Status, tt.expect)
+			}
+		})
+	}
+}
+
+func TestWebsocketMaxBodySize(t *testing.T) {
+	closed := make(chan struct{})
+	defer close(closed)
+	s := autoupdate.New(test.NewMockDatastore(), new(test.MockRestricter), closed)
+	srv := httptest.NewServer(ahttp.New(s, &test.MockAuth{Default: 1}, ahttp.WithMaxBodySize(10)))
+	defer srv.Close()
+
+	conn, r := dialWebsocket(t, srv.URL+"/system/autoupdate/ws")
+	defer conn.Close()
+
+	writeClientFrame(t, conn, 0x1, []byte(`[{"ids":[1],"collection":"user","fields":{"name":null}}]`))
+
+	// The server closes the connection without an answer.
+	if _, err := r.ReadByte(); err != io.EOF {
+		t.Errorf("Got error %v, expected io.EOF", err)
+	}
+}
+
+// dialWebsocket does a websocket handshake to the url.
+func dialWebsocket(t *testing.T, url string) (net.Conn, *bufio.Reader) {
+	t.Helper()
+
+	conn, resp, r := handshake(t, url, "")
+
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("Got status %s, expected %d", resp.Status, http.StatusSwitchingProtocols)
+	}
+
+	// Example from RFC 6455.
+	if got := resp.Header.Get("Sec-WebSocket-Accept"); got != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Errorf("Got Sec-WebSocket-Accept `%s`, expected `s3pPLMBiTxaQ9kYGzzhZRbK+xOo=`", got)
+	}
+	return conn, r
+}
+
+// handshake sends a websocket handshake with the additional header lines to
+// the url and returns the response.
+func handshake(t *testing.T, url string, header string) (net.Conn, *http.Response, *bufio.Reader) {
+	t.Helper()
+
+	addr := strings.TrimPrefix(url, "http://")
+	path := addr[strings.IndexByte(addr, '/'):]
+	addr = addr[:strings.IndexByte(addr, '/')]
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Can not connect: %v", err)
+	}
+	conn.SetDeadline(time.Now().Add(time.Second))
+
+	fmt.Fprintf(conn, "GET %s HTTP/1.1\r\nHost: %s\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\n%s\r\n", path, addr, header)
+
+	r := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(r, nil)
+	if err != nil {
+		t.Fatalf("Can not read handshake: %v", err)
+	}
+	return conn, resp, r
+}
+
+// writeClientFrame writes a small masked frame.
+func writeClientFrame(t *testing.T, w io.Writer, opcode byte, payload []byte) {
+	t.Helper()
+
+	mask := []byte{1, 2, 3, 4}
+	frame := []byte{0x80 | opcode, 0x80 | 126, 0, 0}
+	binary.BigEndian.PutUint16(frame[2:], uint16(len(payload)))
+	frame = append(frame, mask...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+
+	if _, err := w.Write(frame); err != nil {
+		t.Fatalf("Can not write frame: %v", err)
+	}
+}
+
+// readServerFrame reads an unmasked frame and returns its payload.
+func readServerFrame(t *testing.T, r *bufio.Reader) string {
+	t.Helper()
+
+	var head [2]byte
+	if _, err := io.ReadFull(r, head[:]); err != nil {
+		t.Fatalf("Can not read frame: %v", err)
+	}
+
+	length := int(head[1] & 0x7F)
+	if length == 126 {
+		var ext [2]byte
+		if _, err := io.ReadFull(r, ext[:]); err != nil {
+			t.Fatalf("Can not read frame length: %v", err)
+		}
+		length = int(binary.BigEndian.Uint16(ext[:]))
+	}
+
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		t.Fatalf("Can not read payload: %v", err)
+	}
+	return string(payload)
+}
@@ -22,6 +22,8 @@ package keysbuilder
 import (
 	"encoding/json"
 	"fmt"
+	"reflect"
+	"sort"
 	"strings"
 )
 
@@ -40,28 +42,87 @@ type body struct {
 	fieldsMap
 }
 
+// filterScope is the field of a filter, that selects the meeting. The ids of
+// the other collections are read from the relation lists of the meeting.
+const filterScope = "meeting_id"
+
+// organizationID is the id of the only organization. The ids of collections,
+// that do not belong to a meeting, are read from its relation lists.
+const organizationID = 1
+
 // UnmarshallJSON builds a body object from json. It looks for the type argument
 // in the fields and decodes the fields accorently.
+//
+// Instead of ids, a body can have a filter or the attribute all. See
+// filterBody and allBody.
+//
+// With "fields": "*", all fields of the collection from the schema are
+// requested. See fieldsMap.
+//
+// With the attribute exclude_fields, all fields of the collection from the
+// schema are requested except the given fields. This attribute can also be
+// used in relation and generic-relation fields. The attribute fields is
+// optional in this case. It can be used for the description of relation
+// fields.
+//
+//	{
+//		"ids": [1],
+//		"collection": "motion",
+//		"exclude_fields": ["amendment_paragraph_$", "text"]
+//	}
 func (b *body) UnmarshalJSON(data []byte) error {
 	var field struct {
-		IDs        []int     `json:"ids"`
-		Collection string    `json:"collection"`
-		Fields     fieldsMap `json:"fields"`
+		IDs           []int                      `json:"ids"`
+		Collection    string                     `json:"collection"`
+		Fields        fieldsMap                  `json:"fields"`
+		ExcludeFields []string                   `json:"exclude_fields"`
+		Filter        map[string]json.RawMessage `json:"filter"`
+		All           bool                       `json:"all"`
+		MeetingID     int                        `json:"meeting_id"`
 	}
 
 	// Read and validate the data.
 	if err := json.Unmarshal(data, &field); err != nil {
 		return err
 	}
-	if len(field.IDs) == 0 {
+	selectors := 0
+	for _, set := range []bool{len(field.IDs) > 0, field.Filter != nil, field.All} {
+		if set {
+			selectors++
+		}
+	}
+	if selectors > 1 {
+		return InvalidError{msg: "only one of ids, filter and all can be used"}
+	}
+	if selectors == 0 {
 		return InvalidError{msg: "no ids"}
 	}
+	if field.MeetingID != 0 && !field.All {
+		return InvalidError{msg: "meeting_id can only be used with all"}
+	}
 	if field.Collection == "" {
 		return InvalidError{msg: "no collection"}
 	}
-	if field.Fields.fields == nil {
+	if field.Fields.fields == nil && field.ExcludeFields == nil {
 		return InvalidError{msg: "no fields"}
 	}
+	if field.ExcludeFields != nil {
+		field.Fields.excludeFields(field.ExcludeFields)
+	}
+
+	if field.Filter != nil {
+		fb, err := filterBody(field.Collection, field.Filter, field.Fields)
+		if err != nil {
+			return err
+		}
+		*b = fb
+		return nil
+	}
+
+	if field.All {
+		*b = allBody(field.Collection, field.MeetingID, field.Fields)
+		return nil
+	}
 
 	// Set the body fields.
 	b.ids = field.IDs
@@ -70,12 +131,86 @@ func (b *body) UnmarshalJSON(data []byte) error {
 	return nil
 }
 
-func (b *body) keys(data map[string]fieldDescription) error {
+// filterBody returns a body for all objects of the collection, where the
+// fields have the values of the filter.
+//
+// {
+//	"collection": "motion",
+//	"filter": {"meeting_id": 5, "state_id": 3},
+//	"fields": {"title": null}
+// }
+//
+// The datastore can not search for objects, so the filter needs the field
+// meeting_id. The ids are read from the relation list of the meeting, in this
+// example meeting/5/motion_ids. The other fields of the filter are used like
+// the attribute where of a relation-list field.
+func filterBody(collection string, filter map[string]json.RawMessage, fields fieldsMap) (body, error) {
+	raw, ok := filter[filterScope]
+	if !ok {
+		return body{}, InvalidError{msg: "the filter needs the field " + filterScope, field: "filter"}
+	}
+
+	var meetingID int
+	if err := json.Unmarshal(raw, &meetingID); err != nil || meetingID <= 0 {
+		return body{}, InvalidError{msg: "invalid filter value", field: filterScope}
+	}
+
+	conditions := make(map[string]json.RawMessage, len(filter)-1)
+	for name, value := range filter {
+		if name != filterScope {
+			conditions[name] = value
+		}
+	}
+
+	relation := &relationListField{relationField: relationField{collection: collection, fieldsMap: fields}}
+	if len(conditions) > 0 {
+		where, err := newWhereField(conditions, fields)
+		if err != nil {
+			return body{}, err
+		}
+		relation.where = where
+	}
+	return scopeBody("meeting", meetingID, relation), nil
+}
+
+// allBody returns a body for all objects of the collection.
+//
+// {
+//	"collection": "committee",
+//	"all": true,
+//	"fields": {"name": null}
+// }
+//
+// The ids are read from the relation list of the organization, in this
+// example organization/1/committee_ids. With the attribute meeting_id, the
+// ids are read from the relation list of the meeting instead. So the ids are
+// updated, when an object is created or deleted.
+func allBody(collection string, meetingID int, fields fieldsMap) body {
+	relation := &relationListField{relationField: relationField{collection: collection, fieldsMap: fields}}
+	if meetingID > 0 {
+		return scopeBody("meeting", meetingID, relation)
+	}
+	return scopeBody("organization", organizationID, relation)
+}
+
+// scopeBody returns a body for the object scopeCollection/scopeID, that
+// requests the relation list of the collection of relation. The name of the
+// relation list is the collection with the suffix _ids.
+func scopeBody(scopeCollection string, scopeID int, relation *relationListField) body {
+	return body{
+		ids:        []int{scopeID},
+		collection: scopeCollection,
+		fieldsMap: fieldsMap{fields: map[string]fieldDescription{
+			relation.collection + "_ids": relation,
+		}},
+	}
+}
+
+func (b *body) keys(data map[string]fieldDescription) {
 	for _, id := range b.ids {
 		cid := buildCollectionID(b.collection, id)
 		b.fieldsMap.keys(cid, data)
 	}
-	return nil
 }
 
 // relationField is a fieldtype that redirects to one other collection.
@@ -98,8 +233,9 @@ type relationField struct {
 
 func (r *relationField) UnmarshalJSON(data []byte) error {
 	var field struct {
-		Collection string    `json:"collection"`
-		Fields     fieldsMap `json:"fields"`
+		Collection    string    `json:"collection"`
+		Fields        fieldsMap `json:"fields"`
+		ExcludeFields []string  `json:"exclude_fields"`
 	}
 	if err := json.Unmarshal(data, &field); err != nil {
 		return err
@@ -107,9 +243,12 @@ func (r *relationField) UnmarshalJSON(data []byte) error {
 	if field.Collection == "" {
 		return InvalidError{msg: "no collection"}
 	}
-	if field.Fields.fields == nil {
+	if field.Fields.fields == nil && field.ExcludeFields == nil {
 		return InvalidError{msg: "no fields"}
 	}
+	if field.ExcludeFields != nil {
+		field.Fields.excludeFields(field.ExcludeFields)
+	}
 	r.collection = field.Collection
 	r.fieldsMap = field.Fields
 	return nil
@@ -139,8 +278,87 @@ func (r *relationField) keys(key string, value json.RawMessage, data map[string]
 //		}
 //	}
 // }
+//
+// With the optional attribute where, only the objects are used, where the
+// given fields have the given values. The values are read with the
+// permissions of the user, so objects with fields the user can not see are
+// skipped. This can be used to aggregate data over many meetings, for example
+// all active meetings of a committee.
+//
+// {
+//	"ids": [1],
+//	"collection": "committee",
+//	"fields": {
+//		"meeting_ids": {
+//			"type": "relation-list",
+//			"collection": "meeting",
+//			"where": {"is_active_in_organization_id": 1},
+//			"fields": {"name": null}
+//		}
+//	}
+// }
+//
+// With the optional attributes limit and offset, only a part of the ids is
+// used. The ids are used in the order of the relation list. The limit is
+// applied before the attribute where.
+//
+// {
+//	"ids": [1],
+//	"collection": "meeting",
+//	"fields": {
+//		"motion_ids": {
+//			"type": "relation-list",
+//			"collection": "motion",
+//			"offset": 50,
+//			"limit": 50,
+//			"fields": {"title": null}
+//		}
+//	}
+// }
 type relationListField struct {
 	relationField
+	where  *whereField
+	offset int
+	limit  int
+}
+
+func (r *relationListField) UnmarshalJSON(data []byte) error {
+	if err := r.relationField.UnmarshalJSON(data); err != nil {
+		return err
+	}
+
+	var field struct {
+		Where  map[string]json.RawMessage `json:"where"`
+		Offset int                        `json:"offset"`
+		Limit  *int                       `json:"limit"`
+	}
+	if err := json.Unmarshal(data, &field); err != nil {
+		return err
+	}
+	if field.Offset < 0 {
+		return InvalidError{msg: "negative offset"}
+	}
+	if field.Limit != nil && *field.Limit < 1 {
+		return InvalidError{msg: "limit has to be positive"}
+	}
+	r.offset = field.Offset
+	if field.Limit != nil {
+		r.limit = *field.Limit
+	}
+
+	if field.Where == nil {
+		return nil
+	}
+	if len(field.Where) == 0 {
+		return InvalidError{msg: "empty where"}
+	}
+
+	where, err := newWhereField(field.Where, r.fieldsMap)
+	if err != nil {
+		return err
+	}
+	r.where = where
+	return nil
 }
 
 func (r *relationListField) keys(key string, value json.RawMessage, data map[string]fieldDescription) error {
@@ -149,12 +367,81 @@ func (r *relationListField) keys(key string, value json.RawMessage, data map[str
 		return fmt.Errorf("decoding value for key %s: %w", key, err)
 	}
 
+	if r.offset >= len(ids) {
+		return nil
+	}
+	ids = ids[r.offset:]
+	if r.limit > 0 && r.limit < len(ids) {
+		ids = ids[:r.limit]
+	}
+
 	for _, id := range ids {
 		cid := buildCollectionID(r.collection, id)
-		for field, description := range r.fields {
-			data[buildGenericKey(cid, field)] = description
+		if r.where != nil {
+			data[buildGenericKey(cid, r.where.field)] = r.where
+			continue
 		}
+
+		r.fieldsMap.keys(cid, data)
+	}
+	return nil
+}
+
+// whereField is a condition of a relation-list field. The value of the key has
+// to be the expected value. In this case, the next condition is checked or,
+// if this is the last condition, the fields are requested.
+type whereField struct {
+	field    string
+	expected interface{}
+	next     *whereField
+	fields   fieldsMap
+}
+
+// newWhereField creates a chain of whereFields for the conditions. The fields
+// are requested, when all conditions are true.
+func newWhereField(conditions map[string]json.RawMessage, fields fieldsMap) (*whereField, error) {
+	names := make([]string, 0, len(conditions))
+	for name := range conditions {
+		names = append(names, name)
+	}
+	// Sort the fields, so the keys of the builder are deterministic.
+	sort.Strings(names)
+
+	var first, last *whereField
+	for _, name := range names {
+		var expected interface{}
+		if err := json.Unmarshal(conditions[name], &expected); err != nil {
+			return nil, InvalidError{msg: "invalid where value", field: name}
+		}
+
+		w := &whereField{field: name, expected: expected, fields: fields}
+		if first == nil {
+			first = w
+		} else {
+			last.next = w
+		}
+		last = w
+	}
+	return first, nil
+}
+
+func (w *whereField) keys(key string, value json.RawMessage, data map[string]fieldDescription) error {
+	var got interface{}
+	if err := json.Unmarshal(value, &got); err != nil {
+		return fmt.Errorf("decoding value for key %s: %w", key, err)
+	}
+
+	if !reflect.DeepEqual(got, w.expected) {
+		return nil
+	}
+
+	cid := key[:strings.LastIndex(key, keySep)]
+	if w.next != nil {
+		data[buildGenericKey(cid, w.next.field)] = w.next
+		return nil
 	}
+
+	w.fields.keys(cid, data)
 	return nil
 }
 
@@ -170,21 +457,53 @@ func (r *relationListField) keys(key string, value json.RawMessage, data map[str
 //		}
 //	}
 // }
+//
+// The value of the field is a collection and an id like "motion/5". With the
+// optional attribute collections, each collection can request other fields.
+// The fields in the attribute fields are requested for all collections. In
+// this case, the attribute fields is optional.
+//
+// {
+//	"ids": [1],
+//	"collection": "agenda_item",
+//	"fields": {
+//		"content_object_id": {
+//			"type": "generic-relation",
+//			"fields": {"title": null},
+//			"collections": {
+//				"motion": {"number": null},
+//				"assignment": {"open_posts": null}
+//			}
+//		}
+//	}
+// }
 type genericRelationField struct {
 	fieldsMap
+	collections map[string]fieldsMap
 }
 
 func (g *genericRelationField) UnmarshalJSON(data []byte) error {
 	var field struct {
-		Fields fieldsMap `json:"fields"`
+		Fields        fieldsMap            `json:"fields"`
+		ExcludeFields []string             `json:"exclude_fields"`
+		Collections   map[string]fieldsMap `json:"collections"`
 	}
 	if err := json.Unmarshal(data, &field); err != nil {
 		return err
 	}
-	if field.Fields.fields == nil {
+	if field.Fields.fields == nil && field.ExcludeFields == nil && len(field.Collections) == 0 {
 		return InvalidError{msg: "no fields"}
 	}
+	if field.ExcludeFields != nil {
+		field.Fields.excludeFields(field.ExcludeFields)
+	}
+	for collection, fields := range field.Collections {
+		if fields.fields == nil {
+			return InvalidError{msg: "no fields", field: collection}
+		}
+	}
 	g.fieldsMap = field.Fields
+	g.collections = field.Collections
 	return nil
 }
 
@@ -194,10 +513,26 @@ func (g *genericRelationField) keys(key string, value json.RawMessage, data map[
 		return fmt.Errorf("decoding value for key %s: %w", key, err)
 	}
 
-	g.fieldsMap.keys(cid, data)
+	g.cidKeys(cid, data)
 	return nil
 }
 
+// cidKeys adds the keys of one related object. cid is the value of a generic
+// relation field like "motion/5".
+func (g *genericRelationField) cidKeys(cid string, data map[string]fieldDescription) {
+	if cid == "" {
+		// The field is not set.
+		return
+	}
+
+	g.fieldsMap.keys(cid, data)
+	if idx := strings.Index(cid, keySep); idx > 0 {
+		if fields, ok := g.collections[cid[:idx]]; ok {
+			fields.keys(cid, data)
+		}
+	}
+}
+
 // genericRelationListField is like a genericRelationField but with a list of relations.
 //
 // {
@@ -221,7 +556,7 @@ func (g *genericRelationListField) keys(key string, value json.RawMessage, data
 	}
 
 	for _, cid := range cids {
-		g.fieldsMap.keys(cid, data)
+		g.cidKeys(cid, data)
 	}
 	return nil
 }
@@ -331,11 +666,30 @@ func unmarshalField(data []byte) (fieldDescription, error) {
 //
 // A fieldsMap knows how to be decoded from json and how to build the keys from
 // it.
+//
+// With all, the fields of the schema are also requested, except the fields in
+// exclude. In json, all fields are requested with the string "*" instead of
+// an object or with the field "*" in the object. The second form can describe
+// relation fields:
+//
+//	{"*": null, "submitter_ids": {"type": "relation-list", ...}}
 type fieldsMap struct {
-	fields map[string]fieldDescription
+	fields  map[string]fieldDescription
+	all     bool
+	exclude map[string]bool
 }
 
 func (f *fieldsMap) UnmarshalJSON(data []byte) error {
+	var all string
+	if err := json.Unmarshal(data, &all); err == nil {
+		if all != allKey {
+			return InvalidError{msg: fmt.Sprintf("invalid fields `%s`", all)}
+		}
+		f.fields = make(map[string]fieldDescription)
+		f.all = true
+		return nil
+	}
+
 	var fm map[string]json.RawMessage
 	if err := json.Unmarshal(data, &fm); err != nil {
 		return fmt.Errorf("decode fields: %w", err)
@@ -343,6 +697,14 @@ func (f *fieldsMap) UnmarshalJSON(data []byte) error {
 
 	f.fields = make(map[string]fieldDescription, len(fm))
 	for name, field := range fm {
+		if name == allKey {
+			if string(field) != "null" {
+				return InvalidError{msg: "the field * can not have a description", field: name}
+			}
+			f.all = true
+			continue
+		}
+
 		fd, err := unmarshalField(field)
 		if err != nil {
 			if sub, ok := err.(InvalidError); ok {
@@ -359,4 +721,20 @@ func (f *fieldsMap) keys(cid string, data map[string]fieldDescription) {
 	for field, description := range f.fields {
 		data[buildGenericKey(cid, field)] = description
 	}
+	if f.all {
+		data[buildGenericKey(cid, allKey)] = &allFields{*f}
+	}
+}
+
+// excludeFields requests all fields of the schema except the given fields.
+// The fields in f.fields are still requested with their description.
+func (f *fieldsMap) excludeFields(names []string) {
+	if f.fields == nil {
+		f.fields = make(map[string]fieldDescription)
+	}
+	f.all = true
+	f.exclude = make(map[string]bool, len(names))
+	for _, name := range names {
+		f.exclude[name] = true
+	}
 }
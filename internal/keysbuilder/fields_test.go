@@ -213,6 +213,72 @@ func TestRequestErrors(t *testing.T) {
 			`field "group_ids.perm_ids": no fields`,
 			strs("group_ids", "perm_ids"),
 		},
+		{
+			"Empty where",
+			`{
+				"ids": [5],
+				"collection": "user",
+				"fields": {
+					"group_ids": {
+						"type": "relation-list",
+						"collection": "group",
+						"where": {},
+						"fields": {"name": null}
+					}
+				}
+			}`,
+			`field "group_ids": empty where`,
+			strs("group_ids"),
+		},
+		{
+			"Limit zero",
+			`{
+				"ids": [1],
+				"collection": "meeting",
+				"fields": {
+					"motion_ids": {
+						"type": "relation-list",
+						"collection": "motion",
+						"limit": 0,
+						"fields": {"title": null}
+					}
+				}
+			}`,
+			`field "motion_ids": limit has to be positive`,
+			strs("motion_ids"),
+		},
+		{
+			"Filter without meeting",
+			`{
+				"collection": "motion",
+				"filter": {"state_id": 3},
+				"fields": {"title": null}
+			}`,
+			`field "filter": the filter needs the field meeting_id`,
+			strs("filter"),
+		},
+		{
+			"Filter and ids",
+			`{
+				"ids": [1],
+				"collection": "motion",
+				"filter": {"meeting_id": 5},
+				"fields": {"title": null}
+			}`,
+			"only one of ids, filter and all can be used",
+			strs(),
+		},
+		{
+			"Meeting without all",
+			`{
+				"ids": [1],
+				"collection": "motion",
+				"meeting_id": 5,
+				"fields": {"title": null}
+			}`,
+			"meeting_id can only be used with all",
+			strs(),
+		},
 	} {
 		t.Run(tt.name, func(t *testing.T) {
 			_, err := keysbuilder.FromJSON(context.Background(), strings.NewReader(tt.input), &mockDataProvider{}, 1)
@@ -0,0 +1,41 @@
+//go:build go1.18
+// +build go1.18
+
+package keysbuilder
+
+import (
+	"testing"
+)
+
+func FuzzUnmarshalField(f *testing.F) {
+	for _, seed := range []string{
+		`null`,
+		`{"type":"relation","collection":"note","fields":{"important":null}}`,
+		`{"type":"relation-list","collection":"group","fields":{"name":null}}`,
+		`{"type":"generic-relation","fields":{"name":null}}`,
+		`{"type":"generic-relation-list","fields":{"name":null}}`,
+		`{"type":"generic-relation","collections":{"motion":{"number":null}}}`,
+		`{"type":"relation","collection":"note","exclude_fields":["text"]}`,
+		`{"type":"relation","collection":"note","fields":"*"}`,
+		`{"type":"generic-relation","fields":{"*":null}}`,
+		`{"type":"template"}`,
+		`{"type":"template","values":{"type":"relation-list","collection":"group","fields":{}}}`,
+		`{"type":""}`,
+		`{"type":5}`,
+	} {
+		f.Add([]byte(seed))
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		fd, err := unmarshalField(data)
+		if err != nil || fd == nil {
+			return
+		}
+
+		// Build keys with some values to make sure, that a successfully
+		// parsed field does not panic.
+		for _, value := range []string{`1`, `[1,2]`, `"a/1"`, `["a/1"]`, `["1"]`} {
+			fd.keys("a/1/b_$_ids", []byte(value), make(map[string]fieldDescription))
+		}
+	})
+}
@@ -0,0 +1,79 @@
+//go:build go1.18
+// +build go1.18
+
+package keysbuilder_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/openslides/openslides-autoupdate-service/internal/keysbuilder"
+)
+
+// fuzzDataProvider returns a value for each key that depends on the suffix of
+// the key. This makes sure, that relation fields are followed.
+type fuzzDataProvider struct{}
+
+func (fuzzDataProvider) RestrictedData(ctx context.Context, uid int, keys ...string) (map[string]json.RawMessage, error) {
+	data := make(map[string]json.RawMessage, len(keys))
+	for _, key := range keys {
+		switch {
+		case strings.HasSuffix(key, "_id"):
+			data[key] = []byte(`1`)
+		case strings.HasSuffix(key, "_ids"):
+			data[key] = []byte(`[1,2]`)
+		case strings.Contains(key, "$"):
+			data[key] = []byte(`["1","2"]`)
+		default:
+			data[key] = []byte(`"value"`)
+		}
+	}
+	return data, nil
+}
+
+var fuzzSeeds = []string{
+	`{"ids":[1],"collection":"user","fields":{"name":null}}`,
+	`{"ids":[1],"collection":"user","fields":{"group_ids":{"type":"relation-list","collection":"group","fields":{"name":null}}}}`,
+	`{"ids":[1],"collection":"user","fields":{"note_id":{"type":"relation","collection":"note","fields":{"important":null}}}}`,
+	`{"ids":[1],"collection":"user","fields":{"seen":{"type":"generic-relation-list","fields":{"name":null}}}}`,
+	`{"ids":[1],"collection":"user","fields":{"group_$_ids":{"type":"template","values":{"type":"relation-list","collection":"group","fields":{"name":null}}}}}`,
+	`{"collection":"motion","filter":{"meeting_id":1,"state_id":3},"fields":{"title":null}}`,
+	`{"collection":"motion","all":true,"meeting_id":1,"fields":{"title":null}}`,
+	`{"ids":[1],"collection":"user","fields":{"name":{"type":"unknown"}}}`,
+	`{"ids":["1"],"collection":"user","fields":{}}`,
+	`{5`,
+	``,
+}
+
+func FuzzFromJSON(f *testing.F) {
+	for _, seed := range fuzzSeeds {
+		f.Add([]byte(seed))
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		kb, err := keysbuilder.FromJSON(context.Background(), bytes.NewReader(data), fuzzDataProvider{}, 1)
+		if err != nil {
+			return
+		}
+		kb.Keys()
+	})
+}
+
+func FuzzManyFromJSON(f *testing.F) {
+	for _, seed := range fuzzSeeds {
+		f.Add([]byte("[" + seed + "]"))
+	}
+	f.Add([]byte(`[]`))
+	f.Add([]byte(fuzzSeeds[0]))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		kb, err := keysbuilder.ManyFromJSON(context.Background(), bytes.NewReader(data), fuzzDataProvider{}, 1)
+		if err != nil {
+			return
+		}
+		kb.Keys()
+	})
+}
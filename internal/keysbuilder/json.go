@@ -9,7 +9,7 @@ import (
 )
 
 // FromJSON creates a Keysbuilder from json.
-func FromJSON(ctx context.Context, r io.Reader, dataProvider DataProvider, uid int) (*Builder, error) {
+func FromJSON(ctx context.Context, r io.Reader, dataProvider DataProvider, uid int, options ...Option) (*Builder, error) {
 	var b body
 	if err := json.NewDecoder(r).Decode(&b); err != nil {
 		if err == io.EOF {
@@ -21,7 +21,7 @@ func FromJSON(ctx context.Context, r io.Reader, dataProvider DataProvider, uid i
 		return nil, JSONError{err}
 	}
 
-	kb, err := newBuilder(ctx, dataProvider, uid, b)
+	kb, err := newBuilder(ctx, dataProvider, uid, options, b)
 	if err != nil {
 		return nil, fmt.Errorf("build keys: %w", err)
 	}
@@ -29,7 +29,7 @@ func FromJSON(ctx context.Context, r io.Reader, dataProvider DataProvider, uid i
 }
 
 // ManyFromJSON creates a list of Keysbuilder objects from a json list.
-func ManyFromJSON(ctx context.Context, r io.Reader, dataProvider DataProvider, uid int) (*Builder, error) {
+func ManyFromJSON(ctx context.Context, r io.Reader, dataProvider DataProvider, uid int, options ...Option) (*Builder, error) {
 	var bs []body
 	if err := json.NewDecoder(r).Decode(&bs); err != nil {
 		if err == io.EOF {
@@ -63,7 +63,7 @@ func ManyFromJSON(ctx context.Context, r io.Reader, dataProvider DataProvider, u
 		return nil, InvalidError{msg: "No data"}
 	}
 
-	kb, err := newBuilder(ctx, dataProvider, uid, bs...)
+	kb, err := newBuilder(ctx, dataProvider, uid, options, bs...)
 	if err != nil {
 		return nil, fmt.Errorf("build keys: %w", err)
 	}
@@ -22,15 +22,43 @@ type Builder struct {
 	uid          int
 	bodies       []body
 	keys         []string
+	schema       *Schema
+	maxDepth     int
+	maxKeys      int
+}
+
+// Option is an optional argument for FromJSON, ManyFromJSON and
+// Presets.Builder.
+type Option func(*Builder)
+
+// WithMaxKeys limits the number of keys, that a Builder can create. If a
+// request has more keys, Update returns an InvalidError. A value of 0 means no
+// limit.
+func WithMaxKeys(max int) Option {
+	return func(b *Builder) {
+		b.maxKeys = max
+	}
+}
+
+// WithMaxDepth limits the number of nested levels, that are resolved by
+// Update. Each level needs a request to the datastore. If a request is nested
+// deeper, Update returns an InvalidError. A value of 0 means no limit.
+func WithMaxDepth(max int) Option {
+	return func(b *Builder) {
+		b.maxDepth = max
+	}
 }
 
 // newBuilder creates a new Builder instance from one or more bodies.
-func newBuilder(ctx context.Context, dataProvider DataProvider, uid int, bodys ...body) (*Builder, error) {
+func newBuilder(ctx context.Context, dataProvider DataProvider, uid int, options []Option, bodys ...body) (*Builder, error) {
 	b := &Builder{
 		dataProvider: dataProvider,
 		uid:          uid,
 		bodies:       bodys,
 	}
+	for _, o := range options {
+		o(b)
+	}
 	if err := b.Update(ctx); err != nil {
 		return nil, fmt.Errorf("build keys for the first time: %w", err)
 	}
@@ -59,26 +87,65 @@ func (b *Builder) Update(ctx context.Context) (err error) {
 	b.keys = b.keys[:0]
 	var needed []string
 	processed := make(map[string]fieldDescription)
+	depth := 0
+
+	// Relations can build cycles like motion -> lead_motion -> amendments.
+	// So each key is only listed once, each key with the same description
+	// is only expanded once and each value is only loaded once.
+	listed := make(map[string]bool)
+	visited := make(map[visitKey]bool)
+	values := make(map[string]json.RawMessage)
 	for {
+		// Replace the keys for all fields with the fields of the schema.
+		if err := b.expandAll(process); err != nil {
+			return err
+		}
+
 		// Get all keys and descriptions
 		for key, description := range process {
-			b.keys = append(b.keys, key)
+			if !listed[key] {
+				listed[key] = true
+				b.keys = append(b.keys, key)
+			}
 			if description == nil {
 				continue
 			}
 
-			needed = append(needed, key)
+			visit := visitKey{key: key, description: description}
+			if visited[visit] {
+				continue
+			}
+			visited[visit] = true
+
 			processed[key] = description
+			if _, ok := values[key]; !ok {
+				needed = append(needed, key)
+			}
+		}
+
+		if b.maxKeys > 0 && len(b.keys) > b.maxKeys {
+			return InvalidError{msg: fmt.Sprintf("the request has more then %d keys", b.maxKeys)}
 		}
 
-		if len(needed) == 0 {
+		if len(processed) == 0 {
 			break
 		}
 
-		// Get values for all special (not none) fields.
-		data, err := b.dataProvider.RestrictedData(ctx, b.uid, needed...)
-		if err != nil {
-			return fmt.Errorf("load needed keys: %w", err)
+		depth++
+		if b.maxDepth > 0 && depth > b.maxDepth {
+			return InvalidError{msg: fmt.Sprintf("the request has more then %d levels of nested fields", b.maxDepth)}
+		}
+
+		// Get values for all special (not none) fields, that were not loaded
+		// before.
+		if len(needed) > 0 {
+			data, err := b.dataProvider.RestrictedData(ctx, b.uid, needed...)
+			if err != nil {
+				return fmt.Errorf("load needed keys: %w", err)
+			}
+			for k, v := range data {
+				values[k] = v
+			}
 		}
 
 		// Clear process and needed without freeing the memory.
@@ -90,11 +157,11 @@ func (b *Builder) Update(ctx context.Context) (err error) {
 		for key, description := range processed {
 			// This are fields that do not exist or the user has not the
 			// permission to see them.
-			if data[key] == nil {
+			if values[key] == nil {
 				continue
 			}
 
-			if err := description.keys(key, data[key], process); err != nil {
+			if err := description.keys(key, values[key], process); err != nil {
 				var invalidErr *json.UnmarshalTypeError
 				if errors.As(err, &invalidErr) {
 					// value has wrong type.
@@ -112,11 +179,62 @@ func (b *Builder) Update(ctx context.Context) (err error) {
 	return nil
 }
 
-// Keys returns the keys.
+// visitKey is a key together with the description, that is used to expand
+// it.
+type visitKey struct {
+	key         string
+	description fieldDescription
+}
+
+// expandAll replaces the keys of allFields descriptions with the keys of the
+// fields from the schema.
+func (b *Builder) expandAll(process map[string]fieldDescription) error {
+	var all map[string]*allFields
+	for key, description := range process {
+		if a, ok := description.(*allFields); ok {
+			if all == nil {
+				all = make(map[string]*allFields)
+			}
+			all[key] = a
+		}
+	}
+
+	for key := range all {
+		delete(process, key)
+	}
+	for key, a := range all {
+		if err := a.expand(b.schema, key, process); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Keys returns a copy of the keys.
 func (b *Builder) Keys() []string {
 	return append(b.keys[:0:0], b.keys...)
 }
 
+// KeyCount returns the number of keys.
+func (b *Builder) KeyCount() int {
+	return len(b.keys)
+}
+
+// BodyCount returns the number of bodies of the request.
+func (b *Builder) BodyCount() int {
+	return len(b.bodies)
+}
+
+// ForEachKey calls f for each key without copying them. If f returns false,
+// the iteration stops.
+func (b *Builder) ForEachKey(f func(key string) bool) {
+	for _, key := range b.keys {
+		if !f(key) {
+			return
+		}
+	}
+}
+
 // buildGenericKey returns a valid key when the collection and id are already
 // together.
 //
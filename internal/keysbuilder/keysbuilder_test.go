@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"sort"
 	"strings"
 	"testing"
 	"time"
@@ -260,6 +261,244 @@ func TestKeys(t *testing.T) {
 			},
 			strs("user/1/likes", "other/1/name", "other/2/name"),
 		},
+		{
+			"Filter",
+			`{
+				"collection": "motion",
+				"filter": {"meeting_id": 5, "state_id": 3},
+				"fields": {"title": null}
+			}`,
+			map[string]json.RawMessage{
+				"meeting/5/motion_ids": []byte("[1,2]"),
+				"motion/1/state_id":    []byte("3"),
+				"motion/2/state_id":    []byte("4"),
+			},
+			strs("meeting/5/motion_ids", "motion/1/state_id", "motion/2/state_id", "motion/1/title"),
+		},
+		{
+			"Filter only meeting",
+			`{
+				"collection": "motion",
+				"filter": {"meeting_id": 5},
+				"fields": {"title": null}
+			}`,
+			map[string]json.RawMessage{
+				"meeting/5/motion_ids": []byte("[1,2]"),
+			},
+			strs("meeting/5/motion_ids", "motion/1/title", "motion/2/title"),
+		},
+		{
+			"All",
+			`{
+				"collection": "committee",
+				"all": true,
+				"fields": {"name": null}
+			}`,
+			map[string]json.RawMessage{
+				"organization/1/committee_ids": []byte("[1,2]"),
+			},
+			strs("organization/1/committee_ids", "committee/1/name", "committee/2/name"),
+		},
+		{
+			"All of a meeting",
+			`{
+				"collection": "motion",
+				"all": true,
+				"meeting_id": 5,
+				"fields": {"title": null}
+			}`,
+			map[string]json.RawMessage{
+				"meeting/5/motion_ids": []byte("[1]"),
+			},
+			strs("meeting/5/motion_ids", "motion/1/title"),
+		},
+		{
+			"Generic field with collections",
+			`{
+				"ids": [1, 2, 3],
+				"collection": "agenda_item",
+				"fields": {
+					"content_object_id": {
+						"type": "generic-relation",
+						"fields": {"title": null},
+						"collections": {
+							"motion": {"number": null},
+							"assignment": {
+								"candidate_ids": {
+									"type": "relation-list",
+									"collection": "user",
+									"fields": {"name": null}
+								}
+							}
+						}
+					}
+				}
+			}`,
+			map[string]json.RawMessage{
+				"agenda_item/1/content_object_id": []byte(`"motion/1"`),
+				"agenda_item/2/content_object_id": []byte(`"assignment/1"`),
+				"agenda_item/3/content_object_id": []byte(`"topic/1"`),
+				"assignment/1/candidate_ids":      []byte("[5]"),
+			},
+			strs(
+				"agenda_item/1/content_object_id",
+				"agenda_item/2/content_object_id",
+				"agenda_item/3/content_object_id",
+				"motion/1/title",
+				"motion/1/number",
+				"assignment/1/title",
+				"assignment/1/candidate_ids",
+				"user/5/name",
+				"topic/1/title",
+			),
+		},
+		{
+			"Generic list field only with collections",
+			`{
+				"ids": [1],
+				"collection": "user",
+				"fields": {
+					"likes": {
+						"type": "generic-relation-list",
+						"collections": {"motion": {"number": null}}
+					}
+				}
+			}`,
+			map[string]json.RawMessage{
+				"user/1/likes": []byte(`["motion/1","topic/2"]`),
+			},
+			strs("user/1/likes", "motion/1/number"),
+		},
+		{
+			"Generic field not set",
+			`{
+				"ids": [1],
+				"collection": "user",
+				"fields": {
+					"likes": {
+						"type": "generic-relation",
+						"fields": {"name": null}
+					}
+				}
+			}`,
+			nil,
+			strs("user/1/likes"),
+		},
+		{
+			"Relation list with limit",
+			`{
+				"ids": [1],
+				"collection": "meeting",
+				"fields": {
+					"motion_ids": {
+						"type": "relation-list",
+						"collection": "motion",
+						"limit": 2,
+						"fields": {"title": null}
+					}
+				}
+			}`,
+			map[string]json.RawMessage{
+				"meeting/1/motion_ids": []byte("[5,3,4,1]"),
+			},
+			strs("meeting/1/motion_ids", "motion/5/title", "motion/3/title"),
+		},
+		{
+			"Relation list with offset and limit",
+			`{
+				"ids": [1],
+				"collection": "meeting",
+				"fields": {
+					"motion_ids": {
+						"type": "relation-list",
+						"collection": "motion",
+						"offset": 1,
+						"limit": 2,
+						"fields": {"title": null}
+					}
+				}
+			}`,
+			map[string]json.RawMessage{
+				"meeting/1/motion_ids": []byte("[5,3,4,1]"),
+			},
+			strs("meeting/1/motion_ids", "motion/3/title", "motion/4/title"),
+		},
+		{
+			"Relation list with offset after the end",
+			`{
+				"ids": [1],
+				"collection": "meeting",
+				"fields": {
+					"motion_ids": {
+						"type": "relation-list",
+						"collection": "motion",
+						"offset": 10,
+						"fields": {"title": null}
+					}
+				}
+			}`,
+			map[string]json.RawMessage{
+				"meeting/1/motion_ids": []byte("[5,3,4,1]"),
+			},
+			strs("meeting/1/motion_ids"),
+		},
+		{
+			"Relation list with where",
+			`{
+				"ids": [1],
+				"collection": "committee",
+				"fields": {
+					"meeting_ids": {
+						"type": "relation-list",
+						"collection": "meeting",
+						"where": {"is_active_in_organization_id": 1},
+						"fields": {"name": null}
+					}
+				}
+			}`,
+			map[string]json.RawMessage{
+				"committee/1/meeting_ids":                []byte("[1,2,3]"),
+				"meeting/1/is_active_in_organization_id": []byte("1"),
+				"meeting/3/is_active_in_organization_id": []byte("2"),
+			},
+			strs(
+				"committee/1/meeting_ids",
+				"meeting/1/is_active_in_organization_id",
+				"meeting/2/is_active_in_organization_id",
+				"meeting/3/is_active_in_organization_id",
+				"meeting/1/name",
+			),
+		},
+		{
+			"Relation list with many where conditions",
+			`{
+				"ids": [1],
+				"collection": "meeting",
+				"fields": {
+					"motion_ids": {
+						"type": "relation-list",
+						"collection": "motion",
+						"where": {"state_id": 5, "category_id": 1},
+						"fields": {"title": null}
+					}
+				}
+			}`,
+			map[string]json.RawMessage{
+				"meeting/1/motion_ids": []byte("[1,2]"),
+				"motion/1/category_id": []byte("1"),
+				"motion/1/state_id":    []byte("5"),
+				"motion/2/category_id": []byte("1"),
+				"motion/2/state_id":    []byte("6"),
+			},
+			strs(
+				"meeting/1/motion_ids",
+				"motion/1/category_id",
+				"motion/1/state_id",
+				"motion/2/category_id",
+				"motion/2/state_id",
+				"motion/1/title",
+			),
+		},
 	} {
 		t.Run(tt.name, func(t *testing.T) {
 			dataProvider := &mockDataProvider{data: tt.data}
@@ -426,6 +665,18 @@ func TestUpdate(t *testing.T) {
 			strs("user/1/group_ids", "group/2/perm_ids", "perm/2/name", "perm/1/name"),
 			1,
 		},
+		{
+			"All with new object",
+			`{
+				"collection": "committee",
+				"all": true,
+				"fields": {"name": null}
+			}`,
+			map[string]json.RawMessage{"organization/1/committee_ids": []byte("[1]")},
+			map[string]json.RawMessage{"organization/1/committee_ids": []byte("[1,2]")},
+			strs("organization/1/committee_ids", "committee/1/name", "committee/2/name"),
+			1,
+		},
 	} {
 		t.Run(tt.name, func(t *testing.T) {
 			dataProvider := &mockDataProvider{data: tt.data}
@@ -603,3 +854,163 @@ func TestRequestCount(t *testing.T) {
 		t.Errorf("Updated() did %d requests, expected 1", dataProvider.requestCount)
 	}
 }
+
+func TestForEachKey(t *testing.T) {
+	json := strings.NewReader(`{"ids": [1,2,3], "collection": "user", "fields": {"name": null}}`)
+	b, err := keysbuilder.FromJSON(context.Background(), json, &mockDataProvider{}, 1)
+	if err != nil {
+		t.Fatalf("FromJSON returned unexpected error: %v", err)
+	}
+
+	var got []string
+	b.ForEachKey(func(key string) bool {
+		got = append(got, key)
+		return true
+	})
+	sort.Strings(got)
+	if expect := strs("user/1/name", "user/2/name", "user/3/name"); !cmpSlice(got, expect) {
+		t.Errorf("ForEachKey returned %v, expected %v", got, expect)
+	}
+
+	var count int
+	b.ForEachKey(func(key string) bool {
+		count++
+		return false
+	})
+	if count != 1 {
+		t.Errorf("ForEachKey called f %d times after returning false, expected 1", count)
+	}
+}
+
+func TestMaxDepth(t *testing.T) {
+	request := `{
+		"ids": [1],
+		"collection": "user",
+		"fields": {
+			"note_id": {
+				"type": "relation",
+				"collection": "note",
+				"fields": {
+					"user_id": {
+						"type": "relation",
+						"collection": "user",
+						"fields": {"name": null}
+					}
+				}
+			}
+		}
+	}`
+	dataProvider := &mockDataProvider{data: map[string]json.RawMessage{
+		"user/1/note_id": []byte("1"),
+		"note/1/user_id": []byte("2"),
+	}}
+
+	for _, tt := range []struct {
+		name     string
+		maxDepth int
+		valid    bool
+	}{
+		{"No limit", 0, true},
+		{"Enough", 2, true},
+		{"Too deep", 1, false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := keysbuilder.FromJSON(context.Background(), strings.NewReader(request), dataProvider, 1, keysbuilder.WithMaxDepth(tt.maxDepth))
+
+			if tt.valid {
+				if err != nil {
+					t.Errorf("FromJSON returned unexpected error: %v", err)
+				}
+				return
+			}
+
+			var invalid keysbuilder.InvalidError
+			if !errors.As(err, &invalid) {
+				t.Errorf("Got error %v, expected an InvalidError", err)
+			}
+		})
+	}
+}
+
+func TestCycle(t *testing.T) {
+	request := `{
+		"ids": [1],
+		"collection": "motion",
+		"fields": {
+			"lead_motion_id": {
+				"type": "relation",
+				"collection": "motion",
+				"fields": {
+					"lead_motion_id": {
+						"type": "relation",
+						"collection": "motion",
+						"fields": {"title": null}
+					}
+				}
+			}
+		}
+	}`
+	dataProvider := &mockDataProvider{data: map[string]json.RawMessage{
+		"motion/1/lead_motion_id": []byte("1"),
+	}}
+
+	b, err := keysbuilder.FromJSON(context.Background(), strings.NewReader(request), dataProvider, 1)
+	if err != nil {
+		t.Fatalf("FromJSON returned unexpected error: %v", err)
+	}
+
+	expect := strs("motion/1/lead_motion_id", "motion/1/title")
+	keys := b.Keys()
+	sort.Strings(keys)
+	if !cmpSlice(keys, expect) {
+		t.Errorf("Got keys %v, expected %v", keys, expect)
+	}
+
+	if dataProvider.requestCount != 1 {
+		t.Errorf("Update() did %d requests, expected 1", dataProvider.requestCount)
+	}
+}
+
+func TestMaxKeys(t *testing.T) {
+	request := `{
+		"ids": [1],
+		"collection": "user",
+		"fields": {
+			"name": null,
+			"group_ids": {
+				"type": "relation-list",
+				"collection": "group",
+				"fields": {"name": null}
+			}
+		}
+	}`
+	dataProvider := &mockDataProvider{data: map[string]json.RawMessage{
+		"user/1/group_ids": []byte("[1,2]"),
+	}}
+
+	for _, tt := range []struct {
+		name    string
+		maxKeys int
+		valid   bool
+	}{
+		{"No limit", 0, true},
+		{"Enough", 4, true},
+		{"Too many", 3, false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := keysbuilder.FromJSON(context.Background(), strings.NewReader(request), dataProvider, 1, keysbuilder.WithMaxKeys(tt.maxKeys))
+
+			if tt.valid {
+				if err != nil {
+					t.Errorf("FromJSON returned unexpected error: %v", err)
+				}
+				return
+			}
+
+			var invalid keysbuilder.InvalidError
+			if !errors.As(err, &invalid) {
+				t.Errorf("Got error %v, expected an InvalidError", err)
+			}
+		})
+	}
+}
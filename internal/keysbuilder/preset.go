@@ -0,0 +1,132 @@
+package keysbuilder
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// defaultPresetParam is the parameter, that gives the ids of a preset body, if
+// the body does not name another parameter.
+const defaultPresetParam = "ids"
+
+// Presets are named keys requests, that are defined on the server. A client
+// only sends the name of a preset and the ids for its parameters instead of
+// the full request.
+//
+// The presets are defined as json object from the name to a list of bodies.
+// A body is like a body of a normal request, but instead of ids it has the
+// field param with the name of the parameter, that gives the ids. The default
+// parameter is `ids`:
+//
+//	{
+//		"agenda-overview": [{
+//			"param": "meeting",
+//			"collection": "meeting",
+//			"fields": {"agenda_item_ids": {"type": "relation-list", "collection": "agenda_item", "fields": {"item_number": null}}}
+//		}]
+//	}
+//
+// Has to be created with ParsePresets().
+type Presets struct {
+	presets map[string][]presetBody
+}
+
+// presetBody is one body of a preset.
+type presetBody struct {
+	param      string
+	collection string
+	fieldsMap
+}
+
+// UnmarshalJSON builds a presetBody from json.
+func (p *presetBody) UnmarshalJSON(data []byte) error {
+	var field struct {
+		Param         string    `json:"param"`
+		Collection    string    `json:"collection"`
+		Fields        fieldsMap `json:"fields"`
+		ExcludeFields []string  `json:"exclude_fields"`
+	}
+
+	if err := json.Unmarshal(data, &field); err != nil {
+		return err
+	}
+	if field.Collection == "" {
+		return InvalidError{msg: "no collection"}
+	}
+	if field.Fields.fields == nil && field.ExcludeFields == nil {
+		return InvalidError{msg: "no fields"}
+	}
+	if field.ExcludeFields != nil {
+		field.Fields.excludeFields(field.ExcludeFields)
+	}
+
+	p.param = field.Param
+	if p.param == "" {
+		p.param = defaultPresetParam
+	}
+	p.collection = field.Collection
+	p.fieldsMap = field.Fields
+	return nil
+}
+
+// ParsePresets reads the definition of presets from json.
+func ParsePresets(r io.Reader) (*Presets, error) {
+	var presets map[string][]presetBody
+	if err := json.NewDecoder(r).Decode(&presets); err != nil {
+		return nil, fmt.Errorf("decoding presets: %w", err)
+	}
+
+	for name, bodies := range presets {
+		if len(bodies) == 0 {
+			return nil, fmt.Errorf("preset %s has no bodies", name)
+		}
+	}
+	return &Presets{presets: presets}, nil
+}
+
+// Names returns the sorted names of all presets.
+func (p *Presets) Names() []string {
+	if p == nil {
+		return nil
+	}
+
+	names := make([]string, 0, len(p.presets))
+	for name := range p.presets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Builder creates a keysbuilder from the preset with the given name. params
+// are the ids for each parameter of the preset.
+//
+// Returns an InvalidError, if the preset does not exist or a parameter is
+// missing.
+func (p *Presets) Builder(ctx context.Context, name string, params map[string][]int, dataProvider DataProvider, uid int, options ...Option) (*Builder, error) {
+	var bodies []presetBody
+	if p != nil {
+		bodies = p.presets[name]
+	}
+	if len(bodies) == 0 {
+		return nil, InvalidError{msg: fmt.Sprintf("unknown preset `%s`", name)}
+	}
+
+	bs := make([]body, len(bodies))
+	for i, pb := range bodies {
+		ids := params[pb.param]
+		if len(ids) == 0 {
+			return nil, InvalidError{msg: fmt.Sprintf("preset `%s` needs the parameter `%s`", name, pb.param)}
+		}
+		bs[i] = body{ids: ids, collection: pb.collection, fieldsMap: pb.fieldsMap}
+	}
+
+	kb, err := newBuilder(ctx, dataProvider, uid, options, bs...)
+	if err != nil {
+		return nil, fmt.Errorf("build keys: %w", err)
+	}
+	return kb, nil
+}
@@ -0,0 +1,96 @@
+package keysbuilder_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/openslides/openslides-autoupdate-service/internal/keysbuilder"
+)
+
+const presetsJSON = `{
+	"motion-detail": [{
+		"collection": "motion",
+		"fields": {"title": null}
+	}],
+	"agenda-overview": [
+		{
+			"param": "meeting",
+			"collection": "meeting",
+			"fields": {
+				"agenda_item_ids": {
+					"type": "relation-list",
+					"collection": "agenda_item",
+					"fields": {"item_number": null}
+				}
+			}
+		},
+		{
+			"collection": "agenda_item",
+			"fields": {"comment": null}
+		}
+	]
+}`
+
+func TestPresets(t *testing.T) {
+	presets, err := keysbuilder.ParsePresets(strings.NewReader(presetsJSON))
+	if err != nil {
+		t.Fatalf("ParsePresets returned unexpected error: %v", err)
+	}
+
+	if got := presets.Names(); !cmpSlice(got, strs("agenda-overview", "motion-detail")) {
+		t.Errorf("Got names %v, expected agenda-overview and motion-detail", got)
+	}
+
+	dataProvider := &mockDataProvider{data: map[string]json.RawMessage{
+		"meeting/1/agenda_item_ids": []byte(`[3]`),
+	}}
+	b, err := presets.Builder(context.Background(), "agenda-overview", map[string][]int{"meeting": ids(1), "ids": ids(5)}, dataProvider, 1)
+	if err != nil {
+		t.Fatalf("Builder returned unexpected error: %v", err)
+	}
+
+	expect := strs("meeting/1/agenda_item_ids", "agenda_item/3/item_number", "agenda_item/5/comment")
+	if diff := cmpSet(set(expect...), set(b.Keys()...)); diff != nil {
+		t.Errorf("Got keys %v, expected %v", diff, expect)
+	}
+}
+
+func TestPresetsInvalid(t *testing.T) {
+	presets, err := keysbuilder.ParsePresets(strings.NewReader(presetsJSON))
+	if err != nil {
+		t.Fatalf("ParsePresets returned unexpected error: %v", err)
+	}
+
+	for _, tt := range []struct {
+		name   string
+		preset string
+		params map[string][]int
+	}{
+		{"unknown preset", "unknown", map[string][]int{"ids": ids(1)}},
+		{"missing param", "agenda-overview", map[string][]int{"ids": ids(1)}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := presets.Builder(context.Background(), tt.preset, tt.params, &mockDataProvider{}, 1)
+
+			var invalid keysbuilder.InvalidError
+			if !errors.As(err, &invalid) {
+				t.Errorf("Got error %v, expected an InvalidError", err)
+			}
+		})
+	}
+}
+
+func TestParsePresetsInvalid(t *testing.T) {
+	for _, tt := range []string{
+		`{"empty": []}`,
+		`{"no-collection": [{"fields": {"name": null}}]}`,
+		`{"invalid-field": [{"collection": "user", "fields": {"name": {"type": "unknown"}}}]}`,
+	} {
+		if _, err := keysbuilder.ParsePresets(strings.NewReader(tt)); err == nil {
+			t.Errorf("ParsePresets(%s) returned no error", tt)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package keysbuilder
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Schema holds the names of the fields of each collection. It is used for
+// bodies, that request all fields of an object.
+//
+// Has to be created with ParseSchema().
+type Schema struct {
+	fields map[string][]string
+}
+
+// ParseSchema reads the fields of the models from json. The json is an object
+// from each collection to an object from each field to its definition, like
+// the file models.yml of OpenSlides converted to json. The definitions of the
+// fields are not used.
+//
+//	{
+//		"motion": {"title": {"type": "string"}, "text": {"type": "HTMLStrict"}}
+//	}
+func ParseSchema(r io.Reader) (*Schema, error) {
+	var models map[string]map[string]json.RawMessage
+	if err := json.NewDecoder(r).Decode(&models); err != nil {
+		return nil, fmt.Errorf("decoding schema: %w", err)
+	}
+
+	fields := make(map[string][]string, len(models))
+	for collection, model := range models {
+		names := make([]string, 0, len(model))
+		for name := range model {
+			if strings.Contains(name, keySep) {
+				return nil, fmt.Errorf("invalid field %s/%s", collection, name)
+			}
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		fields[collection] = names
+	}
+	return &Schema{fields: fields}, nil
+}
+
+// Fields returns the sorted fields of the collection. It returns nil, if the
+// collection is unknown.
+func (s *Schema) Fields(collection string) []string {
+	if s == nil {
+		return nil
+	}
+	return s.fields[collection]
+}
+
+// WithSchema sets the fields of the models, so bodies can request all fields
+// of an object.
+func WithSchema(schema *Schema) Option {
+	return func(b *Builder) {
+		b.schema = schema
+	}
+}
+
+// allKey is the last part of the key, that is created for a fieldsMap with
+// all fields. The builder replaces it with the fields from the schema.
+const allKey = "*"
+
+// allFields is the description of the key <collection>/<id>/*. The builder
+// replaces it with the keys of all fields of the collection, that are not
+// excluded and not requested explicitly.
+type allFields struct {
+	fieldsMap
+}
+
+// keys does nothing. The key of an allFields is replaced by the builder
+// before the key is requested.
+func (a *allFields) keys(key string, value json.RawMessage, data map[string]fieldDescription) error {
+	return nil
+}
+
+// expand replaces the key cid/* with the fields of the collection.
+func (a *allFields) expand(schema *Schema, key string, data map[string]fieldDescription) error {
+	cid := key[:len(key)-len(keySep+allKey)]
+	collection := cid
+	if idx := strings.Index(cid, keySep); idx > 0 {
+		collection = cid[:idx]
+	}
+
+	if schema == nil {
+		return InvalidError{msg: "the service has no schema to request all fields"}
+	}
+
+	names := schema.Fields(collection)
+	if names == nil {
+		return InvalidError{msg: fmt.Sprintf("unknown collection `%s`", collection)}
+	}
+
+	for _, name := range names {
+		if a.exclude[name] {
+			continue
+		}
+		if _, ok := a.fields[name]; ok {
+			continue
+		}
+
+		fieldKey := buildGenericKey(cid, name)
+		if _, ok := data[fieldKey]; !ok {
+			// Do not overwrite the description of another body.
+			data[fieldKey] = nil
+		}
+	}
+	return nil
+}
@@ -0,0 +1,256 @@
+package keysbuilder_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/openslides/openslides-autoupdate-service/internal/keysbuilder"
+)
+
+const schemaJSON = `{
+	"motion": {
+		"title": {"type": "string"},
+		"text": {"type": "HTMLStrict"},
+		"amendment_paragraph_$": {"type": "template"},
+		"submitter_ids": {"type": "relation-list", "to": "motion_submitter/motion_id"}
+	},
+	"motion_submitter": {
+		"weight": {"type": "number"},
+		"user_id": {"type": "relation", "to": "user/submitted_motion_$_ids"}
+	}
+}`
+
+func TestExcludeFields(t *testing.T) {
+	schema, err := keysbuilder.ParseSchema(strings.NewReader(schemaJSON))
+	if err != nil {
+		t.Fatalf("ParseSchema returned unexpected error: %v", err)
+	}
+
+	for _, tt := range []struct {
+		name    string
+		request string
+		data    map[string]json.RawMessage
+		keys    []string
+	}{
+		{
+			"Body",
+			`[{
+				"ids": [1],
+				"collection": "motion",
+				"exclude_fields": ["text", "amendment_paragraph_$"]
+			}]`,
+			nil,
+			strs("motion/1/title", "motion/1/submitter_ids"),
+		},
+		{
+			"With relation",
+			`[{
+				"ids": [1],
+				"collection": "motion",
+				"exclude_fields": ["text"],
+				"fields": {
+					"submitter_ids": {
+						"type": "relation-list",
+						"collection": "motion_submitter",
+						"exclude_fields": ["user_id"]
+					}
+				}
+			}]`,
+			map[string]json.RawMessage{"motion/1/submitter_ids": []byte("[3]")},
+			strs("motion/1/title", "motion/1/amendment_paragraph_$", "motion/1/submitter_ids", "motion_submitter/3/weight"),
+		},
+		{
+			"Unknown excluded field",
+			`[{
+				"ids": [1],
+				"collection": "motion_submitter",
+				"exclude_fields": ["unknown"]
+			}]`,
+			nil,
+			strs("motion_submitter/1/weight", "motion_submitter/1/user_id"),
+		},
+		{
+			"Generic relation",
+			`[{
+				"ids": [1],
+				"collection": "agenda_item",
+				"fields": {
+					"content_object_id": {
+						"type": "generic-relation",
+						"exclude_fields": ["text", "amendment_paragraph_$", "submitter_ids"]
+					}
+				}
+			}]`,
+			map[string]json.RawMessage{"agenda_item/1/content_object_id": []byte(`"motion/5"`)},
+			strs("agenda_item/1/content_object_id", "motion/5/title"),
+		},
+		{
+			"Same field in other body",
+			`[
+				{
+					"ids": [1],
+					"collection": "motion",
+					"exclude_fields": []
+				},
+				{
+					"ids": [1],
+					"collection": "motion",
+					"fields": {
+						"submitter_ids": {
+							"type": "relation-list",
+							"collection": "motion_submitter",
+							"fields": {"weight": null}
+						}
+					}
+				}
+			]`,
+			map[string]json.RawMessage{"motion/1/submitter_ids": []byte("[3]")},
+			strs("motion/1/title", "motion/1/text", "motion/1/amendment_paragraph_$", "motion/1/submitter_ids", "motion_submitter/3/weight"),
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			dataProvider := &mockDataProvider{data: tt.data}
+			b, err := keysbuilder.ManyFromJSON(context.Background(), strings.NewReader(tt.request), dataProvider, 1, keysbuilder.WithSchema(schema))
+			if err != nil {
+				t.Fatalf("ManyFromJSON returned unexpected error: %v", err)
+			}
+
+			if diff := cmpSet(set(tt.keys...), set(b.Keys()...)); diff != nil {
+				t.Errorf("Got keys %v, expected %v", diff, tt.keys)
+			}
+		})
+	}
+}
+
+func TestExcludeFieldsInvalid(t *testing.T) {
+	schema, err := keysbuilder.ParseSchema(strings.NewReader(schemaJSON))
+	if err != nil {
+		t.Fatalf("ParseSchema returned unexpected error: %v", err)
+	}
+
+	for _, tt := range []struct {
+		name    string
+		request string
+		options []keysbuilder.Option
+	}{
+		{
+			"No schema",
+			`[{"ids": [1], "collection": "motion", "exclude_fields": ["text"]}]`,
+			nil,
+		},
+		{
+			"Unknown collection",
+			`[{"ids": [1], "collection": "unknown", "exclude_fields": ["text"]}]`,
+			[]keysbuilder.Option{keysbuilder.WithSchema(schema)},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := keysbuilder.ManyFromJSON(context.Background(), strings.NewReader(tt.request), &mockDataProvider{}, 1, tt.options...)
+
+			var invalid keysbuilder.InvalidError
+			if !errors.As(err, &invalid) {
+				t.Errorf("Got error %v, expected an InvalidError", err)
+			}
+		})
+	}
+}
+
+func TestParseSchemaInvalid(t *testing.T) {
+	for _, tt := range []string{
+		`[]`,
+		`{"motion": ["title"]}`,
+		`{"motion": {"title/5": {}}}`,
+	} {
+		if _, err := keysbuilder.ParseSchema(strings.NewReader(tt)); err == nil {
+			t.Errorf("ParseSchema(%s) returned no error", tt)
+		}
+	}
+}
+
+func TestAllFields(t *testing.T) {
+	schema, err := keysbuilder.ParseSchema(strings.NewReader(schemaJSON))
+	if err != nil {
+		t.Fatalf("ParseSchema returned unexpected error: %v", err)
+	}
+
+	for _, tt := range []struct {
+		name    string
+		request string
+		data    map[string]json.RawMessage
+		keys    []string
+	}{
+		{
+			"Body",
+			`[{"ids": [1], "collection": "motion_submitter", "fields": "*"}]`,
+			nil,
+			strs("motion_submitter/1/weight", "motion_submitter/1/user_id"),
+		},
+		{
+			"Field in object",
+			`[{
+				"ids": [1],
+				"collection": "motion",
+				"fields": {
+					"*": null,
+					"submitter_ids": {
+						"type": "relation-list",
+						"collection": "motion_submitter",
+						"fields": "*"
+					}
+				}
+			}]`,
+			map[string]json.RawMessage{"motion/1/submitter_ids": []byte("[3]")},
+			strs("motion/1/title", "motion/1/text", "motion/1/amendment_paragraph_$", "motion/1/submitter_ids", "motion_submitter/3/weight", "motion_submitter/3/user_id"),
+		},
+		{
+			"With exclude",
+			`[{"ids": [1], "collection": "motion", "fields": "*", "exclude_fields": ["text", "amendment_paragraph_$"]}]`,
+			nil,
+			strs("motion/1/title", "motion/1/submitter_ids"),
+		},
+		{
+			"Generic relation collections",
+			`[{
+				"ids": [1],
+				"collection": "agenda_item",
+				"fields": {
+					"content_object_id": {
+						"type": "generic-relation",
+						"collections": {"motion_submitter": "*"}
+					}
+				}
+			}]`,
+			map[string]json.RawMessage{"agenda_item/1/content_object_id": []byte(`"motion_submitter/5"`)},
+			strs("agenda_item/1/content_object_id", "motion_submitter/5/weight", "motion_submitter/5/user_id"),
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			dataProvider := &mockDataProvider{data: tt.data}
+			b, err := keysbuilder.ManyFromJSON(context.Background(), strings.NewReader(tt.request), dataProvider, 1, keysbuilder.WithSchema(schema))
+			if err != nil {
+				t.Fatalf("ManyFromJSON returned unexpected error: %v", err)
+			}
+
+			if diff := cmpSet(set(tt.keys...), set(b.Keys()...)); diff != nil {
+				t.Errorf("Got keys %v, expected %v", diff, tt.keys)
+			}
+		})
+	}
+}
+
+func TestAllFieldsInvalid(t *testing.T) {
+	for _, tt := range []string{
+		`[{"ids": [1], "collection": "motion", "fields": "all"}]`,
+		`[{"ids": [1], "collection": "motion", "fields": {"*": {"type": "relation", "collection": "user", "fields": {}}}}]`,
+	} {
+		_, err := keysbuilder.ManyFromJSON(context.Background(), strings.NewReader(tt), &mockDataProvider{}, 1)
+
+		var invalid keysbuilder.InvalidError
+		if !errors.As(err, &invalid) {
+			t.Errorf("Got error %v for %s, expected an InvalidError", err, tt)
+		}
+	}
+}
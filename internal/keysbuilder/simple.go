@@ -16,11 +16,30 @@ func (s *Simple) Update(context.Context) error {
 	return nil
 }
 
+// KeyCount returns the number of keys.
+func (s *Simple) KeyCount() int {
+	return len(s.K)
+}
+
+// BodyCount returns 1. A simple keysbuilder is handled as one body.
+func (s *Simple) BodyCount() int {
+	return 1
+}
+
 // Keys returns the keys the keysbuilder.Simple was initialized.
 func (s *Simple) Keys() []string {
 	return s.K
 }
 
+// ForEachKey calls f for each key. If f returns false, the iteration stops.
+func (s *Simple) ForEachKey(f func(key string) bool) {
+	for _, key := range s.K {
+		if !f(key) {
+			return
+		}
+	}
+}
+
 // Validate checks, if the given keys are valid.
 func (s *Simple) Validate() error {
 	for _, key := range s.K {
@@ -44,3 +44,11 @@ func (s *Service) Update() (map[string]json.RawMessage, error) {
 	}
 	return keys, nil
 }
+
+// Ping tests the connection to redis, if the connection supports it.
+func (s *Service) Ping() error {
+	if tester, ok := s.Conn.(interface{ TestConn() error }); ok {
+		return tester.TestConn()
+	}
+	return nil
+}
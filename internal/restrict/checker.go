@@ -2,6 +2,7 @@ package restrict
 
 //go:generate  sh -c "go run gendef/main.go > def.go && go fmt def.go"
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"regexp"
@@ -10,8 +11,14 @@ import (
 
 // OpenSlidesChecker returns the restricter checkers for the openslides models.
 func OpenSlidesChecker(perm Permission) map[string]Checker {
+	return DefinitionChecker(perm, relationLists)
+}
+
+// DefinitionChecker returns the restricter checkers for the relation lists of
+// the given definition.
+func DefinitionChecker(perm Permission, def Definition) map[string]Checker {
 	checkers := make(map[string]Checker)
-	for k, v := range relationLists {
+	for k, v := range def {
 		// Generic relation list.
 		var checker Checker = &relationList{
 			perm:  perm,
@@ -25,7 +32,7 @@ func OpenSlidesChecker(perm Permission) map[string]Checker {
 
 		// Structured fields.
 		if strings.Contains(k, "$") {
-			re := strings.Replace(k, "$", "[a-z0-9_]+", 1)
+			re := "^" + strings.Replace(k, "$", `\$[a-z0-9_]+`, 1) + "$"
 			checker = &structuredField{
 				perm:    perm,
 				checker: checker,
@@ -43,31 +50,35 @@ type relationList struct {
 	model string
 }
 
-func (r *relationList) Check(uid int, key string, value json.RawMessage) (json.RawMessage, error) {
+func (r *relationList) Check(ctx context.Context, uid int, key string, value json.RawMessage) (json.RawMessage, error) {
 	var ids []int
 	if err := json.Unmarshal(value, &ids); err != nil {
 		return nil, fmt.Errorf("decoding %s: %w", key, err)
 	}
 
 	keys := make([]string, len(ids))
-	keyToID := make(map[string]int)
 	for i, id := range ids {
 		keys[i] = fmt.Sprintf("%s/%d", r.model, id)
-		keyToID[keys[i]] = id
 	}
 
-	allowed, err := r.perm.CheckFQIDs(uid, keys)
+	allowed, err := r.perm.CheckFQIDs(ctx, uid, keys)
 	if err != nil {
 		return nil, fmt.Errorf("check fqids: %w", err)
 	}
 
+	// Keep the order of the list, so pages of the list are stable.
 	allowedIDs := make([]int, 0, len(ids))
-	for key, a := range allowed {
-		if a {
-			allowedIDs = append(allowedIDs, keyToID[key])
+	for i, id := range ids {
+		if allowed[keys[i]] {
+			allowedIDs = append(allowedIDs, id)
 		}
 	}
 
+	if len(allowedIDs) == len(ids) {
+		// The user can see all ids. Return the value without a copy.
+		return value, nil
+	}
+
 	v, err := json.Marshal(allowedIDs)
 	if err != nil {
 		return nil, fmt.Errorf("encoding restricted ids: %w", err)
@@ -75,11 +86,16 @@ func (r *relationList) Check(uid int, key string, value json.RawMessage) (json.R
 	return v, nil
 }
 
+// UserScoped tells, if the related collection belongs to one user.
+func (r *relationList) UserScoped() bool {
+	return userScopedCollections[r.model]
+}
+
 type genericRelationList struct {
 	perm Permission
 }
 
-func (g *genericRelationList) Check(uid int, key string, value json.RawMessage) (json.RawMessage, error) {
+func (g *genericRelationList) Check(ctx context.Context, uid int, key string, value json.RawMessage) (json.RawMessage, error) {
 	var fqids []string
 	if err := json.Unmarshal(value, &fqids); err != nil {
 		return nil, fmt.Errorf("decoding %s: %w", key, err)
@@ -90,18 +106,23 @@ func (g *genericRelationList) Check(uid int, key string, value json.RawMessage)
 		keys[i] = fqid
 	}
 
-	allowed, err := g.perm.CheckFQIDs(uid, keys)
+	allowed, err := g.perm.CheckFQIDs(ctx, uid, keys)
 	if err != nil {
 		return nil, fmt.Errorf("check fqids: %w", err)
 	}
 
 	allowedFQIDs := make([]string, 0, len(fqids))
-	for fqid, a := range allowed {
-		if a {
+	for _, fqid := range fqids {
+		if allowed[fqid] {
 			allowedFQIDs = append(allowedFQIDs, fqid)
 		}
 	}
 
+	if len(allowedFQIDs) == len(fqids) {
+		// The user can see all fqids. Return the value without a copy.
+		return value, nil
+	}
+
 	v, err := json.Marshal(allowedFQIDs)
 	if err != nil {
 		return nil, fmt.Errorf("encoding restricted fqids: %w", err)
@@ -109,33 +130,44 @@ func (g *genericRelationList) Check(uid int, key string, value json.RawMessage)
 	return v, nil
 }
 
+// UserScoped returns true, because the list can contain objects of any
+// collection, also objects, that belong to one user.
+func (g *genericRelationList) UserScoped() bool {
+	return true
+}
+
 type structuredField struct {
 	perm    Permission
 	checker Checker
 	re      *regexp.Regexp
 }
 
-func (s *structuredField) Check(uid int, key string, value json.RawMessage) (json.RawMessage, error) {
+func (s *structuredField) Check(ctx context.Context, uid int, key string, value json.RawMessage) (json.RawMessage, error) {
 	var replacments []string
 	if err := json.Unmarshal(value, &replacments); err != nil {
 		return nil, fmt.Errorf("decoding key %s: %w", key, err)
 	}
 
 	keys := make([]string, len(replacments))
-	keyToReplacement := make(map[string]string, len(replacments))
 	for i, r := range replacments {
 		keys[i] = strings.Replace(key, "$", r, 1)
-		keyToReplacement[keys[i]] = r
 	}
 
-	allowed, err := s.perm.CheckFQFields(uid, keys)
+	allowed, err := s.perm.CheckFQFields(ctx, uid, keys)
 	if err != nil {
 		return nil, fmt.Errorf("check generated structured fields: %w", err)
 	}
 
 	allowedReplacements := make([]string, 0, len(allowed))
-	for key := range allowed {
-		allowedReplacements = append(allowedReplacements, keyToReplacement[key])
+	for i, r := range replacments {
+		if allowed[keys[i]] {
+			allowedReplacements = append(allowedReplacements, r)
+		}
+	}
+
+	if len(allowedReplacements) == len(replacments) {
+		// All replacements are allowed. Return the value without a copy.
+		return value, nil
 	}
 
 	v, err := json.Marshal(allowedReplacements)
@@ -1,6 +1,7 @@
 package restrict
 
 import (
+	"context"
 	"testing"
 
 	"github.com/openslides/openslides-autoupdate-service/internal/test"
@@ -17,7 +18,7 @@ func TestRelationList(t *testing.T) {
 		model: "foo",
 	}
 
-	v, err := r.Check(1, "bar/1/foo_ids", []byte("[1,2]"))
+	v, err := r.Check(context.Background(), 1, "bar/1/foo_ids", []byte("[1,2]"))
 
 	if err != nil {
 		t.Errorf("Check returned an error: %v", err)
@@ -38,7 +39,7 @@ func TestGenericRelationList(t *testing.T) {
 		perm: perm,
 	}
 
-	v, err := r.Check(1, "bar/1/foo_ids", []byte(`["foo/1","other_foo/2"]`))
+	v, err := r.Check(context.Background(), 1, "bar/1/foo_ids", []byte(`["foo/1","other_foo/2"]`))
 
 	if err != nil {
 		t.Errorf("Check returned an error: %v", err)
@@ -48,3 +49,66 @@ func TestGenericRelationList(t *testing.T) {
 		t.Errorf("Check returned `%s`, expected `[\"foo/1\"]`", got)
 	}
 }
+
+func TestRelationListAllAllowedNoCopy(t *testing.T) {
+	perm := new(test.MockPermission)
+	perm.Default = true
+	r := relationList{
+		perm:  perm,
+		model: "foo",
+	}
+
+	value := []byte("[1,2]")
+	v, err := r.Check(context.Background(), 1, "bar/1/foo_ids", value)
+
+	if err != nil {
+		t.Errorf("Check returned an error: %v", err)
+	}
+
+	if &v[0] != &value[0] {
+		t.Errorf("Check returned a copy of the value, expected the same value")
+	}
+}
+
+func TestRelationListKeepsOrder(t *testing.T) {
+	perm := new(test.MockPermission)
+	perm.Default = true
+	perm.Data = map[string]bool{"foo/4": false}
+	r := relationList{
+		perm:  perm,
+		model: "foo",
+	}
+
+	// The map of the permission service has no order, so the check is
+	// repeated to find a random order.
+	for i := 0; i < 20; i++ {
+		v, err := r.Check(context.Background(), 1, "bar/1/foo_ids", []byte("[7,3,4,9,1,5]"))
+		if err != nil {
+			t.Fatalf("Check returned an error: %v", err)
+		}
+
+		if got := string(v); got != "[7,3,9,1,5]" {
+			t.Fatalf("Check returned `%s`, expected `[7,3,9,1,5]`", got)
+		}
+	}
+}
+
+func TestGenericRelationListKeepsOrder(t *testing.T) {
+	perm := new(test.MockPermission)
+	perm.Default = true
+	perm.Data = map[string]bool{"foo/4": false}
+	r := genericRelationList{
+		perm: perm,
+	}
+
+	for i := 0; i < 20; i++ {
+		v, err := r.Check(context.Background(), 1, "bar/1/foo_ids", []byte(`["foo/7","other/3","foo/4","foo/9","other/1"]`))
+		if err != nil {
+			t.Fatalf("Check returned an error: %v", err)
+		}
+
+		if got := string(v); got != `["foo/7","other/3","foo/9","other/1"]` {
+			t.Fatalf("Check returned `%s`, expected `[\"foo/7\",\"other/3\",\"foo/9\",\"other/1\"]`", got)
+		}
+	}
+}
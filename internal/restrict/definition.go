@@ -0,0 +1,52 @@
+package restrict
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+)
+
+// Definition maps the relation list fields of the models to the collection they
+// point to. The key is a model field like `motion/tag_ids`. The value is a
+// collection or `*` for generic relation lists. Structured fields contain a `$`
+// at the place of the replacement, for example `user/group_$_ids`.
+type Definition map[string]string
+
+var (
+	reDefinitionField = regexp.MustCompile(`^[a-z_]+/[a-z0-9_]*\$?[a-z0-9_]*$`)
+	reDefinitionTo    = regexp.MustCompile(`^([a-z_]+|\*)$`)
+)
+
+// DefaultDefinition returns the definition, that was generated from the
+// OpenSlides models.
+func DefaultDefinition() Definition {
+	def := make(Definition, len(relationLists))
+	for k, v := range relationLists {
+		def[k] = v
+	}
+	return def
+}
+
+// ParseDefinition reads a definition as json object from r. It returns an
+// error, if the object is empty or a field or collection is invalid.
+func ParseDefinition(r io.Reader) (Definition, error) {
+	var def Definition
+	if err := json.NewDecoder(r).Decode(&def); err != nil {
+		return nil, fmt.Errorf("decoding definition: %w", err)
+	}
+
+	if len(def) == 0 {
+		return nil, fmt.Errorf("definition is empty")
+	}
+
+	for field, to := range def {
+		if !reDefinitionField.MatchString(field) {
+			return nil, fmt.Errorf("invalid field `%s`", field)
+		}
+		if !reDefinitionTo.MatchString(to) {
+			return nil, fmt.Errorf("invalid collection `%s` for field %s", to, field)
+		}
+	}
+	return def, nil
+}
@@ -0,0 +1,60 @@
+package restrict_test
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/openslides/openslides-autoupdate-service/internal/restrict"
+	"github.com/openslides/openslides-autoupdate-service/internal/test"
+)
+
+func TestParseDefinition(t *testing.T) {
+	for _, tt := range []struct {
+		name  string
+		input string
+		err   bool
+	}{
+		{"valid", `{"motion/tag_ids": "tag", "tag/tagged_ids": "*", "user/group_$_ids": "group"}`, false},
+		{"invalid json", `{"motion/tag_ids": `, true},
+		{"empty", `{}`, true},
+		{"invalid field", `{"motion/1/tag_ids": "tag"}`, true},
+		{"invalid collection", `{"motion/tag_ids": "Tag"}`, true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := restrict.ParseDefinition(strings.NewReader(tt.input))
+			if tt.err && err == nil {
+				t.Errorf("ParseDefinition did not return an error")
+			}
+			if !tt.err && err != nil {
+				t.Errorf("ParseDefinition returned unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestSetDefinition(t *testing.T) {
+	perms := new(test.MockPermission)
+	perms.Default = true
+	perms.Data = map[string]bool{"tag/2": false}
+	r := restrict.New(perms, nil)
+
+	data := map[string]json.RawMessage{"motion/1/tag_ids": []byte("[1,2]")}
+	if err := r.Restrict(context.Background(), 1, data); err != nil {
+		t.Fatalf("Restrict returned unexpected error: %v", err)
+	}
+	if got := string(data["motion/1/tag_ids"]); got != "[1,2]" {
+		t.Errorf("Got `%s` without a definition, expected `[1,2]`", got)
+	}
+
+	r.SetDefinition(restrict.Definition{"motion/tag_ids": "tag"})
+
+	data = map[string]json.RawMessage{"motion/1/tag_ids": []byte("[1,2]")}
+	if err := r.Restrict(context.Background(), 1, data); err != nil {
+		t.Fatalf("Restrict returned unexpected error: %v", err)
+	}
+	if got := string(data["motion/1/tag_ids"]); got != "[1]" {
+		t.Errorf("Got `%s` with the definition, expected `[1]`", got)
+	}
+}
@@ -0,0 +1,164 @@
+package restrict_test
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/openslides/openslides-autoupdate-service/internal/restrict"
+	"github.com/openslides/openslides-autoupdate-service/internal/test"
+)
+
+var update = flag.Bool("update", false, "Update the visible data in the golden files.")
+
+// goldenFile is the content of one file in testdata/golden.
+//
+// Data is the content of the datastore. Each case describes a user with the
+// fqids and fqfields the user has the permission to see. Visible is the data
+// the user gets after the restriction. Keys, that are not visible, are not in
+// Visible.
+type goldenFile struct {
+	Data  map[string]json.RawMessage `json:"data"`
+	Cases []goldenCase               `json:"cases"`
+}
+
+type goldenCase struct {
+	Name        string                     `json:"name"`
+	UID         int                        `json:"uid"`
+	Permissions []string                   `json:"permissions"`
+	Visible     map[string]json.RawMessage `json:"visible"`
+}
+
+func TestGolden(t *testing.T) {
+	files, err := filepath.Glob("testdata/golden/*.json")
+	if err != nil {
+		t.Fatalf("Can not read golden files: %v", err)
+	}
+
+	for _, file := range files {
+		t.Run(filepath.Base(file), func(t *testing.T) {
+			content, err := ioutil.ReadFile(file)
+			if err != nil {
+				t.Fatalf("Can not read file: %v", err)
+			}
+
+			var golden goldenFile
+			if err := json.Unmarshal(content, &golden); err != nil {
+				t.Fatalf("Can not decode file: %v", err)
+			}
+
+			for i, tt := range golden.Cases {
+				t.Run(tt.Name, func(t *testing.T) {
+					perm := make(goldenPermission)
+					perm[tt.UID] = make(map[string]bool, len(tt.Permissions))
+					for _, p := range tt.Permissions {
+						perm[tt.UID][p] = true
+					}
+
+					data := make(map[string]json.RawMessage, len(golden.Data))
+					for k, v := range golden.Data {
+						data[k] = v
+					}
+
+					ds := test.NewMockDatastore(test.WithData(golden.Data), test.WithOnlyData())
+					mediafilePerm := restrict.NewMediafilePermission(perm, ds)
+					r := restrict.New(mediafilePerm, restrict.OpenSlidesChecker(mediafilePerm))
+					if err := r.Restrict(context.Background(), tt.UID, data); err != nil {
+						t.Fatalf("Restrict returned unexpected error: %v", err)
+					}
+
+					visible := make(map[string]json.RawMessage)
+					for k, v := range data {
+						if v != nil {
+							visible[k] = normalize(t, v)
+						}
+					}
+
+					if *update {
+						golden.Cases[i].Visible = visible
+						return
+					}
+
+					expect := make(map[string]json.RawMessage, len(tt.Visible))
+					for k, v := range tt.Visible {
+						expect[k] = normalize(t, v)
+					}
+					cmpVisible(t, visible, expect)
+				})
+			}
+
+			if *update {
+				content, err := json.MarshalIndent(golden, "", "  ")
+				if err != nil {
+					t.Fatalf("Can not encode golden file: %v", err)
+				}
+				if err := ioutil.WriteFile(file, append(content, '\n'), 0644); err != nil {
+					t.Fatalf("Can not write golden file: %v", err)
+				}
+			}
+		})
+	}
+}
+
+// goldenPermission implements the restrict.Permission interface. It maps user
+// ids to the fqids and fqfields the user can see.
+type goldenPermission map[int]map[string]bool
+
+func (p goldenPermission) CheckFQIDs(ctx context.Context, uid int, fqids []string) (map[string]bool, error) {
+	out := make(map[string]bool, len(fqids))
+	for _, fqid := range fqids {
+		out[fqid] = p[uid][fqid]
+	}
+	return out, nil
+}
+
+func (p goldenPermission) CheckFQFields(ctx context.Context, uid int, fqfields []string) (map[string]bool, error) {
+	return p.CheckFQIDs(ctx, uid, fqfields)
+}
+
+// normalize sorts json lists, since the restricter does not keep the order of
+// relation lists.
+func normalize(t *testing.T, value json.RawMessage) json.RawMessage {
+	var list []json.RawMessage
+	if err := json.Unmarshal(value, &list); err != nil {
+		var v interface{}
+		if err := json.Unmarshal(value, &v); err != nil {
+			t.Fatalf("Invalid json value `%s`: %v", value, err)
+		}
+		out, _ := json.Marshal(v)
+		return out
+	}
+
+	elements := make([]string, len(list))
+	for i, e := range list {
+		elements[i] = string(normalize(t, e))
+	}
+	sort.Strings(elements)
+	return []byte("[" + strings.Join(elements, ",") + "]")
+}
+
+func cmpVisible(t *testing.T, got, expect map[string]json.RawMessage) {
+	t.Helper()
+
+	for k, v := range expect {
+		g, ok := got[k]
+		if !ok {
+			t.Errorf("Key %s is not visible, expected value %s", k, v)
+			continue
+		}
+		if string(g) != string(v) {
+			t.Errorf("Key %s has value %s, expected %s", k, g, v)
+		}
+	}
+
+	for k, v := range got {
+		if _, ok := expect[k]; !ok {
+			t.Errorf("Key %s is visible with value %s, expected it to be hidden", k, v)
+		}
+	}
+}
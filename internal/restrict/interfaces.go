@@ -7,8 +7,30 @@ import (
 
 // Permission tells the restricter, if a user has the required permissions.
 type Permission interface {
-	CheckFQIDs(uid int, fqids []string) (map[string]bool, error)
-	CheckFQFields(uid int, fqfields []string) (map[string]bool, error)
+	CheckFQIDs(ctx context.Context, uid int, fqids []string) (map[string]bool, error)
+	CheckFQFields(ctx context.Context, uid int, fqfields []string) (map[string]bool, error)
+}
+
+// HistoryPermission can be implemented by a Permission. It tells, if a user
+// can see the history of objects.
+type HistoryPermission interface {
+	HasHistoryPermission(uid int) (bool, error)
+}
+
+// PriorityPermission can be implemented by a Permission. It tells, if a user
+// can use a higher priority for the connections, for example a projector or a
+// chairperson.
+type PriorityPermission interface {
+	HasPriorityPermission(ctx context.Context, uid int) (bool, error)
+}
+
+// PermissionClasser can be implemented by a Permission. Users with the same
+// permission class get the same result for each check.
+//
+// This does not include the objects of userScopedCollections, that belong to
+// one user. Their result can be different for users of the same class.
+type PermissionClasser interface {
+	PermissionClass(ctx context.Context, uid int) (string, error)
 }
 
 // Datastore informs the restricter about changed data.
@@ -20,14 +42,26 @@ type Datastore interface {
 // Checker checks, if a user has the permission for a key value pair. The value
 // gets replaced with the returned value. Check has to return nil, if the user
 // is not allowed to see the key.
+//
+// The restricted values are shared between the users of one permission class.
+// So Check has to return the same value for all users of a class. It can only
+// use the uid to ask the Permission. A checker, that depends on the user
+// itself, has to implement UserScoped.
 type Checker interface {
-	Check(uid int, key string, value json.RawMessage) (json.RawMessage, error)
+	Check(ctx context.Context, uid int, key string, value json.RawMessage) (json.RawMessage, error)
+}
+
+// UserScoped can be implemented by a Checker. If UserScoped returns true, the
+// result of the checker depends on the user and not only on the permission
+// class of the user. Its values are not shared with other users.
+type UserScoped interface {
+	UserScoped() bool
 }
 
 // CheckerFunc is a function that implements the Checker interface.
-type CheckerFunc func(uid int, key string, value json.RawMessage) (json.RawMessage, error)
+type CheckerFunc func(ctx context.Context, uid int, key string, value json.RawMessage) (json.RawMessage, error)
 
 // Check calls the function.
-func (f CheckerFunc) Check(uid int, key string, value json.RawMessage) (json.RawMessage, error) {
-	return f(uid, key, value)
+func (f CheckerFunc) Check(ctx context.Context, uid int, key string, value json.RawMessage) (json.RawMessage, error) {
+	return f(ctx, uid, key, value)
 }
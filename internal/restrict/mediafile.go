@@ -0,0 +1,349 @@
+package restrict
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// MediafilePermission wraps a Permission and decides the visibility of
+// mediafiles with the same rules, the media service uses to serve a file.
+//
+// A mediafile, that is used as logo or font of a meeting, is visible for
+// everyone. Members of the admin group of the meeting can see all mediafiles.
+// Other users need the permission from the wrapped Permission. In addition,
+// the mediafile has to be public or the user has to be in one of its inherited
+// access groups. If there is no data for a mediafile, only the wrapped
+// Permission decides.
+//
+// All other fqids and fqfields are checked by the wrapped Permission.
+type MediafilePermission struct {
+	perm Permission
+	ds   Datastore
+}
+
+// NewMediafilePermission initializes a MediafilePermission.
+func NewMediafilePermission(perm Permission, ds Datastore) *MediafilePermission {
+	return &MediafilePermission{perm: perm, ds: ds}
+}
+
+// CheckFQIDs checks the fqids with the mediafile rules.
+func (p *MediafilePermission) CheckFQIDs(ctx context.Context, uid int, fqids []string) (map[string]bool, error) {
+	return p.check(ctx, uid, fqids, p.perm.CheckFQIDs)
+}
+
+// CheckFQFields checks the fqfields with the mediafile rules.
+func (p *MediafilePermission) CheckFQFields(ctx context.Context, uid int, fqfields []string) (map[string]bool, error) {
+	return p.check(ctx, uid, fqfields, p.perm.CheckFQFields)
+}
+
+// HasHistoryPermission calls the wrapped Permission, if it implements
+// HistoryPermission.
+func (p *MediafilePermission) HasHistoryPermission(uid int) (bool, error) {
+	hp, ok := p.perm.(HistoryPermission)
+	if !ok {
+		return false, nil
+	}
+	return hp.HasHistoryPermission(uid)
+}
+
+// HasPriorityPermission calls the wrapped Permission, if it implements
+// PriorityPermission.
+func (p *MediafilePermission) HasPriorityPermission(ctx context.Context, uid int) (bool, error) {
+	pp, ok := p.perm.(PriorityPermission)
+	if !ok {
+		return false, nil
+	}
+	return pp.HasPriorityPermission(ctx, uid)
+}
+
+// PermissionClass returns the class of the wrapped Permission together with
+// the groups of the user. The mediafile rules only depend on the groups, so
+// users with the same groups and the same wrapped class get the same result.
+// If the wrapped Permission does not implement PermissionClasser, each user is
+// its own class.
+func (p *MediafilePermission) PermissionClass(ctx context.Context, uid int) (string, error) {
+	pc, ok := p.perm.(PermissionClasser)
+	if !ok {
+		return strconv.Itoa(uid), nil
+	}
+
+	class, err := pc.PermissionClass(ctx, uid)
+	if err != nil {
+		return "", err
+	}
+
+	groups, err := p.userGroups(ctx, uid)
+	if err != nil {
+		return "", fmt.Errorf("get groups of user %d: %w", uid, err)
+	}
+
+	parts := make([]string, len(groups))
+	for i, g := range groups {
+		parts[i] = strconv.Itoa(g)
+	}
+	return class + "/groups:" + strings.Join(parts, ","), nil
+}
+
+// userGroups returns the sorted ids of the groups of the user in all meetings.
+// The anonymous user has no groups.
+func (p *MediafilePermission) userGroups(ctx context.Context, uid int) ([]int, error) {
+	if uid == 0 {
+		return nil, nil
+	}
+
+	templateKey := fmt.Sprintf("user/%d/group_$_ids", uid)
+	values, err := p.ds.Get(ctx, templateKey)
+	if err != nil {
+		return nil, fmt.Errorf("get %s: %w", templateKey, err)
+	}
+
+	var meetings []string
+	if values[0] != nil {
+		if err := json.Unmarshal(values[0], &meetings); err != nil {
+			return nil, fmt.Errorf("decoding %s: %w", templateKey, err)
+		}
+	}
+	if len(meetings) == 0 {
+		return nil, nil
+	}
+
+	keys := make([]string, len(meetings))
+	for i, mid := range meetings {
+		keys[i] = fmt.Sprintf("user/%d/group_$%s_ids", uid, mid)
+	}
+
+	values, err = p.ds.Get(ctx, keys...)
+	if err != nil {
+		return nil, fmt.Errorf("get group ids: %w", err)
+	}
+
+	var groups []int
+	for i, v := range values {
+		if v == nil {
+			continue
+		}
+
+		var ids []int
+		if err := json.Unmarshal(v, &ids); err != nil {
+			return nil, fmt.Errorf("decoding %s: %w", keys[i], err)
+		}
+		groups = append(groups, ids...)
+	}
+	sort.Ints(groups)
+	return groups, nil
+}
+
+// check calls the wrapped check function and applies the mediafile rules to
+// all keys of the mediafile collection.
+func (p *MediafilePermission) check(ctx context.Context, uid int, keys []string, check func(context.Context, int, []string) (map[string]bool, error)) (map[string]bool, error) {
+	allowed, err := check(ctx, uid, keys)
+	if err != nil {
+		return nil, err
+	}
+
+	// Map from the mediafile id to the keys of the mediafile.
+	mediafiles := make(map[int][]string)
+	for _, key := range keys {
+		if !strings.HasPrefix(key, "mediafile/") {
+			continue
+		}
+
+		parts := strings.SplitN(key, "/", 3)
+		id, err := strconv.Atoi(parts[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid mediafile key %s", key)
+		}
+		mediafiles[id] = append(mediafiles[id], key)
+	}
+
+	if len(mediafiles) == 0 {
+		return allowed, nil
+	}
+
+	infos, err := p.mediafileInfos(ctx, uid, mediafiles)
+	if err != nil {
+		return nil, fmt.Errorf("get mediafile data: %w", err)
+	}
+
+	for id, mediafileKeys := range mediafiles {
+		visible := infos[id].visible()
+		for _, key := range mediafileKeys {
+			switch visible {
+			case visibleAlways:
+				allowed[key] = true
+			case visibleNever:
+				allowed[key] = false
+			}
+		}
+	}
+	return allowed, nil
+}
+
+// Results of mediafileInfo.visible.
+const (
+	visibleNever = iota
+	visibleAlways
+	visibleWithPermission
+)
+
+// mediafileInfo is the data that is needed to decide the visibility of a
+// mediafile for one user.
+type mediafileInfo struct {
+	exists       bool
+	isPublic     bool
+	accessGroups []int
+	logoOrFont   bool
+	userGroups   []int
+	adminGroup   int
+}
+
+// visible tells, if the mediafile is always visible, never visible or visible
+// if the wrapped permission allows it.
+func (m mediafileInfo) visible() int {
+	if !m.exists {
+		// Without data, the wrapped permission decides.
+		return visibleWithPermission
+	}
+
+	if m.logoOrFont {
+		return visibleAlways
+	}
+
+	inGroup := make(map[int]bool, len(m.userGroups))
+	for _, g := range m.userGroups {
+		inGroup[g] = true
+	}
+
+	if m.adminGroup != 0 && inGroup[m.adminGroup] {
+		return visibleAlways
+	}
+
+	if m.isPublic {
+		return visibleWithPermission
+	}
+
+	for _, g := range m.accessGroups {
+		if inGroup[g] {
+			return visibleWithPermission
+		}
+	}
+	return visibleNever
+}
+
+// mediafileInfos reads the data of the mediafiles and of the user in the
+// meetings of the mediafiles.
+func (p *MediafilePermission) mediafileInfos(ctx context.Context, uid int, mediafiles map[int][]string) (map[int]mediafileInfo, error) {
+	fields := []string{
+		"meeting_id",
+		"is_public",
+		"inherited_access_group_ids",
+		"used_as_logo_$_in_meeting_id",
+		"used_as_font_$_in_meeting_id",
+	}
+
+	ids := make([]int, 0, len(mediafiles))
+	keys := make([]string, 0, len(mediafiles)*len(fields))
+	for id := range mediafiles {
+		ids = append(ids, id)
+		for _, field := range fields {
+			keys = append(keys, fmt.Sprintf("mediafile/%d/%s", id, field))
+		}
+	}
+
+	values, err := p.ds.Get(ctx, keys...)
+	if err != nil {
+		return nil, fmt.Errorf("get mediafile fields: %w", err)
+	}
+
+	infos := make(map[int]mediafileInfo, len(ids))
+	meetingIDs := make(map[int]int, len(ids))
+	for i, id := range ids {
+		v := values[i*len(fields) : (i+1)*len(fields)]
+
+		var info mediafileInfo
+		var meetingID int
+		var logos, fonts []string
+		for j, target := range []interface{}{&meetingID, &info.isPublic, &info.accessGroups, &logos, &fonts} {
+			if v[j] == nil {
+				continue
+			}
+			if err := json.Unmarshal(v[j], target); err != nil {
+				return nil, fmt.Errorf("decoding mediafile/%d/%s: %w", id, fields[j], err)
+			}
+		}
+		info.exists = meetingID != 0
+		info.logoOrFont = len(logos) > 0 || len(fonts) > 0
+		infos[id] = info
+		meetingIDs[id] = meetingID
+	}
+
+	meetings, err := p.meetingGroups(ctx, uid, meetingIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	for id, info := range infos {
+		m := meetings[meetingIDs[id]]
+		info.userGroups = m.userGroups
+		info.adminGroup = m.adminGroup
+		infos[id] = info
+	}
+	return infos, nil
+}
+
+// meetingGroups is the data of a user in one meeting.
+type meetingGroups struct {
+	userGroups []int
+	adminGroup int
+}
+
+// meetingGroups reads the groups of the user and the admin group for each
+// meeting.
+func (p *MediafilePermission) meetingGroups(ctx context.Context, uid int, meetingIDs map[int]int) (map[int]meetingGroups, error) {
+	var meetings []int
+	seen := make(map[int]bool)
+	for _, mid := range meetingIDs {
+		if mid != 0 && !seen[mid] {
+			meetings = append(meetings, mid)
+			seen[mid] = true
+		}
+	}
+
+	keys := make([]string, 0, 2*len(meetings))
+	for _, mid := range meetings {
+		keys = append(keys,
+			fmt.Sprintf("user/%d/group_$%d_ids", uid, mid),
+			fmt.Sprintf("meeting/%d/admin_group_id", mid),
+		)
+	}
+
+	out := make(map[int]meetingGroups, len(meetings))
+	if len(keys) == 0 {
+		return out, nil
+	}
+
+	values, err := p.ds.Get(ctx, keys...)
+	if err != nil {
+		return nil, fmt.Errorf("get meeting groups: %w", err)
+	}
+
+	for i, mid := range meetings {
+		var m meetingGroups
+		if v := values[2*i]; v != nil && uid != 0 {
+			if err := json.Unmarshal(v, &m.userGroups); err != nil {
+				return nil, fmt.Errorf("decoding %s: %w", keys[2*i], err)
+			}
+		}
+		if v := values[2*i+1]; v != nil {
+			if err := json.Unmarshal(v, &m.adminGroup); err != nil {
+				return nil, fmt.Errorf("decoding %s: %w", keys[2*i+1], err)
+			}
+		}
+		out[mid] = m
+	}
+	return out, nil
+}
@@ -0,0 +1,81 @@
+package restrict
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Field types and restriction modes of the model metadata.
+const (
+	fieldRelationList        = "relation-list"
+	fieldGenericRelationList = "generic-relation-list"
+
+	modeRelationList        = "relation_list"
+	modeGenericRelationList = "generic_relation_list"
+	modeStructuredField     = "structured_field"
+)
+
+// FieldInfo describes one field of a collection.
+type FieldInfo struct {
+	Type        string `json:"type"`
+	To          string `json:"to,omitempty"`
+	Restriction string `json:"restriction"`
+}
+
+// Models is the metadata of the OpenSlides models, that the restricter knows.
+type Models struct {
+	// Version is a hash of the collections. It changes, when the models
+	// change.
+	Version     string                          `json:"version"`
+	Collections map[string]map[string]FieldInfo `json:"collections"`
+}
+
+// ModelsInfo returns the metadata of the models, that are used by the
+// OpenSlidesChecker.
+func ModelsInfo() (Models, error) {
+	return DefinitionInfo(relationLists)
+}
+
+// DefinitionInfo returns the metadata of the models of the given definition.
+func DefinitionInfo(def Definition) (Models, error) {
+	collections := make(map[string]map[string]FieldInfo)
+	for modelField, to := range def {
+		parts := strings.SplitN(modelField, "/", 2)
+		collection, field := parts[0], parts[1]
+
+		info := FieldInfo{
+			Type:        fieldRelationList,
+			To:          to,
+			Restriction: modeRelationList,
+		}
+		if to == "*" {
+			info = FieldInfo{
+				Type:        fieldGenericRelationList,
+				Restriction: modeGenericRelationList,
+			}
+		}
+		if strings.Contains(field, "$") {
+			info.Restriction = modeStructuredField
+		}
+
+		if collections[collection] == nil {
+			collections[collection] = make(map[string]FieldInfo)
+		}
+		collections[collection][field] = info
+	}
+
+	// json.Marshal sorts the keys of maps, so the hash is stable.
+	encoded, err := json.Marshal(collections)
+	if err != nil {
+		return Models{}, fmt.Errorf("encoding collections: %w", err)
+	}
+	hash := sha256.Sum256(encoded)
+
+	return Models{
+		Version:     hex.EncodeToString(hash[:8]),
+		Collections: collections,
+	}, nil
+}
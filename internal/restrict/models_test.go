@@ -0,0 +1,32 @@
+package restrict_test
+
+import (
+	"testing"
+
+	"github.com/openslides/openslides-autoupdate-service/internal/restrict"
+)
+
+func TestModelsInfo(t *testing.T) {
+	models, err := restrict.ModelsInfo()
+	if err != nil {
+		t.Fatalf("ModelsInfo returned unexpected error: %v", err)
+	}
+
+	for _, tt := range []struct {
+		collection string
+		field      string
+		expect     restrict.FieldInfo
+	}{
+		{"agenda_item", "tag_ids", restrict.FieldInfo{Type: "relation-list", To: "tag", Restriction: "relation_list"}},
+		{"committee", "member_ids", restrict.FieldInfo{Type: "relation-list", To: "user", Restriction: "relation_list"}},
+	} {
+		if got := models.Collections[tt.collection][tt.field]; got != tt.expect {
+			t.Errorf("Got %v for %s/%s, expected %v", got, tt.collection, tt.field, tt.expect)
+		}
+	}
+
+	again, _ := restrict.ModelsInfo()
+	if models.Version == "" || models.Version != again.Version {
+		t.Errorf("Got versions `%s` and `%s`, expected the same non empty version", models.Version, again.Version)
+	}
+}
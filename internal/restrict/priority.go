@@ -0,0 +1,139 @@
+package restrict
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// priorityPermissions are the permissions of a group, that allow its users to
+// use a higher priority. They are needed by projectors and chairpersons.
+var priorityPermissions = map[string]bool{
+	"projector.can_manage":        true,
+	"list_of_speakers.can_manage": true,
+}
+
+// GroupPriority decides with the groups of a user, if the user can use a
+// higher priority for the connections. It implements the
+// autoupdate.PriorityPermitter interface.
+//
+// The user has to be in the admin group of a meeting or in a group with one of
+// the permissions projector.can_manage or list_of_speakers.can_manage.
+type GroupPriority struct {
+	ds Datastore
+}
+
+// NewGroupPriority initializes a GroupPriority.
+func NewGroupPriority(ds Datastore) *GroupPriority {
+	return &GroupPriority{ds: ds}
+}
+
+// CanUsePriority tells, if the user can use a higher priority.
+func (g *GroupPriority) CanUsePriority(ctx context.Context, uid int) (bool, error) {
+	meetings, err := g.groupsByMeeting(ctx, uid)
+	if err != nil {
+		return false, fmt.Errorf("get groups of user %d: %w", uid, err)
+	}
+
+	inGroup := make(map[int]bool)
+	var keys []string
+	for mid, groups := range meetings {
+		keys = append(keys, fmt.Sprintf("meeting/%d/admin_group_id", mid))
+		for _, gid := range groups {
+			inGroup[gid] = true
+			keys = append(keys, fmt.Sprintf("group/%d/permissions", gid))
+		}
+	}
+	if len(keys) == 0 {
+		return false, nil
+	}
+
+	values, err := g.ds.Get(ctx, keys...)
+	if err != nil {
+		return false, fmt.Errorf("get groups: %w", err)
+	}
+
+	for i, v := range values {
+		if v == nil {
+			continue
+		}
+
+		if strings.HasPrefix(keys[i], "meeting/") {
+			var adminGroup int
+			if err := json.Unmarshal(v, &adminGroup); err != nil {
+				return false, fmt.Errorf("decoding %s: %w", keys[i], err)
+			}
+			if inGroup[adminGroup] {
+				return true, nil
+			}
+			continue
+		}
+
+		var perms []string
+		if err := json.Unmarshal(v, &perms); err != nil {
+			return false, fmt.Errorf("decoding %s: %w", keys[i], err)
+		}
+		for _, perm := range perms {
+			if priorityPermissions[perm] {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// groupsByMeeting returns the ids of the groups of the user for each meeting
+// of the user. The anonymous user has no groups.
+func (g *GroupPriority) groupsByMeeting(ctx context.Context, uid int) (map[int][]int, error) {
+	if uid == 0 {
+		return nil, nil
+	}
+
+	templateKey := fmt.Sprintf("user/%d/group_$_ids", uid)
+	values, err := g.ds.Get(ctx, templateKey)
+	if err != nil {
+		return nil, fmt.Errorf("get %s: %w", templateKey, err)
+	}
+
+	var replacements []string
+	if values[0] != nil {
+		if err := json.Unmarshal(values[0], &replacements); err != nil {
+			return nil, fmt.Errorf("decoding %s: %w", templateKey, err)
+		}
+	}
+	if len(replacements) == 0 {
+		return nil, nil
+	}
+
+	meetings := make([]int, len(replacements))
+	keys := make([]string, len(replacements))
+	for i, r := range replacements {
+		mid, err := strconv.Atoi(r)
+		if err != nil {
+			return nil, fmt.Errorf("invalid meeting id %q in %s", r, templateKey)
+		}
+		meetings[i] = mid
+		keys[i] = fmt.Sprintf("user/%d/group_$%d_ids", uid, mid)
+	}
+
+	values, err = g.ds.Get(ctx, keys...)
+	if err != nil {
+		return nil, fmt.Errorf("get group ids: %w", err)
+	}
+
+	out := make(map[int][]int, len(meetings))
+	for i, v := range values {
+		if v == nil {
+			continue
+		}
+
+		var ids []int
+		if err := json.Unmarshal(v, &ids); err != nil {
+			return nil, fmt.Errorf("decoding %s: %w", keys[i], err)
+		}
+		out[meetings[i]] = ids
+	}
+	return out, nil
+}
@@ -0,0 +1,48 @@
+package restrict_test
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/openslides/openslides-autoupdate-service/internal/restrict"
+	"github.com/openslides/openslides-autoupdate-service/internal/test"
+)
+
+func TestGroupPriority(t *testing.T) {
+	ds := test.NewMockDatastore(test.WithData(map[string]json.RawMessage{
+		"meeting/1/admin_group_id": []byte(`2`),
+		"group/3/permissions":      []byte(`["motion.can_see", "projector.can_manage"]`),
+		"group/4/permissions":      []byte(`["motion.can_see"]`),
+
+		"user/1/group_$_ids":  []byte(`["1"]`),
+		"user/1/group_$1_ids": []byte(`[2]`),
+		"user/2/group_$_ids":  []byte(`["1"]`),
+		"user/2/group_$1_ids": []byte(`[3]`),
+		"user/3/group_$_ids":  []byte(`["1"]`),
+		"user/3/group_$1_ids": []byte(`[4]`),
+	}), test.WithOnlyData())
+	g := restrict.NewGroupPriority(ds)
+
+	for _, tt := range []struct {
+		name   string
+		uid    int
+		expect bool
+	}{
+		{"admin group", 1, true},
+		{"projector manager", 2, true},
+		{"other group", 3, false},
+		{"without groups", 4, false},
+		{"anonymous", 0, false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := g.CanUsePriority(context.Background(), tt.uid)
+			if err != nil {
+				t.Fatalf("CanUsePriority returned unexpected error: %v", err)
+			}
+			if got != tt.expect {
+				t.Errorf("CanUsePriority(%d) = %t, expected %t", tt.uid, got, tt.expect)
+			}
+		})
+	}
+}
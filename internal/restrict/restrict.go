@@ -3,50 +3,112 @@
 package restrict
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"strconv"
 	"strings"
+	"sync"
 )
 
 // Restricter implements the autoupdate.Restricter interface.
 type Restricter struct {
-	perm             Permission
+	perm Permission
+
+	// mu protects checkers. The checkers are never changed, only replaced.
+	mu       sync.RWMutex
+	checkers *checkerSet
+}
+
+// userScopedCollections are the collections, whose objects belong to one
+// user. The permission service shows them to their owner, so users of the same
+// permission class can see different objects.
+var userScopedCollections = map[string]bool{
+	"personal_note":   true,
+	"motion_vote":     true,
+	"assignment_vote": true,
+}
+
+// checkerSet are the checkers of a restricter and its structured fields.
+type checkerSet struct {
 	checks           map[string]Checker
 	structuredFields []*structuredField
 }
 
+// checker returns the checker for a field like `motion/title`. Returns nil, if
+// the field has no checker.
+func (cs *checkerSet) checker(modelField string) Checker {
+	if checker, ok := cs.checks[modelField]; ok {
+		return checker
+	}
+
+	for _, sf := range cs.structuredFields {
+		if sf.Match(modelField) {
+			return sf.checker
+		}
+	}
+	return nil
+}
+
 // New creates an initialized Restricter.
 func New(perm Permission, checker map[string]Checker) *Restricter {
-	r := &Restricter{
-		perm:   perm,
-		checks: checker,
+	return &Restricter{
+		perm:     perm,
+		checkers: newCheckerSet(checker),
 	}
+}
 
+// newCheckerSet creates a checkerSet and finds the structured fields of the
+// checkers.
+func newCheckerSet(checker map[string]Checker) *checkerSet {
+	cs := &checkerSet{checks: checker}
 	for _, c := range checker {
 		if s, ok := c.(*structuredField); ok {
-			r.structuredFields = append(r.structuredFields, s)
+			cs.structuredFields = append(cs.structuredFields, s)
 		}
 	}
-	return r
+	return cs
+}
+
+// SetChecker replaces the checkers of the restricter. Calls to Restrict, that
+// already started, use the old checkers.
+func (r *Restricter) SetChecker(checker map[string]Checker) {
+	cs := newCheckerSet(checker)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.checkers = cs
+}
+
+// SetDefinition replaces the checkers of the restricter with the checkers of
+// the given definition. See DefinitionChecker.
+func (r *Restricter) SetDefinition(def Definition) {
+	r.SetChecker(DefinitionChecker(r.perm, def))
 }
 
 // Restrict filters and manipulates the given data for the user with the given
 // uid.
 //
 // It is not allowed to manipulate a value in the dict. A value can only be
-// replaced with a new value. If the user does not have the permission to see
+// replaced with a new value. The values are shared with the cache and other
+// connections. Values that the user is allowed to see unchanged are not
+// copied. If the user does not have the permission to see
 // one key, it is not allowed to remove that key, the value has to be set to
 // nil.
-func (r *Restricter) Restrict(uid int, data map[string]json.RawMessage) error {
+func (r *Restricter) Restrict(ctx context.Context, uid int, data map[string]json.RawMessage) error {
 	keys := make([]string, 0, len(data))
 	for k := range data {
 		keys = append(keys, k)
 	}
-	allowed, err := r.perm.CheckFQFields(uid, keys)
+	allowed, err := r.perm.CheckFQFields(ctx, uid, keys)
 	if err != nil {
 		return fmt.Errorf("check permissions: %w", err)
 	}
 
+	r.mu.RLock()
+	cs := r.checkers
+	r.mu.RUnlock()
+
 	for k, v := range data {
 		if v == nil {
 			continue
@@ -57,22 +119,13 @@ func (r *Restricter) Restrict(uid int, data map[string]json.RawMessage) error {
 			continue
 		}
 
-		modelField := fqfieldToModelField(k)
-		checker, ok := r.checks[modelField]
-		if !ok {
-			for _, sf := range r.structuredFields {
-				if sf.Match(modelField) {
-					checker = sf.checker
-					break
-				}
-			}
-			if checker == nil {
-				// Not a check and not a structured field.
-				continue
-			}
+		checker := cs.checker(fqfieldToModelField(k))
+		if checker == nil {
+			// Not a check and not a structured field.
+			continue
 		}
 
-		nv, err := checker.Check(uid, k, v)
+		nv, err := checker.Check(ctx, uid, k, v)
 		if err != nil {
 			return fmt.Errorf("checker for key %s: %w", k, err)
 		}
@@ -81,6 +134,77 @@ func (r *Restricter) Restrict(uid int, data map[string]json.RawMessage) error {
 	return nil
 }
 
+// CanSeeHistory tells, if the user can see the history of objects. It is
+// false, if the permission service does not implement HistoryPermission.
+func (r *Restricter) CanSeeHistory(uid int) (bool, error) {
+	hp, ok := r.perm.(HistoryPermission)
+	if !ok {
+		return false, nil
+	}
+
+	allowed, err := hp.HasHistoryPermission(uid)
+	if err != nil {
+		return false, fmt.Errorf("check history permission: %w", err)
+	}
+	return allowed, nil
+}
+
+// CanUsePriority tells, if the user can use a higher priority for the
+// connections. It is false, if the permission service does not implement
+// PriorityPermission.
+func (r *Restricter) CanUsePriority(ctx context.Context, uid int) (bool, error) {
+	pp, ok := r.perm.(PriorityPermission)
+	if !ok {
+		return false, nil
+	}
+
+	allowed, err := pp.HasPriorityPermission(ctx, uid)
+	if err != nil {
+		return false, fmt.Errorf("check priority permission: %w", err)
+	}
+	return allowed, nil
+}
+
+// PermissionClass returns the permission class of the user. Users with the
+// same class get the same restricted data for all keys, where UserScoped
+// returns false. If the permission service does not implement
+// PermissionClasser, each user is its own class.
+func (r *Restricter) PermissionClass(ctx context.Context, uid int) (string, error) {
+	pc, ok := r.perm.(PermissionClasser)
+	if !ok {
+		return strconv.Itoa(uid), nil
+	}
+
+	class, err := pc.PermissionClass(ctx, uid)
+	if err != nil {
+		return "", fmt.Errorf("get permission class: %w", err)
+	}
+	return class, nil
+}
+
+// UserScoped tells, if the restricted value of the key depends on the user
+// itself and not only on the permission class of the user. This is true for
+// the objects of userScopedCollections and for the keys, where the checker
+// implements UserScoped.
+func (r *Restricter) UserScoped(key string) bool {
+	parts := strings.Split(key, "/")
+	if len(parts) != 3 {
+		// Do not share a key, that can not be checked.
+		return true
+	}
+
+	if userScopedCollections[parts[0]] {
+		return true
+	}
+
+	r.mu.RLock()
+	cs := r.checkers
+	r.mu.RUnlock()
+
+	us, ok := cs.checker(parts[0] + "/" + parts[2]).(UserScoped)
+	return ok && us.UserScoped()
+}
+
 func structuredKeys(key string, replecments []string) []string {
 	replaced := make([]string, len(replecments))
 	for i, r := range replecments {
@@ -1,11 +1,13 @@
 package restrict_test
 
 import (
+	"context"
 	"encoding/json"
 	"testing"
 
 	"github.com/openslides/openslides-autoupdate-service/internal/restrict"
 	"github.com/openslides/openslides-autoupdate-service/internal/test"
+	"github.com/openslides/openslides-autoupdate-service/internal/trace"
 )
 
 func TestRestrict(t *testing.T) {
@@ -19,7 +21,7 @@ func TestRestrict(t *testing.T) {
 		"user/1/name":     []byte("uwe"),
 		"user/1/password": []byte("easy"),
 	}
-	if err := r.Restrict(1, data); err != nil {
+	if err := r.Restrict(context.Background(), 1, data); err != nil {
 		t.Errorf("Restrict returned unexpected error: %v", err)
 	}
 
@@ -42,15 +44,15 @@ func TestChecker(t *testing.T) {
 
 	called := make(map[string]bool)
 	checker := map[string]restrict.Checker{
-		"user/name": restrict.CheckerFunc(func(uid int, key string, value json.RawMessage) (json.RawMessage, error) {
+		"user/name": restrict.CheckerFunc(func(ctx context.Context, uid int, key string, value json.RawMessage) (json.RawMessage, error) {
 			called[key] = true
 			return []byte("touched"), nil
 		}),
-		"user/password": restrict.CheckerFunc(func(uid int, key string, value json.RawMessage) (json.RawMessage, error) {
+		"user/password": restrict.CheckerFunc(func(ctx context.Context, uid int, key string, value json.RawMessage) (json.RawMessage, error) {
 			called[key] = true
 			return []byte("touched"), nil
 		}),
-		"user/first_name": restrict.CheckerFunc(func(uid int, key string, value json.RawMessage) (json.RawMessage, error) {
+		"user/first_name": restrict.CheckerFunc(func(ctx context.Context, uid int, key string, value json.RawMessage) (json.RawMessage, error) {
 			called[key] = true
 			return []byte("touched"), nil
 		}),
@@ -62,7 +64,7 @@ func TestChecker(t *testing.T) {
 		"user/1/password":   []byte("easy"),
 		"user/1/first_name": nil,
 	}
-	if err := r.Restrict(1, data); err != nil {
+	if err := r.Restrict(context.Background(), 1, data); err != nil {
 		t.Errorf("Restrict returned unexpected error: %v", err)
 	}
 
@@ -86,3 +88,99 @@ func TestChecker(t *testing.T) {
 		t.Errorf("checker for key user/1/first_name was called")
 	}
 }
+
+func TestRestrictTraceContext(t *testing.T) {
+	perms := new(test.MockPermission)
+	perms.Default = true
+
+	var got trace.Info
+	checker := map[string]restrict.Checker{
+		"user/name": restrict.CheckerFunc(func(ctx context.Context, uid int, key string, value json.RawMessage) (json.RawMessage, error) {
+			got, _ = trace.FromContext(ctx)
+			return value, nil
+		}),
+	}
+
+	r := restrict.New(perms, checker)
+	ctx := trace.NewContext(context.Background(), trace.Info{RequestID: "my-request"})
+	data := map[string]json.RawMessage{"user/1/name": []byte("uwe")}
+	if err := r.Restrict(ctx, 1, data); err != nil {
+		t.Fatalf("Restrict returned unexpected error: %v", err)
+	}
+
+	if got.RequestID != "my-request" {
+		t.Errorf("Checker got request id `%s`, expected `my-request`", got.RequestID)
+	}
+}
+
+func TestPermissionClass(t *testing.T) {
+	ds := test.NewMockDatastore(test.WithData(map[string]json.RawMessage{
+		"user/1/group_$_ids":  []byte(`["1","2"]`),
+		"user/1/group_$1_ids": []byte(`[3]`),
+		"user/1/group_$2_ids": []byte(`[7]`),
+		"user/2/group_$_ids":  []byte(`["2","1"]`),
+		"user/2/group_$1_ids": []byte(`[3]`),
+		"user/2/group_$2_ids": []byte(`[7]`),
+		"user/3/group_$_ids":  []byte(`["1"]`),
+		"user/3/group_$1_ids": []byte(`[3]`),
+	}), test.WithOnlyData())
+	perm := restrict.NewMediafilePermission(new(test.MockPermission), ds)
+	r := restrict.New(perm, restrict.OpenSlidesChecker(perm))
+
+	classes := make(map[int]string)
+	for _, uid := range []int{1, 2, 3} {
+		class, err := r.PermissionClass(context.Background(), uid)
+		if err != nil {
+			t.Fatalf("PermissionClass(%d) returned unexpected error: %v", uid, err)
+		}
+		classes[uid] = class
+	}
+
+	if classes[1] != classes[2] {
+		t.Errorf("Users with the same groups got the classes %s and %s", classes[1], classes[2])
+	}
+	if classes[1] == classes[3] {
+		t.Errorf("Users with different groups got the same class %s", classes[1])
+	}
+}
+
+func TestPermissionClassWithoutClasser(t *testing.T) {
+	perm := make(goldenPermission)
+	r := restrict.New(perm, nil)
+
+	c1, err := r.PermissionClass(context.Background(), 1)
+	if err != nil {
+		t.Fatalf("PermissionClass returned unexpected error: %v", err)
+	}
+	c2, err := r.PermissionClass(context.Background(), 2)
+	if err != nil {
+		t.Fatalf("PermissionClass returned unexpected error: %v", err)
+	}
+
+	if c1 == c2 {
+		t.Errorf("Got the same class %s for two users, expected one class per user", c1)
+	}
+}
+
+func TestUserScoped(t *testing.T) {
+	perm := new(test.MockPermission)
+	r := restrict.New(perm, restrict.OpenSlidesChecker(perm))
+
+	for _, tt := range []struct {
+		key    string
+		expect bool
+	}{
+		{"motion/1/title", false},
+		{"motion/1/tag_ids", false},
+		{"personal_note/1/note", true},
+		{"motion_vote/1/value", true},
+		{"motion/1/personal_note_ids", true},
+		{"user/1/personal_note_$5_ids", true},
+		{"user/1/group_$5_ids", false},
+		{"invalid", true},
+	} {
+		if got := r.UserScoped(tt.key); got != tt.expect {
+			t.Errorf("UserScoped(%s) returned %t, expected %t", tt.key, got, tt.expect)
+		}
+	}
+}
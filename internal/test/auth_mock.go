@@ -0,0 +1,121 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// ErrAuthOutage is returned by MockAuth, when an outage is simulated.
+var ErrAuthOutage = errors.New("auth service is not available")
+
+// AuthError is returned by MockAuth for an invalid or expired token.
+type AuthError struct {
+	msg string
+}
+
+func (e AuthError) Error() string {
+	return e.msg
+}
+
+// Type returns the name of the error.
+func (e AuthError) Type() string {
+	return "AuthError"
+}
+
+// MockAuth implements the http.Authenticator interface. It reads a token
+// from the Authorization header in the form "Bearer <token>" and maps it to a
+// user id.
+//
+// Requests without a token get the user id Default. A token can belong to a
+// session, see AddSession.
+//
+// MockAuth is save for concurrent use.
+type MockAuth struct {
+	mu       sync.Mutex
+	Default  int
+	tokens   map[string]int
+	sessions map[string]string
+	expired  map[string]bool
+	outage   bool
+}
+
+// AddUser lets the token authenticate as the user with the given id.
+func (a *MockAuth) AddUser(token string, uid int) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.tokens == nil {
+		a.tokens = make(map[string]int)
+	}
+	a.tokens[token] = uid
+	delete(a.expired, token)
+}
+
+// AddSession lets the token authenticate as the user with the given id in the
+// given session.
+func (a *MockAuth) AddSession(token string, uid int, session string) {
+	a.AddUser(token, uid)
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.sessions == nil {
+		a.sessions = make(map[string]string)
+	}
+	a.sessions[token] = session
+}
+
+// Expire lets the token fail with an AuthError.
+func (a *MockAuth) Expire(token string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.expired == nil {
+		a.expired = make(map[string]bool)
+	}
+	a.expired[token] = true
+}
+
+// Outage simulates a not reachable auth service. While the outage is active,
+// each call returns ErrAuthOutage.
+func (a *MockAuth) Outage(active bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.outage = active
+}
+
+// Authenticate returns the user id for the token of the request.
+func (a *MockAuth) Authenticate(ctx context.Context, r *http.Request) (int, error) {
+	uid, _, err := a.AuthenticateSession(ctx, r)
+	return uid, err
+}
+
+// AuthenticateSession returns the user id and the session id for the token of
+// the request.
+func (a *MockAuth) AuthenticateSession(_ context.Context, r *http.Request) (int, string, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.outage {
+		return 0, "", ErrAuthOutage
+	}
+
+	header := r.Header.Get("Authorization")
+	if header == "" {
+		return a.Default, "", nil
+	}
+
+	token := strings.TrimPrefix(header, "Bearer ")
+	if a.expired[token] {
+		return 0, "", AuthError{msg: "Token is expired"}
+	}
+
+	uid, ok := a.tokens[token]
+	if !ok {
+		return 0, "", AuthError{msg: "Invalid token"}
+	}
+	return uid, a.sessions[token], nil
+}
@@ -0,0 +1,93 @@
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// ErrChaos is returned by the mocks, when Chaos decides that a call should
+// fail.
+var ErrChaos = errors.New("chaos error")
+
+// Chaos configures failure injection for the mocks. The zero value does not
+// inject anything.
+//
+// Chaos can be used concurrently.
+type Chaos struct {
+	// ErrorRate is the probability between 0 and 1 that a call returns
+	// ErrChaos.
+	ErrorRate float64
+
+	// MinLatency and MaxLatency define the range of a random delay for each
+	// call.
+	MinLatency time.Duration
+	MaxLatency time.Duration
+
+	// WrongTypeRate is the probability between 0 and 1 that a value is
+	// replaced with a value of another json type.
+	WrongTypeRate float64
+
+	// Seed is used to initialize the random generator. The same seed leads
+	// to the same decisions.
+	Seed int64
+
+	mu   sync.Mutex
+	rand *rand.Rand
+}
+
+// float returns a random number between 0 and 1.
+func (c *Chaos) float() float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.rand == nil {
+		c.rand = rand.New(rand.NewSource(c.Seed))
+	}
+	return c.rand.Float64()
+}
+
+// Call delays the call and returns ErrChaos according to the configuration.
+// Returns the error of the context, if it is done before the delay is over.
+//
+// Call can be called on a nil value.
+func (c *Chaos) Call(ctx context.Context) error {
+	if c == nil {
+		return nil
+	}
+
+	if c.MaxLatency > 0 {
+		latency := c.MinLatency + time.Duration(c.float()*float64(c.MaxLatency-c.MinLatency))
+		timer := time.NewTimer(latency)
+		defer timer.Stop()
+
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	if c.ErrorRate > 0 && c.float() < c.ErrorRate {
+		return ErrChaos
+	}
+	return nil
+}
+
+// Value returns the given value or, according to WrongTypeRate, a value of
+// another json type. Nil values are not changed.
+//
+// Value can be called on a nil value.
+func (c *Chaos) Value(value json.RawMessage) json.RawMessage {
+	if c == nil || value == nil || c.WrongTypeRate <= 0 || c.float() >= c.WrongTypeRate {
+		return value
+	}
+
+	if value[0] == '"' {
+		return []byte(`0`)
+	}
+	return []byte(`"chaos"`)
+}
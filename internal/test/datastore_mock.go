@@ -6,12 +6,79 @@ import (
 	"fmt"
 	"strings"
 	"sync"
+	"time"
 )
 
 // MockDatastore implements the autoupdate.Datastore interface.
+//
+// It is save for concurrent use. It should be created with NewMockDatastore(),
+// but the zero value can also be used.
 type MockDatastore struct {
-	changeListeners []func(map[string]json.RawMessage) error
 	DatastoreValues
+
+	// Chaos injects errors, latency and wrong values into Get. nil means no
+	// chaos.
+	Chaos *Chaos
+
+	listenerMu      sync.RWMutex
+	changeListeners []func(map[string]json.RawMessage) error
+
+	latency time.Duration
+}
+
+// MockDatastoreOption is an option for NewMockDatastore.
+type MockDatastoreOption func(*MockDatastore)
+
+// WithData sets the initial data of the mock.
+func WithData(data map[string]json.RawMessage) MockDatastoreOption {
+	return func(d *MockDatastore) {
+		d.DatastoreValues.Update(data)
+	}
+}
+
+// WithOnlyData lets the mock only return values from its data and no default
+// values.
+func WithOnlyData() MockDatastoreOption {
+	return func(d *MockDatastore) {
+		d.OnlyData = true
+	}
+}
+
+// WithLatency delays each call to Get.
+func WithLatency(latency time.Duration) MockDatastoreOption {
+	return func(d *MockDatastore) {
+		d.latency = latency
+	}
+}
+
+// WithChaos sets the chaos configuration of the mock.
+func WithChaos(c *Chaos) MockDatastoreOption {
+	return func(d *MockDatastore) {
+		d.Chaos = c
+	}
+}
+
+// WithUpdates reads data from the given channel. Each received value is saved
+// in the mock and send to the change listeners. The channel has to be closed
+// after the test.
+func WithUpdates(updates <-chan map[string]json.RawMessage) MockDatastoreOption {
+	return func(d *MockDatastore) {
+		go func() {
+			for data := range updates {
+				d.DatastoreValues.Update(data)
+				d.sendData(data)
+			}
+		}()
+	}
+}
+
+// NewMockDatastore creates a MockDatastore with the given options.
+func NewMockDatastore(options ...MockDatastoreOption) *MockDatastore {
+	d := new(MockDatastore)
+	for _, o := range options {
+		o(d)
+	}
+	return d
 }
 
 // Get returnes the values for the given keys. If the keys exist in the Data
@@ -26,26 +93,41 @@ type MockDatastore struct {
 // If the key ends with "_ids", "[1,2]" is returned.
 //
 // In any other case, "some value" is returned.
+//
+// If Chaos is set, it is applied to the call and to each value.
 func (d *MockDatastore) Get(ctx context.Context, keys ...string) ([]json.RawMessage, error) {
-	data := make(map[string]json.RawMessage, len(keys))
-	for _, key := range keys {
-		value, _, err := d.DatastoreValues.Value(key)
-		if err != nil {
-			return nil, err
+	if d.latency > 0 {
+		timer := time.NewTimer(d.latency)
+		defer timer.Stop()
+
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			return nil, ctx.Err()
 		}
+	}
 
-		data[key] = value
+	if err := d.Chaos.Call(ctx); err != nil {
+		return nil, err
 	}
 
 	values := make([]json.RawMessage, len(keys))
 	for i, key := range keys {
-		values[i] = data[key]
+		value, _, err := d.DatastoreValues.Value(key)
+		if err != nil {
+			return nil, err
+		}
+
+		values[i] = d.Chaos.Value(value)
 	}
 	return values, nil
 }
 
 // RegisterChangeListener registers a change listener.
 func (d *MockDatastore) RegisterChangeListener(f func(map[string]json.RawMessage) error) {
+	d.listenerMu.Lock()
+	defer d.listenerMu.Unlock()
+
 	d.changeListeners = append(d.changeListeners, f)
 }
 
@@ -56,6 +138,12 @@ func (d *MockDatastore) Send(keys []string) {
 	for _, key := range keys {
 		data[key] = nil
 	}
+	d.sendData(data)
+}
+
+func (d *MockDatastore) sendData(data map[string]json.RawMessage) {
+	d.listenerMu.RLock()
+	defer d.listenerMu.RUnlock()
 
 	for _, f := range d.changeListeners {
 		f(data)
@@ -89,6 +177,9 @@ func (d *DatastoreValues) Value(key string) (json.RawMessage, bool, error) {
 		return nil, true, fmt.Errorf("mock datastore error")
 	case strings.HasSuffix(key, "_id"):
 		return json.RawMessage(`1`), true, nil
+	case strings.HasSuffix(key, "_$_ids"):
+		// Template fields hold the replacements as strings.
+		return json.RawMessage(`["1","2"]`), true, nil
 	case strings.HasSuffix(key, "_ids"):
 		return json.RawMessage(`[1,2]`), true, nil
 	default:
@@ -96,6 +187,18 @@ func (d *DatastoreValues) Value(key string) (json.RawMessage, bool, error) {
 	}
 }
 
+// All returns a copy of all values in the Data attribute.
+func (d *DatastoreValues) All() map[string]json.RawMessage {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	data := make(map[string]json.RawMessage, len(d.Data))
+	for k, v := range d.Data {
+		data[k] = v
+	}
+	return data
+}
+
 // Update updates the values from the Datastore.
 //
 // This does not send a signal to the listeners.
@@ -104,8 +207,7 @@ func (d *DatastoreValues) Update(data map[string]json.RawMessage) {
 	defer d.mu.Unlock()
 
 	if d.Data == nil {
-		d.Data = data
-		return
+		d.Data = make(map[string]json.RawMessage, len(data))
 	}
 
 	for key, value := range data {
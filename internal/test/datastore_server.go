@@ -5,27 +5,67 @@ import (
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"sort"
 	"strings"
+	"sync"
+	"time"
 )
 
 type getManyRequest struct {
-	Keys []string `json:"requests"`
+	Keys     []string `json:"requests"`
+	Position int      `json:"position"`
 }
 
 // DatastoreServer simulates the Datastore-Service. Only the methods required by the
-// autoupdate-service are supported. This is currently only the getMany method.
+// autoupdate-service are supported. This is the getMany method, the
+// history_information method and the health route.
+//
+// get_many requests with a position and history_information requests use the
+// data added with AddPosition.
 //
 // Has to be created with NewDatastoreServer.
 type DatastoreServer struct {
 	TS           *httptest.Server
 	RequestCount int
 	DatastoreValues
+
+	mu        sync.Mutex
+	err       error
+	latency   time.Duration
+	positions map[int]map[string]string
+	infos     map[int]positionInfo
+}
+
+// positionInfo is the timestamp and the acting user of a position.
+type positionInfo struct {
+	timestamp int64
+	userID    int
 }
 
 // NewDatastoreServer creates a new DatastoreServer.
 func NewDatastoreServer() *DatastoreServer {
 	ts := new(DatastoreServer)
 	ts.TS = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ts.mu.Lock()
+		err, latency := ts.err, ts.latency
+		ts.mu.Unlock()
+
+		time.Sleep(latency)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if strings.HasSuffix(r.URL.Path, "/health") {
+			w.Write([]byte(`{"healthinfo": {"status": "running"}}`))
+			return
+		}
+
+		if strings.HasSuffix(r.URL.Path, "/history_information") {
+			ts.historyInformation(w, r)
+			return
+		}
+
 		var data getManyRequest
 		if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
 			http.Error(w, fmt.Sprintf("Invalid json input: %v", err), http.StatusBadRequest)
@@ -33,6 +73,11 @@ func NewDatastoreServer() *DatastoreServer {
 		}
 		defer r.Body.Close()
 
+		if data.Position != 0 {
+			ts.getPosition(w, data)
+			return
+		}
+
 		responceData := make(map[string]map[string]map[string]json.RawMessage)
 		for _, key := range data.Keys {
 			value, exist, err := ts.DatastoreValues.Value(key)
@@ -62,3 +107,107 @@ func NewDatastoreServer() *DatastoreServer {
 	}))
 	return ts
 }
+
+// SetError lets each following request fail with the given error. If err is
+// nil, the requests succeed again.
+func (ts *DatastoreServer) SetError(err error) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	ts.err = err
+}
+
+// SetLatency delays each following request by d.
+func (ts *DatastoreServer) SetLatency(d time.Duration) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	ts.latency = d
+}
+
+// AddPosition saves the values of keys at a position. The values are used for
+// history_information requests and get_many requests with a position.
+func (ts *DatastoreServer) AddPosition(position int, data map[string]string) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	if ts.positions == nil {
+		ts.positions = make(map[int]map[string]string)
+	}
+	ts.positions[position] = data
+}
+
+// SetPositionInfo sets the timestamp and the acting user of a position, that
+// are returned by history_information requests.
+func (ts *DatastoreServer) SetPositionInfo(position int, timestamp int64, userID int) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	if ts.infos == nil {
+		ts.infos = make(map[int]positionInfo)
+	}
+	ts.infos[position] = positionInfo{timestamp: timestamp, userID: userID}
+}
+
+// historyInformation returns the positions of the requested fqids.
+func (ts *DatastoreServer) historyInformation(w http.ResponseWriter, r *http.Request) {
+	var data struct {
+		FQIDs []string `json:"fqids"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid json input: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	type info struct {
+		Position  int   `json:"position"`
+		Timestamp int64 `json:"timestamp"`
+		UserID    int   `json:"user_id"`
+	}
+	responce := make(map[string][]info)
+	for _, fqid := range data.FQIDs {
+		var positions []int
+		for position, values := range ts.positions {
+			for key := range values {
+				if strings.HasPrefix(key, fqid+"/") {
+					positions = append(positions, position)
+					break
+				}
+			}
+		}
+		sort.Ints(positions)
+
+		for _, p := range positions {
+			pi := ts.infos[p]
+			responce[fqid] = append(responce[fqid], info{Position: p, Timestamp: pi.timestamp, UserID: pi.userID})
+		}
+	}
+	json.NewEncoder(w).Encode(responce)
+}
+
+// getPosition returns all fields of the requested fqids and the requested
+// fqfields at a position.
+func (ts *DatastoreServer) getPosition(w http.ResponseWriter, data getManyRequest) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	responce := make(map[string]map[string]map[string]json.RawMessage)
+	for _, fqid := range data.Keys {
+		for key, value := range ts.positions[data.Position] {
+			if key != fqid && !strings.HasPrefix(key, fqid+"/") {
+				continue
+			}
+
+			keyParts := strings.SplitN(key, "/", 3)
+			if _, ok := responce[keyParts[0]]; !ok {
+				responce[keyParts[0]] = make(map[string]map[string]json.RawMessage)
+			}
+			if _, ok := responce[keyParts[0]][keyParts[1]]; !ok {
+				responce[keyParts[0]][keyParts[1]] = make(map[string]json.RawMessage)
+			}
+			responce[keyParts[0]][keyParts[1]][keyParts[2]] = json.RawMessage(value)
+		}
+	}
+	json.NewEncoder(w).Encode(responce)
+}
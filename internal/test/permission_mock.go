@@ -1,6 +1,9 @@
 package test
 
-import "sync"
+import (
+	"context"
+	"sync"
+)
 
 //MockPermission mocks the permission api.
 type MockPermission struct {
@@ -11,7 +14,7 @@ type MockPermission struct {
 }
 
 // CheckFQIDs returns the fields where p.Data is true.
-func (p *MockPermission) CheckFQIDs(uid int, fqids []string) (map[string]bool, error) {
+func (p *MockPermission) CheckFQIDs(ctx context.Context, uid int, fqids []string) (map[string]bool, error) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
@@ -36,6 +39,26 @@ func (p *MockPermission) CheckFQIDs(uid int, fqids []string) (map[string]bool, e
 }
 
 // CheckFQFields calls CheckFQIDs.
-func (p *MockPermission) CheckFQFields(uid int, fqfields []string) (map[string]bool, error) {
-	return p.CheckFQIDs(uid, fqfields)
+func (p *MockPermission) CheckFQFields(ctx context.Context, uid int, fqfields []string) (map[string]bool, error) {
+	return p.CheckFQIDs(ctx, uid, fqfields)
+}
+
+// HasHistoryPermission returns p.Default.
+func (p *MockPermission) HasHistoryPermission(uid int) (bool, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.Default, nil
+}
+
+// HasPriorityPermission returns p.Default.
+func (p *MockPermission) HasPriorityPermission(ctx context.Context, uid int) (bool, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.Default, nil
+}
+
+// PermissionClass returns the same class for all users, since the mock does
+// not depend on the user id.
+func (p *MockPermission) PermissionClass(ctx context.Context, uid int) (string, error) {
+	return "mock", nil
 }
@@ -1,11 +1,27 @@
 package test
 
-import "encoding/json"
+import (
+	"context"
+	"encoding/json"
+)
 
 // MockRestricter implements the restricter interface.
-type MockRestricter struct{}
+type MockRestricter struct {
+	// Chaos injects errors, latency and wrong values into Restrict. nil means
+	// no chaos.
+	Chaos *Chaos
+}
+
+// Restrict does not change the data, unless Chaos is set.
+func (r *MockRestricter) Restrict(ctx context.Context, uid int, data map[string]json.RawMessage) error {
+	if err := r.Chaos.Call(context.Background()); err != nil {
+		return err
+	}
 
-// Restrict does currently nothing.
-func (r *MockRestricter) Restrict(uid int, data map[string]json.RawMessage) error {
+	if r.Chaos != nil {
+		for k, v := range data {
+			data[k] = r.Chaos.Value(v)
+		}
+	}
 	return nil
 }
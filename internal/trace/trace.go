@@ -0,0 +1,186 @@
+// Package trace forwards the trace context of a request to other services, so
+// one user action can be followed through all OpenSlides services.
+//
+// The trace context is read from the incoming request with FromHeader and
+// saved in the context of the request. All outgoing requests, that are done
+// with this context, get the headers with SetHeader.
+//
+// The trace id uses the W3C format from the header traceparent. Each outgoing
+// request gets a new span id with the same trace id. The request id is read
+// from the header X-Request-ID. If the headers are missing or invalid, new ids
+// are generated.
+package trace
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// The headers, that are read and forwarded.
+const (
+	TraceParentHeader = "traceparent"
+	TraceStateHeader  = "tracestate"
+	RequestIDHeader   = "X-Request-ID"
+	UserIDHeader      = "X-OpenSlides-User-ID"
+)
+
+// maxRequestIDLength is the maximum length of a request id from a client.
+const maxRequestIDLength = 128
+
+// Info is the trace context of one request.
+type Info struct {
+	// TraceID is the id of the trace as 32 hex characters.
+	TraceID string
+
+	// Flags are the trace flags from traceparent as 2 hex characters.
+	Flags string
+
+	// State is the vendor specific value of the header tracestate.
+	State string
+
+	// RequestID is the id of the request.
+	RequestID string
+
+	// UserID is the user, that sent the request. It is 0, if it is unknown or
+	// the work is done for many users.
+	UserID int
+}
+
+// FromHeader reads the trace context from the headers of an incoming request.
+// Missing or invalid ids are generated.
+func FromHeader(h http.Header) Info {
+	info := Info{
+		State:     h.Get(TraceStateHeader),
+		RequestID: h.Get(RequestIDHeader),
+	}
+
+	// traceparent has the format version-traceid-parentid-flags.
+	parts := strings.Split(h.Get(TraceParentHeader), "-")
+	if len(parts) == 4 && isHex(parts[1], 32) && isHex(parts[2], 16) && isHex(parts[3], 2) {
+		info.TraceID = parts[1]
+		info.Flags = parts[3]
+	}
+
+	if info.TraceID == "" {
+		// Without a valid parent, the state has no meaning.
+		info.TraceID = randomHex(16)
+		info.Flags = "00"
+		info.State = ""
+	}
+
+	if !validRequestID(info.RequestID) {
+		// The request id is written to the logs and sent to other services,
+		// so only short ids with harmless characters are used.
+		info.RequestID = randomHex(16)
+	}
+	return info
+}
+
+type contextKey int
+
+const infoKey contextKey = 0
+
+// NewContext returns a new context that carries the trace info.
+func NewContext(ctx context.Context, info Info) context.Context {
+	return context.WithValue(ctx, infoKey, info)
+}
+
+// FromContext returns the trace info of the context. The second value is
+// false, if the context does not have a trace info.
+func FromContext(ctx context.Context) (Info, bool) {
+	info, ok := ctx.Value(infoKey).(Info)
+	return info, ok
+}
+
+// WithUserID returns a context, where the trace info contains the user id. It
+// does nothing, if the context has no trace info.
+func WithUserID(ctx context.Context, uid int) context.Context {
+	info, ok := FromContext(ctx)
+	if !ok {
+		return ctx
+	}
+	info.UserID = uid
+	return NewContext(ctx, info)
+}
+
+// Shared returns a context with the trace info of ctx but without its
+// deadline, its cancellation and the user id. It is used for work, that is
+// shared by the requests of many users, like filling the cache.
+func Shared(ctx context.Context) context.Context {
+	info, ok := FromContext(ctx)
+	if !ok {
+		return context.Background()
+	}
+	info.UserID = 0
+	return NewContext(context.Background(), info)
+}
+
+// SetHeader sets the trace headers of an outgoing request from the trace info
+// in the context. Each call creates a new span id.
+func SetHeader(ctx context.Context, h http.Header) {
+	info, ok := FromContext(ctx)
+	if !ok {
+		return
+	}
+
+	h.Set(TraceParentHeader, "00-"+info.TraceID+"-"+randomHex(8)+"-"+info.Flags)
+	if info.State != "" {
+		h.Set(TraceStateHeader, info.State)
+	}
+	h.Set(RequestIDHeader, info.RequestID)
+	if info.UserID != 0 {
+		h.Set(UserIDHeader, strconv.Itoa(info.UserID))
+	}
+}
+
+// isHex tells, if s has n lower case hex characters and is not only zeros.
+func isHex(s string, n int) bool {
+	if len(s) != n {
+		return false
+	}
+
+	zero := true
+	for _, c := range s {
+		switch {
+		case c == '0':
+		case c >= '1' && c <= '9', c >= 'a' && c <= 'f':
+			zero = false
+		default:
+			return false
+		}
+	}
+	return !zero || n == 2
+}
+
+// validRequestID tells, if s is a request id with at most maxRequestIDLength
+// characters from A-Z, a-z, 0-9, dot, underscore and dash.
+func validRequestID(s string) bool {
+	if s == "" || len(s) > maxRequestIDLength {
+		return false
+	}
+
+	for _, c := range s {
+		switch {
+		case c >= 'A' && c <= 'Z', c >= 'a' && c <= 'z', c >= '0' && c <= '9':
+		case c == '.', c == '_', c == '-':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// randomHex returns n random bytes as hex string.
+func randomHex(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		// crypto/rand only fails, if the operating system has no random
+		// source. The ids are only used for tracing.
+		return strings.Repeat("0", 2*n-1) + "1"
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,109 @@
+package trace_test
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/openslides/openslides-autoupdate-service/internal/trace"
+)
+
+func TestFromHeader(t *testing.T) {
+	h := make(http.Header)
+	h.Set(trace.TraceParentHeader, "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01")
+	h.Set(trace.TraceStateHeader, "vendor=value")
+	h.Set(trace.RequestIDHeader, "my-request")
+	h.Set(trace.UserIDHeader, "5")
+
+	info := trace.FromHeader(h)
+
+	expect := trace.Info{
+		TraceID:   "0af7651916cd43dd8448eb211c80319c",
+		Flags:     "01",
+		State:     "vendor=value",
+		RequestID: "my-request",
+	}
+	if info != expect {
+		t.Errorf("Got %+v, expected %+v", info, expect)
+	}
+}
+
+func TestFromHeaderGenerate(t *testing.T) {
+	for _, parent := range []string{"", "invalid", "00-00000000000000000000000000000000-b7ad6b7169203331-01"} {
+		h := make(http.Header)
+		h.Set(trace.TraceParentHeader, parent)
+		h.Set(trace.TraceStateHeader, "vendor=value")
+
+		info := trace.FromHeader(h)
+
+		if len(info.TraceID) != 32 || info.TraceID == strings.Repeat("0", 32) {
+			t.Errorf("Got trace id `%s` for traceparent `%s`, expected a new id", info.TraceID, parent)
+		}
+		if info.State != "" {
+			t.Errorf("Got trace state `%s` for traceparent `%s`, expected none", info.State, parent)
+		}
+		if info.RequestID == "" {
+			t.Errorf("Got no request id, expected a new one")
+		}
+	}
+}
+
+func TestFromHeaderInvalidRequestID(t *testing.T) {
+	for _, id := range []string{
+		"with space",
+		"new\nline",
+		"log=injection\"",
+		"ümlaut",
+		strings.Repeat("a", 129),
+	} {
+		h := make(http.Header)
+		h[trace.RequestIDHeader] = []string{id}
+
+		info := trace.FromHeader(h)
+
+		if info.RequestID == id || len(info.RequestID) != 32 {
+			t.Errorf("Got request id `%s` for `%s`, expected a new id", info.RequestID, id)
+		}
+	}
+
+	valid := "Abc.1_2-" + strings.Repeat("x", 120)
+	h := make(http.Header)
+	h.Set(trace.RequestIDHeader, valid)
+	if got := trace.FromHeader(h).RequestID; got != valid {
+		t.Errorf("Got request id `%s`, expected `%s`", got, valid)
+	}
+}
+
+func TestSetHeader(t *testing.T) {
+	info := trace.Info{
+		TraceID:   "0af7651916cd43dd8448eb211c80319c",
+		Flags:     "01",
+		RequestID: "my-request",
+	}
+	ctx := trace.WithUserID(trace.NewContext(context.Background(), info), 5)
+
+	h := make(http.Header)
+	trace.SetHeader(ctx, h)
+
+	parts := strings.Split(h.Get(trace.TraceParentHeader), "-")
+	if len(parts) != 4 || parts[1] != info.TraceID || len(parts[2]) != 16 || parts[3] != "01" {
+		t.Errorf("Got traceparent `%s`, expected the trace id with a new span id", h.Get(trace.TraceParentHeader))
+	}
+	if got := h.Get(trace.RequestIDHeader); got != "my-request" {
+		t.Errorf("Got request id `%s`, expected `my-request`", got)
+	}
+	if got := h.Get(trace.UserIDHeader); got != "5" {
+		t.Errorf("Got user id `%s`, expected `5`", got)
+	}
+
+	// Shared work is not done for one user.
+	h = make(http.Header)
+	trace.SetHeader(trace.Shared(ctx), h)
+	if got := h.Get(trace.UserIDHeader); got != "" {
+		t.Errorf("Got user id `%s` for shared context, expected none", got)
+	}
+	if got := h.Get(trace.RequestIDHeader); got != "my-request" {
+		t.Errorf("Got request id `%s` for shared context, expected `my-request`", got)
+	}
+}
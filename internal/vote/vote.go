@@ -0,0 +1,383 @@
+// Package vote reads the live results of polls from the vote service and
+// provides them as keys of the autoupdate service.
+//
+// The vote service sends a stream of json objects, one per line. Each object
+// contains the fqid of a poll and the live fields of the poll:
+//
+//	{"fqid": "motion_poll/5", "data": {"live_votes_count": 12}}
+//
+// The fields are provided as keys like `motion_poll/5/live_votes_count`. Only
+// motion and assignment polls can have live fields. A null value of data
+// removes all live fields of the poll. Invalid messages are skipped.
+//
+// Each new connection to the vote service starts without live fields. So after
+// a reconnect, the vote service has to send the current state of all running
+// polls.
+//
+// The keys are restricted like all other keys by the restricter.
+package vote
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const streamPath = "/internal/vote/live"
+
+// maxLineSize is the maximum size of one message from the vote service.
+const maxLineSize = 1 << 20
+
+// pollCollections are the collections, that can have live fields.
+var pollCollections = map[string]bool{
+	"motion_poll":     true,
+	"assignment_poll": true,
+}
+
+// Datastore gets values for keys and informs, if they change.
+type Datastore interface {
+	Get(ctx context.Context, keys ...string) ([]json.RawMessage, error)
+	RegisterChangeListener(f func(map[string]json.RawMessage) error)
+}
+
+// historyReader is implemented by datastores that can read older versions of
+// an object.
+type historyReader interface {
+	HistoryPositions(ctx context.Context, fqid string) ([]int, error)
+	GetPosition(ctx context.Context, position int, fqid string) (map[string]json.RawMessage, error)
+}
+
+// positionReader is implemented by datastores that can read keys at an older
+// position.
+type positionReader interface {
+	GetAt(ctx context.Context, position int, keys ...string) ([]json.RawMessage, error)
+}
+
+// historyInformer is implemented by datastores that can tell, when and by whom
+// an object was changed.
+type historyInformer interface {
+	HistoryInformation(ctx context.Context, fqid string, f func(position int, timestamp int64, userID int)) error
+}
+
+// updateIndicator is implemented by datastores that can tell, if they have
+// changed values, that the change listeners did not get yet.
+type updateIndicator interface {
+	Updating() bool
+}
+
+// Vote provides the live results of the vote service. It wraps a datastore
+// and adds the live keys to it.
+//
+// Has to be created with vote.New().
+type Vote struct {
+	Datastore
+	url string
+
+	mu              sync.RWMutex
+	live            map[string]map[string]json.RawMessage
+	changeListeners []func(map[string]json.RawMessage) error
+
+	// updating is the number of changes of the live fields, that are not
+	// given to the change listeners yet.
+	updating atomic.Int32
+}
+
+// New creates a Vote and starts to read the stream from the vote service at
+// url. Errors are given to the errHandler. After an error, the stream is
+// opened again after one second.
+func New(url string, ds Datastore, closed <-chan struct{}, errHandler func(error)) *Vote {
+	v := &Vote{
+		Datastore: ds,
+		url:       url + streamPath,
+		live:      make(map[string]map[string]json.RawMessage),
+	}
+
+	go v.listen(closed, errHandler)
+	return v
+}
+
+// Get returns the values for the keys. The live keys are read from the vote
+// results. All other keys from the wrapped datastore.
+func (v *Vote) Get(ctx context.Context, keys ...string) ([]json.RawMessage, error) {
+	values := make([]json.RawMessage, len(keys))
+	var other []string
+	var otherIdx []int
+
+	v.mu.RLock()
+	for i, key := range keys {
+		if !isLiveKey(key) {
+			other = append(other, key)
+			otherIdx = append(otherIdx, i)
+			continue
+		}
+
+		fqid, field := splitKey(key)
+		values[i] = v.live[fqid][field]
+	}
+	v.mu.RUnlock()
+
+	if len(other) == 0 {
+		return values, nil
+	}
+
+	// The inner datastore can return values together with an error, if some
+	// keys could not be read in time. The error is returned with the values.
+	otherValues, err := v.Datastore.Get(ctx, other...)
+	if otherValues == nil {
+		return nil, err
+	}
+	for i, idx := range otherIdx {
+		values[idx] = otherValues[i]
+	}
+	return values, err
+}
+
+// RegisterChangeListener registers a function that gets the changed data of
+// the wrapped datastore and the changed live keys.
+func (v *Vote) RegisterChangeListener(f func(map[string]json.RawMessage) error) {
+	v.mu.Lock()
+	v.changeListeners = append(v.changeListeners, f)
+	v.mu.Unlock()
+
+	v.Datastore.RegisterChangeListener(f)
+}
+
+// HistoryPositions returns the positions of an object, if the wrapped
+// datastore supports the history.
+func (v *Vote) HistoryPositions(ctx context.Context, fqid string) ([]int, error) {
+	reader, ok := v.Datastore.(historyReader)
+	if !ok {
+		return nil, fmt.Errorf("datastore does not support the history")
+	}
+	return reader.HistoryPositions(ctx, fqid)
+}
+
+// GetPosition returns an object at a position, if the wrapped datastore
+// supports the history. Live fields are not part of the history.
+func (v *Vote) GetPosition(ctx context.Context, position int, fqid string) (map[string]json.RawMessage, error) {
+	reader, ok := v.Datastore.(historyReader)
+	if !ok {
+		return nil, fmt.Errorf("datastore does not support the history")
+	}
+	return reader.GetPosition(ctx, position, fqid)
+}
+
+// GetAt returns the values of keys at a position, if the wrapped datastore
+// supports it. Live fields are not part of the history.
+func (v *Vote) GetAt(ctx context.Context, position int, keys ...string) ([]json.RawMessage, error) {
+	reader, ok := v.Datastore.(positionReader)
+	if !ok {
+		return nil, fmt.Errorf("datastore does not support reading at a position")
+	}
+	return reader.GetAt(ctx, position, keys...)
+}
+
+// HistoryInformation calls f for each position of an object, if the wrapped
+// datastore supports it.
+func (v *Vote) HistoryInformation(ctx context.Context, fqid string, f func(position int, timestamp int64, userID int)) error {
+	informer, ok := v.Datastore.(historyInformer)
+	if !ok {
+		return fmt.Errorf("datastore does not support the history information")
+	}
+	return informer.HistoryInformation(ctx, fqid, f)
+}
+
+// Updating tells, if there are changed live fields or changed values of the
+// wrapped datastore, that the change listeners did not get yet.
+func (v *Vote) Updating() bool {
+	if v.updating.Load() > 0 {
+		return true
+	}
+
+	indicator, ok := v.Datastore.(updateIndicator)
+	return ok && indicator.Updating()
+}
+
+// listen reads the stream of the vote service. Blocks until the service is
+// closed.
+func (v *Vote) listen(closed <-chan struct{}, errHandler func(error)) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-closed
+		cancel()
+	}()
+
+	for {
+		err := v.stream(ctx, errHandler)
+
+		select {
+		case <-closed:
+			return
+		default:
+		}
+
+		if err != nil {
+			errHandler(fmt.Errorf("reading vote stream: %w", err))
+		}
+
+		select {
+		case <-time.After(time.Second):
+		case <-closed:
+			return
+		}
+	}
+}
+
+// stream opens one connection to the vote service and processes its messages
+// until the connection is closed.
+//
+// The live fields of the previous connection are removed, when the new
+// connection is open. Invalid messages are given to the errHandler and
+// skipped.
+func (v *Vote) stream(ctx context.Context, errHandler func(error)) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, v.url, nil)
+	if err != nil {
+		return fmt.Errorf("creating request: %w", err)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("sending request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("vote service returned status %s", resp.Status)
+	}
+
+	if err := v.reset(); err != nil {
+		errHandler(fmt.Errorf("removing old live fields: %w", err))
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 0, 4096), maxLineSize)
+	for scanner.Scan() {
+		if len(strings.TrimSpace(scanner.Text())) == 0 {
+			continue
+		}
+
+		if err := v.process(scanner.Bytes()); err != nil {
+			errHandler(fmt.Errorf("processing message from vote service: %w", err))
+		}
+	}
+	return scanner.Err()
+}
+
+// reset removes all live fields and informs the listeners, that they are
+// removed.
+func (v *Vote) reset() error {
+	v.updating.Add(1)
+	defer v.updating.Add(-1)
+
+	v.mu.Lock()
+	changed := make(map[string]json.RawMessage)
+	for fqid, data := range v.live {
+		for field := range data {
+			changed[fqid+"/"+field] = nil
+		}
+	}
+	v.live = make(map[string]map[string]json.RawMessage)
+	listeners := v.changeListeners
+	v.mu.Unlock()
+
+	if len(changed) == 0 {
+		return nil
+	}
+	return inform(listeners, changed)
+}
+
+// process saves one message of the vote service and informs the listeners.
+func (v *Vote) process(line []byte) error {
+	var msg struct {
+		FQID string                     `json:"fqid"`
+		Data map[string]json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(line, &msg); err != nil {
+		return fmt.Errorf("decoding message: %w", err)
+	}
+
+	collection, _, found := strings.Cut(msg.FQID, "/")
+	if !found || strings.Contains(msg.FQID[len(collection)+1:], "/") {
+		return fmt.Errorf("invalid fqid `%s`", msg.FQID)
+	}
+
+	if !pollCollections[collection] {
+		return fmt.Errorf("invalid fqid `%s`, only polls can have live fields", msg.FQID)
+	}
+
+	for field := range msg.Data {
+		if !isLiveField(field) {
+			return fmt.Errorf("invalid field `%s`, live fields have to start with `live_`", field)
+		}
+	}
+
+	// A field with the value null does not exist, like in the datastore.
+	data := make(map[string]json.RawMessage, len(msg.Data))
+	for field, value := range msg.Data {
+		if string(value) != "null" {
+			data[field] = value
+		}
+	}
+
+	v.updating.Add(1)
+	defer v.updating.Add(-1)
+
+	v.mu.Lock()
+	changed := make(map[string]json.RawMessage)
+	for field := range v.live[msg.FQID] {
+		if _, ok := data[field]; !ok {
+			// Removed field.
+			changed[msg.FQID+"/"+field] = nil
+		}
+	}
+	for field, value := range data {
+		changed[msg.FQID+"/"+field] = value
+	}
+
+	if len(data) == 0 {
+		delete(v.live, msg.FQID)
+	} else {
+		v.live[msg.FQID] = data
+	}
+	listeners := v.changeListeners
+	v.mu.Unlock()
+
+	return inform(listeners, changed)
+}
+
+// inform calls each listener with the changed keys.
+func inform(listeners []func(map[string]json.RawMessage) error, changed map[string]json.RawMessage) error {
+	for _, f := range listeners {
+		if err := f(changed); err != nil {
+			return fmt.Errorf("inform listener: %w", err)
+		}
+	}
+	return nil
+}
+
+// isLiveKey tells, if the key is a live field of a poll.
+func isLiveKey(key string) bool {
+	fqid, field := splitKey(key)
+	collection, _, _ := strings.Cut(fqid, "/")
+	return pollCollections[collection] && isLiveField(field)
+}
+
+func isLiveField(field string) bool {
+	return strings.HasPrefix(field, "live_")
+}
+
+// splitKey splits a key into the fqid and the field.
+func splitKey(key string) (string, string) {
+	i := strings.LastIndexByte(key, '/')
+	if i == -1 {
+		return key, ""
+	}
+	return key[:i], key[i+1:]
+}
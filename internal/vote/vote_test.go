@@ -0,0 +1,274 @@
+package vote_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/openslides/openslides-autoupdate-service/internal/test"
+	"github.com/openslides/openslides-autoupdate-service/internal/vote"
+)
+
+func TestVote(t *testing.T) {
+	messages := make(chan string)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/internal/vote/live" {
+			http.NotFound(w, r)
+			return
+		}
+
+		for {
+			select {
+			case msg := <-messages:
+				fmt.Fprintln(w, msg)
+				w.(http.Flusher).Flush()
+			case <-r.Context().Done():
+				return
+			}
+		}
+	}))
+	defer ts.Close()
+
+	closed := make(chan struct{})
+	defer close(closed)
+
+	ds := test.NewMockDatastore()
+	v := vote.New(ts.URL, ds, closed, func(err error) { t.Errorf("Got error: %v", err) })
+
+	changes := make(chan map[string]json.RawMessage, 1)
+	v.RegisterChangeListener(func(data map[string]json.RawMessage) error {
+		changes <- data
+		return nil
+	})
+
+	receive := func() map[string]json.RawMessage {
+		select {
+		case data := <-changes:
+			return data
+		case <-time.After(time.Second):
+			t.Fatalf("Did not receive a change")
+		}
+		return nil
+	}
+
+	messages <- `{"fqid":"motion_poll/5","data":{"live_votes_count":12,"live_yes":7}}`
+	changed := receive()
+	if len(changed) != 2 || string(changed["motion_poll/5/live_votes_count"]) != "12" {
+		t.Errorf("Got change %v, expected the two live fields", changed)
+	}
+
+	values, err := v.Get(context.Background(), "motion_poll/5/live_votes_count", "motion_poll/5/title", "motion_poll/6/live_votes_count")
+	if err != nil {
+		t.Fatalf("Get returned unexpected error: %v", err)
+	}
+	if got := string(values[0]); got != "12" {
+		t.Errorf("Got live value %s, expected 12", got)
+	}
+	if got := string(values[1]); got != `"Hello World"` {
+		t.Errorf("Got value %s from the datastore, expected the default value of the mock", got)
+	}
+	if values[2] != nil {
+		t.Errorf("Got value %s for unknown poll, expected nil", values[2])
+	}
+
+	messages <- `{"fqid":"motion_poll/5","data":null}`
+	changed = receive()
+	if v, ok := changed["motion_poll/5/live_yes"]; !ok || v != nil {
+		t.Errorf("Got change %v, expected removed live fields", changed)
+	}
+
+	values, err = v.Get(context.Background(), "motion_poll/5/live_votes_count")
+	if err != nil {
+		t.Fatalf("Get returned unexpected error: %v", err)
+	}
+	if values[0] != nil {
+		t.Errorf("Got value %s after removal, expected nil", values[0])
+	}
+}
+
+func TestVoteInvalidField(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, `{"fqid":"motion_poll/5","data":{"title":"hacked"}}`)
+	}))
+	defer ts.Close()
+
+	closed := make(chan struct{})
+	defer close(closed)
+
+	errs := make(chan error, 1)
+	v := vote.New(ts.URL, test.NewMockDatastore(), closed, func(err error) {
+		select {
+		case errs <- err:
+		default:
+		}
+	})
+
+	select {
+	case <-errs:
+	case <-time.After(time.Second):
+		t.Fatalf("Expected an error for a field without the live prefix")
+	}
+
+	values, err := v.Get(context.Background(), "motion_poll/5/title")
+	if err != nil {
+		t.Fatalf("Get returned unexpected error: %v", err)
+	}
+	if got := string(values[0]); got != `"Hello World"` {
+		t.Errorf("Got %s, expected the value from the datastore", got)
+	}
+}
+
+func TestVoteSkipInvalidMessages(t *testing.T) {
+	start := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-start
+		fmt.Fprintln(w, `{"fqid":"motion_poll/5","data":{"title":"hacked"}}`)
+		fmt.Fprintln(w, `{"fqid":"user/1","data":{"live_name":"hacked"}}`)
+		fmt.Fprintln(w, `no json`)
+		fmt.Fprintln(w, `{"fqid":"motion_poll/5","data":{"live_votes_count":12,"live_yes":null}}`)
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+	defer ts.Close()
+
+	closed := make(chan struct{})
+	defer close(closed)
+
+	errs := make(chan error, 3)
+	v := vote.New(ts.URL, test.NewMockDatastore(), closed, func(err error) { errs <- err })
+
+	changes := make(chan map[string]json.RawMessage, 1)
+	v.RegisterChangeListener(func(data map[string]json.RawMessage) error {
+		changes <- data
+		return nil
+	})
+	close(start)
+
+	var changed map[string]json.RawMessage
+	select {
+	case changed = <-changes:
+	case <-time.After(time.Second):
+		t.Fatalf("Did not receive the valid message after the invalid ones")
+	}
+
+	if len(errs) != 3 {
+		t.Errorf("Got %d errors, expected 3", len(errs))
+	}
+
+	if len(changed) != 1 || string(changed["motion_poll/5/live_votes_count"]) != "12" {
+		t.Errorf("Got change %v, expected only live_votes_count", changed)
+	}
+
+	values, err := v.Get(context.Background(), "motion_poll/5/live_yes", "user/1/live_name")
+	if err != nil {
+		t.Fatalf("Get returned unexpected error: %v", err)
+	}
+	if values[0] != nil {
+		t.Errorf("Got value %s for a null field, expected nil", values[0])
+	}
+	if got := string(values[1]); got != `"Hello World"` {
+		t.Errorf("Got %s for a field of a user, expected the value from the datastore", got)
+	}
+}
+
+func TestVoteReconnect(t *testing.T) {
+	start := make(chan struct{})
+	var connections int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-start
+		connections++
+		if connections == 1 {
+			fmt.Fprintln(w, `{"fqid":"motion_poll/5","data":{"live_votes_count":12}}`)
+			return
+		}
+
+		fmt.Fprintln(w, `{"fqid":"motion_poll/6","data":{"live_votes_count":3}}`)
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+	defer ts.Close()
+
+	closed := make(chan struct{})
+	defer close(closed)
+
+	v := vote.New(ts.URL, test.NewMockDatastore(), closed, func(error) {})
+
+	changes := make(chan map[string]json.RawMessage, 3)
+	v.RegisterChangeListener(func(data map[string]json.RawMessage) error {
+		changes <- data
+		return nil
+	})
+	close(start)
+
+	receive := func() map[string]json.RawMessage {
+		select {
+		case data := <-changes:
+			return data
+		case <-time.After(2 * time.Second):
+			t.Fatalf("Did not receive a change")
+		}
+		return nil
+	}
+
+	receive()
+	changed := receive()
+	if v, ok := changed["motion_poll/5/live_votes_count"]; len(changed) != 1 || !ok || v != nil {
+		t.Errorf("Got change %v after reconnect, expected the removed live field", changed)
+	}
+
+	changed = receive()
+	if string(changed["motion_poll/6/live_votes_count"]) != "3" {
+		t.Errorf("Got change %v, expected the live field of the new connection", changed)
+	}
+
+	values, err := v.Get(context.Background(), "motion_poll/5/live_votes_count")
+	if err != nil {
+		t.Fatalf("Get returned unexpected error: %v", err)
+	}
+	if values[0] != nil {
+		t.Errorf("Got value %s from the old connection, expected nil", values[0])
+	}
+}
+
+func TestVoteUpdating(t *testing.T) {
+	start := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-start
+		fmt.Fprintln(w, `{"fqid":"motion_poll/5","data":{"live_votes_count":12}}`)
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+	defer ts.Close()
+
+	closed := make(chan struct{})
+	defer close(closed)
+
+	v := vote.New(ts.URL, test.NewMockDatastore(), closed, func(err error) {})
+
+	updating := make(chan bool, 1)
+	v.RegisterChangeListener(func(data map[string]json.RawMessage) error {
+		updating <- v.Updating()
+		return nil
+	})
+	close(start)
+
+	select {
+	case got := <-updating:
+		if !got {
+			t.Errorf("Updating() returned false while the listeners were called")
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Did not receive a change")
+	}
+
+	for i := 0; v.Updating(); i++ {
+		if i > 100 {
+			t.Fatalf("Updating() still returns true after the listeners were called")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
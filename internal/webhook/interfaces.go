@@ -0,0 +1,12 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+)
+
+// DataProvider returns restricted data for a user. It is implemented by
+// autoupdate.Autoupdate.
+type DataProvider interface {
+	RestrictedData(ctx context.Context, uid int, keys ...string) (map[string]json.RawMessage, error)
+}
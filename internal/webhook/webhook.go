@@ -0,0 +1,336 @@
+// Package webhook sends the restricted values of changed keys to configured
+// urls.
+//
+// Each hook has a list of key patterns. A pattern is a key where each part can
+// be replaced with `*`, for example `motion/*/state_id`. When keys change that
+// match one of the patterns, the values are restricted for the user of the
+// hook and sent as json object with a POST request to the url of the hook.
+//
+// Each hook has one queue of changed keys and sends one request at a time. Keys
+// that change again before they are sent are only sent once with the newest
+// value.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SignatureHeader is the http header that contains the signature of the body.
+// The signature is `sha256=` followed by the hex encoded HMAC-SHA256 of the
+// body with the secret of the hook.
+const SignatureHeader = "X-Autoupdate-Signature"
+
+// DeliveryHeader is the http header that contains the id of a delivery. A
+// retry of a request has the same id, so the receiver can ignore duplicates.
+// The id is unique for each running instance of the service.
+const DeliveryHeader = "X-Autoupdate-Delivery"
+
+// maxQueuedKeys is the maximum number of keys, that can wait to be sent to one
+// hook. More keys are dropped.
+const maxQueuedKeys = 10_000
+
+// Hook is the configuration of one webhook.
+type Hook struct {
+	URL    string   `json:"url"`
+	Keys   []string `json:"keys"`
+	UserID int      `json:"user_id"`
+	Secret string   `json:"secret"`
+}
+
+// LoadHooks reads the hooks from a json list.
+func LoadHooks(r io.Reader) ([]Hook, error) {
+	var hooks []Hook
+	if err := json.NewDecoder(r).Decode(&hooks); err != nil {
+		return nil, fmt.Errorf("decoding hooks: %w", err)
+	}
+
+	for i, h := range hooks {
+		if h.URL == "" {
+			return nil, fmt.Errorf("hook %d has no url", i)
+		}
+		if len(h.Keys) == 0 {
+			return nil, fmt.Errorf("hook %d has no keys", i)
+		}
+	}
+	return hooks, nil
+}
+
+// Webhook sends the changes to the hooks.
+//
+// Has to be created with webhook.New().
+type Webhook struct {
+	queues     []*queue
+	provider   DataProvider
+	closed     <-chan struct{}
+	errHandler func(error)
+	instance   string
+
+	client    *http.Client
+	retries   int
+	retryWait time.Duration
+}
+
+// queue contains the changed keys of one hook, that are not sent yet.
+type queue struct {
+	hook Hook
+
+	mu   sync.Mutex
+	keys map[string]bool
+
+	// wake gets a value, when keys are added to the queue.
+	wake chan struct{}
+
+	// deliveries is the number of sent deliveries. It is only used by the
+	// worker of the queue.
+	deliveries uint64
+}
+
+// add puts the keys into the queue. Keys that are already in the queue are not
+// added again. Returns the number of keys, that were dropped, because the queue
+// is full.
+func (q *queue) add(keys []string) int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var dropped int
+	for _, key := range keys {
+		if q.keys[key] {
+			continue
+		}
+
+		if len(q.keys) >= maxQueuedKeys {
+			dropped++
+			continue
+		}
+		q.keys[key] = true
+	}
+
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+	return dropped
+}
+
+// take removes all keys from the queue and returns them sorted.
+func (q *queue) take() []string {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	keys := make([]string, 0, len(q.keys))
+	for key := range q.keys {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	q.keys = make(map[string]bool)
+	return keys
+}
+
+// Option is an optional argument for webhook.New().
+type Option func(*Webhook)
+
+// WithRetries sets how often a failed request is repeated and how long to wait
+// before the first retry. The wait time is doubled for each retry. The default
+// is 3 retries and one second.
+func WithRetries(retries int, wait time.Duration) Option {
+	return func(w *Webhook) {
+		w.retries = retries
+		w.retryWait = wait
+	}
+}
+
+// New creates a new Webhook and starts one worker for each hook. The workers
+// run until closed is closed. The errHandler is called for each request, that
+// failed after all retries.
+func New(hooks []Hook, provider DataProvider, closed <-chan struct{}, errHandler func(error), options ...Option) *Webhook {
+	w := &Webhook{
+		provider:   provider,
+		closed:     closed,
+		errHandler: errHandler,
+		instance:   instanceID(),
+		client:     &http.Client{Timeout: 10 * time.Second},
+		retries:    3,
+		retryWait:  time.Second,
+	}
+
+	for _, o := range options {
+		o(w)
+	}
+
+	for _, hook := range hooks {
+		q := &queue{
+			hook: hook,
+			keys: make(map[string]bool),
+			wake: make(chan struct{}, 1),
+		}
+		w.queues = append(w.queues, q)
+		go w.work(q)
+	}
+	return w
+}
+
+// OnChange is a change listener for the datastore. It puts the changed keys
+// into the queues of the matching hooks. They are sent in the background.
+//
+// Returns an error, if keys were dropped, because a queue is full.
+func (w *Webhook) OnChange(data map[string]json.RawMessage) error {
+	var errs []error
+	for _, q := range w.queues {
+		var keys []string
+		for key := range data {
+			if q.hook.matches(key) {
+				keys = append(keys, key)
+			}
+		}
+
+		if len(keys) == 0 {
+			continue
+		}
+
+		if dropped := q.add(keys); dropped > 0 {
+			errs = append(errs, fmt.Errorf("webhook %s: queue is full, dropped %d keys", q.hook.URL, dropped))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// work sends the keys of the queue until the service is closed.
+func (w *Webhook) work(q *queue) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-w.closed
+		cancel()
+	}()
+
+	for {
+		select {
+		case <-q.wake:
+		case <-ctx.Done():
+			return
+		}
+
+		keys := q.take()
+		if len(keys) == 0 {
+			continue
+		}
+
+		q.deliveries++
+		delivery := fmt.Sprintf("%s-%d", w.instance, q.deliveries)
+		if err := w.send(ctx, q.hook, keys, delivery); err != nil {
+			if ctx.Err() != nil {
+				// Requests are canceled on shutdown.
+				return
+			}
+			w.errHandler(fmt.Errorf("webhook %s: %w", q.hook.URL, err))
+		}
+	}
+}
+
+// send restricts the keys for the user of the hook and sends them.
+func (w *Webhook) send(ctx context.Context, hook Hook, keys []string, delivery string) error {
+	data, err := w.provider.RestrictedData(ctx, hook.UserID, keys...)
+	if err != nil {
+		return fmt.Errorf("restrict data: %w", err)
+	}
+
+	body, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("encoding data: %w", err)
+	}
+
+	wait := w.retryWait
+	for attempt := 0; ; attempt++ {
+		err = w.post(ctx, hook, body, delivery)
+		if err == nil || attempt >= w.retries {
+			return err
+		}
+
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return fmt.Errorf("service closed before the retry: %w", err)
+		}
+		wait *= 2
+	}
+}
+
+// post sends the body to the url of the hook.
+func (w *Webhook) post(ctx context.Context, hook Hook, body []byte, delivery string) error {
+	req, err := http.NewRequestWithContext(ctx, "POST", hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("creating request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(DeliveryHeader, delivery)
+	if hook.Secret != "" {
+		req.Header.Set(SignatureHeader, Sign(hook.Secret, body))
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("sending request: %w", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("got status %s", resp.Status)
+	}
+	return nil
+}
+
+// instanceID returns a random id, that makes the delivery ids of this instance
+// unique.
+func instanceID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Sign returns the signature of the body.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// matches tells, if the key matches one of the patterns of the hook.
+func (h Hook) matches(key string) bool {
+	for _, pattern := range h.Keys {
+		if matchPattern(pattern, key) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchPattern tells, if the key matches the pattern. Each part of the pattern
+// is compared with the part of the key. A `*` matches every part.
+func matchPattern(pattern, key string) bool {
+	pParts := strings.Split(pattern, "/")
+	kParts := strings.Split(key, "/")
+	if len(pParts) != len(kParts) {
+		return false
+	}
+
+	for i := range pParts {
+		if pParts[i] != "*" && pParts[i] != kParts[i] {
+			return false
+		}
+	}
+	return true
+}
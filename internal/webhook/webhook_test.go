@@ -0,0 +1,240 @@
+package webhook_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/openslides/openslides-autoupdate-service/internal/webhook"
+)
+
+func TestWebhook(t *testing.T) {
+	received := make(chan *http.Request, 1)
+	bodies := make(chan []byte, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		received <- r
+		bodies <- body
+	}))
+	defer ts.Close()
+
+	hooks := []webhook.Hook{{URL: ts.URL, Keys: []string{"motion/*/state_id"}, UserID: 5, Secret: "secret"}}
+	provider := &mockProvider{}
+	closed := make(chan struct{})
+	defer close(closed)
+	wh := webhook.New(hooks, provider, closed, func(err error) { t.Errorf("Got error: %v", err) })
+
+	wh.OnChange(map[string]json.RawMessage{
+		"motion/1/state_id": []byte("2"),
+		"motion/1/title":    []byte(`"title"`),
+	})
+
+	var r *http.Request
+	var body []byte
+	select {
+	case r = <-received:
+		body = <-bodies
+	case <-time.After(time.Second):
+		t.Fatalf("Webhook was not called")
+	}
+
+	if got := string(body); got != `{"motion/1/state_id":"restricted"}` {
+		t.Errorf("Got body %s, expected only the restricted state_id", got)
+	}
+	if got := r.Header.Get(webhook.SignatureHeader); got != webhook.Sign("secret", body) {
+		t.Errorf("Got signature %s, expected %s", got, webhook.Sign("secret", body))
+	}
+	if uid := provider.lastUID(); uid != 5 {
+		t.Errorf("Data was restricted for user %d, expected 5", uid)
+	}
+}
+
+func TestWebhookRetry(t *testing.T) {
+	var mu sync.Mutex
+	var calls int
+	done := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		calls++
+		if calls < 3 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		close(done)
+	}))
+	defer ts.Close()
+
+	hooks := []webhook.Hook{{URL: ts.URL, Keys: []string{"motion/1/title"}}}
+	closed := make(chan struct{})
+	defer close(closed)
+	wh := webhook.New(hooks, &mockProvider{}, closed, func(err error) { t.Errorf("Got error: %v", err) }, webhook.WithRetries(3, time.Millisecond))
+
+	wh.OnChange(map[string]json.RawMessage{"motion/1/title": []byte(`"title"`)})
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("Webhook was not called three times")
+	}
+}
+
+func TestWebhookQueue(t *testing.T) {
+	release := make(chan struct{})
+	type request struct {
+		delivery string
+		body     string
+	}
+	requests := make(chan request, 10)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		requests <- request{delivery: r.Header.Get(webhook.DeliveryHeader), body: string(body)}
+		<-release
+	}))
+	defer ts.Close()
+
+	hooks := []webhook.Hook{{URL: ts.URL, Keys: []string{"motion/*/title"}}}
+	closed := make(chan struct{})
+	defer close(closed)
+	wh := webhook.New(hooks, &mockProvider{}, closed, func(err error) { t.Errorf("Got error: %v", err) })
+
+	receive := func() request {
+		select {
+		case r := <-requests:
+			return r
+		case <-time.After(time.Second):
+			t.Fatalf("Webhook was not called")
+		}
+		return request{}
+	}
+
+	wh.OnChange(map[string]json.RawMessage{"motion/1/title": []byte(`"first"`)})
+	first := receive()
+
+	// The first request is still running. The following changes are sent
+	// together afterwards.
+	wh.OnChange(map[string]json.RawMessage{"motion/2/title": []byte(`"second"`)})
+	wh.OnChange(map[string]json.RawMessage{"motion/2/title": []byte(`"third"`), "motion/3/title": []byte(`"other"`)})
+
+	keys := make(map[string]json.RawMessage)
+	for i := 0; i < 10_000; i++ {
+		keys[fmt.Sprintf("motion/%d/title", i+10)] = []byte(`"many"`)
+	}
+	if err := wh.OnChange(keys); err == nil || !strings.Contains(err.Error(), "dropped 2 keys") {
+		t.Errorf("Got error %v, expected two dropped keys", err)
+	}
+
+	close(release)
+	second := receive()
+
+	var data map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(second.body), &data); err != nil {
+		t.Fatalf("Invalid body: %v", err)
+	}
+	if len(data) != 10_000 || data["motion/2/title"] == nil || data["motion/3/title"] == nil {
+		t.Errorf("Got %d keys in the second request, expected the 10000 queued keys", len(data))
+	}
+
+	select {
+	case r := <-requests:
+		t.Errorf("Got a third request with %d bytes", len(r.body))
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	if first.delivery == "" || first.delivery == second.delivery {
+		t.Errorf("Got delivery ids %q and %q, expected two different ids", first.delivery, second.delivery)
+	}
+}
+
+func TestWebhookRetrySameDelivery(t *testing.T) {
+	var mu sync.Mutex
+	var calls int
+	deliveries := make(chan string, 2)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		calls++
+		deliveries <- r.Header.Get(webhook.DeliveryHeader)
+		if calls == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer ts.Close()
+
+	hooks := []webhook.Hook{{URL: ts.URL, Keys: []string{"motion/1/title"}}}
+	closed := make(chan struct{})
+	defer close(closed)
+	wh := webhook.New(hooks, &mockProvider{}, closed, func(err error) { t.Errorf("Got error: %v", err) }, webhook.WithRetries(1, time.Millisecond))
+
+	wh.OnChange(map[string]json.RawMessage{"motion/1/title": []byte(`"title"`)})
+
+	var got []string
+	for i := 0; i < 2; i++ {
+		select {
+		case d := <-deliveries:
+			got = append(got, d)
+		case <-time.After(time.Second):
+			t.Fatalf("Webhook was not called twice")
+		}
+	}
+
+	if got[0] == "" || got[0] != got[1] {
+		t.Errorf("Got delivery ids %v, expected the same id for the retry", got)
+	}
+}
+
+func TestLoadHooks(t *testing.T) {
+	for _, tt := range []struct {
+		name  string
+		input string
+		err   bool
+	}{
+		{"valid", `[{"url": "http://example.com", "keys": ["motion/*/state_id"], "user_id": 1}]`, false},
+		{"no url", `[{"keys": ["motion/*/state_id"]}]`, true},
+		{"no keys", `[{"url": "http://example.com"}]`, true},
+		{"invalid json", `{`, true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := webhook.LoadHooks(strings.NewReader(tt.input))
+			if (err != nil) != tt.err {
+				t.Errorf("Got error %v, expected error: %t", err, tt.err)
+			}
+		})
+	}
+}
+
+// mockProvider replaces each value with "restricted".
+type mockProvider struct {
+	mu  sync.Mutex
+	uid int
+}
+
+func (p *mockProvider) RestrictedData(ctx context.Context, uid int, keys ...string) (map[string]json.RawMessage, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.uid = uid
+
+	if len(keys) == 0 {
+		return nil, errors.New("no keys")
+	}
+
+	data := make(map[string]json.RawMessage, len(keys))
+	for _, key := range keys {
+		data[key] = []byte(`"restricted"`)
+	}
+	return data, nil
+}
+
+func (p *mockProvider) lastUID() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.uid
+}
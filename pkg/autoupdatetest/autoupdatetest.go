@@ -0,0 +1,198 @@
+// Package autoupdatetest starts the full autoupdate service inside a test
+// process. It can be used to write end-to-end tests against the real protocol.
+//
+// The service uses a fake datastore and a fake auth service. The user id of a
+// request is read from the header UserHeader.
+//
+//	srv := autoupdatetest.NewServer(autoupdatetest.WithData(data))
+//	defer srv.Close()
+//
+//	client, err := srv.Connect(ctx, 1, `[{"ids":[1],"collection":"user","fields":{"name":null}}]`)
+//	first, err := client.Next()
+//
+//	srv.Update(map[string]json.RawMessage{"user/1/name": []byte(`"new"`)})
+//	update, err := client.Next()
+package autoupdatetest
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+
+	"github.com/openslides/openslides-autoupdate-service/internal/autoupdate"
+	"github.com/openslides/openslides-autoupdate-service/internal/datastore"
+	ahttp "github.com/openslides/openslides-autoupdate-service/internal/http"
+	"github.com/openslides/openslides-autoupdate-service/internal/test"
+)
+
+// UserHeader is the http header that contains the user id of a request. If
+// the header is missing, the user is anonymous.
+const UserHeader = "X-Autoupdatetest-User"
+
+// Server is an autoupdate service running in the test process.
+//
+// Has to be created with NewServer().
+type Server struct {
+	// TS is the http server of the autoupdate service. It uses TLS and
+	// HTTP/2.
+	TS *httptest.Server
+
+	datastore *test.DatastoreServer
+	updates   chan map[string]json.RawMessage
+	closed    chan struct{}
+}
+
+// Option is an option for NewServer.
+type Option func(*Server)
+
+// WithData sets the initial data of the fake datastore.
+func WithData(data map[string]json.RawMessage) Option {
+	return func(s *Server) {
+		s.datastore.Update(data)
+	}
+}
+
+// WithDefaultValues lets the fake datastore return default values for keys
+// that are not in the data. For example, keys ending with _id get the value
+// 1.
+func WithDefaultValues() Option {
+	return func(s *Server) {
+		s.datastore.OnlyData = false
+	}
+}
+
+// NewServer starts the autoupdate service. Close has to be called after the
+// test.
+func NewServer(options ...Option) *Server {
+	s := &Server{
+		datastore: test.NewDatastoreServer(),
+		updates:   make(chan map[string]json.RawMessage),
+		closed:    make(chan struct{}),
+	}
+	s.datastore.OnlyData = true
+
+	for _, o := range options {
+		o(s)
+	}
+
+	ds := datastore.New(s.datastore.TS.URL, s.closed, func(error) {}, updater{s})
+	service := autoupdate.New(ds, new(test.MockRestricter), s.closed)
+
+	s.TS = httptest.NewUnstartedServer(ahttp.New(service, headerAuth{}))
+	s.TS.EnableHTTP2 = true
+	s.TS.StartTLS()
+	return s
+}
+
+// Close stops the service and closes all connections.
+func (s *Server) Close() {
+	close(s.closed)
+	s.TS.Close()
+	s.datastore.TS.Close()
+}
+
+// SetData changes values in the fake datastore without informing the
+// service.
+func (s *Server) SetData(data map[string]json.RawMessage) {
+	s.datastore.Update(data)
+}
+
+// Update changes values in the fake datastore and informs the service like
+// the message bus would. A nil value deletes a key.
+//
+// Blocks until the service received the update.
+func (s *Server) Update(data map[string]json.RawMessage) {
+	s.datastore.Update(data)
+	s.updates <- data
+}
+
+// Connect opens a connection for the user with the given id and the given
+// keysrequest. If the service returns an error, it is returned.
+func (s *Server) Connect(ctx context.Context, uid int, request string) (*Client, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", s.TS.URL+"/system/autoupdate", strings.NewReader(request))
+	if err != nil {
+		return nil, fmt.Errorf("create request: %w", err)
+	}
+	if uid != 0 {
+		req.Header.Set(UserHeader, strconv.Itoa(uid))
+	}
+
+	resp, err := s.TS.Client().Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("send request: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		body, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return nil, fmt.Errorf("service returned %s", resp.Status)
+		}
+		return nil, fmt.Errorf("service returned %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	return &Client{resp: resp, r: bufio.NewReader(resp.Body)}, nil
+}
+
+// Client is one connection to the service.
+type Client struct {
+	resp *http.Response
+	r    *bufio.Reader
+}
+
+// Next blocks until the service sends the next message and returns its data.
+func (c *Client) Next() (map[string]json.RawMessage, error) {
+	line, err := c.r.ReadBytes('\n')
+	if err != nil {
+		return nil, fmt.Errorf("reading message: %w", err)
+	}
+
+	var data map[string]json.RawMessage
+	if err := json.Unmarshal(line, &data); err != nil {
+		return nil, fmt.Errorf("decoding message `%s`: %w", line, err)
+	}
+	return data, nil
+}
+
+// Close closes the connection.
+func (c *Client) Close() error {
+	return c.resp.Body.Close()
+}
+
+// updater implements the datastore.Updater interface by reading from the
+// updates channel of the server.
+type updater struct {
+	s *Server
+}
+
+func (u updater) Update() (map[string]json.RawMessage, error) {
+	select {
+	case data := <-u.s.updates:
+		return data, nil
+	case <-u.s.closed:
+		return nil, nil
+	}
+}
+
+// headerAuth implements the http.Authenticator interface by reading the user
+// id from the UserHeader.
+type headerAuth struct{}
+
+func (headerAuth) Authenticate(_ context.Context, r *http.Request) (int, error) {
+	v := r.Header.Get(UserHeader)
+	if v == "" {
+		return 0, nil
+	}
+
+	uid, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, fmt.Errorf("invalid user id %s: %w", v, err)
+	}
+	return uid, nil
+}
@@ -0,0 +1,52 @@
+package autoupdatetest_test
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/openslides/openslides-autoupdate-service/pkg/autoupdatetest"
+)
+
+func TestServer(t *testing.T) {
+	srv := autoupdatetest.NewServer(autoupdatetest.WithData(map[string]json.RawMessage{
+		"user/1/name": []byte(`"hans"`),
+	}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	client, err := srv.Connect(ctx, 1, `[{"ids":[1],"collection":"user","fields":{"name":null}}]`)
+	if err != nil {
+		t.Fatalf("Connect returned unexpected error: %v", err)
+	}
+	defer client.Close()
+
+	data, err := client.Next()
+	if err != nil {
+		t.Fatalf("Next returned unexpected error: %v", err)
+	}
+	if got := string(data["user/1/name"]); got != `"hans"` {
+		t.Errorf("Got user/1/name `%s`, expected `\"hans\"`", got)
+	}
+
+	srv.Update(map[string]json.RawMessage{"user/1/name": []byte(`"gerd"`)})
+
+	data, err = client.Next()
+	if err != nil {
+		t.Fatalf("Next returned unexpected error: %v", err)
+	}
+	if got := string(data["user/1/name"]); got != `"gerd"` {
+		t.Errorf("Got user/1/name `%s`, expected `\"gerd\"`", got)
+	}
+}
+
+func TestServerInvalidRequest(t *testing.T) {
+	srv := autoupdatetest.NewServer()
+	defer srv.Close()
+
+	if _, err := srv.Connect(context.Background(), 1, `[]`); err == nil {
+		t.Errorf("Connect returned no error for an invalid request")
+	}
+}